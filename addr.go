@@ -0,0 +1,75 @@
+// gorfb project addr.go
+// Client addresses formatted the same way for IPv4 and IPv6, and optionally resolved to host names
+package gorfb
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// DefaultReverseDNSTimeout bounds the reverse DNS lookup if the server's ReverseDNSTimeout is 0
+const DefaultReverseDNSTimeout = 2 * time.Second
+
+// Address returns the client's address, IPv4-mapped IPv6 addresses are given as IPv4 and IPv6 addresses in
+// brackets followed by the port
+func (fb *RFBConn) Address() string {
+	addr := fb.remoteAddr().String()
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()).String()
+	}
+	return addr
+}
+
+// IP returns the client's IP address without the port (the whole address for other kinds of connections)
+func (fb *RFBConn) IP() string {
+	addr := fb.remoteAddr().String()
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr().Unmap().String()
+	}
+	return addr
+}
+
+// Hostname returns the name the client's IP address resolved to with the server's ReverseDNS,
+// empty if it is not resolved (yet)
+func (fb *RFBConn) Hostname() string {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.hostname
+}
+
+// peer describes the client in log messages and notifications, by its host name if it is known
+func (fb *RFBConn) peer() string {
+	if name := fb.Hostname(); name != "" {
+		return name + " (" + fb.Address() + ")"
+	}
+	return fb.Address()
+}
+
+// lookupHostname resolves the client's IP address in the background, giving up after ReverseDNSTimeout
+func (fb *RFBConn) lookupHostname() {
+	if !fb.Server.ReverseDNS {
+		return
+	}
+	ip, err := netip.ParseAddr(fb.IP())
+	if err != nil {
+		return
+	}
+	timeout := fb.Server.ReverseDNSTimeout
+	if timeout <= 0 {
+		timeout = DefaultReverseDNSTimeout
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		names, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+		if err != nil || len(names) == 0 {
+			return
+		}
+		fb.mu.Lock()
+		fb.hostname = strings.TrimSuffix(names[0], ".")
+		fb.mu.Unlock()
+	}()
+}
@@ -0,0 +1,38 @@
+// gorfb project basehandler.go
+// Handler with empty implementations of all handler methods, for embedding
+package gorfb
+
+import "image/color"
+
+// BaseHandler implements every method of RFBServerHandler and of the optional handler interfaces by doing nothing
+// Embed it in a handler to only implement the methods that matter, the handler keeps compiling when methods are
+// added to the interfaces. Embedding it does not change behaviour, the no-op implementations of the optional
+// interfaces do the same as not implementing them.
+type BaseHandler struct{}
+
+// Init does nothing
+func (BaseHandler) Init(conn *RFBConn) {}
+
+// ProcessSetPixelFormat does nothing
+func (BaseHandler) ProcessSetPixelFormat(conn *RFBConn, pf PixelFormat) {}
+
+// ProcessSetEncoding does nothing
+func (BaseHandler) ProcessSetEncoding(conn *RFBConn, encodings []int) {}
+
+// ProcessUpdateRequest does nothing
+func (BaseHandler) ProcessUpdateRequest(conn *RFBConn, x, y, width, height int, incremental bool) {}
+
+// ProcessKeyEvent does nothing
+func (BaseHandler) ProcessKeyEvent(conn *RFBConn, key int, downflag bool) {}
+
+// ProcessPointerEvent does nothing
+func (BaseHandler) ProcessPointerEvent(conn *RFBConn, x, y, button int) {}
+
+// ProcessCutText does nothing
+func (BaseHandler) ProcessCutText(conn *RFBConn, text string) {}
+
+// ProcessTouchEvent does nothing
+func (BaseHandler) ProcessTouchEvent(conn *RFBConn, device int, touches []TouchContact) {}
+
+// ProcessFixColourMapEntries does nothing
+func (BaseHandler) ProcessFixColourMapEntries(conn *RFBConn, first int, colours []color.RGBA64) {}
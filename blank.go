@@ -0,0 +1,112 @@
+// gorfb project blank.go
+// Blanking: replacing the updates sent to clients by a blank or branded image, for example while nobody is using
+// an unattended machine
+package gorfb
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+)
+
+// Blank replaces the updates sent to all clients by the BlankImage until a client sends input or Unblank is called
+func (rfb *RFBServer) Blank() {
+	rfb.setBlanked(true)
+}
+
+// Unblank sends the real content to the clients again after Blank or BlankAfter blanked them
+func (rfb *RFBServer) Unblank() {
+	rfb.setBlanked(false)
+}
+
+// Blanked reports if the updates sent to the clients are blanked
+func (rfb *RFBServer) Blanked() bool {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	return rfb.blanked
+}
+
+// setBlanked blanks or unblanks the clients, a change has every client's framebuffer resent
+func (rfb *RFBServer) setBlanked(blanked bool) {
+	rfb.mu.Lock()
+	changed := rfb.blanked != blanked
+	rfb.blanked = blanked
+	rfb.mu.Unlock()
+	if !changed {
+		return
+	}
+	for _, fb := range rfb.Connections() {
+		full := fb.screenBounds()
+		if blanked {
+			go fb.sendRectangles(context.Background(), fb.blankRectangles([]RFBRectangle{{X: 0, Y: 0, Width: full.Dx(), Height: full.Dy()}}))
+		} else {
+			fb.refreshArea(full)
+		}
+	}
+}
+
+// inputReceived unblanks the clients and restarts the BlankAfter timer when a client sent input
+func (rfb *RFBServer) inputReceived() {
+	rfb.watchBlank(true)
+	rfb.setBlanked(false)
+}
+
+// watchBlank starts the timer that blanks the clients once none of them sent input for BlankAfter
+// If restart is false a timer that is already running is left alone
+func (rfb *RFBServer) watchBlank(restart bool) {
+	if rfb.BlankAfter <= 0 {
+		return
+	}
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	if rfb.blankTimer == nil {
+		rfb.blankTimer = time.AfterFunc(rfb.BlankAfter, rfb.Blank)
+	} else if restart {
+		rfb.blankTimer.Reset(rfb.BlankAfter)
+	}
+}
+
+// blankFrame returns the image shown to blanked clients of a framebuffer of size sz
+// BlankImage is centered on black, the frames are cached per size
+func (rfb *RFBServer) blankFrame(sz image.Point) *image.RGBA {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	if frame, ok := rfb.blankFrames[sz]; ok {
+		return frame
+	}
+	frame := image.NewRGBA(image.Rectangle{Max: sz})
+	draw.Draw(frame, frame.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	if img := rfb.BlankImage; img != nil {
+		b := img.Bounds()
+		at := image.Pt((sz.X-b.Dx())/2, (sz.Y-b.Dy())/2)
+		draw.Draw(frame, image.Rectangle{at, at.Add(b.Size())}, img, b.Min, draw.Over)
+	}
+	if rfb.blankFrames == nil {
+		rfb.blankFrames = make(map[image.Point]*image.RGBA)
+	}
+	rfb.blankFrames[sz] = frame
+	return frame
+}
+
+// blankRectangles replaces the pixels of the rectangles (in client coordinates) by the blank frame while the
+// clients are blanked
+func (fb *RFBConn) blankRectangles(rects []RFBRectangle) []RFBRectangle {
+	if !fb.Server.Blanked() {
+		return rects
+	}
+	frame := fb.Server.blankFrame(fb.screenBounds().Size())
+	pf := fb.PixelFormat()
+	out := make([]RFBRectangle, len(rects))
+	for i, rect := range rects {
+		out[i] = rect
+		out[i].ForceEncoding = false // CopyRect rectangles too are sent as pixels of the frame
+		if pf.TrueColor == 1 {
+			out[i].Buffer = ImageToPixels(frame, rect.Bounds(), pf)
+		} else {
+			out[i].Buffer = make([]byte, rect.Width*rect.Height*pf.BytesPerPixel())
+		}
+	}
+	return out
+}
@@ -0,0 +1,198 @@
+// gorfb project bridge.go
+// Synchronization of the session clipboard with the host OS clipboard
+package gorfb
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ClipboardBridge gives access to a clipboard outside of the RFB session, normally the host OS clipboard
+// When a ClipboardBridge is set on the RFBServer text pasted by clients is set on the bridge and changes
+// on the bridge are sent to the clients
+type ClipboardBridge interface {
+	// Get returns the current clipboard text
+	Get() (string, error)
+	// Set replaces the clipboard text
+	Set(text string) error
+	// Watch calls changed with the new text every time the clipboard changes until stop is closed
+	Watch(stop <-chan struct{}, changed func(text string)) error
+}
+
+// CommandClipboard is a ClipboardBridge that uses external commands to get and set the clipboard
+// Changes are detected by polling
+type CommandClipboard struct {
+	// Command (and arguments) that writes the clipboard text to stdout
+	GetCmd []string
+	// Command (and arguments) that reads the new clipboard text from stdin
+	SetCmd []string
+	// How often the clipboard is polled for changes (1 second if not set)
+	PollInterval time.Duration
+}
+
+// NewHostClipboard returns a CommandClipboard using the usual clipboard tools of the host OS
+// (wl-clipboard or xclip on Linux, pbcopy/pbpaste on macOS and PowerShell on Windows)
+func NewHostClipboard() (*CommandClipboard, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return &CommandClipboard{GetCmd: []string{"pbpaste"}, SetCmd: []string{"pbcopy"}}, nil
+	case "windows":
+		return &CommandClipboard{GetCmd: []string{"powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"},
+			SetCmd: []string{"powershell", "-NoProfile", "-Command", "$input | Set-Clipboard"}}, nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			if _, err := exec.LookPath("wl-paste"); err == nil {
+				return &CommandClipboard{GetCmd: []string{"wl-paste", "--no-newline"}, SetCmd: []string{"wl-copy"}}, nil
+			}
+		}
+		if _, err := exec.LookPath("xclip"); err == nil {
+			return &CommandClipboard{GetCmd: []string{"xclip", "-selection", "clipboard", "-o"},
+				SetCmd: []string{"xclip", "-selection", "clipboard", "-i"}}, nil
+		}
+		return nil, errors.New("No clipboard tool (wl-clipboard or xclip) found")
+	}
+	return nil, errors.New("Host clipboard not supported on " + runtime.GOOS)
+}
+
+// Get runs GetCmd and returns its output
+func (cc *CommandClipboard) Get() (string, error) {
+	if len(cc.GetCmd) == 0 {
+		return "", errors.New("No clipboard get command")
+	}
+	out, err := exec.Command(cc.GetCmd[0], cc.GetCmd[1:]...).Output()
+	return string(out), err
+}
+
+// Set runs SetCmd with text as input
+func (cc *CommandClipboard) Set(text string) error {
+	if len(cc.SetCmd) == 0 {
+		return errors.New("No clipboard set command")
+	}
+	cmd := exec.Command(cc.SetCmd[0], cc.SetCmd[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// Watch polls the clipboard and calls changed when the text differs from the previous poll
+// A clipboard that can not be read counts as empty at the start
+func (cc *CommandClipboard) Watch(stop <-chan struct{}, changed func(text string)) error {
+	interval := cc.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	last, _ := cc.Get() // Tools like xclip fail while the clipboard is empty
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			text, err := cc.Get()
+			if err == nil && text != last {
+				last = text
+				changed(text)
+			}
+		}
+	}
+}
+
+// bridgeState is the server side of the clipboard bridge, guarded by the server's mu
+type bridgeState struct {
+	clients int           // Connections using the bridge, it is watched while there are any
+	stop    chan struct{} // Closed when the last of the clients ended
+	pending *string       // Text pasted by a client that is still to be set on the bridge
+	setting bool          // A goroutine is setting the pending text on the bridge
+}
+
+// startClipboardBridge sends the bridge's clipboard to the client and keeps it up to date until the connection closes
+// The bridge is watched once for the server, the changes are sent to all its clients
+func (fb *RFBConn) startClipboardBridge() {
+	rfb := fb.Server
+	bridge := rfb.ClipboardBridge
+	if bridge == nil {
+		return
+	}
+	if text, err := bridge.Get(); err == nil && text != "" {
+		fb.bridgeChanged(text)
+	}
+	rfb.mu.Lock()
+	rfb.bridge.clients++
+	if rfb.bridge.clients == 1 {
+		rfb.bridge.stop = make(chan struct{})
+		go rfb.watchClipboardBridge(bridge, rfb.bridge.stop)
+	}
+	rfb.mu.Unlock()
+	go func() {
+		<-fb.done
+		rfb.mu.Lock()
+		rfb.bridge.clients--
+		if rfb.bridge.clients == 0 {
+			close(rfb.bridge.stop)
+		}
+		rfb.mu.Unlock()
+	}()
+}
+
+// watchClipboardBridge sends the changes on the bridge to all clients until stop is closed
+func (rfb *RFBServer) watchClipboardBridge(bridge ClipboardBridge, stop <-chan struct{}) {
+	err := bridge.Watch(stop, func(text string) {
+		rfb.Broadcast(nil, func(fb *RFBConn) error {
+			fb.bridgeChanged(text)
+			return nil
+		})
+	})
+	if err != nil {
+		rfb.logf("Error watching clipboard bridge: %s\n", err.Error())
+	}
+}
+
+// bridgeChanged sends text that changed on the bridge to the client
+func (fb *RFBConn) bridgeChanged(text string) {
+	fb.mu.Lock()
+	echo := text == fb.bridgeText // Don't echo back what the client has just pasted
+	fb.bridgeText = text
+	fb.mu.Unlock()
+	if !echo {
+		fb.SendCutText(text)
+	}
+}
+
+// setBridgeText puts text pasted by the client on the bridge
+// The text is set from another goroutine so that the client's messages are not held up, if texts are pasted
+// faster than the bridge takes them only the latest is set
+func (fb *RFBConn) setBridgeText(text string) {
+	fb.mu.Lock()
+	fb.bridgeText = text
+	fb.mu.Unlock()
+	rfb := fb.Server
+	rfb.mu.Lock()
+	rfb.bridge.pending = &text
+	start := !rfb.bridge.setting
+	rfb.bridge.setting = true
+	rfb.mu.Unlock()
+	if start {
+		go rfb.setClipboardBridge()
+	}
+}
+
+// setClipboardBridge sets the pending text on the bridge until there is none left
+func (rfb *RFBServer) setClipboardBridge() {
+	for {
+		rfb.mu.Lock()
+		text := rfb.bridge.pending
+		rfb.bridge.pending = nil
+		rfb.bridge.setting = text != nil
+		rfb.mu.Unlock()
+		if text == nil {
+			return
+		}
+		if err := rfb.ClipboardBridge.Set(*text); err != nil {
+			rfb.logf("Error setting clipboard bridge: %s\n", err.Error())
+		}
+	}
+}
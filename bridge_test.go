@@ -0,0 +1,100 @@
+package gorfb_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/client"
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+// fakeBridge is a ClipboardBridge that fails Get like an empty clipboard and records what is set on it
+type fakeBridge struct {
+	mu       sync.Mutex
+	watches  int
+	changed  func(text string)
+	watching chan struct{} // Receives when Watch is called
+	set      chan string   // Receives the texts set
+}
+
+func (b *fakeBridge) Get() (string, error) { return "", errors.New("Clipboard empty") }
+
+func (b *fakeBridge) Set(text string) error {
+	b.set <- text
+	return nil
+}
+
+func (b *fakeBridge) Watch(stop <-chan struct{}, changed func(text string)) error {
+	b.mu.Lock()
+	b.watches++
+	b.changed = changed
+	b.mu.Unlock()
+	b.watching <- struct{}{}
+	<-stop
+	return nil
+}
+
+func TestClipboardBridgeFansOut(t *testing.T) {
+	bridge := &fakeBridge{watching: make(chan struct{}, 2), set: make(chan string, 1)}
+	rfb, h := rfbtest.NewServer(16, 16)
+	rfb.ClipboardBridge = bridge
+	rfb.Logf = func(format string, args ...interface{}) {}
+	ln := rfbtest.Serve(rfb)
+	defer ln.Close()
+	var clients []*client.Client
+	for i := 0; i < 2; i++ {
+		c, err := rfbtest.Connect(ln, true, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := c.SendSetEncodings(gorfb.EncRaw); err != nil { // Cut text is held back until then
+			t.Fatal(err)
+		}
+		for {
+			call, err := h.Next(5 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if call.Method == "ProcessSetEncoding" {
+				break
+			}
+		}
+		clients = append(clients, c)
+	}
+	select {
+	case <-bridge.watching:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Bridge not watched")
+	}
+	bridge.mu.Lock()
+	watches, changed := bridge.watches, bridge.changed
+	bridge.mu.Unlock()
+	if watches != 1 {
+		t.Errorf("Bridge watched %d times for 2 clients", watches)
+	}
+	changed("hello")
+	for i, c := range clients {
+		msg, err := c.Expect(gorfb.MsgServerCutText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Text != "hello" {
+			t.Errorf("Client %d received %q", i, msg.Text)
+		}
+	}
+	if err := clients[0].SendCutText("pasted"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case text := <-bridge.set:
+		if text != "pasted" {
+			t.Errorf("Bridge set to %q", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pasted text not set on the bridge")
+	}
+}
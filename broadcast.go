@@ -0,0 +1,88 @@
+// gorfb project broadcast.go
+// Sending messages to all the clients of a server
+package gorfb
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Broadcast calls send for every active connection that filter returns true for (all if filter is nil)
+// The connections are sent to concurrently and the errors of all failed sends are returned
+func (rfb *RFBServer) Broadcast(filter func(conn *RFBConn) bool, send func(conn *RFBConn) error) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for _, fb := range rfb.Connections() {
+		if filter != nil && !filter(fb) {
+			continue
+		}
+		wg.Add(1)
+		go func(fb *RFBConn) {
+			defer wg.Done()
+			if err := send(fb); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(fb)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// BroadcastRectangles sends the rectangles to all clients of the server's own framebuffer
+// The rectangle buffers must be in the server's PixelFormat, they are converted to each client's pixel format
+func (rfb *RFBServer) BroadcastRectangles(rects []RFBRectangle) error {
+	return rfb.BroadcastScreenRectangles("", rects)
+}
+
+// BroadcastScreenRectangles sends the rectangles to all clients attached to the named screen
+// The rectangle buffers must be in the screen's PixelFormat, they are converted to each client's pixel format
+// With FairBroadcast the rectangles are only queued and errors sending them are logged
+func (rfb *RFBServer) BroadcastScreenRectangles(name string, rects []RFBRectangle) error {
+	var mu sync.Mutex
+	var spf PixelFormat
+	if name == "" {
+		spf = rfb.defaultScreen().PixelFormat
+	} else if screen, ok := rfb.screen(name); ok {
+		spf = screen.PixelFormat
+	} else {
+		return ErrUnknownScreen
+	}
+	converted := map[PixelFormat][]RFBRectangle{spf: rects}
+	return rfb.Broadcast(func(fb *RFBConn) bool { return fb.CurrentScreen().Name == name }, func(fb *RFBConn) error {
+		pf := fb.PixelFormat()
+		mu.Lock()
+		crects, ok := converted[pf]
+		if !ok { // Convert once for every pixel format in use
+			crects = make([]RFBRectangle, len(rects))
+			for i, rect := range rects {
+				crects[i] = rect
+				crects[i].Buffer = ConvertPixels(spf, pf, rect.Buffer)
+			}
+			converted[pf] = crects
+		}
+		mu.Unlock()
+		if rfb.FairBroadcast {
+			rfb.broadcastScheduler().queue(fb, crects)
+			return nil
+		}
+		return fb.sendRectangles(context.Background(), crects)
+	})
+}
+
+// BroadcastCutText sends text to the clipboard of all clients
+func (rfb *RFBServer) BroadcastCutText(text string) error {
+	return rfb.Broadcast(nil, func(fb *RFBConn) error {
+		return fb.SendCutText(text)
+	})
+}
+
+// BroadcastBell rings the bell of all clients
+func (rfb *RFBServer) BroadcastBell() error {
+	return rfb.Broadcast(nil, func(fb *RFBConn) error {
+		return fb.SendBell()
+	})
+}
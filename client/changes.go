@@ -0,0 +1,81 @@
+// gorfb project client/changes.go
+// A stream of the framebuffer areas the server updated, decoded, for consumers that only look at what changed
+package client
+
+import (
+	"context"
+	"errors"
+	"image"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// rgbaFormat is the pixel format of image.RGBA, with the alpha byte left out
+var rgbaFormat = gorfb.PixelFormat{BitsPerPixel: 32, Depth: 24, TrueColor: 1, RedMax: 255, GreenMax: 255,
+	BlueMax: 255, RedShift: 0, GreenShift: 8, BlueShift: 16}
+
+// Change is an area of the framebuffer the server sent in a FramebufferUpdate
+type Change struct {
+	// Area of the framebuffer, also the bounds of Image
+	Rect image.Rectangle
+	// The pixels of the area, nil if the rectangle could not be decoded
+	Image *image.RGBA
+	// Why the rectangle could not be decoded
+	Err error
+}
+
+// Image decodes the rectangle into an image with the bounds of its area of the framebuffer
+// Only rectangles in true colour pixel formats can be decoded, the client does not keep the colour map.
+func (r Rectangle) Image() (*image.RGBA, error) {
+	if r.pf.TrueColor != 1 {
+		return nil, errors.New("Can not convert colour map pixels to an image")
+	}
+	pix, err := r.Pixels(r.pf)
+	if err != nil {
+		return nil, err
+	}
+	pix = gorfb.ConvertPixels(r.pf, rgbaFormat, pix)
+	for i := 3; i < len(pix); i += 4 {
+		pix[i] = 255
+	}
+	return &image.RGBA{Pix: pix, Stride: r.Width * 4, Rect: image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height)}, nil
+}
+
+// Changes decodes the rectangles of the FramebufferUpdates the server sends and delivers them in order, until ctx
+// is done or the connection fails, then the channel is closed
+// Pseudo-encoded rectangles and other messages are dropped, so Next, Expect and RequestUpdate must not be used
+// while the changes are read. Updates still have to be requested with SendUpdateRequest.
+func (c *Client) Changes(ctx context.Context) <-chan Change {
+	changes := make(chan Change, 16)
+	go func() {
+		defer close(changes)
+		for {
+			var msg *Message
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-c.msgs:
+				if !ok {
+					return
+				}
+				msg = m
+			}
+			if msg.Type != gorfb.MsgFramebufferUpdate {
+				continue
+			}
+			for _, r := range msg.Rectangles {
+				if r.Encoding < 0 {
+					continue
+				}
+				change := Change{Rect: image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height)}
+				change.Image, change.Err = r.Image()
+				select {
+				case changes <- change:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return changes
+}
@@ -0,0 +1,707 @@
+// gorfb project client/client.go
+// RFB client: the handshake, client messages and parsing the server's messages
+package client
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/des"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/internal/tile"
+)
+
+// ErrTimeout is returned by Next when no message arrived within the client's Timeout
+var ErrTimeout = errors.New("Timeout waiting for a message from the server")
+
+// Rectangle is a rectangle of a FramebufferUpdate, Data is everything that followed its header
+type Rectangle struct {
+	X, Y, Width, Height int
+	Encoding            gorfb.Encoding
+	Data                []byte
+	// The pixels of a Zlib rectangle or the tiles of a ZRLE rectangle, decompressed with the client's zlib streams
+	Inflated []byte
+
+	pf gorfb.PixelFormat // Pixel format of the client when the rectangle was received
+}
+
+// Message is a message received from the server
+type Message struct {
+	Type gorfb.ServerMessageType
+	// Rectangles of a FramebufferUpdate
+	Rectangles []Rectangle
+	// Text of a ServerCutText, decoded from Latin-1 (empty for extended clipboard messages)
+	Text string
+	// Flags and payload of a ServerFence
+	FenceFlags   uint32
+	FencePayload []byte
+	// Everything following the message type byte as it was received
+	Raw []byte
+}
+
+// Client is an RFB client
+// After the handshake the server's messages are read in the background so that sending never blocks on the
+// server waiting for its output to be read
+type Client struct {
+	Conn net.Conn
+	// How long Next waits for a message (5 seconds if not set)
+	Timeout time.Duration
+	// Answer the server's fence requests as they are received (set before the handshake), they are still delivered
+	AnswerFences bool
+	// Framebuffer details from the ServerInit message
+	Width, Height int
+	PixelFormat   gorfb.PixelFormat
+	Name          string
+
+	mu   sync.Mutex
+	pf   gorfb.PixelFormat // Pixel format in use for parsing updates
+	msgs chan *Message
+	done chan struct{} // Closed when reading stopped
+	err  error         // Why reading stopped
+	// Zlib streams of the Zlib, ZRLE and Tight encodings
+	zlib, zrle zstream
+	tight      [4]zstream
+	// Whether the server showed support for continuous updates and fences
+	continuous, fences bool
+	// Fences sent by RoundTrip waiting for their response, by payload
+	pings   map[string]chan struct{}
+	pingSeq int
+}
+
+// NewClient returns a client on conn, Handshake must be called first
+func NewClient(conn net.Conn) *Client {
+	return &Client{Conn: conn}
+}
+
+// Dial connects to the server at address on the named network (see net.Dial) and performs the handshake
+// An empty password selects no authentication
+func Dial(network, address string, shared bool, password string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	c := NewClient(conn)
+	if err := c.Handshake(shared, password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Handshake performs the handshake (RFB 3.3, 3.7 or 3.8, whichever is the highest the server offers) and starts
+// reading the server's messages
+// No authentication is used if the server offers it, otherwise VNC authentication with password.
+func (c *Client) Handshake(shared bool, password string) error {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout()))
+	defer c.Conn.SetDeadline(time.Time{})
+	buf := make([]byte, 24)
+	if _, err := io.ReadFull(c.Conn, buf[:12]); err != nil {
+		return err
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(string(buf[:12]), "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 || minor < 3 {
+		return fmt.Errorf("Unexpected protocol version %q", buf[:12])
+	}
+	switch {
+	case minor > 8:
+		minor = 8
+	case minor > 3 && minor < 7: // Not defined, such servers speak 3.3
+		minor = 3
+	}
+	if _, err := fmt.Fprintf(c.Conn, "RFB 003.%03d\n", minor); err != nil {
+		return err
+	}
+	sectype, err := c.selectSecurity(minor, password)
+	if err != nil {
+		return err
+	}
+	if sectype == gorfb.SecVNCAuth {
+		challenge := make([]byte, 16)
+		if _, err := io.ReadFull(c.Conn, challenge); err != nil {
+			return err
+		}
+		if _, err := c.Conn.Write(encryptChallenge(password, challenge)); err != nil {
+			return err
+		}
+	}
+	if sectype != gorfb.SecNone || minor == 8 { // Servers before 3.8 send no result without authentication
+		if _, err := io.ReadFull(c.Conn, buf[:4]); err != nil {
+			return err
+		}
+		if gorfb.NewMessageReader(buf[:4]).Uint32() != 0 {
+			if minor < 8 {
+				return errors.New("Authentication failed")
+			}
+			return c.readReason()
+		}
+	}
+	if _, err := c.Conn.Write([]byte{flag(shared)}); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c.Conn, buf[:24]); err != nil {
+		return err
+	}
+	r := gorfb.NewMessageReader(buf[:24])
+	c.Width, c.Height = int(r.Uint16()), int(r.Uint16())
+	c.PixelFormat = gorfb.PixelFormat{BitsPerPixel: r.Uint8(), Depth: r.Uint8(), BigEndian: r.Uint8(), TrueColor: r.Uint8(),
+		RedMax: r.Uint16(), GreenMax: r.Uint16(), BlueMax: r.Uint16(), RedShift: r.Uint8(), GreenShift: r.Uint8(), BlueShift: r.Uint8()}
+	r.Skip(3)
+	nameLen := r.Uint32()
+	if err := r.Err(); err != nil {
+		return err
+	}
+	name := make([]byte, nameLen)
+	if _, err := io.ReadFull(c.Conn, name); err != nil {
+		return err
+	}
+	c.Name = string(name)
+	c.pf = c.PixelFormat
+	c.msgs = make(chan *Message, 64)
+	c.done = make(chan struct{})
+	go c.readMessages()
+	return nil
+}
+
+// selectSecurity agrees the security type with the server, RFB 3.3 servers decide it on their own
+func (c *Client) selectSecurity(minor int, password string) (gorfb.SecurityType, error) {
+	if minor == 3 {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c.Conn, buf); err != nil {
+			return 0, err
+		}
+		sectype := gorfb.SecurityType(gorfb.NewMessageReader(buf).Uint32())
+		if sectype == gorfb.SecInvalid {
+			return 0, c.readReason()
+		}
+		if sectype != gorfb.SecNone && sectype != gorfb.SecVNCAuth {
+			return 0, fmt.Errorf("Unsupported security type %s", sectype)
+		}
+		return sectype, nil
+	}
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return 0, err
+	}
+	types := make([]byte, buf[0])
+	if _, err := io.ReadFull(c.Conn, types); err != nil {
+		return 0, err
+	}
+	if len(types) == 0 {
+		return 0, c.readReason()
+	}
+	sectype := gorfb.SecInvalid
+	for _, t := range types {
+		switch {
+		case gorfb.SecurityType(t) == gorfb.SecNone && (password == "" || sectype == gorfb.SecInvalid):
+			sectype = gorfb.SecNone
+		case gorfb.SecurityType(t) == gorfb.SecVNCAuth && (password != "" || sectype == gorfb.SecInvalid):
+			sectype = gorfb.SecVNCAuth
+		}
+	}
+	if sectype == gorfb.SecInvalid {
+		return 0, fmt.Errorf("None of the security types %v is supported", types)
+	}
+	_, err := c.Conn.Write([]byte{byte(sectype)})
+	return sectype, err
+}
+
+// readReason reads the reason string of a failed handshake and returns it as an error
+func (c *Client) readReason() error {
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return err
+	}
+	reason := make([]byte, gorfb.NewMessageReader(buf).Uint32())
+	if _, err := io.ReadFull(c.Conn, reason); err != nil {
+		return err
+	}
+	return errors.New(string(reason))
+}
+
+// encryptChallenge returns the response to a VNC authentication challenge
+func encryptChallenge(password string, challenge []byte) []byte {
+	key := make([]byte, 8)
+	copy(key, password)
+	for i, b := range key { // VNC uses the key with the bits of every byte mirrored
+		var m byte
+		for j := 0; j < 8; j++ {
+			m = m<<1 | (b>>uint(j))&1
+		}
+		key[i] = m
+	}
+	bk, _ := des.NewCipher(key)
+	resp := make([]byte, 16)
+	bk.Encrypt(resp, challenge)
+	bk.Encrypt(resp[8:], challenge[8:])
+	return resp
+}
+
+// timeout returns how long to wait for the server
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return 5 * time.Second
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.Conn.Close()
+}
+
+// Send sends raw bytes to the server as one write
+func (c *Client) Send(buf []byte) error {
+	_, err := c.Conn.Write(buf)
+	return err
+}
+
+// SendSetPixelFormat asks the server for pixels in pf, updates received afterwards are parsed in pf
+func (c *Client) SendSetPixelFormat(pf gorfb.PixelFormat) error {
+	w := gorfb.NewMessageWriter(20).Uint8(uint8(gorfb.MsgSetPixelFormat)).Padding(3)
+	w.Uint8(pf.BitsPerPixel).Uint8(pf.Depth).Uint8(pf.BigEndian).Uint8(pf.TrueColor)
+	w.Uint16(pf.RedMax).Uint16(pf.GreenMax).Uint16(pf.BlueMax)
+	buf, err := w.Uint8(pf.RedShift).Uint8(pf.GreenShift).Uint8(pf.BlueShift).Padding(3).Bytes()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.pf = pf
+	c.mu.Unlock()
+	return c.Send(buf)
+}
+
+// SendSetEncodings sends the encodings (and pseudo-encodings) the client supports
+func (c *Client) SendSetEncodings(encodings ...gorfb.Encoding) error {
+	w := gorfb.NewMessageWriter(4 + 4*len(encodings)).Uint8(uint8(gorfb.MsgSetEncodings)).Padding(1).Uint16(uint16(len(encodings)))
+	for _, enc := range encodings {
+		w.Int32(int32(enc))
+	}
+	return c.sendMessage(w)
+}
+
+// SendUpdateRequest requests an update of the given area
+func (c *Client) SendUpdateRequest(x, y, width, height int, incremental bool) error {
+	w := gorfb.NewMessageWriter(10).Uint8(uint8(gorfb.MsgFramebufferUpdateRequest)).Uint8(flag(incremental))
+	return c.sendMessage(w.Uint16(uint16(x)).Uint16(uint16(y)).Uint16(uint16(width)).Uint16(uint16(height)))
+}
+
+// SendKey sends a key press or release
+func (c *Client) SendKey(keysym int, down bool) error {
+	return c.sendMessage(gorfb.NewMessageWriter(8).Uint8(uint8(gorfb.MsgKeyEvent)).Uint8(flag(down)).Padding(2).Uint32(uint32(keysym)))
+}
+
+// SendPointer sends a pointer event
+func (c *Client) SendPointer(x, y, buttons int) error {
+	return c.sendMessage(gorfb.NewMessageWriter(6).Uint8(uint8(gorfb.MsgPointerEvent)).Uint8(uint8(buttons)).Uint16(uint16(x)).Uint16(uint16(y)))
+}
+
+// SendCutText sends text as Latin-1 cut text
+func (c *Client) SendCutText(text string) error {
+	latin1, err := gorfb.StringToLatin1(text, true)
+	if err != nil {
+		return err
+	}
+	return c.sendMessage(gorfb.NewMessageWriter(8 + len(latin1)).Uint8(uint8(gorfb.MsgClientCutText)).Padding(3).Uint32(uint32(len(latin1))).Data(latin1))
+}
+
+// sendMessage sends the message built by w
+func (c *Client) sendMessage(w *gorfb.MessageWriter) error {
+	buf, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	return c.Send(buf)
+}
+
+// flag returns b as a protocol flag byte
+func flag(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Next returns the next message from the server, waiting up to Timeout
+func (c *Client) Next() (*Message, error) {
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			return nil, c.err
+		}
+		return msg, nil
+	case <-time.After(c.timeout()):
+		return nil, ErrTimeout
+	}
+}
+
+// Expect returns the next message from the server, failing if it is not of type typ
+func (c *Client) Expect(typ gorfb.ServerMessageType) (*Message, error) {
+	msg, err := c.Next()
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type != typ {
+		return msg, fmt.Errorf("Expected message type %s, received %s", typ, msg.Type)
+	}
+	return msg, nil
+}
+
+// RequestUpdate sends an update request and returns the rectangles of the FramebufferUpdate that follows
+func (c *Client) RequestUpdate(x, y, width, height int, incremental bool) ([]Rectangle, error) {
+	if err := c.SendUpdateRequest(x, y, width, height, incremental); err != nil {
+		return nil, err
+	}
+	msg, err := c.Expect(gorfb.MsgFramebufferUpdate)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Rectangles, nil
+}
+
+// NoMessage checks that the server sends nothing for d
+func (c *Client) NoMessage(d time.Duration) error {
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			return c.err
+		}
+		return fmt.Errorf("Unexpected message type %s", msg.Type)
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// readMessages parses the server's messages until the connection fails
+func (c *Client) readMessages() {
+	r := &recorder{r: c.Conn}
+	for {
+		msg, err := c.readMessage(r)
+		if err != nil { // Close the connection so that sending does not block on a server that can't write
+			c.err = err
+			c.Conn.Close()
+			close(c.done)
+			close(c.msgs)
+			return
+		}
+		if c.syncMessage(msg) {
+			continue
+		}
+		c.msgs <- msg
+	}
+}
+
+// recorder is a reader keeping a copy of everything read
+type recorder struct {
+	r   io.Reader
+	buf []byte
+}
+
+// read reads exactly n bytes
+func (rec *recorder) read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(rec.r, buf); err != nil {
+		return nil, err
+	}
+	rec.buf = append(rec.buf, buf...)
+	return buf, nil
+}
+
+// readMessage reads and parses a single server message
+func (c *Client) readMessage(r *recorder) (*Message, error) {
+	hdr, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	r.buf = r.buf[:0]
+	msg := &Message{Type: gorfb.ServerMessageType(hdr[0])}
+	switch msg.Type {
+	case gorfb.MsgFramebufferUpdate:
+		buf, err := r.read(3)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		bpp := c.pf.BytesPerPixel()
+		pf := c.pf
+		c.mu.Unlock()
+		cpp, _ := cpixel(pf)
+		tp := tpixelSize(pf)
+	rects:
+		for n := int(gorfb.NewMessageReader(buf[1:]).Uint16()); n > 0; n-- {
+			rbuf, err := r.read(12)
+			if err != nil {
+				return nil, err
+			}
+			rr := gorfb.NewMessageReader(rbuf)
+			rect := Rectangle{X: int(rr.Uint16()), Y: int(rr.Uint16()), Width: int(rr.Uint16()), Height: int(rr.Uint16()),
+				Encoding: gorfb.Encoding(rr.Int32()), pf: pf}
+			if err := rr.Err(); err != nil {
+				return nil, err
+			}
+			var sz int
+			var pre []byte // Part of the data read to find its size
+			switch rect.Encoding {
+			case gorfb.EncRaw:
+				sz = rect.Width * rect.Height * bpp
+			case gorfb.EncCopyRect: // The position of the source
+				sz = 4
+			case gorfb.EncCoRRE: // The number of subrectangles and the background precede the subrectangles
+				if pre, err = r.read(4 + bpp); err != nil {
+					return nil, err
+				}
+				sz = int(gorfb.NewMessageReader(pre).Uint32()) * (bpp + 4)
+			case gorfb.EncHextile: // The size is only known after reading every tile
+				if pre, err = readHextile(r, rect.Width, rect.Height, bpp); err != nil {
+					return nil, err
+				}
+			case gorfb.EncTight: // The data of basic compression is decompressed while it is read
+				if pre, rect.Inflated, err = c.readTight(r, rect.Width, rect.Height, tp); err != nil {
+					return nil, err
+				}
+			case gorfb.EncZlib, gorfb.EncZRLE: // The compressed data follows its length
+				if pre, err = r.read(4); err != nil {
+					return nil, err
+				}
+				sz = int(gorfb.NewMessageReader(pre).Uint32())
+			case gorfb.EncRichCursor:
+				sz = rect.Width*rect.Height*bpp + (rect.Width+7)/8*rect.Height
+			case gorfb.EncXCursor:
+				if rect.Width*rect.Height > 0 {
+					sz = 6 + 2*((rect.Width+7)/8)*rect.Height
+				}
+			case gorfb.EncCursorWithAlpha: // Raw encoded
+				sz = 4 + rect.Width*rect.Height*4
+			case gorfb.EncDesktopSize, gorfb.EncLastRect: // No data
+			case gorfb.EncDesktopName: // The name follows its length
+				lbuf, err := r.read(4)
+				if err != nil {
+					return nil, err
+				}
+				sz = int(gorfb.NewMessageReader(lbuf).Uint32())
+			default:
+				return nil, fmt.Errorf("Unsupported encoding %s", rect.Encoding)
+			}
+			data, err := r.read(sz)
+			if err != nil {
+				return nil, err
+			}
+			rect.Data = append(pre, data...)
+			switch rect.Encoding {
+			case gorfb.EncZlib:
+				if rect.Inflated, err = c.inflate(data, rect.Width*rect.Height*bpp); err != nil {
+					return nil, err
+				}
+			case gorfb.EncZRLE:
+				if rect.Inflated, err = c.inflateZRLE(data, rect.Width, rect.Height, cpp); err != nil {
+					return nil, err
+				}
+			}
+			msg.Rectangles = append(msg.Rectangles, rect)
+			if rect.Encoding == gorfb.EncLastRect {
+				break rects
+			}
+		}
+	case gorfb.MsgSetColourMapEntries:
+		buf, err := r.read(5)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.read(6 * int(gorfb.NewMessageReader(buf[3:]).Uint16())); err != nil {
+			return nil, err
+		}
+	case gorfb.MsgBell:
+	case gorfb.MsgServerCutText:
+		buf, err := r.read(7)
+		if err != nil {
+			return nil, err
+		}
+		length := int(gorfb.NewMessageReader(buf[3:]).Int32())
+		text, err := r.read(max(length, -length)) // Negative for extended clipboard
+		if err != nil {
+			return nil, err
+		}
+		if length >= 0 {
+			msg.Text = gorfb.Latin1ToString(text)
+		}
+	case gorfb.MsgEndOfContinuousUpdates:
+	case gorfb.MsgServerFence:
+		buf, err := r.read(8)
+		if err != nil {
+			return nil, err
+		}
+		msg.FenceFlags = gorfb.NewMessageReader(buf[3:]).Uint32()
+		if msg.FencePayload, err = r.read(int(buf[7])); err != nil {
+			return nil, err
+		}
+	case gorfb.MsgServerXvp:
+		if _, err := r.read(3); err != nil {
+			return nil, err
+		}
+	case gorfb.MsgServerGII:
+		buf, err := r.read(3)
+		if err != nil {
+			return nil, err
+		}
+		sz := int(gorfb.NewMessageReader(buf[1:]).Uint16())
+		if buf[0]&0x80 == 0 { // Little endian
+			sz = int(buf[1]) | int(buf[2])<<8
+		}
+		if _, err := r.read(sz); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown server message type %s", msg.Type)
+	}
+	msg.Raw = append([]byte(nil), r.buf...)
+	return msg, nil
+}
+
+// inflate decompresses the data of a Zlib rectangle of n bytes of pixels with the client's stream
+func (c *Client) inflate(data []byte, n int) ([]byte, error) {
+	zr, err := c.zlib.add(data)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, n)
+	if _, err := io.ReadFull(zr, out); err != nil {
+		return nil, fmt.Errorf("Inflating Zlib rectangle: %s", err.Error())
+	}
+	return out, nil
+}
+
+// inflateZRLE decompresses the tiles of a width x height ZRLE rectangle with the client's stream, cpp is the
+// number of bytes per compressed pixel
+func (c *Client) inflateZRLE(data []byte, width, height, cpp int) ([]byte, error) {
+	zr, err := c.zrle.add(data)
+	if err != nil {
+		return nil, err
+	}
+	r := &recorder{r: zr}
+	if err := readZRLE(r, width, height, cpp); err != nil {
+		return nil, fmt.Errorf("Inflating ZRLE rectangle: %s", err.Error())
+	}
+	return r.buf, nil
+}
+
+// readTight reads a width x height Tight rectangle, tp is the number of bytes per Tight pixel
+// The pixel data of basic compression is returned as well, decompressed if it was compressed.
+func (c *Client) readTight(r *recorder, width, height, tp int) ([]byte, []byte, error) {
+	var data []byte
+	read := func(n int) ([]byte, error) {
+		buf, err := r.read(n)
+		data = append(data, buf...)
+		return buf, err
+	}
+	// compactLength reads a length of 1 to 3 bytes, 7 bits per byte with the high bit set if another byte follows
+	compactLength := func() (int, error) {
+		length := 0
+		for i := 0; i < 3; i++ {
+			buf, err := read(1)
+			if err != nil {
+				return 0, err
+			}
+			if i == 2 {
+				return length | int(buf[0])<<14, nil
+			}
+			length |= int(buf[0]&0x7f) << (7 * i)
+			if buf[0]&0x80 == 0 {
+				break
+			}
+		}
+		return length, nil
+	}
+	buf, err := read(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctl := buf[0]
+	for i := range c.tight {
+		if ctl&(1<<i) != 0 {
+			c.tight[i] = zstream{}
+		}
+	}
+	switch ctl >>= 4; {
+	case ctl == 8: // Fill
+		_, err := read(tp)
+		return data, nil, err
+	case ctl == 9: // JPEG, decoded by Pixels
+		length, err := compactLength()
+		if err == nil {
+			_, err = read(length)
+		}
+		return data, nil, err
+	case ctl > 9:
+		return nil, nil, fmt.Errorf("Unsupported Tight compression %d", ctl)
+	}
+	filter := byte(0)
+	if ctl&4 != 0 {
+		if buf, err = read(1); err != nil {
+			return nil, nil, err
+		}
+		filter = buf[0]
+	}
+	n := width * height * tp
+	switch filter {
+	case 0, 2: // Copy and gradient
+	case 1: // Palette
+		if buf, err = read(1); err != nil {
+			return nil, nil, err
+		}
+		colours := int(buf[0]) + 1
+		if _, err := read(colours * tp); err != nil {
+			return nil, nil, err
+		}
+		n = width * height
+		if colours == 2 {
+			n = height * tile.PackedRowSize(width, 1)
+		}
+	default:
+		return nil, nil, fmt.Errorf("Unknown Tight filter %d", filter)
+	}
+	if n < 12 { // Not compressed
+		pixels, err := read(n)
+		return data, pixels, err
+	}
+	length, err := compactLength()
+	if err != nil {
+		return nil, nil, err
+	}
+	compressed, err := read(length)
+	if err != nil {
+		return nil, nil, err
+	}
+	zr, err := c.tight[ctl&3].add(compressed)
+	if err != nil {
+		return nil, nil, err
+	}
+	pixels := make([]byte, n)
+	if _, err := io.ReadFull(zr, pixels); err != nil {
+		return nil, nil, fmt.Errorf("Inflating Tight rectangle: %s", err.Error())
+	}
+	return data, pixels, nil
+}
+
+// zstream is a zlib stream of the client, reading the compressed data from buf
+type zstream struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+// add adds compressed data to the stream and returns the stream, it is started with its first data
+func (s *zstream) add(data []byte) (io.Reader, error) {
+	s.buf.Write(data)
+	if s.r == nil {
+		r, err := zlib.NewReader(&s.buf)
+		if err != nil {
+			return nil, err
+		}
+		s.r = r
+	}
+	return s.r, nil
+}
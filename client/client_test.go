@@ -0,0 +1,119 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/client"
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+// dial serves rfb on a local TCP port and connects a client to it
+func dial(t *testing.T, rfb *gorfb.RFBServer) *client.Client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("No local TCP: " + err.Error())
+	}
+	rfb.Logf = func(format string, args ...interface{}) {}
+	go rfb.Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+	c, err := client.Dial("tcp", ln.Addr().String(), true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestDial(t *testing.T) {
+	rfb, _ := rfbtest.NewServer(24, 8)
+	c := dial(t, rfb)
+	if c.Width != 24 || c.Height != 8 || c.Name != "rfbtest" {
+		t.Errorf("ServerInit gave %dx%d %q", c.Width, c.Height, c.Name)
+	}
+	rects, err := c.RequestUpdate(0, 0, 24, 8, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rects) != 1 || rects[0].Encoding != gorfb.EncRaw {
+		t.Fatalf("Received %d rectangles", len(rects))
+	}
+	c.Timeout = 100 * time.Millisecond
+	if _, err := c.Next(); err != client.ErrTimeout { // Nothing else was requested
+		t.Errorf("Next returned %v instead of ErrTimeout", err)
+	}
+}
+
+func TestViewer(t *testing.T) {
+	rfb, h := rfbtest.NewServer(20, 10)
+	rfb.ConvertPixelFormat = true // The handler sends its buffer as it is, the viewer asks for another pixel format
+	c := dial(t, rfb)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	v, err := client.NewViewer(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-v.Changed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Viewer not updated")
+	}
+	img := v.Snapshot()
+	for y := 0; y < h.Height; y++ {
+		for x := 0; x < h.Width; x++ {
+			p := h.Buffer[(y*h.Width+x)*4:] // Blue, green and red
+			want := color.RGBA{R: p[2], G: p[1], B: p[0], A: 255}
+			if got := img.RGBAAt(x, y); got != want {
+				t.Fatalf("Pixel %d,%d is %v instead of %v", x, y, got, want)
+			}
+		}
+	}
+	cancel()
+	for range v.Changed() {
+	}
+	if v.Err() != context.Canceled {
+		t.Errorf("Viewer stopped with %v", v.Err())
+	}
+}
+
+func TestHandshake33(t *testing.T) {
+	server, conn := net.Pipe()
+	defer server.Close()
+	errs := make(chan error, 1)
+	go func() { // An RFB 3.3 server without authentication
+		buf := make([]byte, 12)
+		server.Write([]byte("RFB 003.003\n"))
+		if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "RFB 003.003\n" {
+			errs <- fmt.Errorf("Client answered %q (%v)", buf, err)
+			return
+		}
+		server.Write([]byte{0, 0, 0, byte(gorfb.SecNone)})
+		if _, err := io.ReadFull(server, buf[:1]); err != nil { // Shared flag
+			errs <- err
+			return
+		}
+		init, _ := gorfb.NewMessageWriter(28).Uint16(4).Uint16(2).Uint8(32).Uint8(24).Uint8(0).Uint8(1).
+			Uint16(255).Uint16(255).Uint16(255).Uint8(16).Uint8(8).Uint8(0).Padding(3).Uint32(4).Data([]byte("3.3!")).Bytes()
+		server.Write(init)
+		errs <- nil
+	}()
+	c := client.NewClient(conn)
+	defer c.Close()
+	if err := c.Handshake(true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if c.Width != 4 || c.Height != 2 || c.Name != "3.3!" {
+		t.Errorf("ServerInit gave %dx%d %q", c.Width, c.Height, c.Name)
+	}
+}
@@ -0,0 +1,485 @@
+// gorfb project client/decode.go
+// Decoding the rectangles of framebuffer updates
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/internal/tile"
+)
+
+// Pixels decodes the rectangle, its pixels are returned in pf (the client's pixel format) row by row
+func (r Rectangle) Pixels(pf gorfb.PixelFormat) ([]byte, error) {
+	bpp := pf.BytesPerPixel()
+	out := make([]byte, r.Width*r.Height*bpp)
+	switch r.Encoding {
+	case gorfb.EncRaw:
+		if len(r.Data) != len(out) {
+			return nil, fmt.Errorf("Raw rectangle has %d bytes instead of %d", len(r.Data), len(out))
+		}
+		copy(out, r.Data)
+	case gorfb.EncCoRRE:
+		rd := gorfb.NewMessageReader(r.Data)
+		n := int(rd.Uint32())
+		fill(out, r.Width, 0, 0, r.Width, r.Height, rd.Data(bpp))
+		for ; n > 0 && rd.Err() == nil; n-- {
+			pixel := rd.Data(bpp)
+			x, y, w, h := int(rd.Uint8()), int(rd.Uint8()), int(rd.Uint8()), int(rd.Uint8())
+			if x+w > r.Width || y+h > r.Height {
+				return nil, fmt.Errorf("CoRRE subrectangle %dx%d at %d,%d outside the %dx%d rectangle", w, h, x, y,
+					r.Width, r.Height)
+			}
+			fill(out, r.Width, x, y, w, h, pixel)
+		}
+		if err := rd.Err(); err != nil {
+			return nil, err
+		}
+	case gorfb.EncHextile:
+		if err := decodeHextile(out, r.Width, r.Height, bpp, gorfb.NewMessageReader(r.Data)); err != nil {
+			return nil, err
+		}
+	case gorfb.EncZlib: // Decompressed when it was read
+		if len(r.Inflated) != len(out) {
+			return nil, fmt.Errorf("Zlib rectangle has %d bytes of pixels instead of %d", len(r.Inflated), len(out))
+		}
+		copy(out, r.Inflated)
+	case gorfb.EncTight: // The pixel data of basic compression was decompressed when it was read
+		if err := decodeTight(out, r.Width, r.Height, pf, r.Data, r.Inflated); err != nil {
+			return nil, err
+		}
+	case gorfb.EncZRLE: // The tiles were decompressed when they were read
+		if err := decodeZRLE(out, r.Width, r.Height, pf, gorfb.NewMessageReader(r.Inflated)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Can not decode encoding %s", r.Encoding)
+	}
+	return out, nil
+}
+
+// fill sets the pixels of a w x h area at x,y of buf, which is width pixels wide, to pixel
+func fill(buf []byte, width, x, y, w, h int, pixel []byte) {
+	bpp := len(pixel)
+	if bpp == 0 {
+		return
+	}
+	for yy := y; yy < y+h; yy++ {
+		for xx := x; xx < x+w; xx++ {
+			copy(buf[(yy*width+xx)*bpp:], pixel)
+		}
+	}
+}
+
+// readHextile reads the tiles of a width x height Hextile rectangle
+func readHextile(r *recorder, width, height, bpp int) ([]byte, error) {
+	var data []byte
+	read := func(n int) error {
+		buf, err := r.read(n)
+		data = append(data, buf...)
+		return err
+	}
+	for _, t := range tile.Split(image.Rect(0, 0, width, height), tile.HextileSize, tile.HextileSize) {
+		if err := read(1); err != nil {
+			return nil, err
+		}
+		flags := data[len(data)-1]
+		if flags&tile.HextileRaw != 0 {
+			if err := read(t.Dx() * t.Dy() * bpp); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		n := 0
+		if flags&tile.HextileBackground != 0 {
+			n += bpp
+		}
+		if flags&tile.HextileForeground != 0 {
+			n += bpp
+		}
+		if err := read(n); err != nil {
+			return nil, err
+		}
+		if flags&tile.HextileAnySubrects == 0 {
+			continue
+		}
+		if err := read(1); err != nil {
+			return nil, err
+		}
+		n = 2 * int(data[len(data)-1])
+		if flags&tile.HextileSubrectsColoured != 0 {
+			n += bpp * int(data[len(data)-1])
+		}
+		if err := read(n); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// decodeHextile decodes the tiles read by rd into buf, which is width pixels wide
+func decodeHextile(buf []byte, width, height, bpp int, rd *gorfb.MessageReader) error {
+	var bg, fg []byte
+	for _, t := range tile.Split(image.Rect(0, 0, width, height), tile.HextileSize, tile.HextileSize) {
+		x, y, w, h := t.Min.X, t.Min.Y, t.Dx(), t.Dy()
+		flags := rd.Uint8()
+		if flags&tile.HextileRaw != 0 {
+			pixels := rd.Data(w * h * bpp)
+			for row := 0; row < h && pixels != nil; row++ {
+				copy(buf[((y+row)*width+x)*bpp:], pixels[row*w*bpp:(row+1)*w*bpp])
+			}
+			bg, fg = nil, nil
+			continue
+		}
+		if flags&tile.HextileBackground != 0 {
+			bg = rd.Data(bpp)
+		}
+		if bg == nil {
+			return fmt.Errorf("Hextile tile at %d,%d without a background", x, y)
+		}
+		fill(buf, width, x, y, w, h, bg)
+		if flags&tile.HextileForeground != 0 {
+			fg = rd.Data(bpp)
+		}
+		if flags&tile.HextileAnySubrects == 0 {
+			continue
+		}
+		for n := int(rd.Uint8()); n > 0 && rd.Err() == nil; n-- {
+			pixel := fg
+			if flags&tile.HextileSubrectsColoured != 0 {
+				pixel = rd.Data(bpp)
+			} else if fg == nil {
+				return fmt.Errorf("Hextile tile at %d,%d without a foreground", x, y)
+			}
+			sx, sy, sw, sh := tile.UnpackSubrect(rd.Uint8(), rd.Uint8())
+			if sx+sw > w || sy+sh > h {
+				return fmt.Errorf("Hextile subrectangle %dx%d at %d,%d outside the %dx%d tile at %d,%d", sw, sh, sx, sy,
+					w, h, x, y)
+			}
+			fill(buf, width, x+sx, y+sy, sw, sh, pixel)
+		}
+		if flags&tile.HextileSubrectsColoured != 0 {
+			fg = nil
+		}
+	}
+	return rd.Err()
+}
+
+// cpixel returns the number of bytes of the compressed pixels of ZRLE in pf, and the shift of their value if they
+// are the most significant 3 bytes of the pixels
+func cpixel(pf gorfb.PixelFormat) (int, uint) {
+	mask := uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+	return tile.CPixel(pf.BytesPerPixel(), int(pf.Depth), pf.TrueColor == 1, mask)
+}
+
+// readZRLE reads the tiles of a width x height ZRLE rectangle, cpp is the number of bytes per compressed pixel
+func readZRLE(r *recorder, width, height, cpp int) error {
+	next := func() (byte, error) {
+		buf, err := r.read(1)
+		if err != nil {
+			return 0, err
+		}
+		return buf[0], nil
+	}
+	for _, t := range tile.Split(image.Rect(0, 0, width, height), tile.ZRLESize, tile.ZRLESize) {
+		w, h := t.Dx(), t.Dy()
+		b, err := next()
+		if err != nil {
+			return err
+		}
+		sub := int(b)
+		switch {
+		case sub == tile.ZRLERaw:
+			_, err = r.read(w * h * cpp)
+		case sub == tile.ZRLESolid:
+			_, err = r.read(cpp)
+		case sub <= tile.ZRLEMaxPacked:
+			_, err = r.read(sub*cpp + h*tile.PackedRowSize(w, tile.PaletteBits(sub)))
+		case sub == tile.ZRLEPlainRLE:
+			for n := 0; n < w*h && err == nil; {
+				var l int
+				if _, err = r.read(cpp); err == nil {
+					l, err = tile.ReadRunLength(next)
+					n += l
+				}
+			}
+		case sub >= tile.ZRLEPaletteRLE+2:
+			_, err = r.read((sub - tile.ZRLEPaletteRLE) * cpp)
+			for n := 0; n < w*h && err == nil; {
+				if b, err = next(); err == nil {
+					l := 1
+					if b&128 != 0 {
+						l, err = tile.ReadRunLength(next)
+					}
+					n += l
+				}
+			}
+		default:
+			return fmt.Errorf("Unknown ZRLE sub-encoding %d", sub)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeZRLE decodes the tiles read by rd into buf, which is width pixels wide, in pf
+func decodeZRLE(buf []byte, width, height int, pf gorfb.PixelFormat, rd *gorfb.MessageReader) error {
+	bpp := pf.BytesPerPixel()
+	cpp, shift := cpixel(pf)
+	// pixel reads a compressed pixel and returns it in pf
+	pixel := func() []byte {
+		data := rd.Data(cpp)
+		if cpp == bpp || data == nil {
+			return data
+		}
+		var val uint32
+		for i := 0; i < cpp; i++ {
+			if pf.BigEndian == 1 {
+				val = val<<8 | uint32(data[i])
+			} else {
+				val |= uint32(data[i]) << (8 * i)
+			}
+		}
+		return pixelBytes(pf, val<<shift)
+	}
+	runLength := func() int {
+		n, _ := tile.ReadRunLength(func() (byte, error) { // Errors are left in rd
+			b := rd.Uint8()
+			return b, rd.Err()
+		})
+		return n
+	}
+	for _, t := range tile.Split(image.Rect(0, 0, width, height), tile.ZRLESize, tile.ZRLESize) {
+		x, y, w, h := t.Min.X, t.Min.Y, t.Dx(), t.Dy()
+		// set sets the i-th pixel of the tile
+		set := func(i int, p []byte) {
+			fill(buf, width, x+i%w, y+i/w, 1, 1, p)
+		}
+		sub := int(rd.Uint8())
+		var palette [][]byte
+		if (sub >= 2 && sub <= tile.ZRLEMaxPacked) || sub >= tile.ZRLEPaletteRLE+2 {
+			for n := sub &^ tile.ZRLEPaletteRLE; n > 0; n-- {
+				palette = append(palette, pixel())
+			}
+		}
+		switch {
+		case sub == tile.ZRLERaw:
+			for i := 0; i < w*h; i++ {
+				set(i, pixel())
+			}
+		case sub == tile.ZRLESolid:
+			fill(buf, width, x, y, w, h, pixel())
+		case sub <= tile.ZRLEMaxPacked:
+			bits := tile.PaletteBits(sub)
+			for row := 0; row < h; row++ {
+				data := rd.Data(tile.PackedRowSize(w, bits))
+				for col := 0; col < w && data != nil; col++ {
+					idx := tile.Unpack(data, bits, col)
+					if idx >= len(palette) {
+						return fmt.Errorf("ZRLE palette index %d of %d colours", idx, len(palette))
+					}
+					set(row*w+col, palette[idx])
+				}
+			}
+		case sub == tile.ZRLEPlainRLE:
+			for i := 0; i < w*h && rd.Err() == nil; {
+				p := pixel()
+				n := runLength()
+				if i+n > w*h {
+					return fmt.Errorf("ZRLE run of %d pixels past the %dx%d tile at %d,%d", n, w, h, x, y)
+				}
+				for ; n > 0; n-- {
+					set(i, p)
+					i++
+				}
+			}
+		case sub >= tile.ZRLEPaletteRLE+2:
+			for i := 0; i < w*h && rd.Err() == nil; {
+				idx := int(rd.Uint8())
+				n := 1
+				if idx&128 != 0 {
+					idx &^= 128
+					n = runLength()
+				}
+				if idx >= len(palette) || i+n > w*h {
+					return fmt.Errorf("ZRLE palette run of %d pixels of colour %d past the %dx%d tile at %d,%d", n, idx,
+						w, h, x, y)
+				}
+				for ; n > 0; n-- {
+					set(i, palette[idx])
+					i++
+				}
+			}
+		default:
+			return fmt.Errorf("Unknown ZRLE sub-encoding %d", sub)
+		}
+		if err := rd.Err(); err != nil {
+			return err
+		}
+	}
+	return rd.Err()
+}
+
+// pixelBytes returns the pixel val in pf
+func pixelBytes(pf gorfb.PixelFormat, val uint32) []byte {
+	bpp := pf.BytesPerPixel()
+	out := make([]byte, bpp)
+	for i := 0; i < bpp; i++ {
+		if pf.BigEndian == 1 {
+			out[bpp-1-i] = byte(val >> (8 * i))
+		} else {
+			out[i] = byte(val >> (8 * i))
+		}
+	}
+	return out
+}
+
+// tpixelSize returns the number of bytes of the pixels of Tight in pf, 3 with 32 bits per pixel and 8 bits per colour
+func tpixelSize(pf gorfb.PixelFormat) int {
+	return tile.TPixelSize(pf.BytesPerPixel(), int(pf.Depth), pf.TrueColor == 1, pf.RedMax, pf.GreenMax, pf.BlueMax)
+}
+
+// decodeTight decodes the Tight rectangle data into buf, which is width pixels wide, in pf
+// pixels is the pixel data of basic compression, decompressed
+func decodeTight(buf []byte, width, height int, pf gorfb.PixelFormat, data, pixels []byte) error {
+	tp := tpixelSize(pf)
+	// tpixel returns the Tight pixel p in pf
+	tpixel := func(p []byte) []byte {
+		if tp != 3 {
+			return p
+		}
+		val := uint32(p[0])<<pf.RedShift | uint32(p[1])<<pf.GreenShift | uint32(p[2])<<pf.BlueShift
+		return pixelBytes(pf, val)
+	}
+	rd := gorfb.NewMessageReader(data)
+	ctl := rd.Uint8() >> 4
+	if ctl == 9 { // JPEG
+		length := 0
+		for i := 0; i < 3; i++ {
+			b := int(rd.Uint8())
+			if i == 2 {
+				length |= b << 14
+				break
+			}
+			length |= b & 0x7f << (7 * i)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		img, err := jpeg.Decode(bytes.NewReader(rd.Data(length)))
+		if err != nil {
+			return err
+		}
+		if img.Bounds().Dx() != width || img.Bounds().Dy() != height {
+			return fmt.Errorf("Tight JPEG image is %dx%d instead of %dx%d", img.Bounds().Dx(), img.Bounds().Dy(), width,
+				height)
+		}
+		copy(buf, gorfb.ImageToPixels(img, img.Bounds(), pf))
+		return nil
+	}
+	if ctl == 8 { // Fill
+		p := rd.Data(tp)
+		if p == nil {
+			return rd.Err()
+		}
+		fill(buf, width, 0, 0, width, height, tpixel(p))
+		return nil
+	}
+	var palette [][]byte
+	if ctl&4 != 0 {
+		switch filter := rd.Uint8(); filter {
+		case 0:
+		case 2:
+			ungradient(pixels, width, height, pf, tp)
+		case 1:
+			for n := int(rd.Uint8()) + 1; n > 0 && rd.Err() == nil; n-- {
+				palette = append(palette, tpixel(rd.Data(tp)))
+			}
+		default:
+			return fmt.Errorf("Can not decode Tight filter %d", filter)
+		}
+	}
+	if err := rd.Err(); err != nil {
+		return err
+	}
+	switch {
+	case palette == nil:
+		for i := 0; i < width*height; i++ {
+			fill(buf, width, i%width, i/width, 1, 1, tpixel(pixels[i*tp:(i+1)*tp]))
+		}
+	case len(palette) == 2:
+		stride := tile.PackedRowSize(width, 1)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				fill(buf, width, x, y, 1, 1, palette[tile.Unpack(pixels[y*stride:], 1, x)])
+			}
+		}
+	default:
+		for i, idx := range pixels {
+			if int(idx) >= len(palette) {
+				return fmt.Errorf("Tight palette index %d of %d colours", idx, len(palette))
+			}
+			fill(buf, width, i%width, i/width, 1, 1, palette[idx])
+		}
+	}
+	return nil
+}
+
+// ungradient undoes the gradient filter of Tight in pixels (width x height Tight pixels of tp bytes): every colour
+// component was sent as its difference with left + above - above left, clamped to the component's range, taking
+// the components outside the rectangle as 0
+func ungradient(pixels []byte, width, height int, pf gorfb.PixelFormat, tp int) {
+	max := [3]int{int(pf.RedMax), int(pf.GreenMax), int(pf.BlueMax)}
+	shift := [3]uint{uint(pf.RedShift), uint(pf.GreenShift), uint(pf.BlueShift)}
+	if tp == 3 { // Red, green and blue bytes
+		max, shift = [3]int{255, 255, 255}, [3]uint{16, 8, 0}
+	}
+	get := func(i int) uint32 {
+		var val uint32
+		for j := 0; j < tp; j++ {
+			if tp == 3 || pf.BigEndian == 1 {
+				val = val<<8 | uint32(pixels[i*tp+j])
+			} else {
+				val |= uint32(pixels[i*tp+j]) << (8 * uint(j))
+			}
+		}
+		return val
+	}
+	put := func(i int, val uint32) {
+		for j := 0; j < tp; j++ {
+			if tp == 3 || pf.BigEndian == 1 {
+				pixels[i*tp+tp-1-j] = byte(val >> (8 * uint(j)))
+			} else {
+				pixels[i*tp+j] = byte(val >> (8 * uint(j)))
+			}
+		}
+	}
+	// component returns colour component c of the pixel at x,y, 0 outside the rectangle
+	component := func(x, y, c int) int {
+		if x < 0 || y < 0 {
+			return 0
+		}
+		return int(get(y*width+x)>>shift[c]) & max[c]
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			diff := get(y*width + x)
+			var val uint32
+			for c := 0; c < 3; c++ {
+				predicted := component(x-1, y, c) + component(x, y-1, c) - component(x-1, y-1, c)
+				if predicted < 0 {
+					predicted = 0
+				} else if predicted > max[c] {
+					predicted = max[c]
+				}
+				val |= uint32((predicted+int(diff>>shift[c]))&max[c]) << shift[c]
+			}
+			put(y*width+x, val)
+		}
+	}
+}
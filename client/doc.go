@@ -0,0 +1,9 @@
+// gorfb project client/doc.go
+// Package client is an RFB client built on gorfb's protocol types
+//
+// A Client performs the RFB 3.3, 3.7 or 3.8 handshake (no authentication or VNC authentication) with any server,
+// sends the client messages and parses the server's messages in the background, decoding the rectangles of Raw,
+// CopyRect, CoRRE, Hextile, Zlib, Tight and ZRLE updates. Changes delivers the decoded areas of the updates, and a
+// Viewer keeps an image of the framebuffer up to date for GUIs that show it and forward the user's input.
+// The rfbtest package uses it to drive servers in tests.
+package client
@@ -0,0 +1,119 @@
+// gorfb project client/fence.go
+// Continuous updates and fences, with which clients of servers that support them avoid waiting for updates they
+// requested and measure the round trip time
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// clientFenceFlags are the fence flags the client supports, it handles messages in order so it can always block
+const clientFenceFlags = gorfb.FenceBlockBefore | gorfb.FenceBlockAfter
+
+// SendEnableContinuousUpdates enables continuous updates of an area, or disables them
+func (c *Client) SendEnableContinuousUpdates(enable bool, x, y, width, height int) error {
+	w := gorfb.NewMessageWriter(10).Uint8(uint8(gorfb.MsgEnableContinuousUpdates)).Uint8(flag(enable))
+	return c.sendMessage(w.Uint16(uint16(x)).Uint16(uint16(y)).Uint16(uint16(width)).Uint16(uint16(height)))
+}
+
+// EnableContinuousUpdates asks the server to send updates of an area as it changes, without update requests
+// The server must have shown that it supports continuous updates, see ContinuousUpdates.
+func (c *Client) EnableContinuousUpdates(x, y, width, height int) error {
+	if !c.ContinuousUpdates() {
+		return errors.New("Server does not support continuous updates")
+	}
+	return c.SendEnableContinuousUpdates(true, x, y, width, height)
+}
+
+// ContinuousUpdates reports if the server sent an EndOfContinuousUpdates message, which servers that support
+// continuous updates do when the client lists the ContinuousUpdates pseudo-encoding
+func (c *Client) ContinuousUpdates() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.continuous
+}
+
+// SendFence sends the server a fence, payload can be up to 64 bytes
+func (c *Client) SendFence(flags uint32, payload []byte) error {
+	w := gorfb.NewMessageWriter(9 + len(payload)).Uint8(uint8(gorfb.MsgClientFence)).Padding(3).Uint32(flags)
+	return c.sendMessage(w.Uint8(uint8(len(payload))).Data(payload))
+}
+
+// Fences reports if the server sent a fence request, which servers that support fences do when the client lists
+// the Fence pseudo-encoding
+func (c *Client) Fences() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fences
+}
+
+// RoundTrip sends the server a fence request and returns how long it took to receive the response
+// The server answers after handling the messages sent before the fence. The response is not delivered as a message.
+func (c *Client) RoundTrip(ctx context.Context) (time.Duration, error) {
+	if !c.Fences() {
+		return 0, errors.New("Server does not support fences")
+	}
+	c.mu.Lock()
+	c.pingSeq++
+	payload := fmt.Sprintf("rtt%d", c.pingSeq)
+	done := make(chan struct{})
+	if c.pings == nil {
+		c.pings = make(map[string]chan struct{})
+	}
+	c.pings[payload] = done
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pings, payload)
+		c.mu.Unlock()
+	}()
+	start := time.Now()
+	if err := c.SendFence(gorfb.FenceRequest|gorfb.FenceBlockBefore, []byte(payload)); err != nil {
+		return 0, err
+	}
+	select {
+	case <-done:
+		return time.Since(start), nil
+	case <-c.done:
+		return 0, c.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// syncMessage notes what a message shows of the server's support for continuous updates and fences, answers fence
+// requests if the client does that and reports if msg was the response to a RoundTrip, which is not delivered
+func (c *Client) syncMessage(msg *Message) bool {
+	switch msg.Type {
+	case gorfb.MsgEndOfContinuousUpdates:
+		c.mu.Lock()
+		c.continuous = true
+		c.mu.Unlock()
+	case gorfb.MsgServerFence:
+		if msg.FenceFlags&gorfb.FenceRequest != 0 {
+			c.mu.Lock()
+			c.fences = true
+			c.mu.Unlock()
+			// Not written from the reading goroutine, the server may be waiting for its output to be read. A failed
+			// write also fails reading, which reports the error.
+			if c.AnswerFences {
+				go c.SendFence(msg.FenceFlags&clientFenceFlags, msg.FencePayload)
+			}
+			return false
+		}
+		c.mu.Lock()
+		done, ok := c.pings[string(msg.FencePayload)]
+		delete(c.pings, string(msg.FencePayload))
+		c.mu.Unlock()
+		if ok {
+			close(done)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// gorfb project client/keys.go
+// Typing text as key events the way a user at the client's keyboard would
+package client
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Keysyms of the keys TypeText presses besides those of the characters
+const (
+	keysymBackSpace = 0xff08
+	keysymTab       = 0xff09
+	keysymReturn    = 0xff0d
+	keysymShiftL    = 0xffe1
+)
+
+// shifted are the characters typed with shift on a US keyboard, besides the upper case letters
+const shifted = `~!@#$%^&*()_+{}|:"<>?`
+
+// TypeText types s as key presses and releases, waiting delay after each character
+// Upper case letters and the symbols above the digits are typed with shift held, as servers that map keysyms to
+// key codes expect. Characters outside Latin-1 are sent as Unicode keysyms. TypeText stops when ctx is done.
+func (c *Client) TypeText(ctx context.Context, s string, delay time.Duration) error {
+	for i, ch := range []rune(s) {
+		if i > 0 && delay > 0 {
+			t := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return ctx.Err()
+			case <-t.C:
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.typeRune(ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// typeRune sends the key events of a single character
+func (c *Client) typeRune(ch rune) error {
+	shift := ch >= 'A' && ch <= 'Z' || strings.ContainsRune(shifted, ch)
+	if shift {
+		if err := c.SendKey(keysymShiftL, true); err != nil {
+			return err
+		}
+	}
+	keysym := runeKeysym(ch)
+	if err := c.SendKey(keysym, true); err != nil {
+		return err
+	}
+	if err := c.SendKey(keysym, false); err != nil {
+		return err
+	}
+	if shift {
+		return c.SendKey(keysymShiftL, false)
+	}
+	return nil
+}
+
+// runeKeysym returns the keysym of a character: control keys for newline, tab and backspace, Latin-1 characters
+// as themselves and anything else as a Unicode keysym
+func runeKeysym(ch rune) int {
+	switch {
+	case ch == '\n' || ch == '\r':
+		return keysymReturn
+	case ch == '\t':
+		return keysymTab
+	case ch == '\b':
+		return keysymBackSpace
+	case ch >= 0x20 && ch <= 0x7e || ch >= 0xa0 && ch <= 0xff:
+		return int(ch)
+	}
+	return 0x01000000 | int(ch)
+}
@@ -0,0 +1,183 @@
+// gorfb project client/viewer.go
+// A copy of the server's framebuffer kept up to date by a client, for GUIs that show it as an image and forward
+// the user's input
+package client
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"sync"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// viewerEncodings are the encodings the viewer asks for, all of which the client decodes, and DesktopSize
+var viewerEncodings = []gorfb.Encoding{gorfb.EncTight, gorfb.EncZRLE, gorfb.EncHextile, gorfb.EncZlib, gorfb.EncCoRRE,
+	gorfb.EncRaw, gorfb.EncDesktopSize}
+
+// Viewer keeps an image of the server's framebuffer, requesting updates as soon as the previous one was applied
+// A Viewer is an image.Image that can be drawn while it is updated, Snapshot returns a copy for longer use.
+type Viewer struct {
+	c       *Client
+	mu      sync.RWMutex
+	img     *image.RGBA
+	changed chan image.Rectangle
+	err     error // Why updating stopped
+}
+
+// NewViewer takes over the messages of c, which must not be read with Next or Changes any more, and keeps the
+// viewer's image up to date until ctx is done or the connection fails
+// The client is switched to a pixel format and encodings it can decode.
+func NewViewer(ctx context.Context, c *Client) (*Viewer, error) {
+	v := &Viewer{c: c, img: image.NewRGBA(image.Rect(0, 0, c.Width, c.Height)), changed: make(chan image.Rectangle, 1)}
+	if err := c.SendSetPixelFormat(rgbaFormat); err != nil {
+		return nil, err
+	}
+	if err := c.SendSetEncodings(viewerEncodings...); err != nil {
+		return nil, err
+	}
+	if err := c.SendUpdateRequest(0, 0, c.Width, c.Height, false); err != nil {
+		return nil, err
+	}
+	go v.run(ctx)
+	return v, nil
+}
+
+// run applies the updates and requests the next ones until ctx is done or the connection fails
+func (v *Viewer) run(ctx context.Context) {
+	defer close(v.changed)
+	for {
+		select {
+		case <-ctx.Done():
+			v.stop(ctx.Err())
+			return
+		case msg, ok := <-v.c.msgs:
+			if !ok {
+				v.stop(v.c.err)
+				return
+			}
+			if msg.Type != gorfb.MsgFramebufferUpdate {
+				continue
+			}
+			changed, err := v.apply(msg.Rectangles)
+			if err != nil {
+				v.stop(err)
+				return
+			}
+			if !changed.Empty() {
+				v.notify(changed)
+			}
+			b := v.Bounds()
+			if err := v.c.SendUpdateRequest(0, 0, b.Dx(), b.Dy(), true); err != nil {
+				v.stop(err)
+				return
+			}
+		}
+	}
+}
+
+// apply draws the rectangles of an update into the image and returns the area they changed
+func (v *Viewer) apply(rects []Rectangle) (image.Rectangle, error) {
+	var changed image.Rectangle
+	for _, r := range rects {
+		if r.Encoding == gorfb.EncDesktopSize { // The framebuffer is cleared
+			v.mu.Lock()
+			v.img = image.NewRGBA(image.Rect(0, 0, r.Width, r.Height))
+			v.mu.Unlock()
+			changed = changed.Union(image.Rect(0, 0, r.Width, r.Height))
+			continue
+		}
+		if r.Encoding < 0 {
+			continue
+		}
+		img, err := r.Image()
+		if err != nil {
+			return changed, err
+		}
+		v.mu.Lock()
+		b := img.Rect.Intersect(v.img.Rect)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			copy(v.img.Pix[v.img.PixOffset(b.Min.X, y):v.img.PixOffset(b.Max.X, y)],
+				img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)])
+		}
+		v.mu.Unlock()
+		changed = changed.Union(b)
+	}
+	return changed, nil
+}
+
+// notify sends the changed area, merged with one that was not received yet
+func (v *Viewer) notify(r image.Rectangle) {
+	select {
+	case old := <-v.changed:
+		r = r.Union(old)
+	default:
+	}
+	v.changed <- r
+}
+
+// stop records why updating stopped
+func (v *Viewer) stop(err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.err = err
+}
+
+// Changed returns a channel receiving the areas of the image that changed, areas that were not received before the
+// next change are merged into it. The channel is closed when updating stopped, see Err.
+func (v *Viewer) Changed() <-chan image.Rectangle {
+	return v.changed
+}
+
+// Err returns why updating stopped, nil while it goes on
+func (v *Viewer) Err() error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.err
+}
+
+// ColorModel returns the colour model of the image
+func (v *Viewer) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds returns the size of the framebuffer
+func (v *Viewer) Bounds() image.Rectangle {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.img.Rect
+}
+
+// At returns the colour of the pixel at x,y
+func (v *Viewer) At(x, y int) color.Color {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.img.RGBAAt(x, y)
+}
+
+// Snapshot returns a copy of the image
+func (v *Viewer) Snapshot() *image.RGBA {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	img := *v.img
+	img.Pix = append([]byte(nil), v.img.Pix...)
+	return &img
+}
+
+// SendPointer sends a pointer event for the position p of a view showing the framebuffer scaled to view
+func (v *Viewer) SendPointer(view image.Rectangle, p image.Point, buttons int) error {
+	b := v.Bounds()
+	if view.Empty() || b.Empty() {
+		return nil
+	}
+	p = p.Sub(view.Min)
+	x := min(max(p.X*b.Dx()/view.Dx(), 0), b.Dx()-1)
+	y := min(max(p.Y*b.Dy()/view.Dy(), 0), b.Dy()-1)
+	return v.c.SendPointer(x, y, buttons)
+}
+
+// SendKey sends a key press or release
+func (v *Viewer) SendKey(keysym int, down bool) error {
+	return v.c.SendKey(keysym, down)
+}
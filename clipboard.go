@@ -0,0 +1,83 @@
+// gorfb project clipboard.go
+// Policies controlling the flow of cut text between server and client
+package gorfb
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrCutTextNotAllowed is returned by SendCutText when the clipboard policy does not allow the text to be sent
+var ErrCutTextNotAllowed = errors.New("Cut text not allowed by clipboard policy")
+
+// ClipboardPolicy controls the cut text that may flow between server and client
+// The zero value allows everything
+type ClipboardPolicy struct {
+	// Ignore any cut text sent by the client
+	DisableClientToServer bool
+	// Do not send any cut text to the client
+	DisableServerToClient bool
+	// Maximum size in bytes of cut text accepted from the client (0 for no limit)
+	MaxClientToServer int
+	// Maximum size in bytes of cut text sent to the client (0 for no limit)
+	MaxServerToClient int
+	// Filter is called with all cut text before it is passed on (fromClient is true for text from the client)
+	// It returns the text to pass on, or false if the text must be dropped
+	Filter func(conn *RFBConn, text string, fromClient bool) (string, bool)
+}
+
+// SetClipboardPolicy overrides the server's clipboard policy for this connection
+// A nil policy reverts to the server's policy
+func (fb *RFBConn) SetClipboardPolicy(policy *ClipboardPolicy) {
+	fb.mu.Lock()
+	fb.clipPolicy = policy
+	fb.mu.Unlock()
+}
+
+// clipboardPolicy returns the clipboard policy in effect for the connection
+func (fb *RFBConn) clipboardPolicy() *ClipboardPolicy {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if fb.clipPolicy != nil {
+		return fb.clipPolicy
+	}
+	return &fb.Server.Clipboard
+}
+
+// allowed reports if cut text of sz bytes may be transferred in the given direction
+func (cp *ClipboardPolicy) allowed(fromClient bool, sz int) bool {
+	if fromClient {
+		return !cp.DisableClientToServer && (cp.MaxClientToServer <= 0 || sz <= cp.MaxClientToServer)
+	}
+	return !cp.DisableServerToClient && (cp.MaxServerToClient <= 0 || sz <= cp.MaxServerToClient)
+}
+
+// filter applies the policy's filter to text, returning false if the text must be dropped
+func (cp *ClipboardPolicy) filter(conn *RFBConn, text string, fromClient bool) (string, bool) {
+	if cp.Filter == nil {
+		return text, true
+	}
+	return cp.Filter(conn, text, fromClient)
+}
+
+// discardCutText skips sz bytes of cut text the client sent that the policy does not allow
+func (fb *RFBConn) discardCutText(sz int) error {
+	fb.logf("Client cut text of %d bytes rejected by clipboard policy\n", sz)
+	_, err := io.CopyN(io.Discard, fb.Conn, int64(sz))
+	return err
+}
+
+// deliverCutText applies the clipboard policy to text received from the client and passes it on to the handler
+func (fb *RFBConn) deliverCutText(text string) {
+	policy := fb.clipboardPolicy()
+	if !policy.allowed(true, len(text)) {
+		fb.logf("Client cut text of %d bytes rejected by clipboard policy\n", len(text))
+		return
+	}
+	if text, ok := policy.filter(fb, text, true); ok {
+		if fb.Server.ClipboardBridge != nil {
+			fb.setBridgeText(text)
+		}
+		fb.dispatch(inputQueue, func() { fb.CurrentScreen().Handler.ProcessCutText(fb, text) })
+	}
+}
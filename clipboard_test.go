@@ -0,0 +1,28 @@
+package gorfb
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSetClipboardPolicyConcurrently(t *testing.T) {
+	fb, _ := fuzzConn(nil, false)
+	deny := &ClipboardPolicy{DisableClientToServer: true}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // Policy changed by the application while the client's cut text is checked
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			fb.SetClipboardPolicy(deny)
+			fb.SetClipboardPolicy(nil)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		fb.clipboardPolicy().allowed(true, 10)
+	}
+	wg.Wait()
+	fb.SetClipboardPolicy(deny)
+	if fb.clipboardPolicy().allowed(true, 10) {
+		t.Error("Cut text allowed by the connection's policy denying it")
+	}
+}
@@ -0,0 +1,183 @@
+// gorfb project cmd/gorfb-serve/main.go
+// gorfb-serve serves an image, a slideshow of the images in a directory or a demo pattern over VNC
+//
+// Usage:
+//
+//	gorfb-serve [-port 5900] [-password pw] [-tls-cert cert.pem -tls-key key.pem] [-encodings raw]
+//	            [-image file | -dir directory [-interval 5s] | -demo bars|ball|clock [-fps 25]] [-size 800x600]
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hduplooy/gorfb/demo"
+	"github.com/hduplooy/gorfb/media"
+)
+
+// encodings are the encodings that can be selected with -encodings
+var encodings = map[string]int{"raw": 0}
+
+func main() {
+	port := flag.String("port", "5900", "port to listen on")
+	password := flag.String("password", "", "require VNC authentication with this password")
+	certFile := flag.String("tls-cert", "", "certificate file, serve over TLS")
+	keyFile := flag.String("tls-key", "", "key file of the TLS certificate")
+	encList := flag.String("encodings", "raw", "comma separated encodings to use (only raw is implemented)")
+	imageFile := flag.String("image", "", "serve this image")
+	dir := flag.String("dir", "", "serve a slideshow of the images in this directory")
+	interval := flag.Duration("interval", 5*time.Second, "time each slideshow image is shown")
+	pattern := flag.String("demo", "", "serve a demo pattern: bars, ball or clock")
+	fps := flag.Float64("fps", 25, "frames per second of the demo pattern")
+	size := flag.String("size", "", "framebuffer size as WIDTHxHEIGHT (the first image's size or 640x480 if not set)")
+	flag.Parse()
+
+	for _, name := range strings.Split(*encList, ",") {
+		if _, ok := encodings[strings.TrimSpace(name)]; !ok {
+			log.Fatalf("Unknown encoding %q\n", name)
+		}
+	}
+	var width, height int
+	if *size != "" {
+		if _, err := fmt.Sscanf(*size, "%dx%d", &width, &height); err != nil {
+			log.Fatalf("Invalid size %q\n", *size)
+		}
+	}
+	src, err := source(*imageFile, *dir, *pattern, *interval, *fps, width, height)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	rfb, err := media.NewServer(*port, src)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	rfb.BufferName = "gorfb-serve"
+	if *password != "" {
+		rfb.Authenticate, rfb.AuthText = true, *password
+	}
+	if *certFile == "" {
+		log.Fatalln(rfb.StartServer())
+	}
+	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	ln, err := net.Listen("tcp", ":"+*port)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Fatalln(rfb.Serve(tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})))
+}
+
+// source returns the frame source selected by the flags
+func source(imageFile, dir, pattern string, interval time.Duration, fps float64, width, height int) (media.FrameSource, error) {
+	var files []string
+	switch {
+	case pattern != "":
+		patterns := map[string]demo.Pattern{"bars": demo.ColorBars, "ball": demo.BouncingBall, "clock": demo.Clock}
+		p, ok := patterns[pattern]
+		if !ok {
+			return nil, fmt.Errorf("Unknown demo pattern %q", pattern)
+		}
+		if width == 0 {
+			width, height = 640, 480
+		}
+		return demo.NewSource(p, width, height, fps)
+	case imageFile != "":
+		files = []string{imageFile}
+	case dir != "":
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".png", ".jpg", ".jpeg", ".gif":
+				files = append(files, filepath.Join(dir, entry.Name()))
+			}
+		}
+		sort.Strings(files)
+		if len(files) == 0 {
+			return nil, errors.New("No images found in " + dir)
+		}
+	default:
+		return nil, errors.New("One of -image, -dir or -demo must be given")
+	}
+	return newSlideshow(files, interval, width, height)
+}
+
+// slideshow is a media.FrameSource showing images one after the other
+type slideshow struct {
+	frames   []*image.RGBA
+	interval time.Duration
+	next     int
+}
+
+// newSlideshow loads the images, fitting each onto a width x height frame (the first image's size if 0)
+func newSlideshow(files []string, interval time.Duration, width, height int) (*slideshow, error) {
+	ss := &slideshow{interval: interval}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		img, _, err := image.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err.Error())
+		}
+		if width == 0 {
+			width, height = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+		ss.frames = append(ss.frames, fit(img, width, height))
+	}
+	return ss, nil
+}
+
+// NextFrame returns the next image, waiting interval between images
+// A single image is returned once, after which io.EOF ends the source (leaving the image on the screen)
+func (ss *slideshow) NextFrame() (image.Image, time.Time, error) {
+	if ss.next > 0 {
+		if len(ss.frames) == 1 {
+			return nil, time.Time{}, io.EOF
+		}
+		time.Sleep(ss.interval)
+	}
+	img := ss.frames[ss.next%len(ss.frames)]
+	ss.next++
+	return img, time.Now(), nil
+}
+
+// fit scales img (nearest neighbour, keeping the aspect ratio) to fit centered on a black width x height frame
+func fit(img image.Image, width, height int) *image.RGBA {
+	frame := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(frame, frame.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	b := img.Bounds()
+	w, h := width, b.Dy()*width/b.Dx()
+	if h > height {
+		w, h = b.Dx()*height/b.Dy(), height
+	}
+	x0, y0 := (width-w)/2, (height-h)/2
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			frame.Set(x0+x, y0+y, img.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h))
+		}
+	}
+	return frame
+}
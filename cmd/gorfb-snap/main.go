@@ -0,0 +1,339 @@
+// gorfb project cmd/gorfb-snap/main.go
+// gorfb-snap takes screenshots of, types on, clicks on or measures any VNC server from the command line
+//
+// Usage:
+//
+//	gorfb-snap [-password pw] [-shared=false] [-timeout 10s] [-delay 20ms] host[:display|::port] command
+//
+// The commands are:
+//
+//	snap file.png|file.jpg   save a screenshot
+//	type text                type text on the server's keyboard
+//	key ctrl+alt+Delete      press a key combination (key names or hexadecimal keysyms joined with +)
+//	click x y [button]       click a mouse button (1 is the left button, the default)
+//	stats [10s]              show the server's details and the messages it sends in the time given
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/client"
+)
+
+// keysyms are the keysyms of the key names the key command knows besides single characters
+var keysyms = map[string]int{
+	"backspace": 0xff08, "tab": 0xff09, "return": 0xff0d, "enter": 0xff0d, "escape": 0xff1b, "esc": 0xff1b,
+	"delete": 0xffff, "del": 0xffff, "insert": 0xff63, "home": 0xff50, "end": 0xff57, "pageup": 0xff55,
+	"pagedown": 0xff56, "left": 0xff51, "up": 0xff52, "right": 0xff53, "down": 0xff54, "space": 0x20,
+	"shift": 0xffe1, "ctrl": 0xffe3, "control": 0xffe3, "alt": 0xffe9, "meta": 0xffe7, "super": 0xffeb,
+	"win": 0xffeb,
+}
+
+// statsEncodings are the encodings asked for by the stats command, everything the client can parse
+var statsEncodings = []gorfb.Encoding{gorfb.EncTight, gorfb.EncZRLE, gorfb.EncHextile, gorfb.EncZlib, gorfb.EncCoRRE,
+	gorfb.EncCopyRect, gorfb.EncRaw, gorfb.EncDesktopSize, gorfb.EncLastRect, gorfb.EncDesktopName, gorfb.EncFence}
+
+func main() {
+	password := flag.String("password", "", "password for VNC authentication")
+	shared := flag.Bool("shared", true, "share the desktop with other clients")
+	timeout := flag.Duration("timeout", 10*time.Second, "time to wait for the server")
+	delay := flag.Duration("delay", 20*time.Millisecond, "time between the characters typed")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gorfb-snap [flags] host[:display|::port] snap file|type text|key keys|click x y [button]|stats [duration]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	address, err := vncAddress(args[0])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	conn, err := net.DialTimeout("tcp", address, *timeout)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	c := client.NewClient(conn)
+	c.Timeout = *timeout
+	c.AnswerFences = true
+	if err := c.Handshake(*shared, *password); err != nil {
+		log.Fatalln(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	cmd, args := args[1], args[2:]
+	switch {
+	case cmd == "snap" && len(args) == 1:
+		err = snap(ctx, c, args[0])
+	case cmd == "type" && len(args) == 1:
+		err = c.TypeText(context.Background(), args[0], *delay)
+	case cmd == "key" && len(args) == 1:
+		err = pressKeys(c, args[0])
+	case cmd == "click" && (len(args) == 2 || len(args) == 3):
+		err = click(c, args)
+	case cmd == "stats" && len(args) <= 1:
+		d := 10 * time.Second
+		if len(args) == 1 {
+			if d, err = time.ParseDuration(args[0]); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		err = stats(c, d)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if cmd != "snap" && cmd != "stats" {
+		if err := handled(ctx, c); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// handled waits for the server to handle the messages sent so far, so that closing the connection loses no input
+// Servers that do not support fences are sent an update request, which is answered after the input was handled.
+func handled(ctx context.Context, c *client.Client) error {
+	if c.Fences() {
+		_, err := c.RoundTrip(ctx)
+		return err
+	}
+	if err := c.SendUpdateRequest(0, 0, 1, 1, false); err != nil {
+		return err
+	}
+	for {
+		msg, err := c.Next()
+		if err != nil || msg.Type == gorfb.MsgFramebufferUpdate {
+			return err
+		}
+	}
+}
+
+// vncAddress returns the network address of host:display (port 5900 + display, 0 if not given) or host::port
+// An IPv6 host is given in brackets.
+func vncAddress(arg string) (string, error) {
+	host, rest := arg, ""
+	if strings.HasPrefix(arg, "[") {
+		if i := strings.Index(arg, "]"); i > 0 {
+			host, rest = arg[1:i], arg[i+1:]
+		}
+	} else if i := strings.Index(arg, ":"); i >= 0 {
+		host, rest = arg[:i], arg[i:]
+	}
+	port := 5900
+	switch {
+	case strings.HasPrefix(rest, "::"):
+		p, err := strconv.Atoi(rest[2:])
+		if err != nil {
+			return "", fmt.Errorf("Invalid port in %q", arg)
+		}
+		port = p
+	case strings.HasPrefix(rest, ":"):
+		display, err := strconv.Atoi(rest[1:])
+		if err != nil {
+			return "", fmt.Errorf("Invalid display in %q", arg)
+		}
+		port += display
+	case rest != "":
+		return "", fmt.Errorf("Invalid address %q", arg)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// snap saves the framebuffer in file, as JPEG if its extension says so and as PNG otherwise
+func snap(ctx context.Context, c *client.Client, file string) error {
+	v, err := client.NewViewer(ctx, c)
+	if err != nil {
+		return err
+	}
+	if _, ok := <-v.Changed(); !ok {
+		return fmt.Errorf("No update received: %v", v.Err())
+	}
+	img := v.Snapshot()
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(f, img, nil)
+	default:
+		err = png.Encode(f, img)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pressKeys presses the keys of a combination like ctrl+alt+Delete in order and releases them in reverse order
+func pressKeys(c *client.Client, combination string) error {
+	var keys []int
+	for _, name := range strings.Split(combination, "+") {
+		keysym, err := keysym(name)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, keysym)
+	}
+	for _, k := range keys {
+		if err := c.SendKey(k, true); err != nil {
+			return err
+		}
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := c.SendKey(keys[i], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keysym returns the keysym of a key name, a single character, F1 to F35 or a hexadecimal keysym like 0xff0d
+func keysym(name string) (int, error) {
+	if k, ok := keysyms[strings.ToLower(name)]; ok {
+		return k, nil
+	}
+	if r := []rune(name); len(r) == 1 {
+		if r[0] < 0x100 {
+			return int(r[0]), nil
+		}
+		return 0x1000000 + int(r[0]), nil
+	}
+	if strings.HasPrefix(name, "F") || strings.HasPrefix(name, "f") {
+		if n, err := strconv.Atoi(name[1:]); err == nil && n >= 1 && n <= 35 {
+			return 0xffbe + n - 1, nil
+		}
+	}
+	if strings.HasPrefix(name, "0x") {
+		if k, err := strconv.ParseUint(name[2:], 16, 29); err == nil {
+			return int(k), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown key %q", name)
+}
+
+// click presses and releases a mouse button at x,y
+func click(c *client.Client, args []string) error {
+	x, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	y, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	button := 1
+	if len(args) == 3 {
+		if button, err = strconv.Atoi(args[2]); err != nil || button < 1 || button > 8 {
+			return errors.New("The button must be 1 to 8")
+		}
+	}
+	if x < 0 || y < 0 || x >= c.Width || y >= c.Height {
+		return fmt.Errorf("%d,%d is outside the %dx%d framebuffer", x, y, c.Width, c.Height)
+	}
+	if err := c.SendPointer(x, y, 0); err != nil { // Move there first like a real mouse
+		return err
+	}
+	if err := c.SendPointer(x, y, 1<<uint(button-1)); err != nil {
+		return err
+	}
+	return c.SendPointer(x, y, 0)
+}
+
+// stats shows the server's details and counts the messages, rectangles and bytes it sends for d, requesting updates
+// as fast as it sends them
+func stats(c *client.Client, d time.Duration) error {
+	pf := c.PixelFormat
+	fmt.Printf("Desktop %q, %dx%d\n", c.Name, c.Width, c.Height)
+	fmt.Printf("Pixel format: %d bpp, depth %d, big endian %d, true colour %d, max %d/%d/%d, shift %d/%d/%d\n",
+		pf.BitsPerPixel, pf.Depth, pf.BigEndian, pf.TrueColor, pf.RedMax, pf.GreenMax, pf.BlueMax,
+		pf.RedShift, pf.GreenShift, pf.BlueShift)
+	if err := c.SendSetEncodings(statsEncodings...); err != nil {
+		return err
+	}
+	if err := c.SendUpdateRequest(0, 0, c.Width, c.Height, false); err != nil {
+		return err
+	}
+	messages := make(map[gorfb.ServerMessageType]int)
+	rects := make(map[gorfb.Encoding]int)
+	var bytes, pixels int
+	start := time.Now()
+	for end := start.Add(d); time.Now().Before(end); {
+		c.Timeout = time.Until(end)
+		msg, err := c.Next()
+		if err == client.ErrTimeout {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		messages[msg.Type]++
+		bytes += 1 + len(msg.Raw)
+		if msg.Type != gorfb.MsgFramebufferUpdate {
+			continue
+		}
+		for _, r := range msg.Rectangles {
+			rects[r.Encoding]++
+			if r.Encoding >= 0 {
+				pixels += r.Width * r.Height
+			}
+		}
+		if err := c.SendUpdateRequest(0, 0, c.Width, c.Height, true); err != nil {
+			return err
+		}
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("Received %d bytes in %s (%.0f bytes/s), %d pixels updated\n", bytes, elapsed.Round(time.Millisecond),
+		float64(bytes)/elapsed.Seconds(), pixels)
+	var types []int
+	for t := range messages {
+		types = append(types, int(t))
+	}
+	sort.Ints(types)
+	for _, t := range types {
+		fmt.Printf("  %-24s %d\n", gorfb.ServerMessageType(t), messages[gorfb.ServerMessageType(t)])
+	}
+	var encs []int
+	for e := range rects {
+		encs = append(encs, int(e))
+	}
+	sort.Ints(encs)
+	for _, e := range encs {
+		fmt.Printf("  %-24s %d rectangles\n", gorfb.Encoding(e), rects[gorfb.Encoding(e)])
+	}
+	if c.Fences() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		rtt, err := c.RoundTrip(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Round trip time %s\n", rtt)
+	}
+	return nil
+}
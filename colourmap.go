@@ -0,0 +1,73 @@
+// gorfb project colourmap.go
+// Colour map (palette) messages for clients that do not use true colour
+package gorfb
+
+import (
+	"errors"
+	"image/color"
+	"io"
+)
+
+// RFBColourMapHandler can be implemented by a RFBServerHandler that wants the colour map entries clients send
+// FixColourMapEntries is not part of RFB 3.8 but some older clients still send it, without this interface the
+// entries are read and ignored
+type RFBColourMapHandler interface {
+	// Handle colour map entries sent by the client
+	// conn is the RFB connection with the client
+	// first is the index of the first entry in colours
+	ProcessFixColourMapEntries(conn *RFBConn, first int, colours []color.RGBA64)
+}
+
+// SendColourMapEntries sets entries of the client's colour map, starting at index first
+// Clients whose pixel format is not true colour look up the pixel values sent to them in this map
+func (fb *RFBConn) SendColourMapEntries(first int, colours []color.Color) error {
+	if first < 0 || first+len(colours) > 65536 {
+		return errors.New("Colour map entries must be within 0 to 65535")
+	}
+	w := NewMessageWriter(6 + 6*len(colours)).Uint8(uint8(MsgSetColourMapEntries)).Padding(1).Uint16(uint16(first)).Uint16(uint16(len(colours)))
+	for _, c := range colours {
+		r, g, b, _ := c.RGBA()
+		w.Uint16(uint16(r)).Uint16(uint16(g)).Uint16(uint16(b))
+	}
+	buf, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	return fb.write(buf)
+}
+
+// processFixColourMapEntries reads a FixColourMapEntries message (the message type byte has already been read)
+// and passes the entries on to the handler if it wants them
+func (fb *RFBConn) processFixColourMapEntries() error {
+	hdr := make([]byte, 5)
+	if _, err := io.ReadFull(fb.Conn, hdr); err != nil {
+		return err
+	}
+	r := NewMessageReader(hdr)
+	r.Skip(1) // Padding
+	first, cnt := int(r.Uint16()), int(r.Uint16())
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if err := checkLimit("colour map entries", cnt, fb.Server.Limits.MaxColourMapEntries, DefaultMaxColourMapEntries); err != nil {
+		return err
+	}
+	buf := make([]byte, 6*cnt)
+	if _, err := io.ReadFull(fb.Conn, buf); err != nil {
+		return err
+	}
+	ch, ok := fb.CurrentScreen().Handler.(RFBColourMapHandler)
+	if !ok {
+		return nil
+	}
+	colours := make([]color.RGBA64, cnt)
+	r = NewMessageReader(buf)
+	for i := range colours {
+		colours[i] = color.RGBA64{R: r.Uint16(), G: r.Uint16(), B: r.Uint16(), A: 0xffff}
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	fb.dispatch(updateQueue, func() { ch.ProcessFixColourMapEntries(fb, first, colours) })
+	return nil
+}
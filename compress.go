@@ -0,0 +1,80 @@
+// gorfb project compress.go
+// Pluggable deflate implementations for the encodings that compress their data with zlib
+package gorfb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+)
+
+// CompressWriter is a zlib stream, *zlib.Writer of the standard library and of most faster replacements are one
+type CompressWriter interface {
+	io.WriteCloser
+	// Flush writes everything written so far in a form the client can decode completely (a sync flush)
+	Flush() error
+	// Reset discards the stream's state and continues writing a new stream to w
+	Reset(w io.Writer)
+}
+
+// Compressor creates the zlib streams used by the Zlib, ZRLE and Tight encodings
+// Set the server's Compressor to use a faster deflate implementation than the standard library's
+type Compressor interface {
+	// NewWriter returns a zlib stream writing to w, level is between zlib.BestSpeed and zlib.BestCompression
+	NewWriter(w io.Writer, level int) (CompressWriter, error)
+}
+
+// CompressorFunc is a function used as a Compressor, for example for github.com/klauspost/compress/zlib:
+//
+//	gorfb.CompressorFunc(func(w io.Writer, level int) (gorfb.CompressWriter, error) {
+//		return zlib.NewWriterLevel(w, level)
+//	})
+type CompressorFunc func(w io.Writer, level int) (CompressWriter, error)
+
+// NewWriter calls f
+func (f CompressorFunc) NewWriter(w io.Writer, level int) (CompressWriter, error) {
+	return f(w, level)
+}
+
+// StdCompressor is the Compressor of the standard library's compress/zlib, used if the server's Compressor is nil
+var StdCompressor Compressor = CompressorFunc(func(w io.Writer, level int) (CompressWriter, error) {
+	return zlib.NewWriterLevel(w, level)
+})
+
+// compressor returns the server's Compressor or the standard library's
+func (rfb *RFBServer) compressor() Compressor {
+	if rfb.Compressor != nil {
+		return rfb.Compressor
+	}
+	return StdCompressor
+}
+
+// zlibStream is a zlib stream lasting for the whole session, as the zlib based encodings require: the client keeps
+// a single decompressor per stream, so every rectangle continues where the previous one stopped
+type zlibStream struct {
+	buf bytes.Buffer
+	w   CompressWriter
+}
+
+// newZlibStream starts a zlib stream with the server's Compressor at level
+func (fb *RFBConn) newZlibStream(level int) (*zlibStream, error) {
+	s := &zlibStream{}
+	w, err := fb.Server.compressor().NewWriter(&s.buf, level)
+	if err != nil {
+		return nil, err
+	}
+	s.w = w
+	return s, nil
+}
+
+// compress returns data compressed and flushed so that the client can decode all of it
+func (s *zlibStream) compress(data []byte) ([]byte, error) {
+	s.buf.Reset()
+	if _, err := s.w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := s.w.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(s.buf.Bytes()), nil
+}
@@ -0,0 +1,123 @@
+// gorfb project context.go
+// Send methods that can be aborted through a context, for example on shutdown or when a frame is due
+package gorfb
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotInitialized is returned when sending a message that can not be held back (such as an update) to a client
+// that has not completed the handshake yet, the protocol does not allow the server to send anything before ServerInit
+var ErrNotInitialized = errors.New("Connection has not completed the handshake")
+
+// Initialized reports if the handshake with the client completed and messages can be sent to it
+func (fb *RFBConn) Initialized() bool {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.initialized
+}
+
+// SendRectanglesContext is SendRectangles that gives up when ctx is done, use context.WithTimeout for a deadline
+// If nothing of the update was sent yet ctx.Err() is returned and the connection can still be used. If the
+// update was only partly sent the client can not make sense of the rest of the stream and the connection is closed.
+func (fb *RFBConn) SendRectanglesContext(ctx context.Context, rects []RFBRectangle) error {
+	if fb.convertsPixels() {
+		rects = fb.convertRectangles(rects)
+	}
+	return fb.sendRectangles(ctx, rects)
+}
+
+// SendCutTextContext is SendCutText that gives up when ctx is done, in the same way as SendRectanglesContext
+func (fb *RFBConn) SendCutTextContext(ctx context.Context, text string) error {
+	if fb.deferSend(func(p *pendingSends) { p.cutText = &text }) {
+		return nil
+	}
+	return fb.sendCutText(ctx, text)
+}
+
+// SendBellContext is SendBell that gives up when ctx is done
+func (fb *RFBConn) SendBellContext(ctx context.Context) error {
+	if fb.deferSend(func(p *pendingSends) { p.bell = true }) {
+		return nil
+	}
+	return fb.writeContext(ctx, []byte{byte(MsgBell)})
+}
+
+// lockWrite acquires the write lock for sending a message of size bytes, giving up if ctx is done before it is
+// acquired. While it is held writes to the connection are aborted as soon as ctx is done.
+// The returned function releases it.
+func (fb *RFBConn) lockWrite(ctx context.Context, size int) (func(), error) {
+	if !fb.Initialized() {
+		return nil, ErrNotInitialized
+	}
+	fb.queueWrite(size)
+	if ctx.Done() == nil { // Can not be cancelled
+		fb.wmu.Lock()
+		written := fb.watchStall()
+		return func() {
+			written()
+			fb.wmu.Unlock()
+			fb.queueWrite(-size)
+			fb.countSent(size)
+		}, nil
+	}
+	locked := make(chan struct{})
+	go func() {
+		fb.wmu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-ctx.Done():
+		go func() { // Hand the lock back once it is acquired
+			<-locked
+			fb.wmu.Unlock()
+		}()
+		fb.queueWrite(-size)
+		return nil, ctx.Err()
+	}
+	written := fb.watchStall()
+	aborted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		fb.setWriteDeadline(time.Now())
+		close(aborted)
+	})
+	return func() {
+		written()
+		if !stop() { // The write deadline was set, clear it again for the next message
+			<-aborted
+			fb.setWriteDeadline(time.Time{})
+		}
+		fb.wmu.Unlock()
+		fb.queueWrite(-size)
+		fb.countSent(size)
+	}, nil
+}
+
+// writeContext sends a complete message to the client, giving up when ctx is done
+func (fb *RFBConn) writeContext(ctx context.Context, buf []byte) error {
+	unlock, err := fb.lockWrite(ctx, len(buf))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if n, err := fb.Conn.Write(buf); err != nil {
+		return fb.writeError(ctx, n > 0, err)
+	}
+	return nil
+}
+
+// writeError handles a failed write, started tells if part of the message has been sent already
+// A write aborted through ctx before anything was sent leaves the stream intact, ctx.Err() is returned for it.
+// Otherwise the connection is closed.
+func (fb *RFBConn) writeError(ctx context.Context, started bool, err error) error {
+	if ctx.Err() == nil {
+		return fb.writeFailed(err)
+	}
+	if started {
+		fb.writeFailed(err)
+	}
+	return ctx.Err()
+}
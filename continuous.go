@@ -0,0 +1,74 @@
+// gorfb project continuous.go
+// ContinuousUpdates extension: updates are sent to the client without it having to request each one
+package gorfb
+
+import (
+	"image"
+	"io"
+)
+
+// continuousUpdatesExtension is the ContinuousUpdates extension
+// While continuous updates are enabled every update sent is followed by an incremental update request for the
+// area on behalf of the client, so handlers need no changes to support it
+type continuousUpdatesExtension struct{}
+
+func (continuousUpdatesExtension) Name() string { return "ContinuousUpdates" }
+func (continuousUpdatesExtension) PseudoEncodings() []Encoding {
+	return []Encoding{EncContinuousUpdates}
+}
+func (continuousUpdatesExtension) MessageTypes() []ClientMessageType {
+	return []ClientMessageType{MsgEnableContinuousUpdates}
+}
+
+// Enable confirms that continuous updates are supported by sending EndOfContinuousUpdates
+func (continuousUpdatesExtension) Enable(fb *RFBConn) error {
+	return fb.write([]byte{byte(MsgEndOfContinuousUpdates)})
+}
+
+// ProcessMessage handles EnableContinuousUpdates, which enables or disables continuous updates for an area
+func (continuousUpdatesExtension) ProcessMessage(fb *RFBConn, msgType ClientMessageType) error {
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(fb.Conn, buf); err != nil {
+		return err
+	}
+	r := NewMessageReader(buf)
+	enable := r.Uint8() != 0
+	x, y := int(r.Uint16()), int(r.Uint16())
+	width, height := int(r.Uint16()), int(r.Uint16())
+	if err := r.Err(); err != nil {
+		return err
+	}
+	x, y, width, height, ok := fb.clampUpdateRequest(x, y, width, height)
+	enable = enable && ok
+	fb.mu.Lock()
+	fb.continuous = enable
+	fb.continuousArea = image.Rect(x, y, x+width, y+height)
+	fb.mu.Unlock()
+	if !enable {
+		return fb.write([]byte{byte(MsgEndOfContinuousUpdates)}) // EndOfContinuousUpdates
+	}
+	fb.dispatch(updateQueue, func() { fb.requestUpdate(x, y, width, height, true) })
+	return nil
+}
+
+// ContinuousUpdates returns the area (in client coordinates) for which continuous updates are enabled,
+// ok is false if they are not enabled
+func (fb *RFBConn) ContinuousUpdates() (area image.Rectangle, ok bool) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.continuousArea, fb.continuous
+}
+
+// continueUpdates requests the next update on behalf of a client with continuous updates after an update was sent
+func (fb *RFBConn) continueUpdates() {
+	area, ok := fb.ContinuousUpdates()
+	if !ok {
+		return
+	}
+	select {
+	case <-fb.done:
+		return
+	default:
+	}
+	go fb.requestUpdate(area.Min.X, area.Min.Y, area.Dx(), area.Dy(), true)
+}
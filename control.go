@@ -0,0 +1,122 @@
+// gorfb project control.go
+// Input control among clients sharing a session
+package gorfb
+
+// ViewOnly reports if input from the client is ignored
+func (fb *RFBConn) ViewOnly() bool {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.viewOnly
+}
+
+// SetViewOnly sets if input from the client must be ignored
+func (fb *RFBConn) SetViewOnly(viewOnly bool) {
+	fb.mu.Lock()
+	fb.viewOnly = viewOnly
+	fb.mu.Unlock()
+}
+
+// Controller returns the connection currently holding the control token of the named screen, nil if nobody holds it
+func (rfb *RFBServer) Controller(screen string) *RFBConn {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	return rfb.controller[screen]
+}
+
+// SetController passes the control token of the named screen to conn (a client of that screen), nil releases the
+// token so that the next client of the screen to send input takes it
+func (rfb *RFBServer) SetController(screen string, conn *RFBConn) {
+	rfb.mu.Lock()
+	changed := rfb.setController(screen, conn)
+	rfb.mu.Unlock()
+	if changed && rfb.OnControlChange != nil {
+		rfb.OnControlChange(screen, conn)
+	}
+}
+
+// setController sets the holder of the control token of the screen with rfb.mu held, reporting if it changed
+func (rfb *RFBServer) setController(screen string, conn *RFBConn) bool {
+	if rfb.controller[screen] == conn {
+		return false
+	}
+	if conn == nil {
+		delete(rfb.controller, screen)
+		return true
+	}
+	if rfb.controller == nil {
+		rfb.controller = make(map[string]*RFBConn)
+	}
+	rfb.controller[screen] = conn
+	return true
+}
+
+// HasControl reports if the client holds the control token of its screen (always true if ControlToken is not used)
+func (fb *RFBConn) HasControl() bool {
+	if !fb.Server.ControlToken {
+		return true
+	}
+	return fb.Server.Controller(fb.CurrentScreen().Name) == fb
+}
+
+// acceptInput reports if input sent by the client must be passed on to the handler
+// If nobody holds the control token of its screen the client takes it
+func (fb *RFBConn) acceptInput() bool {
+	if fb.ViewOnly() {
+		return false
+	}
+	if !fb.Server.ControlToken {
+		return true
+	}
+	rfb := fb.Server
+	screen := fb.CurrentScreen().Name
+	rfb.mu.Lock()
+	taken := rfb.controller[screen] == nil && rfb.setController(screen, fb)
+	ok := rfb.controller[screen] == fb
+	rfb.mu.Unlock()
+	if taken && rfb.OnControlChange != nil {
+		rfb.OnControlChange(screen, fb)
+	}
+	return ok
+}
+
+// controlHotkey handles the control hotkey, true is returned if the key was used to take control
+// The presses and the release of a hotkey that took control are swallowed, so the handler sees none of them.
+func (fb *RFBConn) controlHotkey(key int, downflag bool) bool {
+	rfb := fb.Server
+	if !rfb.ControlToken || rfb.ControlHotkey == 0 || key != rfb.ControlHotkey {
+		return false
+	}
+	fb.mu.Lock()
+	held := fb.hotkeyHeld
+	if !downflag {
+		fb.hotkeyHeld = false
+	}
+	fb.mu.Unlock()
+	if held || !downflag {
+		return held
+	}
+	if fb.ViewOnly() || fb.HasControl() {
+		return false
+	}
+	rfb.SetController(fb.CurrentScreen().Name, fb)
+	fb.mu.Lock()
+	fb.hotkeyHeld = true
+	fb.mu.Unlock()
+	return true
+}
+
+// releaseControl releases the control token if the client holds it
+// The holder is compared and cleared under one lock so that a token passed on meanwhile is not released.
+func (fb *RFBConn) releaseControl() {
+	rfb := fb.Server
+	if !rfb.ControlToken {
+		return
+	}
+	screen := fb.CurrentScreen().Name
+	rfb.mu.Lock()
+	released := rfb.controller[screen] == fb && rfb.setController(screen, nil)
+	rfb.mu.Unlock()
+	if released && rfb.OnControlChange != nil {
+		rfb.OnControlChange(screen, nil)
+	}
+}
@@ -0,0 +1,78 @@
+package gorfb
+
+import "testing"
+
+// attached returns a connection of rfb attached to the named screen
+func attached(rfb *RFBServer, screen string) *RFBConn {
+	return &RFBConn{Server: rfb, Screen: &Screen{Name: screen}}
+}
+
+func TestControlTokenPerScreen(t *testing.T) {
+	var changes []string
+	rfb := &RFBServer{ControlToken: true, OnControlChange: func(screen string, conn *RFBConn) {
+		changes = append(changes, screen)
+	}}
+	a1, a2, b := attached(rfb, "a"), attached(rfb, "a"), attached(rfb, "b")
+	if !a1.acceptInput() {
+		t.Error("First client of screen a did not take control")
+	}
+	if a2.acceptInput() {
+		t.Error("Second client of screen a took control")
+	}
+	if !b.acceptInput() {
+		t.Error("Client of screen b did not take control while screen a is controlled")
+	}
+	if rfb.Controller("a") != a1 || rfb.Controller("b") != b {
+		t.Error("Wrong controllers")
+	}
+	a1.releaseControl()
+	if rfb.Controller("a") != nil || rfb.Controller("b") != b {
+		t.Error("Releasing screen a affected screen b")
+	}
+	if !a2.acceptInput() {
+		t.Error("Second client of screen a did not take the released token")
+	}
+	if len(changes) != 4 {
+		t.Errorf("OnControlChange called for %v", changes)
+	}
+}
+
+func TestExclusivePerScreen(t *testing.T) {
+	rfb := &RFBServer{ExclusivePolicy: ExclusiveRefuse, Logf: func(format string, args ...interface{}) {}}
+	rfb.register(attached(rfb, "a"))
+	if !attached(rfb, "b").applySharePolicy(false) {
+		t.Error("Exclusive client of screen b refused because of a client of screen a")
+	}
+	if attached(rfb, "a").applySharePolicy(false) {
+		t.Error("Exclusive client of screen a accepted while screen a has a client")
+	}
+}
+
+func TestReleaseControlKeepsPassedToken(t *testing.T) {
+	rfb := &RFBServer{ControlToken: true}
+	a, b := attached(rfb, ""), attached(rfb, "")
+	rfb.SetController("", b)
+	a.releaseControl()
+	if rfb.Controller("") != b {
+		t.Error("Releasing a client without control released the token of another client")
+	}
+}
+
+func TestControlHotkeySwallowed(t *testing.T) {
+	const hotkey = 0xffc9 // F12
+	rfb := &RFBServer{ControlToken: true, ControlHotkey: hotkey}
+	a, b := attached(rfb, ""), attached(rfb, "")
+	rfb.SetController("", a)
+	if !b.controlHotkey(hotkey, true) || rfb.Controller("") != b {
+		t.Fatal("Hotkey did not take control")
+	}
+	if !b.controlHotkey(hotkey, true) {
+		t.Error("Repeated press of the hotkey passed on")
+	}
+	if !b.controlHotkey(hotkey, false) {
+		t.Error("Release of the hotkey passed on")
+	}
+	if b.controlHotkey(hotkey, true) || b.controlHotkey(hotkey, false) {
+		t.Error("Hotkey swallowed while in control")
+	}
+}
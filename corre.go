@@ -0,0 +1,107 @@
+// gorfb project corre.go
+// The CoRRE (compact rise-and-run-length) encoding: a background colour with rectangles of other colours painted
+// over it, for rectangles of at most 255x255
+package gorfb
+
+import "image"
+
+// correMaxTile is the largest width and height of a CoRRE rectangle
+const correMaxTile = 255
+
+// subrect is a rectangle of a single colour painted over the background of a tile
+type subrect struct {
+	x, y, width, height int
+	pixel               uint32
+}
+
+// encodeCoRRE splits rect (in pf) into rectangles of at most 255x255 and encodes them with CoRRE, or Raw if that
+// is smaller
+func encodeCoRRE(rect RFBRectangle, pf PixelFormat) ([]encodedRect, error) {
+	bpp := pf.BytesPerPixel()
+	var out []encodedRect
+	for _, t := range SplitTiles(rect.Bounds(), correMaxTile, correMaxTile) {
+		buf := subPixels(rect, t, bpp)
+		pix := tilePixels(buf, pf)
+		bg := backgroundPixel(pix)
+		subs := findSubrects(pix, t.Dx(), t.Dy(), bg)
+		size := 4 + bpp + len(subs)*(bpp+4)
+		if size >= len(buf) {
+			out = append(out, rawRect(t, buf))
+			continue
+		}
+		w := NewMessageWriter(size).Uint32(uint32(len(subs))).pixel(pf, bg)
+		for _, s := range subs {
+			w.pixel(pf, s.pixel).Uint8(uint8(s.x)).Uint8(uint8(s.y)).Uint8(uint8(s.width)).Uint8(uint8(s.height))
+		}
+		data, err := w.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, encodedRect{t.Min.X, t.Min.Y, t.Dx(), t.Dy(), EncCoRRE, data})
+	}
+	return out, nil
+}
+
+// backgroundPixel returns the most common pixel value
+func backgroundPixel(pix []uint32) uint32 {
+	counts := make(map[uint32]int)
+	bg, most := uint32(0), 0
+	for _, p := range pix {
+		counts[p]++
+		if counts[p] > most {
+			bg, most = p, counts[p]
+		}
+	}
+	return bg
+}
+
+// findSubrects covers the pixels of a width x height tile that are not bg with single coloured rectangles
+// From every pixel not covered yet the larger of the rectangles grown across first and grown down first is taken,
+// rectangles may overlap where they have the same colour
+func findSubrects(pix []uint32, width, height int, bg uint32) []subrect {
+	covered := make([]bool, len(pix))
+	same := func(r image.Rectangle, c uint32) bool {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if pix[y*width+x] != c {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	var out []subrect
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := pix[y*width+x]
+			if c == bg || covered[y*width+x] {
+				continue
+			}
+			across := image.Rect(x, y, x+1, y+1)
+			for across.Max.X < width && pix[y*width+across.Max.X] == c {
+				across.Max.X++
+			}
+			for across.Max.Y < height && same(image.Rect(x, across.Max.Y, across.Max.X, across.Max.Y+1), c) {
+				across.Max.Y++
+			}
+			down := image.Rect(x, y, x+1, y+1)
+			for down.Max.Y < height && pix[down.Max.Y*width+x] == c {
+				down.Max.Y++
+			}
+			for down.Max.X < width && same(image.Rect(down.Max.X, y, down.Max.X+1, down.Max.Y), c) {
+				down.Max.X++
+			}
+			r := across
+			if area(down) > area(across) {
+				r = down
+			}
+			for yy := r.Min.Y; yy < r.Max.Y; yy++ {
+				for xx := r.Min.X; xx < r.Max.X; xx++ {
+					covered[yy*width+xx] = true
+				}
+			}
+			out = append(out, subrect{r.Min.X, r.Min.Y, r.Dx(), r.Dy(), c})
+		}
+	}
+	return out
+}
@@ -0,0 +1,189 @@
+// gorfb project cursor.go
+// Cursor shapes shown by clients, or drawn into the updates for clients that can not show them
+package gorfb
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"sync"
+)
+
+// CursorManager shows one cursor shape on all clients of a server
+// Every client gets the cursor in the best form it supports: CursorWithAlpha, RichCursor or XCursor. The cursor
+// is drawn into the updates at the client's pointer position for clients that support none of them.
+type CursorManager struct {
+	rfb     *RFBServer
+	mu      sync.Mutex
+	img     *image.RGBA
+	hotspot image.Point
+}
+
+// Cursor returns the server's cursor manager, no cursor is shown until one is set on it
+func (rfb *RFBServer) Cursor() *CursorManager {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	if rfb.cursor == nil {
+		rfb.cursor = &CursorManager{rfb: rfb}
+	}
+	return rfb.cursor
+}
+
+// Set changes the cursor to img, hotspot is the point within img that is at the pointer position
+// img must not be changed afterwards, a transparent image hides the cursor
+func (cm *CursorManager) Set(img image.Image, hotspot image.Point) {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	cm.mu.Lock()
+	cm.img, cm.hotspot = rgba, hotspot.Sub(b.Min)
+	cm.mu.Unlock()
+	for _, fb := range cm.rfb.Connections() {
+		fb.cursorChanged()
+	}
+}
+
+// get returns the cursor image and hotspot, nil if no cursor was set
+func (cm *CursorManager) get() (*image.RGBA, image.Point) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.img, cm.hotspot
+}
+
+// serverCursor returns the cursor of the server, nil if none was set
+func (fb *RFBConn) serverCursor() (*image.RGBA, image.Point) {
+	fb.Server.mu.Lock()
+	cm := fb.Server.cursor
+	fb.Server.mu.Unlock()
+	if cm == nil {
+		return nil, image.Point{}
+	}
+	return cm.get()
+}
+
+// cursorEncoding returns the cursor pseudo-encoding used with the client, 0 if the cursor is drawn into the updates
+func (fb *RFBConn) cursorEncoding() Encoding {
+	best := Encoding(0)
+	for _, enc := range fb.Encodings() {
+		switch {
+		case enc == EncCursorWithAlpha:
+			return enc
+		case enc == EncRichCursor:
+			best = enc
+		case enc == EncXCursor && best == 0:
+			best = enc
+		}
+	}
+	return best
+}
+
+// cursorChanged sends the client the new cursor, or redraws it in the updates
+// It is also called when the client's encodings change as that may change how the cursor is shown
+func (fb *RFBConn) cursorChanged() {
+	img, hotspot := fb.serverCursor()
+	if img == nil {
+		return
+	}
+	if fb.cursorEncoding() == 0 {
+		fb.mu.Lock()
+		old, pos := fb.cursorArea, fb.pointer
+		fb.mu.Unlock()
+		fb.refreshArea(old.Union(img.Bounds().Add(pos.Sub(hotspot))))
+		return
+	}
+	fb.mu.Lock()
+	fb.cursorPending = true
+	waiting := fb.outstandingUpdates > 0
+	fb.mu.Unlock()
+	if waiting { // Answer the waiting update request with the cursor alone
+		go fb.sendRectangles(context.Background(), nil)
+	}
+}
+
+// sendPendingCursor answers an update request straight away if the client is due a new cursor
+func (fb *RFBConn) sendPendingCursor() {
+	fb.mu.Lock()
+	pending := fb.cursorPending
+	fb.mu.Unlock()
+	if pending {
+		fb.sendRectangles(context.Background(), nil)
+	}
+}
+
+// pointerMoved records the client's pointer position (in client coordinates) and redraws the cursor if it is
+// drawn into the updates
+func (fb *RFBConn) pointerMoved(x, y int) {
+	fb.mu.Lock()
+	old := fb.cursorArea
+	fb.pointer = image.Pt(x, y)
+	fb.mu.Unlock()
+	if img, hotspot := fb.serverCursor(); img != nil && fb.cursorEncoding() == 0 {
+		fb.refreshArea(old.Union(img.Bounds().Add(image.Pt(x, y).Sub(hotspot))))
+	}
+}
+
+// cursorLayer returns the cursor image and where it is drawn for clients that get the cursor in the updates
+// nil is returned if there is no cursor to draw
+func (fb *RFBConn) cursorLayer() (*image.RGBA, image.Rectangle) {
+	img, hotspot := fb.serverCursor()
+	if img == nil || fb.cursorEncoding() != 0 {
+		return nil, image.Rectangle{}
+	}
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.cursorArea = img.Bounds().Add(fb.pointer.Sub(hotspot))
+	return img, fb.cursorArea
+}
+
+// pendingCursor returns the cursor pseudo-rectangle (header and data) if the client is due a new cursor
+func (fb *RFBConn) pendingCursor() []byte {
+	fb.mu.Lock()
+	pending := fb.cursorPending
+	fb.cursorPending = false
+	pf := fb.pixelFormat
+	fb.mu.Unlock()
+	img, hotspot := fb.serverCursor()
+	if !pending || img == nil {
+		return nil
+	}
+	enc := fb.cursorEncoding()
+	b := img.Bounds()
+	var data []byte
+	switch enc {
+	case EncCursorWithAlpha: // Raw encoded premultiplied RGBA, as image.RGBA holds it
+		data = append([]byte{0, 0, 0, 0}, img.Pix...)
+	case EncRichCursor:
+		data = append(ImageToPixels(img, b, pf), cursorMask(img, func(x, y int) bool { return true })...)
+	case EncXCursor: // Black for the dark pixels and white for the light ones
+		data = append([]byte{0, 0, 0, 255, 255, 255}, cursorMask(img, func(x, y int) bool {
+			c := img.RGBAAt(x, y)
+			return int(c.R)+int(c.G)+int(c.B) < int(c.A)*3/2
+		})...)
+		data = append(data, cursorMask(img, func(x, y int) bool { return true })...)
+	default:
+		return nil
+	}
+	w := NewMessageWriter(12 + len(data)) // The rectangle's position is the hotspot
+	w.Uint16(uint16(hotspot.X)).Uint16(uint16(hotspot.Y)).Uint16(uint16(b.Dx())).Uint16(uint16(b.Dy())).Int32(int32(enc))
+	buf, err := w.Data(data).Bytes()
+	if err != nil {
+		return nil
+	}
+	return buf
+}
+
+// cursorMask returns a bitmask (rows padded to whole bytes, most significant bit first) of the visible pixels
+// of img (alpha of at least half) for which set is true
+func cursorMask(img *image.RGBA, set func(x, y int) bool) []byte {
+	b := img.Bounds()
+	stride := (b.Dx() + 7) / 8
+	mask := make([]byte, stride*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			if img.RGBAAt(x, y).A >= 128 && set(x, y) {
+				mask[y*stride+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	return mask
+}
@@ -0,0 +1,274 @@
+// gorfb project cuttext.go
+// Cut text (clipboard) transcoding and the extended clipboard extension
+package gorfb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	extClipText    = 1 << 0 // Plain UTF-8 text format
+	extClipCaps    = 1 << 24
+	extClipRequest = 1 << 25
+	extClipPeek    = 1 << 26
+	extClipNotify  = 1 << 27
+	extClipProvide = 1 << 28
+
+	extClipMaxText = 20 * 1024 * 1024 // Largest text we accept through the extended clipboard
+
+	defaultCutTextChunkSize = 64 * 1024
+)
+
+// ErrCutTextCancelled is returned when a cut text transfer was cancelled by the CutTextProgress callback
+var ErrCutTextCancelled = errors.New("Cut text transfer cancelled")
+
+// cutTextChunkSize returns the chunk size used to transfer cut text
+func (fb *RFBConn) cutTextChunkSize() int {
+	if fb.Server.CutTextChunkSize > 0 {
+		return fb.Server.CutTextChunkSize
+	}
+	return defaultCutTextChunkSize
+}
+
+// readCutTextChunked reads sz bytes of cut text from the client in chunks, reporting progress as it goes
+// If the transfer is cancelled the rest of the data is still read (and discarded) to keep the stream in sync
+// and ok is returned as false
+func (fb *RFBConn) readCutTextChunked(sz int) (buf []byte, ok bool, err error) {
+	chunk := fb.cutTextChunkSize()
+	buf = make([]byte, 0, min(sz, chunk)) // Grow as the data arrives rather than trusting the length sent
+	for len(buf) < sz {
+		n := min(chunk, sz-len(buf))
+		buf = append(buf, make([]byte, n)...)
+		if _, err = io.ReadFull(fb.Conn, buf[len(buf)-n:]); err != nil {
+			return nil, false, err
+		}
+		if fb.Server.CutTextProgress != nil && sz > chunk && !fb.Server.CutTextProgress(fb, true, len(buf), sz) {
+			_, err = io.CopyN(io.Discard, fb.Conn, int64(sz-len(buf)))
+			return nil, false, err
+		}
+	}
+	return buf, true, nil
+}
+
+// writeCutTextChunked writes a complete cut text message to the client in chunks, reporting progress as it goes
+// hdrsz is the size of the message header, progress is only reported on the data following it
+// The header already announced the full length, so if the transfer is cancelled the connection is closed rather than
+// handing the client a truncated or padded text
+func (fb *RFBConn) writeCutTextChunked(ctx context.Context, msg []byte, hdrsz int) error {
+	unlock, err := fb.lockWrite(ctx, len(msg))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	chunk := fb.cutTextChunkSize()
+	total := len(msg) - hdrsz
+	for pos := 0; pos < len(msg); {
+		n := min(chunk, len(msg)-pos)
+		if written, err := fb.Conn.Write(msg[pos : pos+n]); err != nil {
+			return fb.writeError(ctx, pos+written > 0, err)
+		}
+		pos += n
+		if fb.Server.CutTextProgress != nil && total > chunk && pos < len(msg) && !fb.Server.CutTextProgress(fb, false, pos-hdrsz, total) {
+			fb.Close(ErrCutTextCancelled.Error())
+			return ErrCutTextCancelled
+		}
+	}
+	return nil
+}
+
+// Latin1ToString converts Latin-1 (ISO 8859-1) encoded bytes, as used by cut text messages, to a Go string
+func Latin1ToString(buf []byte) string {
+	runes := make([]rune, len(buf))
+	for i, b := range buf {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// StringToLatin1 converts a Go string to Latin-1 (ISO 8859-1) as required by cut text messages
+// If lossy is true characters that cannot be represented are replaced by '?', otherwise an error is returned
+func StringToLatin1(text string, lossy bool) ([]byte, error) {
+	buf := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r > 0xff {
+			if !lossy {
+				return nil, fmt.Errorf("Character %q can not be represented in Latin-1", r)
+			}
+			r = '?'
+		}
+		buf = append(buf, byte(r))
+	}
+	return buf, nil
+}
+
+// sendCutTextMsg sends a ServerCutText message with the given length and data
+// A negative length is used for extended clipboard messages
+func (fb *RFBConn) sendCutTextMsg(ctx context.Context, length int32, data []byte) error {
+	buf, err := NewMessageWriter(8 + len(data)).Uint8(uint8(MsgServerCutText)).Padding(3).Int32(length).Data(data).Bytes() // Command byte, padding and length
+	if err != nil {
+		return err
+	}
+	return fb.writeCutTextChunked(ctx, buf, 8)
+}
+
+// sendExtendedClipboard sends an extended clipboard message with flags followed by data
+func (fb *RFBConn) sendExtendedClipboard(ctx context.Context, flags uint32, data []byte) error {
+	buf, err := NewMessageWriter(4 + len(data)).Uint32(flags).Data(data).Bytes()
+	if err != nil {
+		return err
+	}
+	return fb.sendCutTextMsg(ctx, -int32(len(buf)), buf)
+}
+
+// extendedClipboardExtension is the UTF-8 extended clipboard, its messages are cut text messages with a negative length
+type extendedClipboardExtension struct{}
+
+func (extendedClipboardExtension) Name() string { return "ExtendedClipboard" }
+func (extendedClipboardExtension) PseudoEncodings() []Encoding {
+	return []Encoding{EncExtendedClipboard}
+}
+func (extendedClipboardExtension) MessageTypes() []ClientMessageType { return nil }
+
+// Enable switches the client to the extended clipboard and tells it what the server supports
+func (extendedClipboardExtension) Enable(fb *RFBConn) error {
+	fb.mu.Lock()
+	fb.extClipboard = true
+	fb.mu.Unlock()
+	return fb.sendExtendedClipboardCaps()
+}
+
+func (extendedClipboardExtension) ProcessMessage(fb *RFBConn, msgType ClientMessageType) error {
+	return nil
+}
+
+// sendExtendedClipboardCaps tells the client which formats and actions the server supports
+func (fb *RFBConn) sendExtendedClipboardCaps() error {
+	maxText := min(extClipMaxText, limit(fb.Server.Limits.MaxCutText, DefaultMaxCutText))
+	buf, err := NewMessageWriter(4).Uint32(uint32(maxText)).Bytes() // Maximum text size accepted
+	if err != nil {
+		return err
+	}
+	return fb.sendExtendedClipboard(context.Background(), extClipCaps|extClipRequest|extClipPeek|extClipNotify|extClipProvide|extClipText, buf)
+}
+
+// sendExtendedText sends text as an extended clipboard provide message
+func (fb *RFBConn) sendExtendedText(ctx context.Context, text string) error {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\n", "\r\n", -1) + "\x00" // Text is sent null terminated with CRLF line endings
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	sz, err := NewMessageWriter(4).Uint32(uint32(len(text))).Bytes()
+	if err != nil {
+		return err
+	}
+	zw.Write(sz)
+	zw.Write([]byte(text))
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return fb.sendExtendedClipboard(ctx, extClipProvide|extClipText, zbuf.Bytes())
+}
+
+// sendCutText sends the server clipboard text either through the extended clipboard or as Latin-1
+func (fb *RFBConn) sendCutText(ctx context.Context, text string) error {
+	policy := fb.clipboardPolicy()
+	text, ok := policy.filter(fb, text, false)
+	if !ok || !policy.allowed(false, len(text)) {
+		return ErrCutTextNotAllowed
+	}
+	fb.mu.Lock()
+	extended, notify := fb.extClipboard, fb.extClientFlags&extClipNotify != 0
+	if extended {
+		fb.extClipText = text
+	}
+	fb.mu.Unlock()
+	if extended {
+		if notify { // Let the client request the text when it needs it
+			return fb.sendExtendedClipboard(ctx, extClipNotify|extClipText, nil)
+		}
+		return fb.sendExtendedText(ctx, text)
+	}
+	buf, err := StringToLatin1(text, fb.Server.CutTextLossy)
+	if err != nil {
+		return err
+	}
+	return fb.sendCutTextMsg(ctx, int32(len(buf)), buf)
+}
+
+// processExtendedCutText reads and handles an extended clipboard message of sz bytes sent by the client
+func (fb *RFBConn) processExtendedCutText(sz int) error {
+	fb.mu.Lock()
+	enabled := fb.extClipboard
+	fb.mu.Unlock()
+	if !enabled {
+		return errors.New("Extended clipboard message received but the extended clipboard was not negotiated")
+	}
+	if sz < 4 || sz > extClipMaxText+1024 {
+		return fmt.Errorf("Invalid extended clipboard message size %d", sz)
+	}
+	buf, ok, err := fb.readCutTextChunked(sz)
+	if err != nil || !ok {
+		return err
+	}
+	r := NewMessageReader(buf)
+	flags := r.Uint32()
+	if err := r.Err(); err != nil {
+		return err
+	}
+	fb.mu.Lock()
+	if flags&extClipCaps != 0 {
+		fb.extClientFlags = flags
+	}
+	available := fb.extClipText
+	fb.mu.Unlock()
+	switch {
+	case flags&extClipCaps != 0: // Capabilities recorded above
+	case flags&extClipRequest != 0:
+		if flags&extClipText != 0 {
+			return fb.sendExtendedText(context.Background(), available)
+		}
+	case flags&extClipPeek != 0: // Client wants to know what is available
+		if available == "" {
+			return fb.sendExtendedClipboard(context.Background(), extClipNotify, nil)
+		}
+		return fb.sendExtendedClipboard(context.Background(), extClipNotify|extClipText, nil)
+	case flags&extClipNotify != 0:
+		if flags&extClipText != 0 && !fb.clipboardPolicy().DisableClientToServer { // Client has text available, ask for it
+			return fb.sendExtendedClipboard(context.Background(), extClipRequest|extClipText, nil)
+		}
+	case flags&extClipProvide != 0:
+		if flags&extClipText == 0 || fb.clipboardPolicy().DisableClientToServer {
+			return nil
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(buf[4:]))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		tsz := make([]byte, 4)
+		if _, err := io.ReadFull(zr, tsz); err != nil {
+			return err
+		}
+		tr := NewMessageReader(tsz)
+		tlen := tr.Uint32()
+		if err := tr.Err(); err != nil {
+			return err
+		}
+		if tlen > extClipMaxText || checkLimit("bytes of cut text", int(tlen), fb.Server.Limits.MaxCutText, DefaultMaxCutText) != nil {
+			return errors.New("Extended clipboard text too large")
+		}
+		text := make([]byte, tlen)
+		if _, err := io.ReadFull(zr, text); err != nil {
+			return err
+		}
+		str := strings.TrimRight(string(text), "\x00")
+		fb.deliverCutText(strings.Replace(str, "\r\n", "\n", -1))
+	}
+	return nil
+}
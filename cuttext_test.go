@@ -0,0 +1,40 @@
+package gorfb
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestExtendedClipboardConcurrentAccess(t *testing.T) {
+	const rounds = 100
+	var data []byte
+	for i := 0; i < rounds; i++ {
+		caps, _ := NewMessageWriter(8).Uint32(extClipCaps | extClipNotify | extClipText).Uint32(1024).Bytes()
+		peek, _ := NewMessageWriter(4).Uint32(extClipPeek).Bytes()
+		data = append(append(data, caps...), peek...)
+	}
+	fb, _ := fuzzConn(data, false)
+	fb.initialized = true
+	fb.extClipboard = true
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // Server side sending text while the client's messages are processed
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			fb.sendCutText(context.Background(), "text")
+		}
+	}()
+	for i := 0; i < rounds; i++ {
+		if err := fb.processExtendedCutText(8); err != nil {
+			t.Fatal(err)
+		}
+		if err := fb.processExtendedCutText(4); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if fb.extClientFlags&extClipNotify == 0 || fb.extClipText != "text" {
+		t.Errorf("Flags %x and text %q after the exchange", fb.extClientFlags, fb.extClipText)
+	}
+}
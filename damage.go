@@ -0,0 +1,117 @@
+// gorfb project damage.go
+// Damage hints: applications that know what changed mark it dirty instead of having the handler find out
+package gorfb
+
+import "image"
+
+// RFBDirtyHandler can be implemented by a RFBServerHandler that sends all dirty rectangles in a single update
+// Without it the handler gets a full update request for every dirty rectangle
+type RFBDirtyHandler interface {
+	// Send the rectangles of the framebuffer to the client
+	// conn is the RFB connection with the client
+	// rects are the dirty rectangles within the area of an incremental update request, in the handler's coordinates
+	ProcessDirtyUpdate(conn *RFBConn, rects []image.Rectangle)
+}
+
+// MarkDirty marks the rectangles (in the handler's coordinates) as changed for all clients of the server
+func (rfb *RFBServer) MarkDirty(rects ...image.Rectangle) {
+	for _, fb := range rfb.Connections() {
+		fb.MarkDirty(rects...)
+	}
+}
+
+// MarkDirtyScreen marks the rectangles (in the handler's coordinates) as changed for the clients of the named screen
+func (rfb *RFBServer) MarkDirtyScreen(name string, rects ...image.Rectangle) {
+	for _, fb := range rfb.Connections() {
+		if fb.CurrentScreen().Name == name {
+			fb.MarkDirty(rects...)
+		}
+	}
+}
+
+// MarkDirty marks the rectangles (in the handler's coordinates) as changed for the client
+// With the server's DamageHints incremental update requests are answered with the dirty rectangles within the
+// requested area, a request for an area that has nothing dirty waits until something in it is marked. The handler
+// may be called from MarkDirty when a request was waiting.
+func (fb *RFBConn) MarkDirty(rects ...image.Rectangle) {
+	if !fb.Server.DamageHints {
+		return
+	}
+	off := fb.offset()
+	client := make([]image.Rectangle, len(rects))
+	for i, r := range rects {
+		client[i] = r.Sub(off)
+	}
+	fb.markDirty(client...)
+}
+
+// markDirty marks the rectangles (in client coordinates) as changed, answering a waiting incremental request
+func (fb *RFBConn) markDirty(rects ...image.Rectangle) {
+	bounds := fb.screenBounds()
+	fb.mu.Lock()
+	for _, r := range rects {
+		fb.dirty = fb.dirty.Add(r.Intersect(bounds))
+	}
+	var send Region
+	if fb.dirtyWaiting != nil {
+		send = fb.takeDirty(*fb.dirtyWaiting)
+		if !send.Empty() {
+			fb.dirtyWaiting = nil
+		}
+	}
+	fb.mu.Unlock()
+	if !send.Empty() {
+		fb.dispatch(updateQueue, func() { fb.sendDirty(send) })
+	}
+}
+
+// dirtyRequest answers an incremental update request for area (in client coordinates) with what is dirty in it,
+// or if wait is set remembers the request until something in it is marked dirty
+// false is returned if nothing was dirty and the request was not remembered
+func (fb *RFBConn) dirtyRequest(area image.Rectangle, wait bool) bool {
+	fb.mu.Lock()
+	send := fb.takeDirty(area)
+	if send.Empty() && wait {
+		if fb.dirtyWaiting != nil {
+			area = area.Union(*fb.dirtyWaiting)
+		}
+		fb.dirtyWaiting = &area
+	}
+	fb.mu.Unlock()
+	if !send.Empty() {
+		fb.sendDirty(send)
+	}
+	return wait || !send.Empty()
+}
+
+// takeDirty removes the dirty part of area from the dirty region and returns it, fb.mu must be held
+func (fb *RFBConn) takeDirty(area image.Rectangle) Region {
+	send := fb.dirty.Clip(area)
+	if !send.Empty() {
+		fb.dirty = fb.dirty.Subtract(area)
+	}
+	return send
+}
+
+// cleanArea removes area (in client coordinates) from the dirty region, it is being sent in full
+func (fb *RFBConn) cleanArea(area image.Rectangle) {
+	fb.mu.Lock()
+	fb.dirty = fb.dirty.Subtract(area)
+	fb.mu.Unlock()
+}
+
+// sendDirty has the handler send the dirty rectangles (in client coordinates)
+func (fb *RFBConn) sendDirty(dirty Region) {
+	rects := MergeRects(dirty, 64*64)
+	if dh, ok := fb.CurrentScreen().Handler.(RFBDirtyHandler); ok {
+		off := fb.offset()
+		for i := range rects {
+			rects[i] = rects[i].Add(off)
+		}
+		dh.ProcessDirtyUpdate(fb, rects)
+		return
+	}
+	for _, r := range rects {
+		fb.requestUpdate(r.Min.X, r.Min.Y, r.Dx(), r.Dy(), false)
+	}
+}
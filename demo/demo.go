@@ -0,0 +1,121 @@
+// gorfb project demo/demo.go
+// Package demo has animated test patterns (colour bars, a bouncing ball and a clock) served at a configurable rate
+//
+// They serve as reference handlers and for load testing clients, every frame is a full update of the screen.
+package demo
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/bitfont"
+	"github.com/hduplooy/gorfb/media"
+)
+
+// Pattern draws frame number n of an animation, t is the time of the frame
+type Pattern func(img *image.RGBA, n int, t time.Time)
+
+// bars are the colours of the colour bars
+var bars = []color.RGBA{{192, 192, 192, 255}, {192, 192, 0, 255}, {0, 192, 192, 255}, {0, 192, 0, 255},
+	{192, 0, 192, 255}, {192, 0, 0, 255}, {0, 0, 192, 255}}
+
+// ColorBars draws colour bars with a white line sweeping across them
+func ColorBars(img *image.RGBA, n int, t time.Time) {
+	b := img.Bounds()
+	for i, c := range bars {
+		r := image.Rect(b.Min.X+i*b.Dx()/len(bars), b.Min.Y, b.Min.X+(i+1)*b.Dx()/len(bars), b.Max.Y)
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	x := b.Min.X + n%b.Dx()
+	draw.Draw(img, image.Rect(x, b.Min.Y, x+2, b.Max.Y), image.White, image.Point{}, draw.Src)
+}
+
+// BouncingBall draws a ball bouncing off the edges of the screen
+func BouncingBall(img *image.RGBA, n int, t time.Time) {
+	b := img.Bounds()
+	draw.Draw(img, b, image.NewUniform(color.RGBA{0, 0, 64, 255}), image.Point{}, draw.Src)
+	radius := max(4, min(b.Dx(), b.Dy())/12)
+	w, h := b.Dx()-2*radius, b.Dy()-2*radius
+	if w <= 0 || h <= 0 {
+		return
+	}
+	cx := b.Min.X + radius + bounce(n*5, w)
+	cy := b.Min.Y + radius + bounce(n*3, h)
+	for y := -radius; y <= radius; y++ {
+		dx := int(math.Sqrt(float64(radius*radius - y*y)))
+		draw.Draw(img, image.Rect(cx-dx, cy+y, cx+dx+1, cy+y+1), image.NewUniform(color.RGBA{255, 160, 0, 255}),
+			image.Point{}, draw.Src)
+	}
+}
+
+// bounce returns the position after moving pos pixels back and forth over a distance of size
+func bounce(pos, size int) int {
+	pos %= 2 * size
+	if pos > size {
+		return 2*size - pos
+	}
+	return pos
+}
+
+// Clock draws the time of the frame in large digits
+func Clock(img *image.RGBA, n int, t time.Time) {
+	b := img.Bounds()
+	draw.Draw(img, b, image.Black, image.Point{}, draw.Src)
+	text := t.Format("15:04:05.0")
+	scale := max(1, b.Dx()/(len(text)*bitfont.Width+2))
+	scale = min(scale, max(1, b.Dy()/bitfont.Height))
+	sz := bitfont.Measure(text, scale)
+	pt := image.Pt(b.Min.X+(b.Dx()-sz.X)/2, b.Min.Y+(b.Dy()-sz.Y)/2)
+	bitfont.Draw(img, pt, text, color.RGBA{0, 255, 0, 255}, scale)
+}
+
+// Source is a media.FrameSource drawing a pattern at a fixed rate
+type Source struct {
+	Pattern       Pattern
+	Width, Height int
+	// Frames per second
+	FPS    float64
+	ticker *time.Ticker
+	n      int
+}
+
+// NewSource returns a source drawing width x height frames of the pattern at fps
+func NewSource(pattern Pattern, width, height int, fps float64) (*Source, error) {
+	if width <= 0 || height <= 0 || fps <= 0 {
+		return nil, errors.New("Invalid frame size or rate")
+	}
+	return &Source{Pattern: pattern, Width: width, Height: height, FPS: fps}, nil
+}
+
+// NextFrame waits for the time of the next frame and draws it
+func (s *Source) NextFrame() (image.Image, time.Time, error) {
+	if s.ticker == nil {
+		s.ticker = time.NewTicker(time.Duration(float64(time.Second) / s.FPS))
+	} else {
+		<-s.ticker.C
+	}
+	t := time.Now()
+	img := image.NewRGBA(image.Rect(0, 0, s.Width, s.Height))
+	s.Pattern(img, s.n, t)
+	s.n++
+	return img, t, nil
+}
+
+// NewServer returns a server serving the pattern on port as a width x height screen updated fps times per second
+func NewServer(port string, pattern Pattern, width, height int, fps float64) (*gorfb.RFBServer, error) {
+	src, err := NewSource(pattern, width, height, fps)
+	if err != nil {
+		return nil, err
+	}
+	rfb, err := media.NewServer(port, src)
+	if err != nil {
+		return nil, err
+	}
+	rfb.BufferName = "Demo"
+	return rfb, nil
+}
@@ -0,0 +1,66 @@
+// gorfb project descriptor.go
+// Settings per client decided once the client sent ClientInit, such as a framebuffer size per user
+package gorfb
+
+// SessionDescriptor describes a client that completed ClientInit, it is passed to the server's DescribeSession
+type SessionDescriptor struct {
+	// Address of the client
+	Address string
+	// The client asked to share the desktop with other clients
+	Shared bool
+	// Security type the client completed (SecNone or SecVNCAuth)
+	Security SecurityType
+	// Identity the server's Identify gave the client, empty without it as VNC authentication only checks a password
+	Identity string
+	// Name of the screen the client is attached to
+	Screen string
+}
+
+// SessionSettings override what the client is told in ServerInit, zero values keep the screen's settings
+type SessionSettings struct {
+	// Size of the client's framebuffer, the handler serves the size in the connection's Screen
+	// Screens with a Viewport can not be resized
+	Width, Height int
+	// Pixel format offered to the client, rectangles are converted from the screen's format. It must be true color.
+	PixelFormat *PixelFormat
+	// Desktop name shown by the client
+	DesktopName string
+}
+
+// describeSession calls the server's DescribeSession and applies the settings it returns
+func (fb *RFBConn) describeSession(shared bool) {
+	if fb.Server.DescribeSession == nil {
+		return
+	}
+	s := fb.Server.DescribeSession(fb, SessionDescriptor{Address: fb.Address(), Shared: shared, Security: fb.security,
+		Identity: fb.identity, Screen: fb.Screen.Name})
+	if s.Width != 0 || s.Height != 0 {
+		switch {
+		case fb.Screen.Viewport != nil:
+			fb.logf("Session size ignored, the screen has a viewport\n")
+		case s.Width < 0 || s.Height < 0 || s.Width > 0xffff || s.Height > 0xffff:
+			fb.logf("Session size %dx%d ignored\n", s.Width, s.Height)
+		default:
+			screen := *fb.Screen // The client gets a screen of its own
+			if s.Width > 0 {
+				screen.Width = s.Width
+			}
+			if s.Height > 0 {
+				screen.Height = s.Height
+			}
+			fb.Screen = &screen
+		}
+	}
+	if pf := s.PixelFormat; pf != nil {
+		if err := pf.check(); err != nil || pf.TrueColor != 1 {
+			fb.logf("Session pixel format ignored, it is not a valid true color format\n")
+		} else {
+			fb.advertised = pf
+		}
+	}
+	if s.DesktopName != "" {
+		if err := fb.SetDesktopName(s.DesktopName); err != nil {
+			fb.logf("Session desktop name ignored: %s\n", err.Error())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// gorfb project diff.go
+// Finding the areas that changed between two frames
+package gorfb
+
+import (
+	"bytes"
+	"hash/maphash"
+	"image"
+)
+
+// DefaultDiffTile is the size of the tiles frames are compared in by Diff and by a Differ with a TileSize of 0
+const DefaultDiffTile = 16
+
+// Diff returns the areas of cur that differ from prev, as tiles of DefaultDiffTile merged into as few rectangles
+// as possible without including unchanged tiles. If the bounds differ all of cur is returned.
+func Diff(prev, cur image.Image) []image.Rectangle {
+	return DiffTiles(prev, cur, DefaultDiffTile)
+}
+
+// DiffTiles is Diff comparing tiles of tile x tile pixels
+func DiffTiles(prev, cur image.Image, tile int) []image.Rectangle {
+	b := cur.Bounds()
+	if prev.Bounds() != b {
+		if b.Empty() {
+			return nil
+		}
+		return []image.Rectangle{b}
+	}
+	var changed []image.Rectangle
+	for _, t := range SplitTiles(b, tile, tile) {
+		if !tileEqual(prev, cur, t) {
+			changed = append(changed, t)
+		}
+	}
+	return mergeTiles(changed)
+}
+
+// Differ finds the areas that changed between successive frames by remembering a hash of every tile, so that the
+// previous frame does not have to be kept. A hash collision can hide a change, which is very unlikely.
+type Differ struct {
+	// TileSize is the size of the tiles frames are compared in, DefaultDiffTile if 0
+	TileSize int
+	seed     maphash.Seed
+	bounds   image.Rectangle
+	hashes   []uint64
+}
+
+// Next returns the areas of img that changed since the previous frame, all of it for the first frame and after
+// the bounds changed
+func (d *Differ) Next(img image.Image) []image.Rectangle {
+	tile := d.TileSize
+	if tile <= 0 {
+		tile = DefaultDiffTile
+	}
+	b := img.Bounds()
+	tiles := SplitTiles(b, tile, tile)
+	if d.hashes == nil {
+		d.seed = maphash.MakeSeed()
+	}
+	fresh := b != d.bounds || len(d.hashes) != len(tiles)
+	if fresh {
+		d.bounds = b
+		d.hashes = make([]uint64, len(tiles))
+	}
+	var changed []image.Rectangle
+	for i, t := range tiles {
+		h := d.hashTile(img, t)
+		if fresh || h != d.hashes[i] {
+			changed = append(changed, t)
+		}
+		d.hashes[i] = h
+	}
+	return mergeTiles(changed)
+}
+
+// Reset forgets the previous frame, the next frame is returned as changed completely
+func (d *Differ) Reset() {
+	d.bounds = image.Rectangle{}
+	d.hashes = nil
+}
+
+// hashTile returns the hash of the pixels of r in img
+func (d *Differ) hashTile(img image.Image, r image.Rectangle) uint64 {
+	var h maphash.Hash
+	h.SetSeed(d.seed)
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			pos := rgba.PixOffset(r.Min.X, y)
+			h.Write(rgba.Pix[pos : pos+r.Dx()*4])
+		}
+		return h.Sum64()
+	}
+	var px [8]byte
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, ca := img.At(x, y).RGBA()
+			px = [8]byte{byte(cr >> 8), byte(cr), byte(cg >> 8), byte(cg), byte(cb >> 8), byte(cb), byte(ca >> 8), byte(ca)}
+			h.Write(px[:])
+		}
+	}
+	return h.Sum64()
+}
+
+// tileEqual reports if the pixels of r are the same in both images
+func tileEqual(a, b image.Image, r image.Rectangle) bool {
+	ra, oka := a.(*image.RGBA)
+	rb, okb := b.(*image.RGBA)
+	if oka && okb {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			pa, pb := ra.PixOffset(r.Min.X, y), rb.PixOffset(r.Min.X, y)
+			if !bytes.Equal(ra.Pix[pa:pa+r.Dx()*4], rb.Pix[pb:pb+r.Dx()*4]) {
+				return false
+			}
+		}
+		return true
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r1, g1, b1, a1 := a.At(x, y).RGBA()
+			r2, g2, b2, a2 := b.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mergeTiles merges changed tiles, given row by row, into runs along the rows and runs of the same width into
+// rectangles spanning several rows, so no unchanged pixels are added
+func mergeTiles(tiles []image.Rectangle) []image.Rectangle {
+	var out []image.Rectangle
+	open := make(map[[2]int]int) // Index in out of the rectangle that ends at the last row, by its horizontal extent
+	for i := 0; i < len(tiles); {
+		run := tiles[i]
+		for i++; i < len(tiles) && tiles[i].Min.Y == run.Min.Y && tiles[i].Min.X == run.Max.X; i++ {
+			run.Max.X = tiles[i].Max.X
+		}
+		key := [2]int{run.Min.X, run.Max.X}
+		if j, ok := open[key]; ok && out[j].Max.Y == run.Min.Y {
+			out[j].Max.Y = run.Max.Y
+			continue
+		}
+		open[key] = len(out)
+		out = append(out, run)
+	}
+	return out
+}
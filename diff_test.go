@@ -0,0 +1,107 @@
+package gorfb_test
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// frame returns a width x height frame with a pattern, as *image.RGBA or, if generic, as another image type so
+// that the comparison does not take the RGBA shortcut
+func frame(width, height int, generic bool) draw.Image {
+	var img draw.Image = image.NewRGBA(image.Rect(0, 0, width, height))
+	if generic {
+		img = image.NewNRGBA(image.Rect(0, 0, width, height))
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 5), uint8(y * 7), uint8(x ^ y), 0xff})
+		}
+	}
+	return img
+}
+
+// differs runs prev and cur through Diff and through a Differ, returning both results
+func differs(prev, cur image.Image) (diff, differ []image.Rectangle) {
+	var d gorfb.Differ
+	d.Next(prev)
+	return gorfb.Diff(prev, cur), d.Next(cur)
+}
+
+// sameRects reports if both lists hold the same rectangles in the same order
+func sameRects(a, b []image.Rectangle) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiff(t *testing.T) {
+	for _, generic := range []bool{false, true} {
+		// 40x20 is no multiple of the 16 pixel tiles, the last column and row of tiles are smaller
+		prev := frame(40, 20, generic)
+		if diff, differ := differs(prev, frame(40, 20, generic)); diff != nil || differ != nil {
+			t.Errorf("Generic %v: unchanged frame gives %v and %v", generic, diff, differ)
+		}
+		for _, c := range []struct {
+			p    image.Point
+			want image.Rectangle
+		}{
+			{image.Pt(0, 0), image.Rect(0, 0, 16, 16)},
+			{image.Pt(15, 0), image.Rect(0, 0, 16, 16)},
+			{image.Pt(16, 0), image.Rect(16, 0, 32, 16)},
+			{image.Pt(15, 15), image.Rect(0, 0, 16, 16)},
+			{image.Pt(16, 16), image.Rect(16, 16, 32, 20)},
+			{image.Pt(0, 16), image.Rect(0, 16, 16, 20)},
+			{image.Pt(32, 15), image.Rect(32, 0, 40, 16)},
+			{image.Pt(39, 19), image.Rect(32, 16, 40, 20)},
+		} {
+			cur := frame(40, 20, generic)
+			cur.Set(c.p.X, c.p.Y, color.NRGBA{1, 2, 3, 0xff})
+			diff, differ := differs(prev, cur)
+			want := []image.Rectangle{c.want}
+			if !sameRects(diff, want) || !sameRects(differ, want) {
+				t.Errorf("Generic %v: pixel %v changed gives %v and %v instead of %v", generic, c.p, diff, differ, want)
+			}
+		}
+		cur := frame(40, 20, generic) // Changed tiles next to each other are merged
+		cur.Set(15, 15, color.NRGBA{1, 2, 3, 0xff})
+		cur.Set(16, 15, color.NRGBA{1, 2, 3, 0xff})
+		want := []image.Rectangle{image.Rect(0, 0, 32, 16)}
+		if diff, differ := differs(prev, cur); !sameRects(diff, want) || !sameRects(differ, want) {
+			t.Errorf("Generic %v: adjacent tiles changed give %v and %v instead of %v", generic, diff, differ, want)
+		}
+		resized := frame(48, 20, generic)
+		want = []image.Rectangle{resized.Bounds()}
+		if diff, differ := differs(prev, resized); !sameRects(diff, want) || !sameRects(differ, want) {
+			t.Errorf("Generic %v: size change gives %v and %v instead of %v", generic, diff, differ, want)
+		}
+	}
+}
+
+func TestDifferFrames(t *testing.T) {
+	d := gorfb.Differ{TileSize: 8}
+	img := frame(20, 12, false)
+	if got := d.Next(img); !sameRects(got, []image.Rectangle{img.Bounds()}) {
+		t.Errorf("First frame gives %v", got)
+	}
+	if got := d.Next(img); got != nil {
+		t.Errorf("Same frame again gives %v", got)
+	}
+	img.Set(19, 11, color.Black)
+	if got := d.Next(img); !sameRects(got, []image.Rectangle{image.Rect(16, 8, 20, 12)}) {
+		t.Errorf("Last pixel changed gives %v", got)
+	}
+	d.Reset()
+	if got := d.Next(img); !sameRects(got, []image.Rectangle{img.Bounds()}) {
+		t.Errorf("Frame after Reset gives %v", got)
+	}
+}
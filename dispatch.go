@@ -0,0 +1,115 @@
+// gorfb project dispatch.go
+// Calling the handler from worker goroutines instead of the connection's read loop
+package gorfb
+
+import "sync"
+
+// DispatchMode determines from which goroutine the handler's callbacks for client messages are called
+type DispatchMode int
+
+const (
+	// DispatchInline calls the handler from the connection's read loop, nothing is read from the client while
+	// a callback runs
+	DispatchInline DispatchMode = iota
+	// DispatchOrdered calls the handler from a worker goroutine per connection, in the order the messages arrived
+	DispatchOrdered
+	// DispatchSplit calls the handler from two worker goroutines per connection, one for update requests (along
+	// with pixel format, encoding and colour map changes) and one for input, so that slow updates do not hold up
+	// the input. The callbacks of each worker are called in the order the messages arrived.
+	DispatchSplit
+)
+
+// dispatchQueueSize is the number of callbacks a worker queues before the read loop waits for it
+const dispatchQueueSize = 64
+
+// queue selects the worker a callback is dispatched to
+type queue int
+
+const (
+	updateQueue queue = iota
+	inputQueue
+)
+
+// dispatcher runs the callbacks of a connection on its workers
+type dispatcher struct {
+	queues [2]chan func()
+	stop   chan struct{} // Closed by stopDispatch, the workers finish what is queued and return
+	wg     sync.WaitGroup
+}
+
+// startDispatch starts the workers for the server's DispatchMode
+func (fb *RFBConn) startDispatch() {
+	fb.mu.Lock()
+	fb.dispatchStopped = false
+	fb.mu.Unlock()
+	if fb.Server.Dispatch == DispatchInline {
+		return
+	}
+	d := &dispatcher{stop: make(chan struct{})}
+	d.queues[updateQueue] = make(chan func(), dispatchQueueSize)
+	d.queues[inputQueue] = d.queues[updateQueue]
+	if fb.Server.Dispatch == DispatchSplit {
+		d.queues[inputQueue] = make(chan func(), dispatchQueueSize)
+	}
+	for i, q := range d.queues {
+		if i > 0 && q == d.queues[0] {
+			break
+		}
+		d.wg.Add(1)
+		go func(q chan func()) {
+			defer d.wg.Done()
+			for {
+				select {
+				case fn := <-q:
+					fn()
+				case <-d.stop:
+					for {
+						select {
+						case fn := <-q:
+							fn()
+						default:
+							return
+						}
+					}
+				}
+			}
+		}(q)
+	}
+	fb.mu.Lock()
+	fb.dispatcher = d
+	fb.mu.Unlock()
+}
+
+// stopDispatch waits for the workers to finish the callbacks still queued, callbacks dispatched after that are
+// dropped
+// The queues are not closed as callers outside the read loop (ResumeUpdates, MarkDirty, timers) can still dispatch.
+func (fb *RFBConn) stopDispatch() {
+	fb.mu.Lock()
+	fb.dispatchStopped = true
+	d := fb.dispatcher
+	fb.mu.Unlock()
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// dispatch calls fn, from the worker for q if the handler is not called inline
+// Nothing is called once the connection stopped dispatching.
+func (fb *RFBConn) dispatch(q queue, fn func()) {
+	fb.mu.Lock()
+	stopped, d := fb.dispatchStopped, fb.dispatcher
+	fb.mu.Unlock()
+	if stopped {
+		return
+	}
+	if d == nil {
+		fn()
+		return
+	}
+	select {
+	case d.queues[q] <- fn:
+	case <-d.stop: // Stopped while waiting for room in the queue
+	}
+}
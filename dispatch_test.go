@@ -0,0 +1,57 @@
+package gorfb_test
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+func TestDispatchAfterDisconnect(t *testing.T) {
+	modes := map[string]gorfb.DispatchMode{"inline": gorfb.DispatchInline, "ordered": gorfb.DispatchOrdered,
+		"split": gorfb.DispatchSplit}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			rfb, h := rfbtest.NewServer(16, 16)
+			rfb.Dispatch = mode
+			rfb.Logf = func(format string, args ...interface{}) {}
+			ln := rfbtest.Serve(rfb)
+			defer ln.Close()
+			c, err := rfbtest.Connect(ln, true, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if call, err := h.Next(5 * time.Second); err != nil || call.Method != "Init" { // Registered by then
+				t.Fatalf("First call %v (%v)", call, err)
+			}
+			conns := rfb.Connections()
+			if len(conns) != 1 {
+				t.Fatalf("%d connections", len(conns))
+			}
+			conn := conns[0]
+			conn.PauseUpdates()
+			if err := c.SendUpdateRequest(0, 0, 16, 16, false); err != nil {
+				t.Fatal(err)
+			}
+			for { // The update is held back while paused
+				call, err := h.Next(5 * time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if call.Method == "ProcessUpdateRequest" {
+					break
+				}
+			}
+			c.Close()
+			select {
+			case <-conn.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("Connection not ended")
+			}
+			conn.ResumeUpdates() // Neither may panic or block
+			conn.MarkDirty(image.Rect(0, 0, 4, 4))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// gorfb project display/animate.go
+// Rendering frames at a fixed rate and flushing what changed
+package display
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// RenderFunc renders frame n (counting from 0) at time t into img, img holds the previous frame
+// It has the signature of demo.Pattern so the demo patterns can be animated
+type RenderFunc func(img *image.RGBA, n int, t time.Time)
+
+// Animator calls a RenderFunc at a fixed rate and flushes the parts of the frame that changed to the display
+type Animator struct {
+	d        *Display
+	render   RenderFunc
+	interval time.Duration
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// animTile is the size of the tiles frames are compared in
+const animTile = 16
+
+// Animate starts rendering frames with render fps times per second, frames are skipped when rendering is slower
+// Only the 16x16 tiles that differ from the previous frame are drawn on the display and flushed, merged where they
+// touch
+func (d *Display) Animate(fps float64, render RenderFunc) *Animator {
+	if fps <= 0 {
+		fps = 25
+	}
+	a := &Animator{d: d, render: render, interval: time.Duration(float64(time.Second) / fps), stop: make(chan struct{})}
+	go a.run()
+	return a
+}
+
+// Stop stops rendering, the last frame stays on the display
+func (a *Animator) Stop() {
+	a.once.Do(func() { close(a.stop) })
+}
+
+// run renders frames until stopped
+func (a *Animator) run() {
+	b := a.d.Bounds()
+	prev, cur := image.NewRGBA(b), image.NewRGBA(b)
+	a.d.copyTo(prev) // Start from what is on the display
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for n := 0; ; n++ {
+		copy(cur.Pix, prev.Pix)
+		a.render(cur, n, time.Now())
+		changed := gorfb.DiffTiles(prev, cur, animTile)
+		for _, r := range changed {
+			a.d.Modify(r, func(img *image.RGBA) {
+				for y := r.Min.Y; y < r.Max.Y; y++ {
+					pos := cur.PixOffset(r.Min.X, y)
+					copy(img.Pix[pos:pos+r.Dx()*4], cur.Pix[pos:])
+				}
+			})
+		}
+		if len(changed) > 0 {
+			a.d.Flush()
+		}
+		prev, cur = cur, prev
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
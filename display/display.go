@@ -0,0 +1,186 @@
+// gorfb project display/display.go
+// Package display is a high level API: draw on a framebuffer and flush, the updates reach all clients
+//
+// Display keeps the framebuffer, tracks the areas that were drawn on and answers the update requests of every
+// client with the areas that changed since it was last updated.
+package display
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// PixelFormat is the pixel format the framebuffer is served in, it matches the memory layout of image.RGBA
+var PixelFormat = gorfb.PixelFormat{BitsPerPixel: 32, Depth: 24, BigEndian: 0, TrueColor: 1,
+	RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 0, GreenShift: 8, BlueShift: 16}
+
+// Display is a framebuffer served to clients, it is a gorfb.RFBServerHandler
+// Draw on it with Draw, Fill or Modify and call Flush to send what changed to the clients
+type Display struct {
+	gorfb.BaseHandler
+	// OnKey, OnPointer and OnCutText if not nil receive the input of the clients
+	OnKey     func(conn *gorfb.RFBConn, keysym int, down bool)
+	OnPointer func(conn *gorfb.RFBConn, x, y, buttons int)
+	OnCutText func(conn *gorfb.RFBConn, text string)
+
+	mu      sync.Mutex
+	img     *image.RGBA
+	pending gorfb.Region // Drawn on since the last Flush
+	clients map[*gorfb.RFBConn]*client
+}
+
+// client is the update state of a connection
+type client struct {
+	send      sync.Mutex   // Keeps updates in the order their pixels were taken
+	damage    gorfb.Region // Flushed changes the client has not received yet
+	requested bool         // The client is waiting for an update
+}
+
+// New returns a black width x height display
+func New(width, height int) *Display {
+	d := &Display{img: image.NewRGBA(image.Rect(0, 0, width, height)), clients: make(map[*gorfb.RFBConn]*client)}
+	draw.Draw(d.img, d.img.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	return d
+}
+
+// NewServer returns a server serving a new width x height display on port
+func NewServer(port string, width, height int) (*gorfb.RFBServer, *Display) {
+	d := New(width, height)
+	return &gorfb.RFBServer{Port: port, Width: width, Height: height, PixelFormat: PixelFormat,
+		BufferName: "Display", Handler: d, ConvertPixelFormat: true}, d
+}
+
+// Bounds returns the bounds of the framebuffer
+func (d *Display) Bounds() image.Rectangle {
+	return d.img.Bounds()
+}
+
+// Draw draws src onto r of the framebuffer like draw.Draw
+func (d *Display) Draw(r image.Rectangle, src image.Image, sp image.Point, op draw.Op) {
+	d.Modify(r, func(img *image.RGBA) {
+		draw.Draw(img, r, src, sp, op)
+	})
+}
+
+// Fill fills r with c
+func (d *Display) Fill(r image.Rectangle, c color.Color) {
+	d.Draw(r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// Modify calls fn to change the framebuffer directly, fn must only change pixels within r
+func (d *Display) Modify(r image.Rectangle, fn func(img *image.RGBA)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(d.img)
+	d.pending = d.pending.Add(r.Intersect(d.img.Bounds()))
+}
+
+// copyTo copies the framebuffer to img, which has the same bounds
+func (d *Display) copyTo(img *image.RGBA) {
+	d.mu.Lock()
+	copy(img.Pix, d.img.Pix)
+	d.mu.Unlock()
+}
+
+// Flush makes everything drawn since the last Flush available to the clients, clients waiting for an update
+// are sent the changes straight away and the others with their next update request
+func (d *Display) Flush() {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = nil
+	conns := make([]*gorfb.RFBConn, 0, len(d.clients))
+	for conn, c := range d.clients {
+		c.damage = c.damage.Union(pending)
+		conns = append(conns, conn)
+	}
+	d.mu.Unlock()
+	for _, conn := range conns {
+		d.push(conn)
+	}
+}
+
+// push sends a client its changes if it is waiting for an update
+func (d *Display) push(conn *gorfb.RFBConn) {
+	d.mu.Lock()
+	c := d.clients[conn]
+	d.mu.Unlock()
+	if c == nil {
+		return
+	}
+	c.send.Lock()
+	defer c.send.Unlock()
+	d.mu.Lock()
+	if !c.requested || c.damage.Empty() {
+		d.mu.Unlock()
+		return
+	}
+	rects := make([]gorfb.RFBRectangle, 0, len(c.damage))
+	for _, r := range gorfb.MergeRects(c.damage, 64*64) {
+		rects = append(rects, gorfb.RFBRectangle{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(),
+			Buffer: gorfb.ImageToPixels(d.img, r, PixelFormat)})
+	}
+	c.damage, c.requested = nil, false
+	d.mu.Unlock()
+	conn.SendRectangles(rects)
+}
+
+// Init starts tracking the updates of a client until it disconnects
+func (d *Display) Init(conn *gorfb.RFBConn) {
+	d.mu.Lock()
+	d.clients[conn] = &client{}
+	d.mu.Unlock()
+	go func() {
+		<-conn.Done()
+		d.mu.Lock()
+		delete(d.clients, conn)
+		d.mu.Unlock()
+	}()
+}
+
+// ProcessLeave stops tracking the updates of a client that switched to another screen
+func (d *Display) ProcessLeave(conn *gorfb.RFBConn) {
+	d.mu.Lock()
+	delete(d.clients, conn)
+	d.mu.Unlock()
+}
+
+// ProcessUpdateRequest marks the client as waiting for an update, a full update request is answered straight away
+func (d *Display) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	d.mu.Lock()
+	c := d.clients[conn]
+	if c == nil {
+		d.mu.Unlock()
+		return
+	}
+	c.requested = true
+	if !incremental {
+		c.damage = c.damage.Add(image.Rect(x, y, x+width, y+height).Intersect(d.img.Bounds()))
+	}
+	d.mu.Unlock()
+	d.push(conn)
+}
+
+// ProcessKeyEvent passes the key on to OnKey
+func (d *Display) ProcessKeyEvent(conn *gorfb.RFBConn, key int, downflag bool) {
+	if d.OnKey != nil {
+		d.OnKey(conn, key, downflag)
+	}
+}
+
+// ProcessPointerEvent passes the pointer event on to OnPointer
+func (d *Display) ProcessPointerEvent(conn *gorfb.RFBConn, x, y, button int) {
+	if d.OnPointer != nil {
+		d.OnPointer(conn, x, y, button)
+	}
+}
+
+// ProcessCutText passes the pasted text on to OnCutText
+func (d *Display) ProcessCutText(conn *gorfb.RFBConn, text string) {
+	if d.OnCutText != nil {
+		d.OnCutText(conn, text)
+	}
+}
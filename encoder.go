@@ -0,0 +1,31 @@
+// gorfb project encoder.go
+// Encoders registered by applications for encodings the server does not implement, or to replace built in ones
+package gorfb
+
+// Encoder encodes framebuffer rectangles in an encoding
+// An encoder serves all connections at the same time, so it keeps no state between rectangles.
+type Encoder interface {
+	// Encoding returns the number of the encoding, clients that list it get rectangles from the encoder
+	Encoding() Encoding
+	// Encode returns the data following the header of rect, its pixels are in the client's pixel format pf
+	Encode(pf PixelFormat, rect RFBRectangle) ([]byte, error)
+}
+
+// RegisterEncoder adds enc to the encodings the server sends, replacing the encoder registered or built in for its
+// encoding. Encoders should be registered before clients connect, as rectangles sent to earlier clients (static
+// images) may have been encoded already.
+func (rfb *RFBServer) RegisterEncoder(enc Encoder) {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	if rfb.encoders == nil {
+		rfb.encoders = make(map[Encoding]Encoder)
+	}
+	rfb.encoders[enc.Encoding()] = enc
+}
+
+// encoder returns the encoder registered for encoding, nil if there is none
+func (rfb *RFBServer) encoder(encoding Encoding) Encoder {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	return rfb.encoders[encoding]
+}
@@ -0,0 +1,165 @@
+// gorfb project encoding.go
+// Choosing the encoding of the rectangles sent to a client and encoding them
+package gorfb
+
+import (
+	"errors"
+	"image"
+)
+
+// encodedRect is a rectangle of a FramebufferUpdate as it is sent
+type encodedRect struct {
+	x, y, width, height int
+	encoding            Encoding
+	data                []byte
+}
+
+// header returns the rectangle header preceding the data
+func (er encodedRect) header() ([]byte, error) {
+	w := NewMessageWriter(12)
+	w.Uint16(uint16(er.x)).Uint16(uint16(er.y)).Uint16(uint16(er.width)).Uint16(uint16(er.height))
+	return w.Int32(int32(er.encoding)).Bytes()
+}
+
+// encodingRanking are the encodings SelectBestEncoding picks from, best first
+var encodingRanking = []Encoding{EncTight, EncZRLE, EncHextile, EncZlib, EncCoRRE}
+
+// updateEncoding returns the encoding rectangles are sent to the client in: the first encoding in the client's
+// order of preference that the server implements or has an Encoder for, Raw if there is none
+// With BestEncoding set on the server it is the best encoding the client supports instead.
+func (fb *RFBConn) updateEncoding() Encoding {
+	if fb.Server.BestEncoding {
+		return fb.SelectBestEncoding()
+	}
+	for _, enc := range fb.Encodings() {
+		if fb.canEncode(enc) {
+			return enc
+		}
+	}
+	return EncRaw
+}
+
+// SelectBestEncoding returns the best encoding both the client and the server support, whatever the client's order
+// of preference: Tight, ZRLE, Hextile, Zlib, CoRRE and finally Raw, which all clients support
+// Encoders replacing a built in encoding take its place, other registered encodings are not considered.
+func (fb *RFBConn) SelectBestEncoding() Encoding {
+	for _, enc := range encodingRanking {
+		if fb.Supports(enc) && fb.canEncode(enc) {
+			return enc
+		}
+	}
+	return EncRaw
+}
+
+// canEncode reports if the server can send the client rectangles of pixels in encoding, with an Encoder or built in
+// Stateful encodings are left out when the client's streams were lost.
+func (fb *RFBConn) canEncode(encoding Encoding) bool {
+	if fb.Server.statefulEncoding(encoding) && fb.streamsLost {
+		return false
+	}
+	if fb.Server.encoder(encoding) != nil {
+		return true
+	}
+	switch encoding {
+	case EncRaw, EncCoRRE, EncHextile, EncZlib, EncTight, EncZRLE:
+		return true
+	}
+	return false
+}
+
+// rectEncoding returns the encoding rect is sent in: the encoding it forces if the client supports it, otherwise
+// encoding (the connection's)
+// A CopyRect rectangle has no pixels to send otherwise, so it fails if the client does not support CopyRect.
+func (fb *RFBConn) rectEncoding(rect RFBRectangle, encoding Encoding) (Encoding, error) {
+	switch {
+	case !rect.ForceEncoding:
+		return encoding, nil
+	case rect.Encoding == EncRaw:
+		return EncRaw, nil
+	case rect.Encoding == EncCopyRect:
+		if !fb.Supports(EncCopyRect) {
+			return 0, errors.New("Client does not support CopyRect")
+		}
+		return EncCopyRect, nil
+	case fb.Supports(rect.Encoding) && fb.canEncode(rect.Encoding):
+		return rect.Encoding, nil
+	}
+	return encoding, nil
+}
+
+// statefulEncoding reports if the client keeps state between the rectangles of the encoding (zlib streams), the
+// rectangles must then be encoded in the order they are sent and can not be reused for other clients
+// Encoders are stateless, also when they replace a built in encoding that is not.
+func (rfb *RFBServer) statefulEncoding(encoding Encoding) bool {
+	if rfb.encoder(encoding) != nil {
+		return false
+	}
+	return encoding == EncZlib || encoding == EncTight || encoding == EncZRLE
+}
+
+// encodeRectangle encodes rect (in the client's pixel format pf) with encoding, the result may be several
+// rectangles covering rect
+func (fb *RFBConn) encodeRectangle(rect RFBRectangle, encoding Encoding, pf PixelFormat) ([]encodedRect, error) {
+	if enc := fb.Server.encoder(encoding); enc != nil {
+		data, err := enc.Encode(pf, rect)
+		if err != nil {
+			return nil, err
+		}
+		return []encodedRect{{rect.X, rect.Y, rect.Width, rect.Height, encoding, data}}, nil
+	}
+	switch encoding {
+	case EncCopyRect:
+		data, err := NewMessageWriter(4).Uint16(uint16(rect.SrcX)).Uint16(uint16(rect.SrcY)).Bytes()
+		if err != nil {
+			return nil, err
+		}
+		return []encodedRect{{rect.X, rect.Y, rect.Width, rect.Height, EncCopyRect, data}}, nil
+	case EncCoRRE:
+		return encodeCoRRE(rect, pf)
+	case EncHextile:
+		return encodeHextile(rect, pf)
+	case EncZlib:
+		return fb.encodeZlib(rect)
+	case EncTight:
+		return fb.encodeTight(rect, pf)
+	case EncZRLE:
+		return fb.encodeZRLE(rect, pf)
+	}
+	return []encodedRect{rawRect(rect.Bounds(), rect.Buffer)}, nil
+}
+
+// rawRect returns the Raw encoded rectangle r with the pixels buf
+func rawRect(r image.Rectangle, buf []byte) encodedRect {
+	return encodedRect{r.Min.X, r.Min.Y, r.Dx(), r.Dy(), EncRaw, buf}
+}
+
+// subPixels returns the pixels of the part r of rect, bpp is the number of bytes per pixel
+func subPixels(rect RFBRectangle, r image.Rectangle, bpp int) []byte {
+	if r == rect.Bounds() {
+		return rect.Buffer
+	}
+	buf := make([]byte, 0, r.Dx()*r.Dy()*bpp)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		pos := ((y-rect.Y)*rect.Width + r.Min.X - rect.X) * bpp
+		buf = append(buf, rect.Buffer[pos:pos+r.Dx()*bpp]...)
+	}
+	return buf
+}
+
+// pixel writes the pixel val in pf
+func (w *MessageWriter) pixel(pf PixelFormat, val uint32) *MessageWriter {
+	var buf [4]byte
+	bpp := pf.BytesPerPixel()
+	pf.putPixel(buf[:bpp], 0, val)
+	return w.Data(buf[:bpp])
+}
+
+// tilePixels returns the pixel values of buf in pf
+func tilePixels(buf []byte, pf PixelFormat) []uint32 {
+	bpp := pf.BytesPerPixel()
+	pix := make([]uint32, len(buf)/bpp)
+	for i := range pix {
+		pix[i] = pf.getPixel(buf, i*bpp)
+	}
+	return pix
+}
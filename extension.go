@@ -0,0 +1,98 @@
+// gorfb project extension.go
+// Framework for protocol extensions that clients enable through pseudo-encodings
+package gorfb
+
+import "fmt"
+
+// Extension is a protocol extension, clients enable it by listing one of its pseudo-encodings in SetEncodings
+// The extended clipboard, gii, Fence, ContinuousUpdates and xvp are built in, others can be added to the server's
+// Extensions. An extension serves all connections, state it keeps per client should be keyed by the connection.
+type Extension interface {
+	// Name identifies the extension, for example in the Capabilities of a connection
+	Name() string
+	// PseudoEncodings returns the pseudo-encodings through which clients announce support for the extension
+	PseudoEncodings() []Encoding
+	// MessageTypes returns the types of the client messages the extension handles once a client enabled it
+	MessageTypes() []ClientMessageType
+	// Enable is called when a client enabled the extension, it sends whatever the extension requires the server
+	// to send to set it up. An error disconnects the client.
+	Enable(conn *RFBConn) error
+	// ProcessMessage reads and handles a client message of one of the extension's types (the message type byte
+	// has already been read). An error disconnects the client.
+	ProcessMessage(conn *RFBConn, msgType ClientMessageType) error
+}
+
+// extensions returns the extensions available to the client: the built in ones the server and screen support
+// and the server's Extensions
+func (fb *RFBConn) extensions() []Extension {
+	var exts []Extension
+	if fb.Server.ExtendedClipboard {
+		exts = append(exts, extendedClipboardExtension{})
+	}
+	exts = append(exts, giiExtension{}, fenceExtension{}, continuousUpdatesExtension{})
+	if _, ok := fb.CurrentScreen().Handler.(RFBPowerHandler); ok {
+		exts = append(exts, xvpExtension{})
+	}
+	return append(exts, fb.Server.Extensions...)
+}
+
+// enableExtensions enables the extensions of which the client listed a pseudo-encoding and that are not yet enabled
+func (fb *RFBConn) enableExtensions(encodings []Encoding) error {
+	listed := make(map[Encoding]bool, len(encodings))
+	for _, enc := range encodings {
+		listed[enc] = true
+	}
+	for _, ext := range fb.extensions() {
+		if fb.ExtensionEnabled(ext.Name()) {
+			continue
+		}
+		for _, enc := range ext.PseudoEncodings() {
+			if !listed[enc] {
+				continue
+			}
+			fb.mu.Lock()
+			fb.enabledExtensions = append(fb.enabledExtensions, ext)
+			fb.mu.Unlock()
+			if err := ext.Enable(fb); err != nil {
+				return fmt.Errorf("Error enabling %s: %s", ext.Name(), err.Error())
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// ExtensionEnabled reports if the client enabled the extension with the given name
+func (fb *RFBConn) ExtensionEnabled(name string) bool {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	for _, ext := range fb.enabledExtensions {
+		if ext.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// extensionFor returns the enabled extension that handles client messages of type msgType, nil if there is none
+func (fb *RFBConn) extensionFor(msgType ClientMessageType) Extension {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	for _, ext := range fb.enabledExtensions {
+		for _, t := range ext.MessageTypes() {
+			if t == msgType {
+				return ext
+			}
+		}
+	}
+	return nil
+}
+
+// extensionNames returns the names of the enabled extensions, fb.mu must be held
+func (fb *RFBConn) extensionNames() []string {
+	names := make([]string, len(fb.enabledExtensions))
+	for i, ext := range fb.enabledExtensions {
+		names[i] = ext.Name()
+	}
+	return names
+}
@@ -0,0 +1,7 @@
+// gorfb project fbdev/doc.go
+// Package fbdev mirrors a Linux framebuffer device (/dev/fb0) through gorfb
+//
+// This is meant for kiosks and embedded devices that draw straight to the framebuffer without X or Wayland.
+// The local cursor position can be read from an input device (/dev/input/eventN) and drawn into the updates.
+// Input from clients is not injected, the session is view only.
+package fbdev
@@ -0,0 +1,142 @@
+// gorfb project fbdev/fbdev.go
+// Handler mirroring a framebuffer device
+package fbdev
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// Capturer captures regions of a framebuffer
+type Capturer interface {
+	// Size returns the visible size of the framebuffer
+	Size() (width, height int)
+	// PixelFormat returns the pixel format of the framebuffer
+	PixelFormat() gorfb.PixelFormat
+	// Capture returns the pixels of a region of the framebuffer
+	Capture(r image.Rectangle) ([]byte, error)
+}
+
+// CursorSource reports the position of the local cursor
+type CursorSource interface {
+	Position() (x, y int)
+}
+
+// Handler is a gorfb.RFBServerHandler mirroring a framebuffer
+// The whole framebuffer is marked dirty on the servers of the clients every Interval. The handler must be served with
+// DamageHints set (as NewServer does), without it every incremental update request is answered in full.
+type Handler struct {
+	Capturer Capturer
+	// If Cursor is set a cursor is drawn at its position
+	Cursor CursorSource
+	// How often the framebuffer is marked dirty (100ms if not set)
+	Interval time.Duration
+
+	mu      sync.Mutex
+	servers map[*gorfb.RFBServer]bool // Servers of the clients, marked dirty every Interval
+	watch   sync.Once
+}
+
+// NewServer returns a server mirroring the framebuffer through handler on port
+// The server converts the framebuffer's pixels to the pixel format requested by each client
+func NewServer(port string, handler *Handler) *gorfb.RFBServer {
+	width, height := handler.Capturer.Size()
+	return &gorfb.RFBServer{Port: port, Width: width, Height: height, PixelFormat: handler.Capturer.PixelFormat(),
+		BufferName: "Framebuffer", Handler: handler, ConvertPixelFormat: true, DamageHints: true}
+}
+
+// Init notes the server of the client and starts marking the framebuffer dirty with the first client
+func (h *Handler) Init(conn *gorfb.RFBConn) {
+	h.mu.Lock()
+	if h.servers == nil {
+		h.servers = make(map[*gorfb.RFBServer]bool)
+	}
+	h.servers[conn.Server] = true
+	h.mu.Unlock()
+	h.watch.Do(func() { go h.watchFramebuffer() })
+}
+
+// watchFramebuffer marks the whole framebuffer dirty on the servers every Interval
+func (h *Handler) watchFramebuffer() {
+	interval := h.Interval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	for {
+		time.Sleep(interval)
+		width, height := h.Capturer.Size()
+		h.mu.Lock()
+		servers := make([]*gorfb.RFBServer, 0, len(h.servers))
+		for rfb := range h.servers {
+			servers = append(servers, rfb)
+		}
+		h.mu.Unlock()
+		for _, rfb := range servers {
+			rfb.MarkDirty(image.Rect(0, 0, width, height))
+		}
+	}
+}
+
+// ProcessSetPixelFormat is handled by the server converting the pixels
+func (h *Handler) ProcessSetPixelFormat(conn *gorfb.RFBConn, pf gorfb.PixelFormat) {}
+
+// ProcessSetEncoding is ignored, raw is used
+func (h *Handler) ProcessSetEncoding(conn *gorfb.RFBConn, encodings []int) {}
+
+// ProcessUpdateRequest captures the requested region and sends it to the client
+// With the server's DamageHints incremental requests only reach the handler once the framebuffer was marked dirty.
+func (h *Handler) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	r := image.Rect(x, y, x+width, y+height)
+	buf, err := h.Capturer.Capture(r)
+	if err != nil {
+		return
+	}
+	if h.Cursor != nil {
+		h.drawCursor(buf, r)
+	}
+	conn.SendRectangles([]gorfb.RFBRectangle{{X: x, Y: y, Width: width, Height: height, Buffer: buf}})
+}
+
+// drawCursor draws a small white cross with a black outline at the cursor position into buf holding region r
+// The pixels are written in the capturer's pixel format, white being all of its colour bitfields set.
+func (h *Handler) drawCursor(buf []byte, r image.Rectangle) {
+	pf := h.Capturer.PixelFormat()
+	bpp := pf.BytesPerPixel()
+	white := uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+	cx, cy := h.Cursor.Position()
+	put := func(x, y int, val uint32) {
+		if !(image.Point{x, y}).In(r) {
+			return
+		}
+		pos := ((y-r.Min.Y)*r.Dx() + x - r.Min.X) * bpp
+		for i := 0; i < bpp; i++ {
+			shift := 8 * uint(i)
+			if pf.BigEndian != 0 {
+				shift = 8 * uint(bpp-1-i)
+			}
+			buf[pos+i] = byte(val >> shift)
+		}
+	}
+	for d := -6; d <= 6; d++ {
+		put(cx+d, cy-1, 0)
+		put(cx+d, cy+1, 0)
+		put(cx-1, cy+d, 0)
+		put(cx+1, cy+d, 0)
+	}
+	for d := -6; d <= 6; d++ {
+		put(cx+d, cy, white)
+		put(cx, cy+d, white)
+	}
+}
+
+// ProcessKeyEvent is ignored, the session is view only
+func (h *Handler) ProcessKeyEvent(conn *gorfb.RFBConn, key int, downflag bool) {}
+
+// ProcessPointerEvent is ignored, the session is view only
+func (h *Handler) ProcessPointerEvent(conn *gorfb.RFBConn, x, y, button int) {}
+
+// ProcessCutText is ignored
+func (h *Handler) ProcessCutText(conn *gorfb.RFBConn, text string) {}
@@ -0,0 +1,241 @@
+// gorfb project fbdev/fbdev_linux.go
+// Access to framebuffer and input devices on Linux
+
+//go:build linux
+
+package fbdev
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/hduplooy/gorfb"
+)
+
+const (
+	fbioGetVScreenInfo = 0x4600
+	fbioGetFScreenInfo = 0x4602
+
+	evRel = 0x02
+	evAbs = 0x03
+	relX  = 0x00
+	relY  = 0x01
+	absX  = 0x00
+	absY  = 0x01
+
+	eviocgabs = 0x80184540 // EVIOCGABS(0), add the axis
+)
+
+type fbBitfield struct {
+	Offset, Length, MsbRight uint32
+}
+
+type fbVarScreenInfo struct {
+	XRes, YRes, XResVirtual, YResVirtual, XOffset, YOffset uint32
+	BitsPerPixel, Grayscale                                uint32
+	Red, Green, Blue, Transp                               fbBitfield
+	Rest                                                   [20]uint32 // Timing, rotation and reserved fields
+}
+
+type fbFixScreenInfo struct {
+	ID                             [16]byte
+	SmemStart                      uintptr
+	SmemLen, Type, TypeAux, Visual uint32
+	XPanStep, YPanStep, YWrapStep  uint16
+	LineLength                     uint32
+	MmioStart                      uintptr
+	MmioLen, Accel                 uint32
+	Capabilities                   uint16
+	Reserved                       [2]uint16
+}
+
+type absInfo struct {
+	Value, Minimum, Maximum, Fuzz, Flat, Resolution int32
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Device is an open framebuffer device
+type Device struct {
+	file  *os.File
+	vinfo fbVarScreenInfo
+	finfo fbFixScreenInfo
+}
+
+// Open opens a framebuffer device such as /dev/fb0
+func Open(path string) (*Device, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	dev := &Device{file: file}
+	if err := ioctl(file.Fd(), fbioGetVScreenInfo, unsafe.Pointer(&dev.vinfo)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := ioctl(file.Fd(), fbioGetFScreenInfo, unsafe.Pointer(&dev.finfo)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if bpp := dev.vinfo.BitsPerPixel; bpp != 8 && bpp != 16 && bpp != 24 && bpp != 32 {
+		file.Close()
+		return nil, errors.New("Unsupported framebuffer depth")
+	}
+	return dev, nil
+}
+
+// Close closes the device
+func (dev *Device) Close() error {
+	return dev.file.Close()
+}
+
+// Size returns the visible size of the framebuffer
+func (dev *Device) Size() (int, int) {
+	return int(dev.vinfo.XRes), int(dev.vinfo.YRes)
+}
+
+// hostBigEndian reports whether the host stores multi-byte values big endian
+func hostBigEndian() bool {
+	val := uint16(1)
+	return *(*byte)(unsafe.Pointer(&val)) == 0
+}
+
+// PixelFormat returns the pixel format of the framebuffer from its bitfields (in host byte order)
+func (dev *Device) PixelFormat() gorfb.PixelFormat {
+	v := dev.vinfo
+	var bigEndian uint8
+	if hostBigEndian() {
+		bigEndian = 1
+	}
+	return gorfb.PixelFormat{BitsPerPixel: uint8(v.BitsPerPixel), Depth: uint8(v.Red.Length + v.Green.Length + v.Blue.Length),
+		BigEndian: bigEndian, TrueColor: 1, RedMax: uint16(1<<v.Red.Length - 1), GreenMax: uint16(1<<v.Green.Length - 1), BlueMax: uint16(1<<v.Blue.Length - 1),
+		RedShift: uint8(v.Red.Offset), GreenShift: uint8(v.Green.Offset), BlueShift: uint8(v.Blue.Offset)}
+}
+
+// Capture reads a region of the visible framebuffer
+func (dev *Device) Capture(r image.Rectangle) ([]byte, error) {
+	w, h := dev.Size()
+	r = r.Intersect(image.Rect(0, 0, w, h))
+	bpp := int(dev.vinfo.BitsPerPixel / 8)
+	line := r.Dx() * bpp
+	buf := make([]byte, line*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		off := int64(y+int(dev.vinfo.YOffset))*int64(dev.finfo.LineLength) + int64(r.Min.X+int(dev.vinfo.XOffset))*int64(bpp)
+		if _, err := dev.file.ReadAt(buf[(y-r.Min.Y)*line:(y-r.Min.Y+1)*line], off); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Pointer tracks the cursor position from an input device such as /dev/input/event0
+type Pointer struct {
+	mu            sync.Mutex
+	x, y          int
+	width, height int
+	abs           [2]absInfo
+}
+
+// OpenPointer starts tracking the cursor from an input device on a screen of the given size
+// Both relative (mouse) and absolute (touch screen) devices are supported
+func OpenPointer(path string, width, height int) (*Pointer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pointer{x: width / 2, y: height / 2, width: width, height: height}
+	for axis := range p.abs {
+		if ioctl(file.Fd(), eviocgabs+uintptr(axis), unsafe.Pointer(&p.abs[axis])) != nil {
+			p.abs[axis] = absInfo{}
+		}
+	}
+	go p.read(file)
+	return p, nil
+}
+
+// Position returns the current cursor position
+func (p *Pointer) Position() (int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.x, p.y
+}
+
+// read processes input events until the device can no longer be read
+func (p *Pointer) read(file *os.File) {
+	defer file.Close()
+	ev := make([]byte, 16+8) // struct input_event on 64 bit (timeval, type, code, value)
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		ev = ev[:8+8]
+	}
+	tv := len(ev) - 8
+	for {
+		if _, err := io.ReadFull(file, ev); err != nil {
+			return
+		}
+		typ := binary.LittleEndian.Uint16(ev[tv:])
+		code := binary.LittleEndian.Uint16(ev[tv+2:])
+		val := int(int32(binary.LittleEndian.Uint32(ev[tv+4:])))
+		p.mu.Lock()
+		switch {
+		case typ == evRel && code == relX:
+			p.x = clamp(p.x+val, p.width)
+		case typ == evRel && code == relY:
+			p.y = clamp(p.y+val, p.height)
+		case typ == evAbs && code == absX:
+			p.x = clamp(scale(val, p.abs[0], p.width), p.width)
+		case typ == evAbs && code == absY:
+			p.y = clamp(scale(val, p.abs[1], p.height), p.height)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// scale scales an absolute axis value to the screen size
+func scale(val int, info absInfo, size int) int {
+	if info.Maximum <= info.Minimum {
+		return val
+	}
+	return (val - int(info.Minimum)) * (size - 1) / int(info.Maximum-info.Minimum)
+}
+
+// clamp keeps a coordinate on the screen
+func clamp(val, size int) int {
+	if val < 0 {
+		return 0
+	}
+	if val >= size {
+		return size - 1
+	}
+	return val
+}
+
+// NewHandler returns a handler mirroring the framebuffer device
+// If pointer is not empty the cursor position is read from that input device and drawn into the updates
+func NewHandler(device, pointer string) (*Handler, error) {
+	dev, err := Open(device)
+	if err != nil {
+		return nil, err
+	}
+	h := &Handler{Capturer: dev}
+	if pointer != "" {
+		w, ht := dev.Size()
+		p, err := OpenPointer(pointer, w, ht)
+		if err != nil {
+			dev.Close()
+			return nil, err
+		}
+		h.Cursor = p
+	}
+	return h, nil
+}
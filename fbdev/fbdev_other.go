@@ -0,0 +1,13 @@
+// gorfb project fbdev/fbdev_other.go
+// Stub for systems other than Linux
+
+//go:build !linux
+
+package fbdev
+
+import "errors"
+
+// NewHandler returns an error as framebuffer devices are only supported on Linux
+func NewHandler(device, pointer string) (*Handler, error) {
+	return nil, errors.New("Framebuffer devices are only supported on Linux")
+}
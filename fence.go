@@ -0,0 +1,116 @@
+// gorfb project fence.go
+// Fence extension used by clients to synchronize with the stream of updates
+package gorfb
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	fenceMaxPayload = 64
+)
+
+// Fence flags
+const (
+	// Messages before the fence are handled before the fence is
+	FenceBlockBefore = 1 << 0
+	// Messages after the fence are not handled before the fence is
+	FenceBlockAfter = 1 << 1
+	// The fence response is sent right after the next message
+	FenceSyncNext = 1 << 2
+	// The fence is a request that the other side responds to
+	FenceRequest = 1 << 31
+
+	fenceSupported = FenceBlockBefore | FenceBlockAfter | FenceSyncNext | FenceRequest
+)
+
+// RFBFenceHandler can be implemented by a RFBServerHandler that sends fences to clients with SendFence
+type RFBFenceHandler interface {
+	// Handle the client's response to a fence
+	// conn is the RFB connection with the client
+	// flags are the flags the client supports of those that were requested, payload is the fence's payload
+	ProcessFenceResponse(conn *RFBConn, flags uint32, payload []byte)
+}
+
+// fenceExtension is the Fence extension
+type fenceExtension struct{}
+
+func (fenceExtension) Name() string                      { return "Fence" }
+func (fenceExtension) PseudoEncodings() []Encoding       { return []Encoding{EncFence} }
+func (fenceExtension) MessageTypes() []ClientMessageType { return []ClientMessageType{MsgClientFence} }
+
+// Enable confirms that fences are supported by sending the client a fence request
+// and starts probing the bandwidth if the server does that
+func (fenceExtension) Enable(fb *RFBConn) error {
+	if err := fb.writeFence(FenceRequest, nil); err != nil {
+		return err
+	}
+	if fb.Server.BandwidthProbe {
+		go func() { // Not from the read loop, which has to read the responses while the burst is sent
+			if err := fb.startProbe(); err != nil {
+				fb.logf("Error probing bandwidth: %s\n", err.Error())
+			}
+		}()
+	}
+	return nil
+}
+
+// ProcessMessage answers fence requests with the supported flags and the same payload, responses go to the handler
+// Requests are answered after the callbacks for the messages before them were called
+func (fenceExtension) ProcessMessage(fb *RFBConn, msgType ClientMessageType) error {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(fb.Conn, hdr); err != nil {
+		return err
+	}
+	r := NewMessageReader(hdr)
+	r.Skip(3)
+	flags := r.Uint32()
+	payload := make([]byte, r.Uint8())
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if len(payload) > fenceMaxPayload {
+		return errors.New("Fence payload too long")
+	}
+	if _, err := io.ReadFull(fb.Conn, payload); err != nil {
+		return err
+	}
+	if flags&FenceRequest == 0 {
+		if fb.probeResponse(payload) {
+			return nil
+		}
+		if fh, ok := fb.CurrentScreen().Handler.(RFBFenceHandler); ok {
+			fb.dispatch(updateQueue, func() { fh.ProcessFenceResponse(fb, flags, payload) })
+		}
+		return nil
+	}
+	fb.dispatch(updateQueue, func() {
+		if err := fb.writeFence(flags&fenceSupported&^FenceRequest, payload); err != nil {
+			fb.logf("Error sending fence response: %s\n", err.Error())
+		}
+	})
+	return nil
+}
+
+// SendFence sends the client a fence, a request (FenceRequest in flags) is answered through the RFBFenceHandler
+// payload can be up to 64 bytes
+func (fb *RFBConn) SendFence(flags uint32, payload []byte) error {
+	if !fb.ExtensionEnabled("Fence") {
+		return errors.New("Client does not support fences")
+	}
+	if len(payload) > fenceMaxPayload {
+		return errors.New("Fence payload too long")
+	}
+	return fb.writeFence(flags&fenceSupported, payload)
+}
+
+// writeFence sends a fence message
+func (fb *RFBConn) writeFence(flags uint32, payload []byte) error {
+	w := NewMessageWriter(9 + len(payload)).Uint8(uint8(MsgServerFence)).Padding(3).Uint32(flags)
+	buf, err := w.Uint8(uint8(len(payload))).Data(payload).Bytes()
+	if err != nil {
+		return err
+	}
+	return fb.write(buf)
+}
@@ -0,0 +1,120 @@
+// gorfb project flow.go
+// Flow control: reporting connections that can not keep up with the data sent to them
+package gorfb
+
+import "time"
+
+// DefaultHighWatermark is the number of bytes waiting to be sent above which a client is congested
+const DefaultHighWatermark = 1 << 20
+
+// FlowControl configures how congestion of the connections is reported, so that the application can lower its
+// update rate for clients that can not keep up
+type FlowControl struct {
+	// A client is congested once more than HighWatermark bytes are waiting to be sent to it
+	// (DefaultHighWatermark if 0)
+	HighWatermark int
+	// A congested client is no longer congested once at most LowWatermark bytes are waiting (a quarter of
+	// HighWatermark if 0)
+	LowWatermark int
+	// A write to a client taking longer than StallTimeout is reported as stalled (0 disables it)
+	StallTimeout time.Duration
+	// OnCongestion is called when a client becomes congested and when it is no longer congested
+	OnCongestion func(conn *RFBConn, congested bool)
+	// OnStall is called when a write to a client stalled and again once the stalled write completed
+	OnStall func(conn *RFBConn, stalled bool)
+}
+
+// FlowStats describes the flow control state of a connection
+type FlowStats struct {
+	// Bytes waiting to be sent, including the message being written
+	Queued int
+	// More than the high watermark was queued and the queue has not dropped to the low watermark since
+	Congested bool
+	// The current write is taking longer than the StallTimeout
+	Stalled bool
+	// Number of writes that stalled
+	Stalls int
+}
+
+// flowState is the flow control state of a connection, guarded by fb.mu
+type flowState struct {
+	queued      int
+	congested   bool
+	stalled     bool
+	stalls      int
+	congestions int
+}
+
+// FlowStats returns the flow control state of the connection
+func (fb *RFBConn) FlowStats() FlowStats {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return FlowStats{Queued: fb.flow.queued, Congested: fb.flow.congested, Stalled: fb.flow.stalled, Stalls: fb.flow.stalls}
+}
+
+// watermarks returns the high and low watermark with the defaults applied
+func (fc *FlowControl) watermarks() (int, int) {
+	high := fc.HighWatermark
+	if high <= 0 {
+		high = DefaultHighWatermark
+	}
+	low := fc.LowWatermark
+	if low <= 0 || low > high {
+		low = high / 4
+	}
+	return high, low
+}
+
+// queueWrite adds n bytes to the bytes waiting to be sent (removes them for a negative n)
+// and reports a change in congestion
+func (fb *RFBConn) queueWrite(n int) {
+	fc := &fb.Server.FlowControl
+	high, low := fc.watermarks()
+	fb.mu.Lock()
+	fb.flow.queued += n
+	changed := (!fb.flow.congested && fb.flow.queued > high) || (fb.flow.congested && fb.flow.queued <= low)
+	if changed {
+		fb.flow.congested = !fb.flow.congested
+		if fb.flow.congested {
+			fb.flow.congestions++
+		}
+	}
+	congested := fb.flow.congested
+	fb.mu.Unlock()
+	if changed && fc.OnCongestion != nil {
+		fc.OnCongestion(fb, congested)
+	}
+}
+
+// watchStall reports the write that is about to start as stalled if it takes longer than the StallTimeout
+// The returned function is called once the write completed
+func (fb *RFBConn) watchStall() func() {
+	timeout := fb.Server.FlowControl.StallTimeout
+	if timeout <= 0 {
+		return func() {}
+	}
+	reported := make(chan struct{})
+	t := time.AfterFunc(timeout, func() {
+		fb.setStalled(true)
+		close(reported)
+	})
+	return func() {
+		if !t.Stop() {
+			<-reported
+			fb.setStalled(false)
+		}
+	}
+}
+
+// setStalled records that a write stalled or that the stalled write completed
+func (fb *RFBConn) setStalled(stalled bool) {
+	fb.mu.Lock()
+	fb.flow.stalled = stalled
+	if stalled {
+		fb.flow.stalls++
+	}
+	fb.mu.Unlock()
+	if fb.Server.FlowControl.OnStall != nil {
+		fb.Server.FlowControl.OnStall(fb, stalled)
+	}
+}
@@ -0,0 +1,57 @@
+// gorfb project formathints.go
+// Steering clients towards pixel formats that are cheap to serve
+package gorfb
+
+import "errors"
+
+// PixelFormatHints steers clients towards pixel formats that are cheap to convert to, for servers on constrained
+// devices
+type PixelFormatHints struct {
+	// Advertised is offered in ServerInit instead of the screen's format, clients that keep the offered format
+	// use it. The rectangles given to SendRectangles stay in the screen's format and are converted.
+	Advertised *PixelFormat
+	// Accept decides on the formats clients ask for with SetPixelFormat, rejected formats are treated like invalid
+	// ones: the client is disconnected with PixelFormatDisconnect, otherwise it keeps its previous format
+	Accept func(conn *RFBConn, pf PixelFormat) bool
+}
+
+// CheapPixelFormat reports if pixels in format src can be sent to a client using format dst without converting
+// every channel, because the formats are the same or only differ in the order of the bytes
+func CheapPixelFormat(src, dst PixelFormat) bool {
+	if src == dst {
+		return true
+	}
+	_, ok := swizzlePixels(src, dst, nil)
+	return ok
+}
+
+// initPixelFormat returns the format offered to the client in ServerInit
+func (fb *RFBConn) initPixelFormat() PixelFormat {
+	if fb.advertised != nil {
+		return *fb.advertised
+	}
+	if pf := fb.Server.PixelFormatHints.Advertised; pf != nil {
+		return *pf
+	}
+	return fb.CurrentScreen().PixelFormat.wire()
+}
+
+// convertsPixels reports if the rectangles given to SendRectangles are converted to the client's format
+func (fb *RFBConn) convertsPixels() bool {
+	return fb.Server.ConvertPixelFormat || fb.CurrentScreen().PixelFormat.BitsPerPixel == 24 ||
+		fb.Server.PixelFormatHints.Advertised != nil || fb.advertised != nil
+}
+
+// validate checks that the advertised format can be used
+func (h *PixelFormatHints) validate() error {
+	if h.Advertised == nil {
+		return nil
+	}
+	if h.Advertised.TrueColor != 1 {
+		return errors.New("The advertised pixel format must be true color")
+	}
+	if err := h.Advertised.check(); err != nil {
+		return errors.New("Advertised pixel format: " + err.Error())
+	}
+	return nil
+}
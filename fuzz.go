@@ -0,0 +1,51 @@
+// gorfb project fuzz.go
+// Entry points for fuzzing the protocol parsing with go test -fuzz (or go-fuzz)
+package gorfb
+
+import "bytes"
+
+// bytesConn is a transport reading from a byte slice, everything written to it is discarded
+type bytesConn struct {
+	r *bytes.Reader
+}
+
+func (bc *bytesConn) Read(b []byte) (int, error)  { return bc.r.Read(b) }
+func (bc *bytesConn) Write(b []byte) (int, error) { return len(b), nil }
+func (bc *bytesConn) Close() error                { return nil }
+
+// fuzzConn returns a connection that reads data, on a server with all the optional protocol features enabled
+func fuzzConn(data []byte, auth bool) (*RFBConn, *bytesConn) {
+	rfb := &RFBServer{Width: 64, Height: 64, PixelFormat: PixelFormat{32, 24, 0, 1, 255, 255, 255, 16, 8, 0},
+		Handler: BaseHandler{}, Authenticate: auth, AuthText: "fuzz", ExtendedClipboard: true}
+	bc := &bytesConn{r: bytes.NewReader(data)}
+	fb := &RFBConn{Server: rfb, Conn: bc, done: make(chan struct{})}
+	fb.Screen = rfb.defaultScreen()
+	fb.pixelFormat = fb.Screen.PixelFormat.wire()
+	return fb, bc
+}
+
+// FuzzHandshake runs the server side of the handshake on data as sent by a client
+// The first byte selects if authentication is required (odd) or not
+// 1 is returned if the handshake completed, 0 otherwise
+func FuzzHandshake(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	fb, _ := fuzzConn(data[1:], data[0]&1 == 1)
+	if fb.agreeProtocol() && fb.agreeSecurity() && fb.performInit() {
+		return 1
+	}
+	return 0
+}
+
+// FuzzClientMessages processes data as the client messages following a completed handshake
+// 1 is returned if all of data was consumed, 0 otherwise
+func FuzzClientMessages(data []byte) int {
+	fb, bc := fuzzConn(data, false)
+	fb.initialized = true
+	fb.processClientRequest()
+	if bc.r.Len() == 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package gorfb_test
+
+import (
+	"testing"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// message returns the bytes written to w
+func message(w *gorfb.MessageWriter) []byte {
+	buf, err := w.Bytes()
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// concat joins messages into one stream
+func concat(msgs ...[]byte) []byte {
+	var out []byte
+	for _, m := range msgs {
+		out = append(out, m...)
+	}
+	return out
+}
+
+func FuzzHandshake(f *testing.F) {
+	f.Add(concat([]byte{0}, []byte(gorfb.PROTOCOL), []byte{1, 1}))                           // No authentication
+	f.Add(concat([]byte{1}, []byte(gorfb.PROTOCOL), []byte{2}, make([]byte, 16), []byte{0})) // VNC authentication
+	f.Add(concat([]byte{0}, []byte("RFB 003.003\n"), []byte{1}))                             // RFB 3.3
+	f.Add(concat([]byte{0}, []byte("RFB 003.007\n"), []byte{1, 0}))                          // RFB 3.7
+	f.Add([]byte{0, 'R', 'F', 'B'})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		gorfb.FuzzHandshake(data)
+	})
+}
+
+func FuzzClientMessages(f *testing.F) {
+	pf := message(gorfb.NewMessageWriter(20).Uint8(uint8(gorfb.MsgSetPixelFormat)).Padding(3).
+		Uint8(16).Uint8(16).Uint8(0).Uint8(1).Uint16(31).Uint16(63).Uint16(31).Uint8(11).Uint8(5).Uint8(0).Padding(3))
+	encs := gorfb.NewMessageWriter(4 + 4*5).Uint8(uint8(gorfb.MsgSetEncodings)).Padding(1).Uint16(5)
+	for _, enc := range []gorfb.Encoding{gorfb.EncZRLE, gorfb.EncTight, gorfb.EncHextile, gorfb.EncRaw, gorfb.EncExtendedClipboard} {
+		encs.Int32(int32(enc))
+	}
+	update := message(gorfb.NewMessageWriter(10).Uint8(uint8(gorfb.MsgFramebufferUpdateRequest)).Uint8(0).
+		Uint16(0).Uint16(0).Uint16(64).Uint16(64))
+	key := message(gorfb.NewMessageWriter(8).Uint8(uint8(gorfb.MsgKeyEvent)).Uint8(1).Padding(2).Uint32(0x61))
+	pointer := message(gorfb.NewMessageWriter(6).Uint8(uint8(gorfb.MsgPointerEvent)).Uint8(1).Uint16(10).Uint16(20))
+	cut := message(gorfb.NewMessageWriter(13).Uint8(uint8(gorfb.MsgClientCutText)).Padding(3).Uint32(5).Data([]byte("hello")))
+	extCut := message(gorfb.NewMessageWriter(12).Uint8(uint8(gorfb.MsgClientCutText)).Padding(3).Int32(-4).Uint32(1 << 24))
+	f.Add(concat(pf, message(encs), update, key, pointer, cut))
+	f.Add(concat(message(encs), extCut))
+	f.Add(update)
+	f.Add([]byte{byte(gorfb.MsgSetEncodings), 0, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		gorfb.FuzzClientMessages(data)
+	})
+}
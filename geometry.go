@@ -0,0 +1,165 @@
+// gorfb project geometry.go
+// Rectangle and region helpers for computing update areas
+package gorfb
+
+import (
+	"image"
+	"sort"
+
+	"github.com/hduplooy/gorfb/internal/tile"
+)
+
+// Bounds returns the area covered by the rectangle
+func (r RFBRectangle) Bounds() image.Rectangle {
+	return image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height)
+}
+
+// copyRect reports if the rectangle is sent as a CopyRect
+func (r RFBRectangle) copyRect() bool {
+	return r.ForceEncoding && r.Encoding == EncCopyRect
+}
+
+// area returns the number of pixels in r
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
+
+// SubtractRect returns the parts of r that are not in s, as at most 4 non-overlapping rectangles
+func SubtractRect(r, s image.Rectangle) []image.Rectangle {
+	is := r.Intersect(s)
+	if is.Empty() {
+		if r.Empty() {
+			return nil
+		}
+		return []image.Rectangle{r}
+	}
+	var out []image.Rectangle
+	for _, part := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, is.Min.Y),   // Above
+		image.Rect(r.Min.X, is.Max.Y, r.Max.X, r.Max.Y),   // Below
+		image.Rect(r.Min.X, is.Min.Y, is.Min.X, is.Max.Y), // Left
+		image.Rect(is.Max.X, is.Min.Y, r.Max.X, is.Max.Y), // Right
+	} {
+		if !part.Empty() {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// SplitTiles splits r into tiles of at most tileWidth x tileHeight, row by row
+func SplitTiles(r image.Rectangle, tileWidth, tileHeight int) []image.Rectangle {
+	return tile.Split(r, tileWidth, tileHeight)
+}
+
+// MergeRects merges rectangles into their bounding box when that adds at most maxWaste pixels that are in
+// neither of them, fewer but larger rectangles are often cheaper to send
+// The largest rectangles are merged first and the result is sorted by area, largest first
+func MergeRects(rects []image.Rectangle, maxWaste int) []image.Rectangle {
+	out := make([]image.Rectangle, 0, len(rects))
+	for _, r := range rects {
+		if !r.Empty() {
+			out = append(out, r)
+		}
+	}
+	byArea := func() {
+		sort.SliceStable(out, func(i, j int) bool { return area(out[i]) > area(out[j]) })
+	}
+	byArea()
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(out); i++ {
+			for j := i + 1; j < len(out); j++ {
+				u := out[i].Union(out[j])
+				if area(u)-area(out[i])-area(out[j])+area(out[i].Intersect(out[j])) <= maxWaste {
+					out[i] = u
+					out = append(out[:j], out[j+1:]...)
+					j = i // Check the grown rectangle against all others again
+					merged = true
+				}
+			}
+		}
+	}
+	byArea()
+	return out
+}
+
+// Region is an area made up of non-overlapping rectangles, such as the damaged part of a framebuffer
+type Region []image.Rectangle
+
+// Add returns the region with r added, only the parts of r that are not in the region yet are added
+func (rg Region) Add(r image.Rectangle) Region {
+	if r.Empty() {
+		return rg
+	}
+	parts := []image.Rectangle{r}
+	for _, have := range rg {
+		var rest []image.Rectangle
+		for _, p := range parts {
+			rest = append(rest, SubtractRect(p, have)...)
+		}
+		parts = rest
+	}
+	return append(rg, parts...)
+}
+
+// Subtract returns the region without r
+func (rg Region) Subtract(r image.Rectangle) Region {
+	var out Region
+	for _, have := range rg {
+		out = append(out, SubtractRect(have, r)...)
+	}
+	return out
+}
+
+// Clip returns the part of the region inside bounds
+func (rg Region) Clip(bounds image.Rectangle) Region {
+	var out Region
+	for _, have := range rg {
+		if is := have.Intersect(bounds); !is.Empty() {
+			out = append(out, is)
+		}
+	}
+	return out
+}
+
+// Intersect returns the area that is in both regions
+func (rg Region) Intersect(other Region) Region {
+	var out Region
+	for _, r := range other {
+		out = append(out, rg.Clip(r)...)
+	}
+	return out
+}
+
+// Union returns the area that is in either region
+func (rg Region) Union(other Region) Region {
+	out := append(Region(nil), rg...)
+	for _, r := range other {
+		out = out.Add(r)
+	}
+	return out
+}
+
+// Bounds returns the smallest rectangle containing the whole region
+func (rg Region) Bounds() image.Rectangle {
+	var b image.Rectangle
+	for _, r := range rg {
+		b = b.Union(r)
+	}
+	return b
+}
+
+// Area returns the number of pixels in the region
+func (rg Region) Area() int {
+	n := 0
+	for _, r := range rg {
+		n += area(r)
+	}
+	return n
+}
+
+// Empty reports if the region covers no pixels
+func (rg Region) Empty() bool {
+	return len(rg) == 0
+}
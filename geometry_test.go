@@ -0,0 +1,149 @@
+package gorfb_test
+
+import (
+	"image"
+	"testing"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// covered returns the pixels covered by rects, failing if any pixel is covered twice
+func covered(t *testing.T, rects []image.Rectangle) map[image.Point]bool {
+	t.Helper()
+	out := make(map[image.Point]bool)
+	for _, r := range rects {
+		if r.Empty() {
+			t.Errorf("Empty rectangle %v in %v", r, rects)
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				p := image.Pt(x, y)
+				if out[p] {
+					t.Errorf("Pixel %v covered twice by %v", p, rects)
+				}
+				out[p] = true
+			}
+		}
+	}
+	return out
+}
+
+// pixelsOf returns the pixels of r for which keep returns true
+func pixelsOf(r image.Rectangle, keep func(p image.Point) bool) map[image.Point]bool {
+	out := make(map[image.Point]bool)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if p := image.Pt(x, y); keep(p) {
+				out[p] = true
+			}
+		}
+	}
+	return out
+}
+
+// samePixels reports if both sets hold the same pixels
+func samePixels(a, b map[image.Point]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p := range a {
+		if !b[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// geometryCases are pairs of rectangles in the relations the helpers have to handle
+var geometryCases = []struct {
+	name string
+	a, b image.Rectangle
+}{
+	{"both empty", image.Rectangle{}, image.Rectangle{}},
+	{"first empty", image.Rectangle{}, image.Rect(0, 0, 4, 4)},
+	{"second empty", image.Rect(0, 0, 4, 4), image.Rect(2, 2, 2, 2)},
+	{"disjoint", image.Rect(0, 0, 4, 4), image.Rect(10, 10, 12, 12)},
+	{"adjacent side", image.Rect(0, 0, 4, 4), image.Rect(4, 0, 8, 4)},
+	{"adjacent corner", image.Rect(0, 0, 4, 4), image.Rect(4, 4, 8, 8)},
+	{"overlapping", image.Rect(0, 0, 6, 6), image.Rect(3, 2, 9, 8)},
+	{"crossing", image.Rect(0, 3, 10, 5), image.Rect(4, 0, 6, 10)},
+	{"contained", image.Rect(0, 0, 10, 10), image.Rect(3, 3, 6, 6)},
+	{"containing", image.Rect(3, 3, 6, 6), image.Rect(0, 0, 10, 10)},
+	{"equal", image.Rect(1, 1, 5, 5), image.Rect(1, 1, 5, 5)},
+}
+
+func TestSubtractRect(t *testing.T) {
+	for _, c := range geometryCases {
+		got := gorfb.SubtractRect(c.a, c.b)
+		if len(got) > 4 {
+			t.Errorf("%s: %d rectangles", c.name, len(got))
+		}
+		want := pixelsOf(c.a, func(p image.Point) bool { return !p.In(c.b) })
+		if !samePixels(covered(t, got), want) {
+			t.Errorf("%s: %v - %v = %v", c.name, c.a, c.b, got)
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	for _, c := range geometryCases {
+		all := c.a.Union(c.b)
+		ra, rb := gorfb.Region{}.Add(c.a), gorfb.Region{}.Add(c.b)
+		union := ra.Union(rb)
+		if !samePixels(covered(t, union), pixelsOf(all, func(p image.Point) bool { return p.In(c.a) || p.In(c.b) })) {
+			t.Errorf("%s: union %v", c.name, union)
+		}
+		if got := ra.Add(c.b); !samePixels(covered(t, got), covered(t, union)) {
+			t.Errorf("%s: added %v instead of %v", c.name, got, union)
+		}
+		if union.Area() != len(covered(t, union)) || union.Empty() != (union.Area() == 0) {
+			t.Errorf("%s: area %d of %v", c.name, union.Area(), union)
+		}
+		if !union.Empty() && union.Bounds() != all {
+			t.Errorf("%s: bounds %v instead of %v", c.name, union.Bounds(), all)
+		}
+		diff := ra.Subtract(c.b)
+		if !samePixels(covered(t, diff), pixelsOf(c.a, func(p image.Point) bool { return !p.In(c.b) })) {
+			t.Errorf("%s: difference %v", c.name, diff)
+		}
+		both := ra.Intersect(rb)
+		if !samePixels(covered(t, both), pixelsOf(c.a, func(p image.Point) bool { return p.In(c.b) })) {
+			t.Errorf("%s: intersection %v", c.name, both)
+		}
+		if clip := union.Clip(c.b); !samePixels(covered(t, clip), covered(t, rb)) {
+			t.Errorf("%s: clipped to %v gives %v", c.name, c.b, clip)
+		}
+	}
+}
+
+func TestMergeRects(t *testing.T) {
+	for _, c := range geometryCases {
+		in := []image.Rectangle{c.a, c.b}
+		merged := gorfb.MergeRects(in, 0) // Only merged when that adds nothing
+		got := make(map[image.Point]bool)
+		for _, r := range merged {
+			if r.Empty() {
+				t.Errorf("%s: empty rectangle in %v", c.name, merged)
+			}
+			for p := range pixelsOf(r, func(image.Point) bool { return true }) {
+				got[p] = true
+			}
+		}
+		want := pixelsOf(c.a.Union(c.b), func(p image.Point) bool { return p.In(c.a) || p.In(c.b) })
+		if !samePixels(got, want) {
+			t.Errorf("%s: merged %v into %v", c.name, in, merged)
+		}
+		for i := 1; i < len(merged); i++ {
+			if merged[i].Dx()*merged[i].Dy() > merged[i-1].Dx()*merged[i-1].Dy() {
+				t.Errorf("%s: %v not sorted by area", c.name, merged)
+			}
+		}
+		if all := gorfb.MergeRects(in, 1<<20); len(all) > 1 || len(all) == 1 && all[0] != c.a.Union(c.b) {
+			t.Errorf("%s: merged without limit into %v", c.name, all)
+		}
+	}
+	if got := gorfb.MergeRects([]image.Rectangle{image.Rect(0, 0, 4, 4), image.Rect(4, 0, 8, 4)}, 0); len(got) != 1 ||
+		got[0] != image.Rect(0, 0, 8, 4) {
+		t.Errorf("Adjacent rectangles merged into %v", got)
+	}
+}
@@ -0,0 +1,167 @@
+// gorfb project gii.go
+// General Input Interface (gii) extension used by clients to send multitouch input
+package gorfb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	giiBigEndian      = 0x80
+	giiInjectEvents   = 0
+	giiVersion        = 1
+	giiDeviceCreate   = 2
+	giiDeviceDestroy  = 3
+	giiEvValAbsolute  = 13
+	giiValuatorLength = 116 // Size of a single valuator description in a device creation message
+	giiDeviceLength   = 56  // Size of the device creation message without the valuators
+)
+
+// TouchContact is a single contact point reported by a multitouch device
+type TouchContact struct {
+	ID       int // Identifies the contact for as long as it touches the surface
+	X, Y     int // Position of the contact
+	Pressure int // Pressure of the contact, 0 indicates the contact was lifted
+}
+
+// RFBTouchHandler can be implemented by a RFBServerHandler that wants to receive multitouch input
+// Clients send multitouch input through gii devices whose valuators are grouped per contact as
+// (id, x, y, pressure), so a device with 4*n valuators can report up to n contacts at a time
+type RFBTouchHandler interface {
+	// Handle the current set of contacts of a touch device
+	// conn is the RFB connection with the client
+	// device is the device origin assigned to the device when the client created it
+	// touches is all the contact slots of the device, lifted contacts have a zero pressure
+	ProcessTouchEvent(conn *RFBConn, device int, touches []TouchContact)
+}
+
+// giiDevice is a gii input device created by the client
+type giiDevice struct {
+	name   string
+	values []int32 // Current value of every valuator of the device
+}
+
+// giiExtension is the gii extension through which clients send multitouch and other extended input
+type giiExtension struct{}
+
+func (giiExtension) Name() string                      { return "GII" }
+func (giiExtension) PseudoEncodings() []Encoding       { return []Encoding{EncGII} }
+func (giiExtension) MessageTypes() []ClientMessageType { return []ClientMessageType{MsgClientGII} }
+
+// Enable lets the client know that gii is supported
+func (giiExtension) Enable(fb *RFBConn) error {
+	fb.mu.Lock()
+	fb.giiDevices = make(map[uint32]*giiDevice)
+	fb.mu.Unlock()
+	return fb.sendGIIVersion()
+}
+
+func (giiExtension) ProcessMessage(fb *RFBConn, msgType ClientMessageType) error {
+	return fb.processGII()
+}
+
+// sendGIIVersion tells the client that the gii extension (version 1) is supported
+func (fb *RFBConn) sendGIIVersion() error {
+	w := NewMessageWriter(8).Uint8(uint8(MsgServerGII)).Uint8(giiBigEndian | giiVersion).Uint16(4)
+	buf, err := w.Uint16(1).Uint16(1).Bytes() // Maximum and minimum version
+	if err != nil {
+		return err
+	}
+	return fb.write(buf)
+}
+
+// sendGIIDeviceOrigin sends the response on a device creation, an origin of 0 indicates failure
+func (fb *RFBConn) sendGIIDeviceOrigin(origin uint32) error {
+	buf, err := NewMessageWriter(8).Uint8(uint8(MsgServerGII)).Uint8(giiBigEndian | giiDeviceCreate).Uint16(4).Uint32(origin).Bytes()
+	if err != nil {
+		return err
+	}
+	return fb.write(buf)
+}
+
+// processGII reads a gii message (the message type byte has already been read) and processes it
+func (fb *RFBConn) processGII() error {
+	hdr := make([]byte, 3)
+	if _, err := io.ReadFull(fb.Conn, hdr); err != nil {
+		return err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if hdr[0]&giiBigEndian != 0 {
+		order = binary.BigEndian
+	}
+	msg := make([]byte, order.Uint16(hdr[1:]))
+	if _, err := io.ReadFull(fb.Conn, msg); err != nil {
+		return err
+	}
+	switch hdr[0] &^ giiBigEndian {
+	case giiVersion:
+		if len(msg) >= 2 {
+			fb.logf("gii version %d selected by client\n", order.Uint16(msg))
+		}
+	case giiDeviceCreate:
+		if len(msg) < giiDeviceLength {
+			return errors.New("gii device creation message too short")
+		}
+		valcnt := int(order.Uint32(msg[48:]))
+		if len(msg) != giiDeviceLength+valcnt*giiValuatorLength {
+			return fb.sendGIIDeviceOrigin(0)
+		}
+		name := msg[:31]
+		for i, b := range name {
+			if b == 0 {
+				name = name[:i]
+				break
+			}
+		}
+		fb.giiNextOrigin++
+		fb.giiDevices[fb.giiNextOrigin] = &giiDevice{name: string(name), values: make([]int32, valcnt)}
+		fb.logf("gii device %q created with %d valuators\n", name, valcnt)
+		return fb.sendGIIDeviceOrigin(fb.giiNextOrigin)
+	case giiDeviceDestroy:
+		if len(msg) >= 4 {
+			delete(fb.giiDevices, order.Uint32(msg))
+		}
+	case giiInjectEvents:
+		for len(msg) > 0 {
+			sz := int(msg[0])
+			if sz < 2 || sz > len(msg) {
+				return errors.New("invalid gii event size")
+			}
+			if msg[1] == giiEvValAbsolute {
+				fb.giiValuatorEvent(order, msg[:sz])
+			}
+			msg = msg[sz:]
+		}
+	}
+	return nil
+}
+
+// giiValuatorEvent applies an absolute valuator event to its device and passes the contacts on to the handler
+func (fb *RFBConn) giiValuatorEvent(order binary.ByteOrder, ev []byte) {
+	if len(ev) < 16 {
+		return
+	}
+	origin := order.Uint32(ev[4:])
+	first := int(order.Uint32(ev[8:]))
+	count := int(order.Uint32(ev[12:]))
+	dev, ok := fb.giiDevices[origin]
+	if !ok || count < 0 || len(ev) < 16+count*4 {
+		return
+	}
+	for i := 0; i < count && first+i < len(dev.values); i++ {
+		dev.values[first+i] = int32(order.Uint32(ev[16+i*4:]))
+	}
+	th, ok := fb.CurrentScreen().Handler.(RFBTouchHandler)
+	if !ok || len(dev.values) < 4 || !fb.acceptInput() {
+		return
+	}
+	touches := make([]TouchContact, len(dev.values)/4)
+	off := fb.offset()
+	for i := range touches {
+		v := dev.values[i*4:]
+		touches[i] = TouchContact{ID: int(v[0]), X: int(v[1]) + off.X, Y: int(v[2]) + off.Y, Pressure: int(v[3])}
+	}
+	fb.dispatch(inputQueue, func() { th.ProcessTouchEvent(fb, int(origin), touches) })
+}
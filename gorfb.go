@@ -5,13 +5,17 @@
 package gorfb
 
 import (
-	"bytes"
+	"context"
 	"crypto/des"
 	"crypto/rand"
+	"crypto/subtle"
 	"errors"
 	"fmt"
-	"log"
+	"image"
+	"io"
 	"net"
+	"sync"
+	"time"
 )
 
 const (
@@ -35,31 +39,317 @@ type PixelFormat struct {
 
 // RFBServer is the basic information that we need to start a RFB
 type RFBServer struct {
-	// On which port do we start the server (5900 is used as default)
+	// On which port do we start the server (5900 is used as default), with "0" the system chooses a free port that
+	// Addr reports
 	Port string
 	// Pixel Width of the FrameBuffer
 	Width int
 	// Pixel Height of the FrameBuffer
-	Height      int
+	Height int
+	// Pixel format of the buffers given to SendRectangles, with 24 bits per pixel the clients get 32 bits per pixel
+	// (depth 24) and the buffers are converted
 	PixelFormat PixelFormat
-	BufferName  string
+	// Layout of the buffers given to SendRectangles, if set it replaces the PixelFormat
+	Layout     PixelLayout
+	BufferName string
 	// The handler that will handle client requests
 	Handler RFBServerHandler
+	// Additional named screens, each with its own framebuffer and handler
+	Screens map[string]*Screen
+	// SelectScreen selects the name of the screen a client is attached to after security was agreed
+	// An empty name selects the server's own framebuffer and handler
+	SelectScreen func(conn *RFBConn) string
 	// Is authentication to be use
 	Authenticate bool
 	// If authentication is to be used, AuthText is the string to authenticate against
 	AuthText string
+	// Cut text is sent as Latin-1, if CutTextLossy is set characters that can not be represented are replaced
+	// by '?' otherwise SendCutText fails on them
+	CutTextLossy bool
+	// Use the UTF-8 extended clipboard for cut text with clients that support it
+	ExtendedClipboard bool
+	// Cut text is transferred in chunks of CutTextChunkSize bytes (64KiB if not set)
+	CutTextChunkSize int
+	// CutTextProgress is called after each chunk of cut text larger than a single chunk was transferred
+	// fromClient indicates the direction, done and total are the bytes transferred so far and in total
+	// Returning false cancels the transfer, a cancelled transfer to the client closes its connection
+	CutTextProgress func(conn *RFBConn, fromClient bool, done, total int) bool
+	// Clipboard is the policy applied to cut text, it can be overridden per connection
+	Clipboard ClipboardPolicy
+	// ClipboardBridge if set is kept in sync with the clipboard of every client
+	ClipboardBridge ClipboardBridge
+	// If ConvertPixelFormat is set the application always sends rectangles in the server's PixelFormat
+	// and they are converted to the pixel format requested by each client
+	ConvertPixelFormat bool
+	// Send rectangles in the best encoding each client supports (see SelectBestEncoding) instead of the first one it
+	// lists
+	BestEncoding bool
+	// How the shared flag sent by clients is treated
+	SharePolicy SharePolicy
+	// What happens when an exclusive client connects while other clients are connected to the same screen
+	ExclusivePolicy ExclusivePolicy
+	// If ControlToken is set only input from the client holding the control token of its screen reaches the
+	// handler, the other clients of that screen are view only
+	ControlToken bool
+	// Keysym that a client without control can press to take the control token of its screen (0 disables it)
+	ControlHotkey int
+	// OnControlChange is called when the control token of a screen is passed to another client (nil when released)
+	OnControlChange func(screen string, conn *RFBConn)
+	// Clients that have not sent input or update requests for IdleTimeout are disconnected (0 disables it)
+	IdleTimeout time.Duration
+	// How long before an idle client is disconnected it is warned with a bell and IdleWarningText as cut text
+	IdleWarning     time.Duration
+	IdleWarningText string
+	// What happens with clients that sent no update request within NoRequestTimeout (DefaultNoRequestTimeout if 0)
+	// of completing the handshake, by default they are waited for
+	NoRequestPolicy  NoRequestPolicy
+	NoRequestTimeout time.Duration
+	// Sessions are disconnected once they are older than MaxSessionDuration (0 for no limit)
+	MaxSessionDuration time.Duration
+	// Clients must complete the version, security and init phases within HandshakeTimeout
+	// (DefaultHandshakeTimeout if 0, negative for no limit)
+	HandshakeTimeout time.Duration
+	// OnDisconnect is called when a session that completed the handshake ends
+	OnDisconnect func(conn *RFBConn, info DisconnectInfo)
+	// If ExitOnLastClient is set the server stops serving once the last client disconnected
+	ExitOnLastClient bool
+	// Clients that lost their connection and reconnect within ResumeWindow get their earlier session state (screen,
+	// view only flag, pixel format and encodings) back and a full refresh (0 disables resuming)
+	ResumeWindow time.Duration
+	// ResumeToken returns the resume secret a reconnecting client presented (see RFBConn.ResumeSecret), for example
+	// from the URL of its WebSocket connection, empty if it presented none. Without it sessions are not resumed.
+	ResumeToken func(conn *RFBConn) string
+	// Resuming clients skip authentication
+	ResumeSkipAuth bool
+	// Show a notification overlay to the other clients when a client connects or disconnects
+	ConnectNotifications bool
+	// Greeting delivered to every client once it connected (nil for none)
+	Greeting *Greeting
+	// Update requests reaching outside the framebuffer are normally clipped to it, with StrictUpdateRequests
+	// they are ignored instead
+	StrictUpdateRequests bool
+	// What to do with invalid pixel formats sent by clients
+	PixelFormatPolicy PixelFormatPolicy
+	// Which pixel format is offered to clients and which formats they may ask for
+	PixelFormatHints PixelFormatHints
+	// Limits on what clients may send
+	Limits Limits
+	// How congestion of the connections is reported
+	FlowControl FlowControl
+	// With BandwidthProbe clients that enable fences are sent a burst of BandwidthProbeSize bytes
+	// (DefaultBandwidthProbeSize if 0) of fences straight away, timing the responses (for at most
+	// BandwidthProbeTimeout, DefaultBandwidthProbeTimeout if 0) estimates the link's capacity before the
+	// handler has to choose the quality of the first updates. The estimate is passed to OnBandwidthProbe and
+	// returned by the connection's Bandwidth.
+	BandwidthProbe        bool
+	BandwidthProbeSize    int
+	BandwidthProbeTimeout time.Duration
+	OnBandwidthProbe      func(conn *RFBConn, est BandwidthEstimate)
+	// Protocol extensions in addition to the built in ones
+	Extensions []Extension
+	// With FairBroadcast BroadcastRectangles queues the update for every client and returns right away, the
+	// clients are sent to in turns of at most BroadcastBudget bytes (DefaultBroadcastBudget if 0) by
+	// BroadcastWorkers goroutines (DefaultBroadcastWorkers if 0). Updates that pile up for a slow client are
+	// combined, so the other clients do not wait for it.
+	FairBroadcast    bool
+	BroadcastWorkers int
+	BroadcastBudget  int
+	// Updates are replaced by the BlankImage (centered on black) once no client sent input for BlankAfter
+	// (0 disables it) until a client sends input again, Blank does this on demand
+	BlankAfter time.Duration
+	BlankImage image.Image
+	// Watermark returns the overlay every update sent to a client is stamped with, for example the user name and
+	// the time (nil for none). It is asked for after Init and again every WatermarkInterval (DefaultWatermarkInterval
+	// if 0, negative to only ask once), a watermark that changed is resent to the client. Like the other overlays it
+	// is only drawn for true color clients.
+	Watermark         func(conn *RFBConn) *Overlay
+	WatermarkInterval time.Duration
+	// Options for the sockets StartServer and ServeScreen listen on and for every accepted connection
+	Listen ListenOptions
+	// With ReverseDNS the IP address of every client is resolved in the background (for at most ReverseDNSTimeout,
+	// DefaultReverseDNSTimeout if 0), the host name is used in log messages and returned by the connection's Hostname
+	ReverseDNS        bool
+	ReverseDNSTimeout time.Duration
+	// Logf is used for the server's log messages (log.Printf if nil)
+	Logf func(format string, args ...interface{})
+	// Messages of the same kind (format) are logged at most LogBurst times (DefaultLogBurst if 0) per LogInterval
+	// (DefaultLogInterval if 0, negative to log everything), the others are counted in LogStats
+	LogInterval time.Duration
+	LogBurst    int
+	// With DamageHints the application marks what changed with MarkDirty and incremental update requests are
+	// answered by asking the handler for exactly those areas, the handler only gets full update requests
+	DamageHints bool
+	// Dispatch determines from which goroutine the handler is called for client messages (inline by default)
+	Dispatch DispatchMode
+	// The 12 bytes of the client's protocol version are parsed leniently, ignoring whitespace and trailing garbage.
+	// With StrictVersion only the exact version strings of RFB3.3, RFB3.7 and RFB3.8 are accepted
+	StrictVersion bool
+	// HandshakePolicy restricts the protocol versions and security types clients can use
+	HandshakePolicy HandshakePolicy
+	// OnHandshakeRejected is called when a client is refused for its protocol version or security type
+	OnHandshakeRejected func(conn *RFBConn, rejection HandshakeRejection)
+	// UpdateFilter can change the rectangles of every update before they are sent, overlays are drawn afterwards
+	UpdateFilter UpdateFilter
+	// DescribeSession is called once a client sent ClientInit, the settings it returns override what the client is
+	// told in ServerInit
+	DescribeSession func(conn *RFBConn, desc SessionDescriptor) SessionSettings
+	// Compressor provides the zlib streams of the compressing encodings (StdCompressor if nil)
+	Compressor Compressor
+	// Identify returns the identity of a client that completed the security handshake, for example from its TLS
+	// client certificate, empty if it has none
+	Identify func(conn *RFBConn) string
+	// ByteQuota returns how many bytes the clients of an identity may be sent over all their sessions (0 for no
+	// limit), clients are disconnected when it is used up and refused until ResetQuota
+	ByteQuota func(identity string) int64
+	// OnQuotaWarning is called once QuotaWarning (DefaultQuotaWarning if 0, negative for never) of the byte quota
+	// of an identity is used
+	QuotaWarning   float64
+	OnQuotaWarning func(conn *RFBConn, identity string, used, limit int64)
+	// Active connections
+	mu         sync.Mutex
+	controller map[string]*RFBConn // Holder of the control token of each screen
+	listeners  []net.Listener
+	connected  chan struct{} // Closed once the first client connected
+	listening  chan struct{} // Closed once the server listens
+	didListen  bool
+	handoff    net.Listener  // Handoff socket served for the next process
+	active     int           // Connections accepted or attached and not closed yet
+	drained    chan struct{} // Closed once active is 0 while draining
+	hadClient  bool
+	resumable  map[string]*resumeState
+	quotaUsed  map[string]int64 // Bytes sent per identity
+	conns      map[int]*RFBConn
+	nextID     int
+	overlays   []*activeOverlay
+	cursor     *CursorManager
+	scheduler  *scheduler
+	statics    map[string]*staticImage
+	encoders   map[Encoding]Encoder // Registered with RegisterEncoder
+	// Blanking state and the blank frames rendered per framebuffer size
+	blanked     bool
+	blankTimer  *time.Timer
+	blankFrames map[image.Point]*image.RGBA
+	logs        logLimiter
+	bridge      bridgeState
 }
 
 // RFBConn is created when a successful TCP/IP connection was made with the client
 type RFBConn struct {
+	// ID identifies the connection among the server's active connections
+	ID int
 	// Link to the server info that was used to create this connection
 	Server *RFBServer
-	// The Socket connection to the client
-	Conn net.Conn
+	// The screen (framebuffer and handler) the client is attached to, read it with CurrentScreen once the session
+	// runs as SetScreen may switch it
+	Screen *Screen
+	// The connection to the client, usually a net.Conn. Other transports are served with ServeTransport.
+	Conn io.ReadWriteCloser
+	// Serializes writes so that messages sent from different goroutines do not interleave
+	wmu sync.Mutex
+	// Serializes updates in encodings with state, from encoding them until they are written (taken before wmu)
+	emu sync.Mutex
+	// Protects connection state that is shared between goroutines
+	mu sync.Mutex
+	// Closed when the connection is closed
+	done chan struct{}
+	// gii input devices created by the client
+	giiDevices    map[uint32]*giiDevice
+	giiNextOrigin uint32
+	// Extensions enabled by the client
+	enabledExtensions []Extension
+	// Extended clipboard state
+	extClipboard   bool   // Extended clipboard is in use with this client
+	extClientFlags uint32 // Capabilities announced by the client
+	extClipText    string // Last text made available to the client
+	// Clipboard policy overriding the server's policy
+	clipPolicy *ClipboardPolicy
+	// Last text exchanged with the clipboard bridge
+	bridgeText string
+	// Reason given when the connection was closed
+	closeReason string
+	// Host name of the client found by reverse DNS
+	hostname string
+	// Set once ServerInit has been sent, before that only the handshake may write to the client
+	initialized bool
+	// Security type the client completed and the pixel format offered to it by DescribeSession
+	security   SecurityType
+	advertised *PixelFormat
+	// Cut text, bells and desktop names are held back in pending until the client is ready for them
+	readyMu sync.Mutex
+	ready   bool
+	pending pendingSends
+	// Pixel format requested by the client
+	pixelFormat PixelFormat
+	// Client shares the session with other clients
+	shared bool
+	// Encodings supported by the client in order of preference
+	encodings []Encoding
+	// Input from the client is ignored
+	viewOnly bool
+	// The control hotkey took control and was not released yet
+	hotkeyHeld bool
+	// Last time the client sent input or an update request and whether it sent an update request at all
+	lastActivity time.Time
+	requested    bool
+	// When the handshake completed
+	started time.Time
+	// Name of the screen the connection is pinned to by its listener
+	screenName string
+	// State of an earlier session that is resumed and the secret for resuming this one
+	resumed      *resumeState
+	resumeSecret string
+	// The connection was closed because writing to it failed, not by the server
+	connLost bool
+	// Identity given by the server's Identify
+	identity string
+	// Overlays shown only to this client and its watermark
+	overlays  []*activeOverlay
+	watermark *activeOverlay
+	// Update requests not yet answered and the area they cover (in client coordinates)
+	outstandingUpdates int
+	outstandingArea    image.Rectangle
+	// Minor protocol version agreed with the client (3, 7 or 8)
+	version int
+	// Bytes waiting to be sent and whether the client is congested or stalled
+	flow flowState
+	// Statistics for the session report
+	counters sessionCounters
+	// Bandwidth probe in progress and its estimate
+	probe     *bandwidthProbe
+	bandwidth BandwidthEstimate
+	probed    bool
+	// Areas marked dirty that the client has not been sent yet and the area of an incremental update request
+	// waiting for something to be marked dirty (both in client coordinates)
+	dirty        Region
+	dirtyWaiting *image.Rectangle
+	// Updates are paused, the areas of the updates held back are in pausedDamage (in client coordinates)
+	paused       bool
+	pausedDamage Region
+	// Areas blanked in every update (in the handler's coordinates)
+	redactions []image.Rectangle
+	// Streams of the Zlib, ZRLE and Tight encodings, guarded by emu. After Attach the client's streams belong to the
+	// server it came from and encodings with streams are not used.
+	zlib        *zlibStream
+	zrle        *zlibStream
+	tight       [4]*zlibStream
+	streamsLost bool
+	// Continuous updates are enabled for continuousArea (in client coordinates)
+	continuous     bool
+	continuousArea image.Rectangle
+	// Set by Detach, the read loop hands the connection over between messages
+	detach    chan detachResult
+	inMessage bool
+	// Workers calling the handler if it is not called inline and whether dispatching stopped with the read loop
+	dispatcher      *dispatcher
+	dispatchStopped bool
+	// Cursor state: a new cursor shape is due, the last pointer position and where the cursor was drawn
+	cursorPending bool
+	pointer       image.Point
+	cursorArea    image.Rectangle
 }
 
 // RFBServerHandler is an interface with the function to handle requests
+// Handlers can embed BaseHandler to only implement the methods they need
 type RFBServerHandler interface {
 	// Init is called as soon as RFB has been successfully established with client. Used by app to initialize any info for a session
 	// conn is the RFB connection with the client, it is used by an app to send image data as well as cuttext information
@@ -70,7 +360,8 @@ type RFBServerHandler interface {
 	ProcessSetPixelFormat(conn *RFBConn, pf PixelFormat)
 	// Handle indication by client what encoding formats can be used (for now we ignore them and use raw)
 	// conn is the RFB connection with the client
-	// encodings is a slice of encodings supported by the client (refer to protocol)
+	// encodings is a slice of encodings supported by the client (refer to protocol), conn.Encodings returns them
+	// as Encoding values to compare with the Enc constants
 	ProcessSetEncoding(conn *RFBConn, encodings []int)
 	// Handle request by client to send part of
 	// conn is the RFB connection with the client
@@ -96,28 +387,39 @@ type RFBServerHandler interface {
 type RFBRectangle struct {
 	X, Y, Width, Height int
 	Buffer              []byte
+	// Encoding the rectangle is sent in instead of the encoding chosen for the connection, if ForceEncoding is set
+	// An encoding the client did not list is replaced by the connection's encoding, except for Raw which all clients
+	// support. A CopyRect rectangle has no Buffer, the client copies the area at SrcX,SrcY of its framebuffer.
+	Encoding      Encoding
+	ForceEncoding bool
+	SrcX, SrcY    int
 }
 
-// agreeProtocol is used to first agree on RFB3.8 as the protocol to use
+// agreeProtocol is used to first agree on the protocol version to use, the server offers RFB3.8 and also
+// speaks RFB3.3 and RFB3.7 with clients that ask for them
 // if an error is experienced at any point false is returned
 func (fb *RFBConn) agreeProtocol() bool {
 	sndsz, err := fmt.Fprintf(fb.Conn, PROTOCOL)
 	if err != nil {
-		log.Printf("Error sending server protocol: %s\n", err.Error())
+		fb.logf("Error sending server protocol: %s\n", err.Error())
 		return false
 	}
 	if sndsz != len(PROTOCOL) {
-		log.Println("Full protocol version was not sent to client!")
+		fb.logf("Full protocol version was not sent to client!\n")
 		return false
 	}
-	buf := make([]byte, 12)
-	sz, err := fb.Conn.Read(buf)
+	buf := make([]byte, 12) // The version is always 12 bytes, a lenient parse only tolerates what is in them
+	if _, err = io.ReadFull(fb.Conn, buf); err != nil {
+		fb.logf("Error receiving client protocol: %s\n", err.Error())
+		return false
+	}
+	fb.version, err = parseVersion(buf, fb.Server.StrictVersion)
 	if err != nil {
-		log.Printf("Error receiving client protocol: %s\n", err.Error())
+		fb.logf("%s\n", err.Error())
 		return false
 	}
-	if string(buf[:sz]) != PROTOCOL {
-		log.Println("The client doesn't support RFB3.8!")
+	if !fb.Server.HandshakePolicy.allowsVersion(fb.version) {
+		fb.refuseHandshake(SecInvalid, fmt.Sprintf("Protocol version 3.%d is not allowed", fb.version))
 		return false
 	}
 	return true
@@ -153,107 +455,165 @@ func fixDesKey(key string) []byte {
 }
 
 // agreeSecurity does the agreement on the security between server and client
-// Currently only no auth is used, it will be changed shortly
+// Either no authentication or VNC authentication is used
 func (fb *RFBConn) agreeSecurity() bool {
-	buf := make([]byte, 8+len([]byte(AUTH_FAIL)))
-	buf[0] = 1
-	if fb.Server.Authenticate {
-		buf[1] = 2 // Client must authenticate
-	} else {
-		buf[1] = 1 // No authentication
-	}
-	sndsz, err := fb.Conn.Write(buf[:2])
-	if sndsz != 2 || err != nil {
-		log.Printf("Error sending security types: %s\n", err.Error())
-		return false
+	fb.lookupResume()
+	policy := fb.Server.HandshakePolicy
+	auth := fb.Server.Authenticate && !(fb.resumed != nil && fb.Server.ResumeSkipAuth && policy.allowsSecurity(SecNone))
+	sectype := SecNone
+	if auth {
+		sectype = SecVNCAuth // Client must authenticate
 	}
-	sz, err := fb.Conn.Read(buf[:1])
-	if sz != 1 || err != nil {
-		log.Printf("Error reading security type from client: %s\n", err.Error())
+	if !policy.allowsSecurity(sectype) {
+		fb.refuseHandshake(sectype, fmt.Sprintf("Security type %s is not allowed", sectype))
 		return false
 	}
-	log.Printf("Security type %d requested by client\n", buf[0])
-	if fb.Server.Authenticate {
-		rand.Read(buf[:16]) // Random 16 bytes in buf
-		sndsz, err = fb.Conn.Write(buf[:16])
-		if err != nil {
-			log.Printf("Error sending challenge to client: %s\n", err.Error())
+	fb.security = sectype
+	if fb.version == 3 { // With RFB3.3 the server decides on the security type
+		if _, err := fb.Conn.Write([]byte{0, 0, 0, byte(sectype)}); err != nil {
+			fb.logf("Error sending security type: %s\n", err.Error())
 			return false
 		}
-		if sndsz != 16 {
-			log.Printf("The full 16 byte challenge was not sent!\n")
+	} else {
+		if _, err := fb.Conn.Write([]byte{1, byte(sectype)}); err != nil {
+			fb.logf("Error sending security types: %s\n", err.Error())
 			return false
 		}
-		buf2 := make([]byte, 16)
-		_, err := fb.Conn.Read(buf2)
-		if err != nil {
-			log.Printf("The authentication result was not read: %s\n", err.Error())
+		buf := make([]byte, 1)
+		if _, err := io.ReadFull(fb.Conn, buf); err != nil {
+			fb.logf("Error reading security type from client: %s\n", err.Error())
 			return false
 		}
-		bk, err := des.NewCipher([]byte(fixDesKey(fb.Server.AuthText)))
-		if err != nil {
-			log.Printf("Error generating authentication cipher: %s\n", err.Error())
+		fb.logf("Security type %s requested by client\n", SecurityType(buf[0]))
+		if SecurityType(buf[0]) != sectype {
+			fb.sendSecurityResult("Security type not supported")
+			fb.handshakeRejected(SecurityType(buf[0]), "Security type not supported")
 			return false
 		}
-		buf3 := make([]byte, 16)
-		bk.Encrypt(buf3, buf)               //Encrypt first 8 bytes
-		bk.Encrypt(buf3[8:], buf[8:])       // Encrypt second 8 bytes
-		if bytes.Compare(buf2, buf3) != 0 { // If the result does not decrypt correctly to what we sent then a problem
-			SetUint32(buf, 0, 1)
-			SetUint32(buf, 4, uint32(len([]byte(AUTH_FAIL))))
-			copy(buf[8:], []byte(AUTH_FAIL))
-			fb.Conn.Write(buf)
+	}
+	if auth {
+		if !fb.vncAuthenticate() {
+			fb.sendSecurityResult(AUTH_FAIL)
 			return false
 		}
 	}
+	resultExpected := auth || fb.version == 8 // Before RFB3.8 there is no security result without authentication
+	if !fb.identify(resultExpected) {
+		return false
+	}
+	if !resultExpected {
+		return true
+	}
 	// Authentication was either none or it was successful
-	SetUint32(buf, 0, 0)
-	sndsz, err = fb.Conn.Write(buf[:4])
-	if sndsz != 4 || err != nil {
-		log.Printf("Error sending security successful notification: %s\n", err.Error())
+	if err := fb.sendSecurityResult(""); err != nil {
+		fb.logf("Error sending security successful notification: %s\n", err.Error())
 		return false
 	}
-	log.Printf("Security successful notification sent!\n")
+	fb.logf("Security successful notification sent!\n")
 	return true
+}
+
+// sendSecurityResult tells the client if the security handshake succeeded, reason is empty on success
+// Only RFB3.8 clients are sent the reason of a failure
+func (fb *RFBConn) sendSecurityResult(reason string) error {
+	if reason == "" {
+		_, err := fb.Conn.Write([]byte{0, 0, 0, 0})
+		return err
+	}
+	fb.logf("Security handshake failed: %s\n", reason)
+	w := NewMessageWriter(4).Uint32(1)
+	if fb.version == 8 {
+		w = NewMessageWriter(8 + len(reason)).Uint32(1).Uint32(uint32(len(reason))).Data([]byte(reason))
+	}
+	msg, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = fb.Conn.Write(msg)
+	return err
+}
+
+// vncAuthenticate performs VNC authentication: the client must return a freshly generated random challenge
+// encrypted with the password. The challenge, response and key are zeroed once done.
+// false is returned if the client failed to authenticate, the caller sends the security result
+func (fb *RFBConn) vncAuthenticate() bool {
+	challenge := make([]byte, 16)
+	response := make([]byte, 16)
+	expected := make([]byte, 16)
+	key := fixDesKey(fb.Server.AuthText)
+	defer zeroBytes(challenge, response, expected, key)
+	if _, err := rand.Read(challenge); err != nil {
+		fb.logf("Error generating authentication challenge: %s\n", err.Error())
+		return false
+	}
+	if sndsz, err := fb.Conn.Write(challenge); err != nil || sndsz != len(challenge) {
+		fb.logf("Error sending challenge to client: %v\n", err)
+		return false
+	}
+	if _, err := io.ReadFull(fb.Conn, response); err != nil { // The response is exactly 16 bytes
+		fb.logf("The authentication result was not read: %s\n", err.Error())
+		return false
+	}
+	bk, err := des.NewCipher(key)
+	if err != nil {
+		fb.logf("Error generating authentication cipher: %s\n", err.Error())
+		return false
+	}
+	bk.Encrypt(expected, challenge)                            // Encrypt first 8 bytes
+	bk.Encrypt(expected[8:], challenge[8:])                    // Encrypt second 8 bytes
+	return subtle.ConstantTimeCompare(response, expected) == 1 // The result must match what we expect
+}
 
+// zeroBytes overwrites buffers that held sensitive data
+func zeroBytes(bufs ...[]byte) {
+	for _, buf := range bufs {
+		for i := range buf {
+			buf[i] = 0
+		}
+	}
 }
 
 // performInit sends the dimensions and pixel information as part of the initializing phase
 // If an error is experienced at any time a false is returned
 func (fb *RFBConn) performInit() bool {
 	buf := make([]byte, 100)
-	_, err := fb.Conn.Read(buf[:1])
+	_, err := io.ReadFull(fb.Conn, buf[:1])
+	if err != nil {
+		fb.logf("Error reading init request from client: %s\n", err.Error())
+		return false
+	}
+	fb.logf("Share buffer with other clients: %v\n", buf[0] == 1)
+	if !fb.applySharePolicy(buf[0] == 1) {
+		return false
+	}
+	fb.describeSession(buf[0] == 1)
+	// Client uses the server's pixel format (or the advertised one) until it asks otherwise
+	pf := fb.initPixelFormat()
+	fb.pixelFormat = pf
+
+	name := []byte(fb.desktopName())
+	w := NewMessageWriter(24 + len(name))
+	screen := fb.CurrentScreen()
+	w.Uint16(uint16(screen.Width)).Uint16(uint16(screen.Height)) // Buffer dimensions
+	pf.write(w)
+	w.Uint32(uint32(len(name))).Data(name)
+	msg, err := w.Bytes()
 	if err != nil {
-		log.Printf("Error reading init request from client: %s\n", err.Error())
+		fb.logf("Error building init info: %s\n", err.Error())
 		return false
 	}
-	log.Printf("Share buffer with other clients: %v\n", buf[0] == 1)
-	SetUint16(buf, 0, uint16(fb.Server.Width))         // Buffer width
-	SetUint16(buf, 2, uint16(fb.Server.Height))        // Buffer height
-	buf[4] = fb.Server.PixelFormat.BitsPerPixel        // Bits per pixel
-	buf[5] = fb.Server.PixelFormat.Depth               // Depth
-	buf[6] = fb.Server.PixelFormat.BigEndian           // Big Endian
-	buf[7] = fb.Server.PixelFormat.TrueColor           // True Color
-	SetUint16(buf, 8, fb.Server.PixelFormat.RedMax)    // Max red
-	SetUint16(buf, 10, fb.Server.PixelFormat.GreenMax) // Max green
-	SetUint16(buf, 12, fb.Server.PixelFormat.BlueMax)  // Max blue
-	buf[14] = fb.Server.PixelFormat.RedShift           // red shift
-	buf[15] = fb.Server.PixelFormat.GreenShift         // green shift
-	buf[16] = fb.Server.PixelFormat.BlueShift          // blue shift
-	buf[17] = 0                                        // padding
-	buf[18] = 0                                        // padding
-	buf[19] = 0                                        // padding
-	SetUint32(buf, 20, uint32(len(fb.Server.BufferName)))
-	copy(buf[24:], []byte(fb.Server.BufferName))
-	sz, err := fb.Conn.Write(buf[:24+len(fb.Server.BufferName)])
+	sz, err := fb.Conn.Write(msg)
 	if err != nil {
-		log.Printf("Error sending init info: %s\n", err.Error())
+		fb.logf("Error sending init info: %s\n", err.Error())
 		return false
 	}
-	if sz != 24+len(fb.Server.BufferName) {
-		log.Printf("The init data was not sent to the client\n")
+	if sz != len(msg) {
+		fb.logf("The init data was not sent to the client\n")
 		return false
 	}
+	fb.mu.Lock()
+	fb.initialized = true
+	fb.mu.Unlock()
 	return true
 }
 
@@ -261,104 +621,207 @@ func (fb *RFBConn) performInit() bool {
 // for each request the appropriate call to the correct RFBServerHandler function is made
 func (fb *RFBConn) processClientRequest() {
 	defer fb.Conn.Close()
+	fb.startDispatch()
+	defer fb.stopDispatch()
 	for {
+		if fb.messageDone() { // Between messages the connection can be detached
+			fb.finishDetach()
+			return
+		}
 		buf := make([]byte, 100)
-		_, err := fb.Conn.Read(buf[:1]) // Read the command byte sent by the client
+		_, err := io.ReadFull(fb.Conn, buf[:1]) // Read the command byte sent by the client
 		if err == nil {
-			switch buf[0] {
-			case 0: // Set Pixel Format
-				_, err := fb.Conn.Read(buf[:19]) // Read the 16 bytes for the pixel format + 3 lead padding bytes
+			fb.messageStarted()
+			switch msgType := ClientMessageType(buf[0]); msgType {
+			case MsgSetPixelFormat:
+				_, err := io.ReadFull(fb.Conn, buf[:19]) // Read the 16 bytes for the pixel format + 3 lead padding bytes
 				if err != nil {
-					log.Printf("Error reading info: %s\n", err.Error())
+					fb.logf("Error reading info: %s\n", err.Error())
 					return
 				}
-				pf := PixelFormat{buf[3], buf[4], buf[5], buf[6], GetUint16(buf, 7), GetUint16(buf, 9), GetUint16(buf, 11), buf[13], buf[14], buf[15]}
-				fb.Server.Handler.ProcessSetPixelFormat(fb, pf)
-			case 1: // FixColorMapEntries - not part of RFB 3.8 but some VNC clients send it anyway. We just ignore it
-				_, err := fb.Conn.Read(buf[:6])
-				if err != nil {
-					log.Printf("Error reading FixColorMapEntries (1): %s\n", err.Error())
+				r := NewMessageReader(buf[:19])
+				r.Skip(3)
+				pf, ok := fb.checkClientPixelFormat(readPixelFormat(r))
+				if err := r.Err(); err != nil {
+					fb.logf("Error reading pixel format: %s\n", err.Error())
 					return
 				}
-				cnt := int(GetUint16(buf, 4))
-				tmpbuf := make([]byte, 6*cnt)
-				_, err = fb.Conn.Read(tmpbuf)
-				if err != nil {
-					log.Printf("Error reading FixColorMapEntries (2): %s\n", err.Error())
+				if !ok {
+					continue
+				}
+				fb.mu.Lock()
+				fb.pixelFormat = pf
+				fb.mu.Unlock()
+				fb.dispatch(updateQueue, func() { fb.CurrentScreen().Handler.ProcessSetPixelFormat(fb, pf) })
+			case MsgFixColourMapEntries:
+				if err := fb.processFixColourMapEntries(); err != nil {
+					fb.logf("Error reading FixColourMapEntries: %s\n", err.Error())
 					return
 				}
-			case 2: // Set Encoding
-				_, err := fb.Conn.Read(buf[:3]) // Read 3 bytes with encoding count (number of encodings following)
+			case MsgSetEncodings:
+				_, err := io.ReadFull(fb.Conn, buf[:3]) // Read 3 bytes with encoding count (number of encodings following)
 				if err != nil {
-					log.Printf("Error reading count of encoding types: %s\n", err.Error())
+					fb.logf("Error reading count of encoding types: %s\n", err.Error())
 					return
 				}
-				cnt := int(GetUint16(buf, 1))      // Get count from buffer
-				_, err = fb.Conn.Read(buf[:cnt*4]) // For the number of encodings times 4 (for uint32) read the encodings
+				cnt := int(NewMessageReader(buf[1:3]).Uint16()) // Get count from buffer
+				if err := checkLimit("encodings", cnt, fb.Server.Limits.MaxEncodings, DefaultMaxEncodings); err != nil {
+					fb.logf("%s\n", err.Error())
+					return
+				}
+				encbuf := make([]byte, cnt*4)
+				_, err = io.ReadFull(fb.Conn, encbuf) // For the number of encodings times 4 (for uint32) read the encodings
 				if err != nil {
-					log.Printf("Error reading encoding types: %s\n", err.Error())
+					fb.logf("Error reading encoding types: %s\n", err.Error())
 					return
 				}
-				encodings := make([]int, cnt)
+				encodings := make([]Encoding, cnt)
+				r := NewMessageReader(encbuf)
 				for i := 0; i < cnt; i++ {
-					encodings[i] = int(GetUint32(buf, i*4))
+					encodings[i] = Encoding(r.Int32()) // Encodings are signed, pseudo-encodings are negative
 				}
-				fb.Server.Handler.ProcessSetEncoding(fb, encodings)
-			case 3: // FB Update Request
-				_, err := fb.Conn.Read(buf[:9]) // Read the bounds of the rectangle requested as well as the incremental flag
+				if err := r.Err(); err != nil {
+					fb.logf("Error reading encoding types: %s\n", err.Error())
+					return
+				}
+				fb.mu.Lock()
+				fb.encodings = encodings
+				fb.mu.Unlock()
+				fb.dispatch(updateQueue, func() { fb.CurrentScreen().Handler.ProcessSetEncoding(fb, encodingInts(encodings)) })
+				fb.cursorChanged()
+				if err := fb.enableExtensions(encodings); err != nil {
+					fb.logf("%s\n", err.Error())
+					return
+				}
+				fb.becomeReady()
+			case MsgFramebufferUpdateRequest:
+				_, err := io.ReadFull(fb.Conn, buf[:9]) // Read the bounds of the rectangle requested as well as the incremental flag
 				if err != nil {
-					log.Printf("Error reading Frame Buffer Update info: %s\n", err.Error())
+					fb.logf("Error reading Frame Buffer Update info: %s\n", err.Error())
+					return
+				}
+				r := NewMessageReader(buf[:9])
+				inc := r.Uint8()
+				x := int(r.Uint16())
+				y := int(r.Uint16())
+				width := int(r.Uint16())
+				height := int(r.Uint16())
+				if err := r.Err(); err != nil {
+					fb.logf("Error reading Frame Buffer Update info: %s\n", err.Error())
 					return
 				}
-				inc := buf[0]
-				x := int(GetUint16(buf, 1))
-				y := int(GetUint16(buf, 3))
-				width := int(GetUint16(buf, 5))
-				height := int(GetUint16(buf, 7))
-				fb.Server.Handler.ProcessUpdateRequest(fb, x, y, width, height, inc == 1)
-			case 4: // Key Event
-				_, err := fb.Conn.Read(buf[:7]) // Read the key and the downflag
+				fb.markActive()
+				fb.updateRequestSeen()
+				if x, y, width, height, ok := fb.clampUpdateRequest(x, y, width, height); ok {
+					dispatch, ok := fb.updateRequested(image.Rect(x, y, x+width, y+height), inc == 1)
+					if !ok {
+						fb.logf("Too many outstanding update requests, request dropped\n")
+					}
+					if !dispatch {
+						continue
+					}
+					fb.becomeReady()
+					fb.sendPendingCursor()
+					fb.dispatch(updateQueue, func() { fb.requestUpdate(x, y, width, height, inc == 1) })
+				}
+			case MsgKeyEvent:
+				_, err := io.ReadFull(fb.Conn, buf[:7]) // Read the key and the downflag
 				if err != nil {
-					fmt.Printf("Error reading Key RFBEvent info: %s\n", err.Error())
+					fb.logf("Error reading Key RFBEvent info: %s\n", err.Error())
 					return
 				}
-				downflag := buf[0] == 1
-				key := int(GetUint32(buf, 3))
-				fb.Server.Handler.ProcessKeyEvent(fb, key, downflag)
-			case 5: // Pointer Event
-				_, err := fb.Conn.Read(buf[:5]) // Read the coordinates and the button mask
+				r := NewMessageReader(buf[:7])
+				downflag := r.Uint8() == 1
+				r.Skip(2)
+				key := int(r.Uint32())
+				if err := r.Err(); err != nil {
+					fb.logf("Error reading Key RFBEvent info: %s\n", err.Error())
+					return
+				}
+				fb.markActive()
+				if !fb.controlHotkey(key, downflag) && fb.acceptInput() {
+					fb.Server.inputReceived()
+					fb.dispatch(inputQueue, func() { fb.CurrentScreen().Handler.ProcessKeyEvent(fb, key, downflag) })
+				}
+			case MsgPointerEvent:
+				_, err := io.ReadFull(fb.Conn, buf[:5]) // Read the coordinates and the button mask
 				if err != nil {
-					log.Printf("Error reading Pointer RFBEvent info: %s\n", err.Error())
+					fb.logf("Error reading Pointer RFBEvent info: %s\n", err.Error())
+					return
+				}
+				r := NewMessageReader(buf[:5])
+				buttonmask := int(r.Uint8())
+				x := int(r.Uint16())
+				y := int(r.Uint16())
+				if err := r.Err(); err != nil {
+					fb.logf("Error reading Pointer RFBEvent info: %s\n", err.Error())
 					return
 				}
-				buttonmask := int(buf[0])
-				x := int(GetUint16(buf, 1))
-				y := int(GetUint16(buf, 3))
-				fb.Server.Handler.ProcessPointerEvent(fb, x, y, buttonmask)
-			case 6: // Client Cut Text - normally text pasted by the client
-				_, err := fb.Conn.Read(buf[:7]) // Read the length of the text that was send
+				fb.markActive()
+				fb.pointerMoved(x, y)
+				if fb.acceptInput() {
+					fb.Server.inputReceived()
+					off := fb.offset()
+					fb.dispatch(inputQueue, func() { fb.CurrentScreen().Handler.ProcessPointerEvent(fb, x+off.X, y+off.Y, buttonmask) })
+				}
+			case MsgClientCutText: // Normally text pasted by the client
+				_, err := io.ReadFull(fb.Conn, buf[:7]) // Read the length of the text that was send
 				if err != nil {
-					log.Printf("Error reading Client Cut Text info: %s\n", err.Error())
+					fb.logf("Error reading Client Cut Text info: %s\n", err.Error())
+					return
+				}
+				sz := int(NewMessageReader(buf[3:7]).Int32()) // Get the text length from the buffer
+				if err := checkLimit("bytes of cut text", max(sz, -sz), fb.Server.Limits.MaxCutText, DefaultMaxCutText); err != nil {
+					fb.logf("%s\n", err.Error())
 					return
 				}
-				sz := int(GetUint32(buf, 3)) // Get the text length from the buffer
-				buf2 := make([]byte, sz)     // Read the actual text
-				_, err = fb.Conn.Read(buf2)
+				if sz < 0 { // A negative length indicates an extended clipboard message
+					if err := fb.processExtendedCutText(-sz); err != nil {
+						fb.logf("Error processing extended clipboard message: %s\n", err.Error())
+						return
+					}
+					continue
+				}
+				if !fb.clipboardPolicy().allowed(true, sz) {
+					if err := fb.discardCutText(sz); err != nil {
+						fb.logf("Error reading client cut text: %s\n", err.Error())
+						return
+					}
+					continue
+				}
+				buf2, ok, err := fb.readCutTextChunked(sz) // Read the actual text
 				if err != nil {
-					log.Printf("Error reading client cut text: %s\n", err.Error())
+					fb.logf("Error reading client cut text: %s\n", err.Error())
 					return
 				}
-				cuttext := string(buf2)
-				fb.Server.Handler.ProcessCutText(fb, cuttext)
+				if !ok {
+					fb.logf("Client cut text transfer cancelled\n")
+					continue
+				}
+				cuttext := Latin1ToString(buf2) // Cut text is Latin-1 encoded
+				fb.deliverCutText(cuttext)
 			default:
-				log.Printf("Unknown cmd received (%d)\n", buf[0])
+				ext := fb.extensionFor(msgType) // Messages of the extensions enabled by the client
+				// The length of any other message is unknown so the stream can not be followed any further
+				if ext == nil {
+					fb.Close(fmt.Sprintf("Client sent message type %s which was not negotiated", msgType))
+					return
+				}
+				if err := ext.ProcessMessage(fb, msgType); err != nil {
+					fb.logf("Error processing %s message: %s\n", ext.Name(), err.Error())
+					return
+				}
 			}
 		} else {
+			if fb.messageDone() {
+				fb.finishDetach()
+				return
+			}
 			if err != nil {
-				log.Printf("Error: %s\n", err.Error())
+				fb.logf("Error: %s\n", err.Error())
 				return
 			} else {
-				log.Printf("Nothing to read!\n")
+				fb.logf("Nothing to read!\n")
 			}
 		}
 	}
@@ -368,54 +831,199 @@ func (fb *RFBConn) processClientRequest() {
 // Once the handshaking and initializing has been done the Init function of the handler is called to initialize whatever the server app needs
 // Then the client requests are processed as they come in
 func (fb *RFBConn) process() {
-	if fb.agreeProtocol() && fb.agreeSecurity() && fb.performInit() {
-		fb.Server.Handler.Init(fb)
-		fb.processClientRequest()
+	defer close(fb.done)
+	defer fb.Server.connEnded()
+	fb.lookupHostname()
+	if fb.handshake() {
+		fb.started = time.Now()
+		fb.session()
 	}
 	fb.Conn.Close()
 }
 
+// session runs a session that completed the handshake, or was attached, until it ends
+func (fb *RFBConn) session() {
+	fb.Server.register(fb)
+	defer fb.ended()
+	defer fb.saveResume()
+	defer fb.Server.notifyConnection(fb, false)
+	defer fb.Server.unregister(fb)
+	defer fb.releaseControl()
+	fb.enforceMaxSession()
+	fb.CurrentScreen().Handler.Init(fb)
+	fb.startWatermark()
+	fb.issueResumeSecret()
+	fb.restoreResume()
+	fb.Server.notifyConnection(fb, true)
+	go fb.greet()
+	fb.startClipboardBridge()
+	fb.watchIdle()
+	fb.watchRequests()
+	fb.Server.watchBlank(false)
+	fb.processClientRequest()
+}
+
+// DefaultHandshakeTimeout is the time clients have to complete the handshake if the server's HandshakeTimeout is 0
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// handshake performs the version, security and init phases within the server's HandshakeTimeout,
+// so that port scanners and stalled clients do not hold on to a connection
+func (fb *RFBConn) handshake() bool {
+	timeout := fb.Server.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	if timeout > 0 {
+		fb.setDeadline(time.Now().Add(timeout))
+		defer fb.setDeadline(time.Time{})
+	}
+	return fb.agreeProtocol() && fb.agreeSecurity() && fb.attachScreen() && fb.performInit()
+}
+
+// write sends a complete message to the client
+func (fb *RFBConn) write(buf []byte) error {
+	return fb.writeContext(context.Background(), buf)
+}
+
+// SendBell rings the bell on the client
+// Until the client is ready (it sent its encodings or an update request) the bell is held back
+func (fb *RFBConn) SendBell() error {
+	if fb.deferSend(func(p *pendingSends) { p.bell = true }) {
+		return nil
+	}
+	return fb.write([]byte{byte(MsgBell)})
+}
+
 // SendCutText will send text back to client (normally copied text)
-// text is the text that need to be send to the client
+// text is the text that need to be send to the client, it is sent as Latin-1 unless the extended clipboard is used
+// Until the client is ready (it sent its encodings or an update request) the last text is held back
 func (fb *RFBConn) SendCutText(text string) error {
-	buf := make([]byte, 8+len([]byte(text)))     // Make byte buffer for command byte, length and actual string
-	buf[0] = 3                                   // Command byte
-	SetUint32(buf, 4, uint32(len([]byte(text)))) // Length of text
-	copy(buf[8:], []byte(text))                  // Text to be sent
-	_, err := fb.Conn.Write(buf)                 //Send it
-	if err != nil {
-		return err
+	if fb.deferSend(func(p *pendingSends) { p.cutText = &text }) {
+		return nil
 	}
-	return nil
+	return fb.sendCutText(context.Background(), text)
 }
 
 // SendRectangle sends a rectangle of image information to the client
 // x,y,width,height is the bounds of the rectangle
-// buf is the actual image data that is in the format indicated by the PixelFormat
+// buf is the actual image data that is in the format indicated by the PixelFormat requested by the client,
+// or in the server's PixelFormat if ConvertPixelFormat is set on the server
 func (fb *RFBConn) SendRectangles(rects []RFBRectangle) error { //x, y, width, height int, buf []byte) error {
-	tmpbuf := make([]byte, 4)
-	tmpbuf[0] = 0                            // Command byte
-	SetUint16(tmpbuf, 2, uint16(len(rects))) // Number of rectangles
-	_, err := fb.Conn.Write(tmpbuf)
+	if fb.convertsPixels() { // 24 bpp is always converted
+		rects = fb.convertRectangles(rects)
+	}
+	return fb.sendRectangles(context.Background(), rects)
+}
+
+// sendRectangles sends the rectangles, which are already in the client's pixel format, as a FramebufferUpdate
+// The update is checked completely before anything is written, so that the rectangles always match the count
+// declared in the header. If writing fails halfway the stream is out of sync and the connection is closed.
+func (fb *RFBConn) sendRectangles(ctx context.Context, rects []RFBRectangle) error {
+	return fb.sendUpdate(ctx, rects, nil)
+}
+
+// sendUpdate is sendRectangles taking the encodings of the rectangles of a static image from its cache
+func (fb *RFBConn) sendUpdate(ctx context.Context, rects []RFBRectangle, static *staticImage) error {
+	rects = fb.cropRectangles(rects)
+	if fb.holdUpdate(rects) {
+		return nil
+	}
+	rects = fb.applyOverlays(fb.filterRectangles(fb.blankRectangles(rects)))
+	pf := fb.PixelFormat()
+	bpp := pf.BytesPerPixel()
+	encoding := fb.updateEncoding()
+	rectEncodings := make([]Encoding, len(rects))
+	stateful := false
+	for i, rect := range rects {
+		enc, err := fb.rectEncoding(rect, encoding)
+		if err != nil {
+			return err
+		}
+		if enc != EncCopyRect && len(rect.Buffer) != rect.Width*rect.Height*bpp {
+			return fmt.Errorf("Rectangle %dx%d at %d,%d has %d bytes of pixel data instead of %d", rect.Width, rect.Height,
+				rect.X, rect.Y, len(rect.Buffer), rect.Width*rect.Height*bpp)
+		}
+		rectEncodings[i] = enc
+		stateful = stateful || fb.Server.statefulEncoding(enc)
+	}
+	if stateful {
+		if !fb.Initialized() {
+			return ErrNotInitialized
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fb.emu.Lock()
+		defer fb.emu.Unlock()
+		ctx = context.Background() // Once compressed the update must be sent, or the client's stream is out of sync
+	}
+	var encoded []encodedRect
+	for i, rect := range rects {
+		var er []encodedRect
+		var err error
+		if static != nil {
+			er, err = static.encode(fb, rect, rectEncodings[i], pf)
+		} else {
+			er, err = fb.encodeRectangle(rect, rectEncodings[i], pf)
+		}
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, er...)
+	}
+	cursor := fb.pendingCursor()
+	count := len(encoded)
+	if cursor != nil {
+		count++
+	}
+	hdr, err := NewMessageWriter(4).Uint8(uint8(MsgFramebufferUpdate)).Padding(1).Uint16(uint16(count)).Bytes() // Command byte and number of rectangles
 	if err != nil {
 		return err
 	}
-	for _, rect := range rects {
-		tmpbuf = make([]byte, 12+len(rect.Buffer))
-		SetUint16(tmpbuf, 0, uint16(rect.X))
-		SetUint16(tmpbuf, 2, uint16(rect.Y))
-		SetUint16(tmpbuf, 4, uint16(rect.Width))
-		SetUint16(tmpbuf, 6, uint16(rect.Height))
-		SetUint32(tmpbuf, 8, uint32(0)) // Encoding = Raw. Will change as other encodings are implemented
-		copy(tmpbuf[12:], rect.Buffer)
-		_, err := fb.Conn.Write(tmpbuf)
+	bufs := net.Buffers{hdr}
+	encodings := make([]Encoding, 0, count) // Of the rectangles for the session report
+	if cursor != nil {
+		bufs = append(bufs, cursor)
+		encodings = append(encodings, fb.cursorEncoding())
+	}
+	for _, er := range encoded {
+		rhdr, err := er.header()
 		if err != nil {
 			return err
 		}
+		bufs = append(bufs, rhdr, er.data)
+		encodings = append(encodings, er.encoding)
+	}
+	size := 0
+	for _, buf := range bufs {
+		size += len(buf)
+	}
+	unlock, err := fb.lockWrite(ctx, size)
+	if err != nil {
+		return err
 	}
+	defer unlock()
+	fb.updateSent()
+	if n, err := bufs.WriteTo(fb.Conn); err != nil {
+		return fb.writeError(ctx, n > 0, err)
+	}
+	fb.countUpdate(encodings...)
+	fb.countEncoded(encoded, bpp)
+	fb.continueUpdates()
 	return nil
 }
 
+// writeFailed closes the connection after a write error, part of a message may have been sent so the
+// client can not make sense of anything that follows
+// The connection ends through the usual path, calling the OnDisconnect hook. err is returned.
+func (fb *RFBConn) writeFailed(err error) error {
+	fb.mu.Lock()
+	fb.connLost = true
+	fb.mu.Unlock()
+	fb.Close("Write failed: " + err.Error())
+	return err
+}
+
 // StartServer will start a server waiting for connections on the port as specified by the RFBServer port
 // If Port is missing use the default of 5900
 // For each connection handshaking is done and initialization and then client requests are handled and send to the Handler
@@ -423,38 +1031,67 @@ func (rfb *RFBServer) StartServer() error {
 	if rfb.Port == "" {
 		rfb.Port = "5900"
 	}
+	if err := rfb.validate(); err != nil {
+		return err
+	}
+	if err := rfb.defaultScreen().validate(); err != nil {
+		return err
+	}
+	ln, err := rfb.listen(rfb.Port)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Error listening on port %s: %s", rfb.Port, err.Error()))
+	}
+	return rfb.serve(ln, "")
+}
+
+// Serve accepts connections on ln until it is closed, Port is not used
+// ln can be any listener, for example a TLS or Unix socket listener or an in memory one for testing
+func (rfb *RFBServer) Serve(ln net.Listener) error {
+	if err := rfb.validate(); err != nil {
+		return err
+	}
+	if err := rfb.defaultScreen().validate(); err != nil {
+		return err
+	}
+	return rfb.serve(ln, "")
+}
+
+// validate checks the server configuration (including all its named screens) before it starts serving
+func (rfb *RFBServer) validate() error {
 	if rfb.Authenticate && len(rfb.AuthText) == 0 {
 		return errors.New("For authentication a authentication string must be provided!")
 	}
-	if rfb.Width <= 0 || rfb.Height <= 0 {
-		return errors.New("Width and Height must be provided in RFBServer and they must be positive values!")
-	}
-	if rfb.Handler == nil {
-		return errors.New("A handler must be provided!")
+	if err := rfb.PixelFormatHints.validate(); err != nil {
+		return err
 	}
-	if rfb.PixelFormat.BitsPerPixel != 8 && rfb.PixelFormat.BitsPerPixel != 16 && rfb.PixelFormat.BitsPerPixel != 24 && rfb.PixelFormat.BitsPerPixel != 32 {
-		return errors.New("Only 8, 16, 24 and 32 bits per pixel allowed")
+	if err := rfb.HandshakePolicy.validate(rfb.Authenticate); err != nil {
+		return err
 	}
-	if rfb.PixelFormat.TrueColor == 1 {
-		if rfb.PixelFormat.RedMax == 0 || rfb.PixelFormat.GreenMax == 0 || rfb.PixelFormat.BlueMax == 0 {
-			return errors.New("Provide maximum values for red, green and blue in the PixelFormat structure")
-		}
-		if rfb.PixelFormat.RedShift == rfb.PixelFormat.GreenShift || rfb.PixelFormat.RedShift == rfb.PixelFormat.BlueShift || rfb.PixelFormat.GreenShift == rfb.PixelFormat.BlueShift {
-			return errors.New("None of the shifts can be the same!")
+	for name, screen := range rfb.Screens {
+		screen.Name = name
+		if err := screen.validate(); err != nil {
+			return errors.New(fmt.Sprintf("Screen %s: %s", name, err.Error()))
 		}
 	}
-	ln, err := net.Listen("tcp", ":"+rfb.Port)
-	if err != nil {
-		return errors.New(fmt.Sprintf("Error listening on port %s: %s", rfb.Port, err.Error()))
-	}
+	return nil
+}
+
+// serve accepts connections on ln until it is closed
+// screen is the name of the screen that the connections are attached to, if empty the screen is selected per connection
+func (rfb *RFBServer) serve(ln net.Listener, screen string) error {
+	rfb.addListener(ln)
 	for {
 		con, err := ln.Accept()
 		if err != nil {
-			log.Printf("Error accepting incoming connection: %s\n", err.Error())
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			rfb.logf("Error accepting incoming connection: %s\n", err.Error())
 		} else {
-			rfbcon := &RFBConn{Server: rfb, Conn: con}
+			rfb.accepted(con)
+			rfbcon := &RFBConn{Server: rfb, Conn: con, done: make(chan struct{}), screenName: screen}
+			rfb.connStarted()
 			go rfbcon.process()
 		}
 	}
-	return nil
 }
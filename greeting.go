@@ -0,0 +1,79 @@
+// gorfb project greeting.go
+// Greeting (message of the day) delivered to clients once they connected
+package gorfb
+
+import (
+	"image/color"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultGreetingDuration is how long the greeting banner is shown if the Greeting's Duration is 0
+const DefaultGreetingDuration = 10 * time.Second
+
+// Greeting is a message delivered to every client after the handler's Init, on its clipboard and/or as a banner
+type Greeting struct {
+	// Text is a text/template executed with the client's GreetingInfo, for example "Welcome to {{.Screen}}"
+	Text string
+	// Send the text to the client's clipboard (subject to the clipboard policy)
+	Clipboard bool
+	// Show the text as a banner for Duration (DefaultGreetingDuration if 0, negative until the client disconnects)
+	Banner   bool
+	Duration time.Duration
+	// Appearance and position of the banner, its Text is replaced by the greeting (a box at the top left if nil)
+	Overlay *Overlay
+}
+
+// GreetingInfo holds the session details available to the Greeting's Text
+type GreetingInfo struct {
+	ID            int       // ID of the connection
+	Address       string    // Address of the client
+	Hostname      string    // Host name of the client if ReverseDNS resolved it
+	Screen        string    // Name of the screen the client is attached to
+	Width, Height int       // Size of the framebuffer
+	Shared        bool      // The client shares the session
+	ViewOnly      bool      // Input from the client is ignored
+	Clients       int       // Number of connected clients, including this one
+	Time          time.Time // When the session started
+}
+
+// greet delivers the server's Greeting to the client
+func (fb *RFBConn) greet() {
+	g := fb.Server.Greeting
+	if g == nil || (!g.Clipboard && !g.Banner) {
+		return
+	}
+	tmpl, err := template.New("greeting").Parse(g.Text)
+	if err != nil {
+		fb.logf("Error parsing greeting: %s\n", err.Error())
+		return
+	}
+	screen := fb.CurrentScreen()
+	info := GreetingInfo{ID: fb.ID, Address: fb.Address(), Hostname: fb.Hostname(), Screen: screen.BufferName,
+		Width: screen.Width, Height: screen.Height, Shared: fb.Shared(), ViewOnly: fb.ViewOnly(),
+		Clients: len(fb.Server.Connections()), Time: fb.started}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, info); err != nil {
+		fb.logf("Error executing greeting: %s\n", err.Error())
+		return
+	}
+	text := sb.String()
+	if g.Banner {
+		o := &Overlay{X: 8, Y: 8, Scale: 2, Padding: 4, Background: color.RGBA{0, 0, 0, 0xc0}}
+		if g.Overlay != nil {
+			*o = *g.Overlay
+		}
+		o.Text = text
+		d := g.Duration
+		if d == 0 {
+			d = DefaultGreetingDuration
+		}
+		fb.AddOverlay(o, max(d, 0))
+	}
+	if g.Clipboard {
+		if err := fb.SendCutText(text); err != nil && err != ErrCutTextNotAllowed {
+			fb.logf("Error sending greeting: %s\n", err.Error())
+		}
+	}
+}
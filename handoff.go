@@ -0,0 +1,87 @@
+// gorfb project handoff.go
+// Handing the port over to a newer process of a service, so that it can be upgraded without downtime
+package gorfb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Messages on the handoff socket: the newer process asks, the older one replies once it stopped accepting
+const (
+	handoffRequest = "RFB handoff\n"
+	handoffDone    = "RFB handed off\n"
+)
+
+// DefaultHandoffTimeout is the time the processes have to complete a handoff
+const DefaultHandoffTimeout = 10 * time.Second
+
+// TakeOver takes over from the older process serving the Unix socket path and then serves path for the next process
+// The older process stops accepting connections, its StartServer returns and it can Drain its connections. Both
+// processes listen on the same port with ListenOptions.ReusePort, so TakeOver is called once this one listens.
+// Without an older process (nothing serving path) this one only serves path.
+func (rfb *RFBServer) TakeOver(path string) error {
+	if conn, err := net.DialTimeout("unix", path, DefaultHandoffTimeout); err == nil {
+		err = requestHandoff(conn)
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("Error taking over from %s: %s", path, err.Error())
+		}
+		rfb.logf("Took over from the process serving %s\n", path)
+	}
+	os.Remove(path) // Left behind by a process that did not stop cleanly
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("Error listening on %s: %s", path, err.Error())
+	}
+	rfb.mu.Lock()
+	old := rfb.handoff
+	rfb.handoff = ln
+	rfb.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	go rfb.serveHandoff(ln)
+	return nil
+}
+
+// requestHandoff asks the older process on conn to stop accepting and waits for it to reply
+func requestHandoff(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(DefaultHandoffTimeout))
+	if _, err := conn.Write([]byte(handoffRequest)); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if reply != handoffDone {
+		return errors.New("Unexpected reply to the handoff request")
+	}
+	return nil
+}
+
+// serveHandoff waits on the handoff socket ln for a newer process and stops accepting connections when it asks
+func (rfb *RFBServer) serveHandoff(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.SetDeadline(time.Now().Add(DefaultHandoffTimeout))
+		req, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || req != handoffRequest {
+			conn.Close()
+			continue
+		}
+		rfb.logf("Handing over to a newer process\n")
+		rfb.Close() // Also closes ln, removing the socket before the newer process serves it
+		conn.Write([]byte(handoffDone))
+		conn.Close()
+		return
+	}
+}
@@ -0,0 +1,69 @@
+package gorfb_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+// handshake sends version as the client's protocol version and completes an RFB 3.8 handshake without
+// authentication, it returns the desktop name from ServerInit
+func handshake(t *testing.T, strict bool, version string) (string, error) {
+	t.Helper()
+	rfb, _ := rfbtest.NewServer(8, 8)
+	rfb.StrictVersion = strict
+	rfb.Logf = func(format string, args ...interface{}) {}
+	ln, err := net.Listen("tcp", "127.0.0.1:0") // Over a pipe the bytes not read by the server would block writing
+	if err != nil {
+		t.Skip("No local TCP: " + err.Error())
+	}
+	defer ln.Close()
+	go rfb.Serve(ln)
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 24)
+	steps := []func() error{
+		func() error { _, err := io.ReadFull(conn, buf[:12]); return err },
+		func() error { go conn.Write([]byte(version)); return nil },       // The pipe blocks until all of it is read
+		func() error { _, err := io.ReadFull(conn, buf[:2]); return err }, // One security type: None
+		func() error { _, err := conn.Write([]byte{byte(gorfb.SecNone)}); return err },
+		func() error { _, err := io.ReadFull(conn, buf[:4]); return err }, // SecurityResult
+		func() error {
+			if r := gorfb.NewMessageReader(buf[:4]).Uint32(); r != 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		},
+		func() error { _, err := conn.Write([]byte{1}); return err },
+		func() error { _, err := io.ReadFull(conn, buf[:24]); return err },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return "", err
+		}
+	}
+	name := make([]byte, gorfb.NewMessageReader(buf[20:24]).Uint32())
+	_, err = io.ReadFull(conn, name)
+	return string(name), err
+}
+
+func TestLenientVersionHandshake(t *testing.T) {
+	for _, version := range []string{"RFB 003.008\n", "RFB 3.8    \n", "RFB 003.008\r", "RFB 003.009\n", " RFB 3.8\n\n\n\n"} {
+		if name, err := handshake(t, false, version); err != nil || name != "rfbtest" {
+			t.Errorf("Handshake with version %q failed: %q, %v", version, name, err)
+		}
+	}
+	if _, err := handshake(t, true, "RFB 3.8    \n"); err == nil {
+		t.Error("Lenient version accepted by a strict server")
+	}
+	// Only the 12 bytes of the version are read, the rest of a longer one ends up as the security type
+	if _, err := handshake(t, false, "RFB 3.8     \r\n"); err == nil {
+		t.Error("Handshake with a 14 byte version succeeded")
+	}
+}
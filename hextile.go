@@ -0,0 +1,84 @@
+// gorfb project hextile.go
+// The Hextile encoding: 16x16 tiles that are sent raw or as a background with subrectangles, reusing the colours
+// of the previous tile
+package gorfb
+
+import "github.com/hduplooy/gorfb/internal/tile"
+
+// encodeHextile encodes rect (in pf) with Hextile
+func encodeHextile(rect RFBRectangle, pf PixelFormat) ([]encodedRect, error) {
+	bpp := pf.BytesPerPixel()
+	var out []byte
+	var bg, fg uint32
+	validBg, validFg := false, false // The client keeps the colours of the previous tile, unless it was raw
+	for _, t := range tile.Split(rect.Bounds(), tile.HextileSize, tile.HextileSize) {
+		buf := subPixels(rect, t, bpp)
+		pix := tilePixels(buf, pf)
+		tbg := backgroundPixel(pix)
+		subs := findSubrects(pix, t.Dx(), t.Dy(), tbg)
+		colours := map[uint32]bool{}
+		for _, s := range subs {
+			colours[s.pixel] = true
+		}
+		flags := 0
+		if !validBg || tbg != bg {
+			flags |= tile.HextileBackground
+		}
+		size := 1
+		if flags&tile.HextileBackground != 0 {
+			size += bpp
+		}
+		var tfg uint32
+		switch len(colours) {
+		case 0:
+		case 1:
+			for c := range colours {
+				tfg = c
+			}
+			flags |= tile.HextileAnySubrects
+			if !validFg || tfg != fg {
+				flags |= tile.HextileForeground
+				size += bpp
+			}
+			size += 1 + 2*len(subs)
+		default:
+			flags |= tile.HextileAnySubrects | tile.HextileSubrectsColoured
+			size += 1 + (bpp+2)*len(subs)
+		}
+		if size > 1+len(buf) || len(subs) > 255 {
+			out = append(out, tile.HextileRaw)
+			out = append(out, buf...)
+			validBg, validFg = false, false
+			continue
+		}
+		w := NewMessageWriter(size).Uint8(uint8(flags))
+		if flags&tile.HextileBackground != 0 {
+			w.pixel(pf, tbg)
+		}
+		if flags&tile.HextileForeground != 0 {
+			w.pixel(pf, tfg)
+		}
+		if flags&tile.HextileAnySubrects != 0 {
+			w.Uint8(uint8(len(subs)))
+			for _, s := range subs {
+				if flags&tile.HextileSubrectsColoured != 0 {
+					w.pixel(pf, s.pixel)
+				}
+				xy, wh := tile.PackSubrect(s.x, s.y, s.width, s.height)
+				w.Uint8(xy).Uint8(wh)
+			}
+		}
+		data, err := w.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, data...)
+		bg, validBg = tbg, true
+		if flags&tile.HextileSubrectsColoured != 0 {
+			validFg = false // The client's foreground is left at the colour of the last subrectangle, don't rely on it
+		} else if flags&tile.HextileForeground != 0 {
+			fg, validFg = tfg, true
+		}
+	}
+	return []encodedRect{{rect.X, rect.Y, rect.Width, rect.Height, EncHextile, out}}, nil
+}
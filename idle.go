@@ -0,0 +1,61 @@
+// gorfb project idle.go
+// Disconnecting clients that have been idle for too long
+package gorfb
+
+import "time"
+
+// LastActivity returns when the client last sent input or an update request
+func (fb *RFBConn) LastActivity() time.Time {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.lastActivity
+}
+
+// markActive records activity of the client
+func (fb *RFBConn) markActive() {
+	fb.mu.Lock()
+	fb.lastActivity = time.Now()
+	fb.mu.Unlock()
+}
+
+// watchIdle disconnects the client once it has been idle for the server's IdleTimeout
+// If IdleWarning is set the client is warned (bell and IdleWarningText) that long before it is disconnected
+func (fb *RFBConn) watchIdle() {
+	timeout := fb.Server.IdleTimeout
+	if timeout <= 0 {
+		return
+	}
+	warning := fb.Server.IdleWarning
+	if warning >= timeout {
+		warning = 0
+	}
+	fb.markActive()
+	go func() {
+		warned := time.Time{} // Activity time at which the client was last warned
+		for {
+			last := fb.LastActivity()
+			wait := time.Until(last.Add(timeout))
+			if warning > 0 && !warned.Equal(last) {
+				wait -= warning
+			}
+			if wait > 0 {
+				select {
+				case <-fb.done:
+					return
+				case <-time.After(wait):
+				}
+				continue // Check if there was activity in the meantime
+			}
+			if warning > 0 && !warned.Equal(last) {
+				warned = last
+				fb.SendBell()
+				if fb.Server.IdleWarningText != "" {
+					fb.SendCutText(fb.Server.IdleWarningText)
+				}
+				continue
+			}
+			fb.Close("Idle timeout")
+			return
+		}
+	}()
+}
@@ -0,0 +1,193 @@
+// gorfb project internal/tile/tile.go
+// Tiles, palettes, packed pixels and run lengths shared by the Hextile, ZRLE and Tight encoders of the server and
+// the decoders of the client
+package tile
+
+import "image"
+
+// Hextile tile size and sub-encoding flags
+const (
+	HextileSize             = 16
+	HextileRaw              = 1
+	HextileBackground       = 2
+	HextileForeground       = 4
+	HextileAnySubrects      = 8
+	HextileSubrectsColoured = 16
+)
+
+// ZRLE tile size and sub-encodings, 2-16 are packed palettes and 130-255 run length encoded palettes of that many
+// colours (less 128)
+const (
+	ZRLESize       = 64
+	ZRLERaw        = 0
+	ZRLESolid      = 1
+	ZRLEPlainRLE   = 128
+	ZRLEPaletteRLE = 128
+)
+
+// Largest palettes of the ZRLE palette sub-encodings
+const (
+	ZRLEMaxPacked  = 16
+	ZRLEMaxPalette = 127
+)
+
+// Split splits r into tiles of at most width x height, row by row
+func Split(r image.Rectangle, width, height int) []image.Rectangle {
+	if r.Empty() || width <= 0 || height <= 0 {
+		return nil
+	}
+	var out []image.Rectangle
+	for y := r.Min.Y; y < r.Max.Y; y += height {
+		for x := r.Min.X; x < r.Max.X; x += width {
+			out = append(out, image.Rect(x, y, min(x+width, r.Max.X), min(y+height, r.Max.Y)))
+		}
+	}
+	return out
+}
+
+// PackSubrect returns the two bytes of a Hextile subrectangle of w x h at x,y in its tile
+func PackSubrect(x, y, w, h int) (byte, byte) {
+	return byte(x<<4 | y), byte((w-1)<<4 | (h - 1))
+}
+
+// UnpackSubrect returns the position and size of a Hextile subrectangle from its two bytes
+func UnpackSubrect(xy, wh byte) (x, y, w, h int) {
+	return int(xy >> 4), int(xy & 15), int(wh>>4) + 1, int(wh&15) + 1
+}
+
+// CPixel returns the number of bytes of the compressed pixels of ZRLE and Tight, and the shift of their value:
+// with 32 bits per pixel and the colours (mask) in 3 of the bytes only those 3 bytes are sent
+func CPixel(bytesPerPixel, depth int, trueColour bool, mask uint32) (int, uint) {
+	if !trueColour || bytesPerPixel != 4 || depth > 24 {
+		return bytesPerPixel, 0
+	}
+	switch {
+	case mask < 1<<24:
+		return 3, 0
+	case mask&0xff == 0:
+		return 3, 8
+	}
+	return bytesPerPixel, 0
+}
+
+// TPixelSize returns the number of bytes of the pixels of Tight: 3 (red, green and blue) with 32 bits per pixel and
+// 8 bits per colour
+func TPixelSize(bytesPerPixel, depth int, trueColour bool, redMax, greenMax, blueMax uint16) int {
+	if trueColour && bytesPerPixel == 4 && depth == 24 && redMax == 255 && greenMax == 255 && blueMax == 255 {
+		return 3
+	}
+	return bytesPerPixel
+}
+
+// Palette is the colours of a tile in the order they appear, given up once there are too many
+type Palette struct {
+	Colours []uint32
+	// Overflowed is set, and Colours nil, once more than the largest palette were added
+	Overflowed bool
+	index      map[uint32]int
+	max        int
+}
+
+// NewPalette returns an empty palette of at most max colours
+func NewPalette(max int) *Palette {
+	return &Palette{index: make(map[uint32]int), max: max}
+}
+
+// Add adds the colour c if it is not in the palette yet
+func (p *Palette) Add(c uint32) {
+	if p.Overflowed {
+		return
+	}
+	if _, ok := p.index[c]; ok {
+		return
+	}
+	if len(p.Colours) == p.max {
+		p.Colours, p.index, p.Overflowed = nil, nil, true
+		return
+	}
+	p.index[c] = len(p.Colours)
+	p.Colours = append(p.Colours, c)
+}
+
+// Index returns the index of the colour c in the palette
+func (p *Palette) Index(c uint32) int {
+	return p.index[c]
+}
+
+// AppendPacked appends the palette indices of the pixels of a row, bits per pixel and starting at a byte
+func (p *Palette) AppendPacked(out []byte, row []uint32, bits int) []byte {
+	var b byte
+	n := 0
+	for _, c := range row {
+		b = b<<bits | byte(p.index[c])
+		if n += bits; n == 8 {
+			out = append(out, b)
+			b, n = 0, 0
+		}
+	}
+	if n > 0 {
+		out = append(out, b<<(8-n))
+	}
+	return out
+}
+
+// PaletteBits returns the bits per pixel of a packed ZRLE palette of n colours
+func PaletteBits(n int) int {
+	switch {
+	case n <= 2:
+		return 1
+	case n <= 4:
+		return 2
+	}
+	return 4
+}
+
+// PackedRowSize returns the number of bytes of a row of width packed pixels of bits each
+func PackedRowSize(width, bits int) int {
+	return (width*bits + 7) / 8
+}
+
+// Unpack returns the palette index of pixel i of a packed row of bits per pixel
+func Unpack(row []byte, bits, i int) int {
+	pos := i * bits
+	return int(row[pos/8]>>(8-bits-pos%8)) & (1<<bits - 1)
+}
+
+// Runs calls fn with the colour and length of every run of equal pixels in pix
+func Runs(pix []uint32, fn func(c uint32, n int)) {
+	for i := 0; i < len(pix); {
+		j := i + 1
+		for j < len(pix) && pix[j] == pix[i] {
+			j++
+		}
+		fn(pix[i], j-i)
+		i = j
+	}
+}
+
+// RunLengthSize returns the number of bytes of the length of a run of n pixels
+func RunLengthSize(n int) int {
+	return (n-1)/255 + 1
+}
+
+// AppendRunLength appends the length n of a run as ZRLE does: bytes of 255 followed by the remainder, less one
+func AppendRunLength(out []byte, n int) []byte {
+	for n--; n >= 255; n -= 255 {
+		out = append(out, 255)
+	}
+	return append(out, byte(n))
+}
+
+// ReadRunLength reads the length of a run with next, which returns the next byte
+func ReadRunLength(next func() (byte, error)) (int, error) {
+	n := 1
+	for {
+		b, err := next()
+		if err != nil {
+			return 0, err
+		}
+		if n += int(b); b != 255 {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package tile
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestRunLength(t *testing.T) {
+	for _, n := range []int{1, 2, 255, 256, 257, 511, 1000} {
+		out := AppendRunLength(nil, n)
+		if len(out) != RunLengthSize(n) {
+			t.Errorf("Run of %d: %d bytes instead of %d", n, len(out), RunLengthSize(n))
+		}
+		rd := bytes.NewReader(out)
+		if got, err := ReadRunLength(rd.ReadByte); err != nil || got != n || rd.Len() != 0 {
+			t.Errorf("Run of %d read back as %d (%v, %d bytes left)", n, got, err, rd.Len())
+		}
+	}
+}
+
+func TestPacked(t *testing.T) {
+	row := []uint32{5, 6, 7, 5, 8, 5, 6}
+	for _, bits := range []int{2, 4} {
+		p := NewPalette(16)
+		for _, c := range row {
+			p.Add(c)
+		}
+		out := p.AppendPacked(nil, row, bits)
+		if len(out) != PackedRowSize(len(row), bits) {
+			t.Errorf("%d bits: %d bytes instead of %d", bits, len(out), PackedRowSize(len(row), bits))
+		}
+		for i, c := range row {
+			if got := Unpack(out, bits, i); got != p.Index(c) {
+				t.Errorf("%d bits: pixel %d unpacked as %d instead of %d", bits, i, got, p.Index(c))
+			}
+		}
+	}
+}
+
+func TestPaletteOverflow(t *testing.T) {
+	p := NewPalette(2)
+	for _, c := range []uint32{1, 2, 1, 2} {
+		p.Add(c)
+	}
+	if p.Overflowed || len(p.Colours) != 2 || p.Index(2) != 1 {
+		t.Fatalf("Palette of 2 colours: %v, overflowed %v", p.Colours, p.Overflowed)
+	}
+	if p.Add(3); !p.Overflowed || p.Colours != nil {
+		t.Errorf("Third colour kept: %v", p.Colours)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tiles := Split(image.Rect(0, 0, 40, 20), 16, 16)
+	want := []image.Rectangle{image.Rect(0, 0, 16, 16), image.Rect(16, 0, 32, 16), image.Rect(32, 0, 40, 16),
+		image.Rect(0, 16, 16, 20), image.Rect(16, 16, 32, 20), image.Rect(32, 16, 40, 20)}
+	if len(tiles) != len(want) {
+		t.Fatalf("%d tiles instead of %d", len(tiles), len(want))
+	}
+	for i := range want {
+		if tiles[i] != want[i] {
+			t.Errorf("Tile %d is %v instead of %v", i, tiles[i], want[i])
+		}
+	}
+}
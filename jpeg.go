@@ -0,0 +1,48 @@
+// gorfb project jpeg.go
+// The JPEG compression of the Tight encoding, used for rectangles with many colours when the client asks for a
+// JPEG quality level
+package gorfb
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+)
+
+// tightMinJPEG is the number of pixels from which rectangles with too many colours for a palette are sent as JPEG
+const tightMinJPEG = 1024
+
+// jpegQualities are the JPEG qualities of the quality levels 0 to 9, as other servers use them
+var jpegQualities = [10]int{15, 29, 41, 42, 62, 77, 79, 86, 92, 100}
+
+// jpegQuality returns the JPEG quality of the quality level the client asked for with a pseudo-encoding
+// ok is false if it did not ask for one or its pixel format can not hold the colours of JPEG images
+func (fb *RFBConn) jpegQuality(pf PixelFormat) (quality int, ok bool) {
+	if pf.TrueColor != 1 || pf.BitsPerPixel < 16 {
+		return 0, false
+	}
+	for _, enc := range fb.Encodings() {
+		if enc >= EncQualityLevel0 && enc <= EncQualityLevel9 {
+			return jpegQualities[enc-EncQualityLevel0], true
+		}
+	}
+	return 0, false
+}
+
+// tightJPEGRect returns the Tight data of the pixels pix (in pf) of a rectangle that is width pixels wide
+// compressed as JPEG
+func tightJPEGRect(pix []uint32, width int, pf PixelFormat, quality int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, width, len(pix)/width))
+	for i, p := range pix {
+		c := img.Pix[i*4 : i*4+4]
+		c[0] = uint8(scaleColor(p>>pf.RedShift&uint32(pf.RedMax), pf.RedMax, 255))
+		c[1] = uint8(scaleColor(p>>pf.GreenShift&uint32(pf.GreenMax), pf.GreenMax, 255))
+		c[2] = uint8(scaleColor(p>>pf.BlueShift&uint32(pf.BlueMax), pf.BlueMax, 255))
+		c[3] = 255
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return append(appendCompactLength([]byte{tightJPEG}, buf.Len()), buf.Bytes()...), nil
+}
@@ -0,0 +1,187 @@
+// gorfb project keymap/keymap.go
+// Package keymap translates the keysyms sent by clients to PC scancodes for the keyboard layout of a guest,
+// for servers backing virtual machines or emulators that take scancodes rather than characters
+package keymap
+
+import "unicode"
+
+// Scancode is a PC (XT, set 1) scancode, extended keys have the 0xe0 prefix in the high byte
+type Scancode uint16
+
+// Scancodes of the modifiers the translator presses and releases
+const (
+	ScanShiftL Scancode = 0x2a
+	ScanShiftR Scancode = 0x36
+	ScanAltGr  Scancode = 0xe038
+	ScanCaps   Scancode = 0x3a
+)
+
+// Key is a key of a layout along with the modifiers needed to produce a character with it
+type Key struct {
+	Scancode Scancode
+	Shift    bool
+	AltGr    bool
+}
+
+// Layout maps the characters of a keyboard layout to the keys producing them
+// Keys that do not produce characters (Return, the arrows, function keys and modifiers) are the same for all
+// layouts and handled by the Translator
+type Layout interface {
+	// Name identifies the layout, for example "de"
+	Name() string
+	// Lookup returns the key producing the character ch on the layout, ok is false if the layout has none
+	Lookup(ch rune) (key Key, ok bool)
+}
+
+// KeyEvent is a key press or release to send to the guest
+type KeyEvent struct {
+	Scancode Scancode
+	Down     bool
+}
+
+// Translator converts the key events of a client to scancodes for a layout, keeping track of the modifiers the
+// client holds so that they can be adjusted for characters that need other modifiers on the guest's layout
+// A Translator serves a single client and is not safe for concurrent use.
+type Translator struct {
+	Layout  Layout
+	held    map[Scancode]bool // Modifiers held by the client
+	pressed map[int]Scancode  // Scancodes of the keys pressed per keysym
+	caps    bool              // Caps lock is on
+}
+
+// NewTranslator returns a translator for layout
+func NewTranslator(layout Layout) *Translator {
+	return &Translator{Layout: layout, held: make(map[Scancode]bool), pressed: make(map[int]Scancode)}
+}
+
+// Key translates a key event of the client to the scancode events for the guest, nil if the keysym can not be
+// typed on the layout
+func (t *Translator) Key(keysym int, down bool) []KeyEvent {
+	if !down {
+		sc, ok := t.pressed[keysym]
+		if !ok {
+			return nil
+		}
+		delete(t.pressed, keysym)
+		delete(t.held, sc)
+		return []KeyEvent{{sc, false}}
+	}
+	if sc, ok := specialKeys[keysym]; ok {
+		switch sc {
+		case ScanShiftL, ScanShiftR, ScanAltGr:
+			t.held[sc] = true
+		case ScanCaps:
+			t.caps = !t.caps
+		}
+		t.pressed[keysym] = sc
+		return []KeyEvent{{sc, true}}
+	}
+	ch, ok := keysymRune(keysym)
+	if !ok {
+		return nil
+	}
+	key, ok := t.Layout.Lookup(ch)
+	if !ok {
+		return nil
+	}
+	if t.caps && unicode.IsLetter(ch) && unicode.ToUpper(ch) != unicode.ToLower(ch) {
+		key.Shift = !key.Shift // Caps lock on the guest inverts shift for letters
+	}
+	var before, after []KeyEvent
+	shifts := []Scancode{ScanShiftL, ScanShiftR}
+	if key.Shift && !t.held[ScanShiftL] && !t.held[ScanShiftR] {
+		before = append(before, KeyEvent{ScanShiftL, true})
+		after = append(after, KeyEvent{ScanShiftL, false})
+	} else if !key.Shift {
+		for _, sc := range shifts {
+			if t.held[sc] {
+				before = append(before, KeyEvent{sc, false})
+				after = append(after, KeyEvent{sc, true})
+			}
+		}
+	}
+	if key.AltGr != t.held[ScanAltGr] {
+		before = append(before, KeyEvent{ScanAltGr, key.AltGr})
+		after = append(after, KeyEvent{ScanAltGr, !key.AltGr})
+	}
+	t.pressed[keysym] = key.Scancode
+	events := append(before, KeyEvent{key.Scancode, true})
+	for i := len(after) - 1; i >= 0; i-- { // Restore the modifiers in reverse order
+		events = append(events, after[i])
+	}
+	return events
+}
+
+// keysymRune returns the character of a keysym for Latin-1 and Unicode keysyms
+func keysymRune(keysym int) (rune, bool) {
+	switch {
+	case keysym >= 0x20 && keysym <= 0xff:
+		return rune(keysym), true
+	case keysym&0xff000000 == 0x01000000:
+		return rune(keysym & 0xffffff), true
+	case keysym == 0x20ac: // EuroSign
+		return '€', true
+	}
+	return 0, false
+}
+
+// specialKeys maps the keysyms of keys that are the same on all layouts to their scancodes
+var specialKeys = map[int]Scancode{
+	0x0020: 0x39, // space
+	0xff1b: 0x01, // Escape
+	0xff08: 0x0e, // BackSpace
+	0xff09: 0x0f, // Tab
+	0xff0d: 0x1c, // Return
+	0xffe3: 0x1d, // Control_L
+	0xffe1: ScanShiftL,
+	0xffe2: ScanShiftR,
+	0xffe9: 0x38, // Alt_L
+	0xffe5: ScanCaps,
+	0xffbe: 0x3b, // F1
+	0xffbf: 0x3c,
+	0xffc0: 0x3d,
+	0xffc1: 0x3e,
+	0xffc2: 0x3f,
+	0xffc3: 0x40,
+	0xffc4: 0x41,
+	0xffc5: 0x42,
+	0xffc6: 0x43,
+	0xffc7: 0x44,      // F10
+	0xffc8: 0x57,      // F11
+	0xffc9: 0x58,      // F12
+	0xff7f: 0x45,      // Num_Lock
+	0xff14: 0x46,      // Scroll_Lock
+	0xffb7: 0x47,      // KP_7
+	0xffb8: 0x48,      // KP_8
+	0xffb9: 0x49,      // KP_9
+	0xffad: 0x4a,      // KP_Subtract
+	0xffb4: 0x4b,      // KP_4
+	0xffb5: 0x4c,      // KP_5
+	0xffb6: 0x4d,      // KP_6
+	0xffab: 0x4e,      // KP_Add
+	0xffb1: 0x4f,      // KP_1
+	0xffb2: 0x50,      // KP_2
+	0xffb3: 0x51,      // KP_3
+	0xffb0: 0x52,      // KP_0
+	0xffae: 0x53,      // KP_Decimal
+	0xffaa: 0x37,      // KP_Multiply
+	0xff8d: 0xe01c,    // KP_Enter
+	0xffe4: 0xe01d,    // Control_R
+	0xffaf: 0xe035,    // KP_Divide
+	0xff61: 0xe037,    // Print
+	0xffea: ScanAltGr, // Alt_R
+	0xfe03: ScanAltGr, // ISO_Level3_Shift (AltGr)
+	0xff50: 0xe047,    // Home
+	0xff52: 0xe048,    // Up
+	0xff55: 0xe049,    // Prior
+	0xff51: 0xe04b,    // Left
+	0xff53: 0xe04d,    // Right
+	0xff57: 0xe04f,    // End
+	0xff54: 0xe050,    // Down
+	0xff56: 0xe051,    // Next
+	0xff63: 0xe052,    // Insert
+	0xffff: 0xe053,    // Delete
+	0xffeb: 0xe05b,    // Super_L
+	0xffec: 0xe05c,    // Super_R
+	0xff67: 0xe05d,    // Menu
+}
@@ -0,0 +1,78 @@
+// gorfb project keymap/layouts.go
+// Built in keyboard layouts
+package keymap
+
+import "strings"
+
+// Scancodes of the rows of character keys, from left to right
+// The ISO key between left shift and Z (0x56) does not exist on US keyboards, 0x2b is the key above Return
+var rows = [4][]Scancode{
+	{0x29, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d},
+	{0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x2b},
+	{0x1e, 0x1f, 0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28},
+	{0x56, 0x2c, 0x2d, 0x2e, 0x2f, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35},
+}
+
+// table is a layout described by the characters of its keys
+type table struct {
+	name string
+	keys map[rune]Key
+}
+
+// newTable builds a layout from the characters of the rows without modifiers, with shift and with AltGr
+// A space marks a key that produces no character (or a dead key) at that level
+func newTable(name string, levels [3][4]string) *table {
+	t := &table{name: name, keys: make(map[rune]Key)}
+	for level, lrows := range levels {
+		for row, chars := range lrows {
+			for i, ch := range []rune(chars) {
+				if _, ok := t.keys[ch]; ok || ch == ' ' || i >= len(rows[row]) {
+					continue // The first key producing a character is used
+				}
+				t.keys[ch] = Key{Scancode: rows[row][i], Shift: level == 1, AltGr: level == 2}
+			}
+		}
+	}
+	return t
+}
+
+func (t *table) Name() string {
+	return t.name
+}
+
+func (t *table) Lookup(ch rune) (Key, bool) {
+	key, ok := t.keys[ch]
+	return key, ok
+}
+
+// Built in layouts
+var (
+	US = newTable("us", [3][4]string{
+		{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", " zxcvbnm,./"},
+		{"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", " ZXCVBNM<>?"},
+	})
+	UK = newTable("uk", [3][4]string{
+		{"`1234567890-=", "qwertyuiop[]#", "asdfghjkl;'", "\\zxcvbnm,./"},
+		{"¬!\"£$%^&*()_+", "QWERTYUIOP{}~", "ASDFGHJKL:@", "|ZXCVBNM<>?"},
+		{"¦   €"},
+	})
+	DE = newTable("de", [3][4]string{
+		{" 1234567890ß ", "qwertzuiopü+#", "asdfghjklöä", "<yxcvbnm,.-"},
+		{"°!\"§$%&/()=? ", "QWERTZUIOPÜ*'", "ASDFGHJKLÖÄ", ">YXCVBNM;:_"},
+		{"  ²³   {[]}\\ ", "@ €        ~ ", "", "|      µ"},
+	})
+	FR = newTable("fr", [3][4]string{
+		{"²&é\"'(-è_çà)=", "azertyuiop $*", "qsdfghjklmù", "<wxcvbn,;:!"},
+		{" 1234567890°+", "AZERTYUIOP £µ", "QSDFGHJKLM%", ">WXCVBN?./§"},
+		{"   #{[| \\^@]}", "  €        ¤ "},
+	})
+)
+
+// layouts are the built in layouts by name
+var layouts = map[string]Layout{"us": US, "uk": UK, "de": DE, "fr": FR}
+
+// ByName returns the built in layout with the given name (us, uk, de or fr)
+func ByName(name string) (Layout, bool) {
+	l, ok := layouts[strings.ToLower(name)]
+	return l, ok
+}
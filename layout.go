@@ -0,0 +1,76 @@
+// gorfb project layout.go
+// Common in-memory pixel layouts and the byte swizzling fast path for converting between them
+package gorfb
+
+// PixelLayout names a common in-memory layout of 32 bit pixels with 8 bit channels
+// Telling the server the layout of the application's buffers lets conversions to clients using one of these layouts
+// copy or reorder bytes instead of converting every channel of every pixel
+type PixelLayout int
+
+const (
+	// LayoutCustom leaves the layout to the PixelFormat
+	LayoutCustom PixelLayout = iota
+	// LayoutRGBA has the bytes red, green, blue and alpha in memory order, as image.RGBA
+	LayoutRGBA
+	// LayoutBGRA has the bytes blue, green, red and alpha in memory order, as used by most capture APIs
+	LayoutBGRA
+	// LayoutRGBX is LayoutRGBA with an unused fourth byte
+	LayoutRGBX
+	// LayoutBGRX is LayoutBGRA with an unused fourth byte
+	LayoutBGRX
+)
+
+// PixelFormat returns the pixel format describing the layout
+func (l PixelLayout) PixelFormat() PixelFormat {
+	switch l {
+	case LayoutRGBA, LayoutRGBX:
+		return PixelFormat{32, 24, 0, 1, 255, 255, 255, 0, 8, 16}
+	case LayoutBGRA, LayoutBGRX:
+		return PixelFormat{32, 24, 0, 1, 255, 255, 255, 16, 8, 0}
+	}
+	return PixelFormat{}
+}
+
+// byteOffsets returns the offsets of the red, green and blue bytes within a pixel
+// false is returned if the format is not 32 bits per pixel with every channel in a byte of its own
+func (pf PixelFormat) byteOffsets() ([3]int, bool) {
+	var offs [3]int
+	if pf.BitsPerPixel != 32 || pf.TrueColor != 1 || pf.RedMax != 255 || pf.GreenMax != 255 || pf.BlueMax != 255 {
+		return offs, false
+	}
+	for i, shift := range []uint8{pf.RedShift, pf.GreenShift, pf.BlueShift} {
+		if shift%8 != 0 || shift > 24 {
+			return offs, false
+		}
+		offs[i] = int(shift / 8)
+		if pf.BigEndian == 1 {
+			offs[i] = 3 - offs[i]
+		}
+	}
+	return offs, true
+}
+
+// swizzlePixels converts between two formats that only differ in the order of the bytes, false is returned if
+// they differ in more than that. If the channels are at the same offsets buf is returned as is.
+func swizzlePixels(src, dst PixelFormat, buf []byte) ([]byte, bool) {
+	soffs, ok := src.byteOffsets()
+	if !ok {
+		return nil, false
+	}
+	doffs, ok := dst.byteOffsets()
+	if !ok {
+		return nil, false
+	}
+	if soffs == doffs { // The unused byte may differ, the client ignores it
+		return buf, true
+	}
+	out := make([]byte, len(buf)/4*4)
+	for pos := 0; pos < len(out); pos += 4 {
+		p := buf[pos : pos+4 : pos+4]
+		q := out[pos : pos+4 : pos+4]
+		q[doffs[0]] = p[soffs[0]]
+		q[doffs[1]] = p[soffs[1]]
+		q[doffs[2]] = p[soffs[2]]
+	}
+	return out, true
+}
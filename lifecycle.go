@@ -0,0 +1,147 @@
+// gorfb project lifecycle.go
+// Server lifecycle: waiting for clients and stopping
+package gorfb
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// addListener records a listener the server accepts connections on so that it can be closed later
+func (rfb *RFBServer) addListener(ln net.Listener) {
+	rfb.mu.Lock()
+	rfb.listeners = append(rfb.listeners, ln)
+	if !rfb.didListen {
+		rfb.didListen = true
+		close(rfb.listeningChan())
+	}
+	rfb.mu.Unlock()
+	rfb.logf("Listening on %s\n", ln.Addr().String())
+}
+
+// listeningChan returns the channel that is closed once the server listens
+// The server's mutex must be held
+func (rfb *RFBServer) listeningChan() chan struct{} {
+	if rfb.listening == nil {
+		rfb.listening = make(chan struct{})
+	}
+	return rfb.listening
+}
+
+// Addr returns the address the server listens on, with Port "0" it has the port the system chose
+// With several listeners (ServeScreen) it is the address of the first one, nil if the server is not listening
+func (rfb *RFBServer) Addr() net.Addr {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	if len(rfb.listeners) == 0 {
+		return nil
+	}
+	return rfb.listeners[0].Addr()
+}
+
+// WaitForListener blocks until the server listens and returns its address as Addr does
+// It is used to find the port after starting StartServer with Port "0" in a goroutine
+func (rfb *RFBServer) WaitForListener() net.Addr {
+	rfb.mu.Lock()
+	ch := rfb.listeningChan()
+	rfb.mu.Unlock()
+	<-ch
+	return rfb.Addr()
+}
+
+// Close stops the server from accepting new connections, StartServer and ServeScreen return
+// Connections that are active are not closed
+func (rfb *RFBServer) Close() error {
+	rfb.mu.Lock()
+	lns := rfb.listeners
+	rfb.listeners = nil
+	if rfb.handoff != nil {
+		lns = append(lns, rfb.handoff)
+		rfb.handoff = nil
+	}
+	rfb.mu.Unlock()
+	var errs []error
+	for _, ln := range lns {
+		if err := ln.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// connStarted is called when a connection is accepted or attached, before it is served
+func (rfb *RFBServer) connStarted() {
+	rfb.mu.Lock()
+	rfb.active++
+	rfb.mu.Unlock()
+}
+
+// connEnded is called when a connection that started has been closed
+func (rfb *RFBServer) connEnded() {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	rfb.active--
+	if rfb.active == 0 && rfb.drained != nil {
+		close(rfb.drained)
+		rfb.drained = nil
+	}
+}
+
+// Drain stops the server from accepting new connections and waits until the active connections (including those
+// still in the handshake) have ended, or ctx is done
+func (rfb *RFBServer) Drain(ctx context.Context) error {
+	err := rfb.Close()
+	rfb.mu.Lock()
+	if rfb.active == 0 {
+		rfb.mu.Unlock()
+		return err
+	}
+	if rfb.drained == nil {
+		rfb.drained = make(chan struct{})
+	}
+	ch := rfb.drained
+	rfb.mu.Unlock()
+	select {
+	case <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connectedChan returns the channel that is closed once the first client has connected
+// The server's mutex must be held
+func (rfb *RFBServer) connectedChan() chan struct{} {
+	if rfb.connected == nil {
+		rfb.connected = make(chan struct{})
+	}
+	return rfb.connected
+}
+
+// WaitForClient blocks until the first client has connected (completed the handshake)
+func (rfb *RFBServer) WaitForClient() {
+	rfb.mu.Lock()
+	ch := rfb.connectedChan()
+	rfb.mu.Unlock()
+	<-ch
+}
+
+// clientConnected is called with the server's mutex held when a client has connected
+func (rfb *RFBServer) clientConnected() {
+	if !rfb.hadClient {
+		rfb.hadClient = true
+		close(rfb.connectedChan())
+	}
+}
+
+// clientDisconnected is called when a client has disconnected
+// If it was the last client and ExitOnLastClient is set the server stops serving
+func (rfb *RFBServer) clientDisconnected() {
+	rfb.mu.Lock()
+	last := len(rfb.conns) == 0
+	rfb.mu.Unlock()
+	if last && rfb.ExitOnLastClient {
+		rfb.Close()
+	}
+}
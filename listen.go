@@ -0,0 +1,69 @@
+// gorfb project listen.go
+// Options for the sockets the server listens on and the connections it accepts
+package gorfb
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// ListenOptions configures the sockets the server listens on and the connections accepted on them
+type ListenOptions struct {
+	// DSCP (0-63) marked on the packets sent to clients, so that networks can prioritize interactive traffic
+	// (0 leaves the system default)
+	DSCP int
+	// Priority (SO_PRIORITY, Linux only) of the packets sent to clients (0 leaves the system default)
+	Priority int
+	// ReusePort (SO_REUSEPORT, Linux only) lets another process listen on the same port, so that a newer version
+	// can take over (see TakeOver) while this one drains
+	ReusePort bool
+}
+
+// listen creates a listening socket on port with the server's ListenOptions
+func (rfb *RFBServer) listen(port string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if rfb.Listen.ReusePort {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) { err = setReusePort(fd) }); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", ":"+port)
+}
+
+// accepted applies the server's ListenOptions to an accepted connection
+// Failures are logged, the connection is served without the options
+func (rfb *RFBServer) accepted(conn net.Conn) {
+	opts := rfb.Listen
+	if opts.DSCP == 0 && opts.Priority == 0 {
+		return
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		rfb.logf("Error setting connection options: %s\n", err.Error())
+		return
+	}
+	ipv4 := true
+	if addr, ok := tc.LocalAddr().(*net.TCPAddr); ok {
+		ipv4 = addr.IP.To4() != nil
+	}
+	raw.Control(func(fd uintptr) {
+		if opts.DSCP != 0 {
+			err = setDSCP(fd, ipv4, opts.DSCP)
+		}
+		if err == nil && opts.Priority != 0 {
+			err = setPriority(fd, opts.Priority)
+		}
+	})
+	if err != nil {
+		rfb.logf("Error setting connection options: %s\n", err.Error())
+	}
+}
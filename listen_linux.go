@@ -0,0 +1,38 @@
+// gorfb project listen_linux.go
+// Socket options on Linux
+
+//go:build linux
+
+package gorfb
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// setDSCP marks the packets sent on the socket with the DSCP value
+func setDSCP(fd uintptr, ipv4 bool, dscp int) error {
+	if dscp < 0 || dscp > 63 {
+		return errors.New("DSCP must be in the range 0-63")
+	}
+	if ipv4 {
+		return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+}
+
+// setPriority sets SO_PRIORITY of the socket
+func setPriority(fd uintptr, priority int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PRIORITY, priority)
+}
+
+// setReusePort sets SO_REUSEPORT of the socket, the syscall package only defines it on some architectures
+func setReusePort(fd uintptr) error {
+	opt := 0xf
+	if strings.HasPrefix(runtime.GOARCH, "mips") {
+		opt = 0x200
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, opt, 1)
+}
@@ -0,0 +1,23 @@
+// gorfb project listen_other.go
+// Stub for systems other than Linux
+
+//go:build !linux
+
+package gorfb
+
+import "errors"
+
+// setDSCP returns an error as DSCP marking is only supported on Linux
+func setDSCP(fd uintptr, ipv4 bool, dscp int) error {
+	return errors.New("DSCP is only supported on Linux")
+}
+
+// setPriority returns an error as SO_PRIORITY is only supported on Linux
+func setPriority(fd uintptr, priority int) error {
+	return errors.New("Socket priority is only supported on Linux")
+}
+
+// setReusePort returns an error as SO_REUSEPORT is only supported on Linux
+func setReusePort(fd uintptr) error {
+	return errors.New("Reusing ports is only supported on Linux")
+}
@@ -0,0 +1,105 @@
+// gorfb project logging.go
+// Logging with repeated messages rate limited, so that misbehaving clients can not flood the log
+package gorfb
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLogInterval is the interval over which repeated log messages are limited if LogInterval is 0
+	DefaultLogInterval = time.Second
+	// DefaultLogBurst is the number of messages of a kind logged per interval if LogBurst is 0
+	DefaultLogBurst = 10
+)
+
+// LogStats counts the log messages of a kind, messages of the same kind share the same format
+type LogStats struct {
+	Logged     int
+	Suppressed int
+}
+
+// logKind is the rate limiting state of a kind of log message
+type logKind struct {
+	stats   LogStats
+	start   time.Time // Start of the current interval
+	count   int       // Messages in the current interval
+	pending int       // Messages suppressed since the last one logged
+}
+
+// logLimiter rate limits the log messages of a server per kind
+type logLimiter struct {
+	mu    sync.Mutex
+	kinds map[string]*logKind
+}
+
+// logf logs a message of the connection
+func (fb *RFBConn) logf(format string, args ...interface{}) {
+	fb.Server.logf(format, args...)
+}
+
+// logf logs a message through the server's Logf, messages of a kind logged more than LogBurst times per
+// LogInterval are suppressed and counted, the count is added to the next message of the kind that is logged
+func (rfb *RFBServer) logf(format string, args ...interface{}) {
+	out := rfb.Logf
+	if out == nil {
+		out = log.Printf
+	}
+	interval, burst := rfb.LogInterval, rfb.LogBurst
+	if interval == 0 {
+		interval = DefaultLogInterval
+	}
+	if burst <= 0 {
+		burst = DefaultLogBurst
+	}
+	rfb.logs.mu.Lock()
+	if rfb.logs.kinds == nil {
+		rfb.logs.kinds = make(map[string]*logKind)
+	}
+	kind, ok := rfb.logs.kinds[format]
+	if !ok {
+		kind = &logKind{}
+		rfb.logs.kinds[format] = kind
+	}
+	now := time.Now()
+	if now.Sub(kind.start) >= interval {
+		kind.start, kind.count = now, 0
+	}
+	kind.count++
+	if interval > 0 && kind.count > burst {
+		kind.stats.Suppressed++
+		kind.pending++
+		rfb.logs.mu.Unlock()
+		return
+	}
+	kind.stats.Logged++
+	suppressed := kind.pending
+	kind.pending = 0
+	rfb.logs.mu.Unlock()
+	msg := fmt.Sprintf(format, args...)
+	if suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d similar messages suppressed)\n", strings.TrimRight(msg, "\n"), suppressed)
+	}
+	out("%s", msg)
+}
+
+// Log logs a message through the server's Logf, rate limited like the server's own messages, for handlers and
+// packages serving through the server
+func (rfb *RFBServer) Log(format string, args ...interface{}) {
+	rfb.logf(format, args...)
+}
+
+// LogStats returns the counts of the log messages per kind, identified by their format
+func (rfb *RFBServer) LogStats() map[string]LogStats {
+	rfb.logs.mu.Lock()
+	defer rfb.logs.mu.Unlock()
+	stats := make(map[string]LogStats, len(rfb.logs.kinds))
+	for format, kind := range rfb.logs.kinds {
+		stats[strings.TrimSpace(format)] = kind.stats
+	}
+	return stats
+}
@@ -0,0 +1,262 @@
+// gorfb project media/composite.go
+// Merging the frames of several sources into one framebuffer, for monitoring walls showing many feeds in a session
+package media
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// Feed is a source shown by a Compositor
+type Feed struct {
+	Source FrameSource
+	// Weight of the feed in the layout (1 if 0)
+	Weight float64
+}
+
+// Layout places the feeds of a Compositor in its frame
+type Layout interface {
+	// Cells returns the area of every feed in a frame of size, weights holds the weight of every feed
+	// The feeds are drawn in order, so where cells overlap the later feeds are shown
+	Cells(size image.Point, weights []float64) []image.Rectangle
+}
+
+// Grid lays the feeds out in rows of Columns cells (as many as make a square if 0)
+// The widths of the cells in a row follow the weights of their feeds, the heights of the rows follow the largest
+// weight in each row.
+type Grid struct {
+	Columns int
+}
+
+// Cells returns the cells of the grid
+func (g Grid) Cells(size image.Point, weights []float64) []image.Rectangle {
+	n := len(weights)
+	if n == 0 {
+		return nil
+	}
+	cols := g.Columns
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(n))))
+	}
+	rows := (n + cols - 1) / cols
+	rowWeights := make([]float64, rows)
+	for i, w := range weights {
+		rowWeights[i/cols] = math.Max(rowWeights[i/cols], w)
+	}
+	ys := split(size.Y, rowWeights)
+	cells := make([]image.Rectangle, 0, n)
+	for row := 0; row < rows; row++ {
+		rw := weights[row*cols : min((row+1)*cols, n)]
+		xs := split(size.X, rw)
+		for i := range rw {
+			cells = append(cells, image.Rect(xs[i], ys[row], xs[i+1], ys[row+1]))
+		}
+	}
+	return cells
+}
+
+// split divides length into parts following the weights and returns the boundaries of the parts
+func split(length int, weights []float64) []int {
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	bounds := make([]int, len(weights)+1)
+	sum := 0.0
+	for i, w := range weights {
+		sum += w
+		bounds[i+1] = int(math.Round(float64(length) * sum / total))
+	}
+	return bounds
+}
+
+// PictureInPicture shows the first feed on the whole frame and the others as insets along the bottom, from right
+// to left. The insets are Scale (a quarter if 0) of the frame's height times their weight high, with the frame's
+// aspect ratio, and Margin pixels apart.
+type PictureInPicture struct {
+	Scale  float64
+	Margin int
+}
+
+// Cells returns the whole frame for the first feed and the insets for the others
+func (p PictureInPicture) Cells(size image.Point, weights []float64) []image.Rectangle {
+	if len(weights) == 0 {
+		return nil
+	}
+	scale := p.Scale
+	if scale <= 0 {
+		scale = 0.25
+	}
+	frame := image.Rectangle{Max: size}
+	cells := []image.Rectangle{frame}
+	x := size.X - p.Margin
+	for _, w := range weights[1:] {
+		h := min(int(float64(size.Y)*scale*w), size.Y-2*p.Margin)
+		iw := h * size.X / size.Y
+		cells = append(cells, image.Rect(x-iw, size.Y-p.Margin-h, x, size.Y-p.Margin).Intersect(frame))
+		x -= iw + p.Margin
+	}
+	return cells
+}
+
+// Compositor is a gorfb.RFBServerHandler serving the latest frames of several feeds merged into one frame
+// Frames are scaled to their cells. Run marks the cell of a feed dirty when it delivered a frame, so with the
+// server's DamageHints only the cells of the feeds that changed are sent.
+type Compositor struct {
+	gorfb.BaseHandler
+	// Bounds of the merged frame
+	Bounds image.Rectangle
+
+	mu     sync.Mutex
+	rfb    *gorfb.RFBServer // Server marked dirty, set by Run
+	feeds  []Feed
+	layout Layout
+	cells  []image.Rectangle
+	frames []image.Image
+}
+
+// NewCompositor returns a compositor merging the feeds into a frame of width x height with layout
+// Run must be called to pull the frames of the feeds
+func NewCompositor(width, height int, layout Layout, feeds ...Feed) *Compositor {
+	c := &Compositor{Bounds: image.Rect(0, 0, width, height), feeds: feeds, frames: make([]image.Image, len(feeds))}
+	c.SetLayout(layout)
+	return c
+}
+
+// NewCompositorServer returns a server serving the feeds merged with layout on port and starts pulling their frames
+// in the background
+func NewCompositorServer(port string, width, height int, layout Layout, feeds ...Feed) *gorfb.RFBServer {
+	c := NewCompositor(width, height, layout, feeds...)
+	rfb := &gorfb.RFBServer{Port: port, Width: width, Height: height, PixelFormat: PixelFormat, BufferName: "Feeds",
+		Handler: c, ConvertPixelFormat: true, DamageHints: true}
+	go func() {
+		if err := c.Run(rfb); err != nil {
+			rfb.Log("Error reading frames: %s\n", err.Error())
+		}
+	}()
+	return rfb
+}
+
+// SetLayout changes the layout, the clients of the server Run was given are sent the whole frame
+func (c *Compositor) SetLayout(layout Layout) {
+	weights := make([]float64, len(c.feeds))
+	for i, f := range c.feeds {
+		weights[i] = f.Weight
+		if weights[i] <= 0 {
+			weights[i] = 1
+		}
+	}
+	cells := layout.Cells(c.Bounds.Size(), weights)
+	c.mu.Lock()
+	c.layout, c.cells = layout, cells
+	rfb := c.rfb
+	c.mu.Unlock()
+	if rfb != nil {
+		rfb.MarkDirty(c.Bounds)
+	}
+}
+
+// Run pulls the frames of every feed until they all ended, marking the cell of a feed dirty on rfb for each frame
+// The last frame of a feed that ended stays on the screen. The errors of the feeds are returned, io.EOF excepted.
+func (c *Compositor) Run(rfb *gorfb.RFBServer) error {
+	c.mu.Lock()
+	c.rfb = rfb
+	c.mu.Unlock()
+	errs := make([]error, len(c.feeds))
+	var wg sync.WaitGroup
+	for i := range c.feeds {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.pull(i)
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// pull keeps the latest frame of feed i until its source ends
+func (c *Compositor) pull(i int) error {
+	for {
+		img, _, err := c.feeds[i].Source.NextFrame()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		c.mu.Lock()
+		c.frames[i] = img
+		var cell image.Rectangle
+		if i < len(c.cells) {
+			cell = c.cells[i]
+		}
+		c.mu.Unlock()
+		c.rfb.MarkDirty(cell)
+	}
+}
+
+// render draws the part r of the merged frame
+func (c *Compositor) render(r image.Rectangle) *image.RGBA {
+	c.mu.Lock()
+	cells := c.cells
+	frames := append([]image.Image(nil), c.frames...)
+	c.mu.Unlock()
+	img := image.NewRGBA(r)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	for i, cell := range cells {
+		if i < len(frames) && frames[i] != nil {
+			drawScaled(img, cell, frames[i])
+		}
+	}
+	return img
+}
+
+// drawScaled draws src scaled to cell into the part of cell that is within dst, picking the nearest pixels
+func drawScaled(dst *image.RGBA, cell image.Rectangle, src image.Image) {
+	r := cell.Intersect(dst.Rect)
+	sb := src.Bounds()
+	if r.Empty() || sb.Empty() {
+		return
+	}
+	rgba, _ := src.(*image.RGBA)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		sy := sb.Min.Y + (y-cell.Min.Y)*sb.Dy()/cell.Dy()
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sx := sb.Min.X + (x-cell.Min.X)*sb.Dx()/cell.Dx()
+			if rgba != nil {
+				dst.SetRGBA(x, y, rgba.RGBAAt(sx, sy))
+			} else {
+				dst.SetRGBA(x, y, color.RGBAModel.Convert(src.At(sx, sy)).(color.RGBA))
+			}
+		}
+	}
+}
+
+// ProcessUpdateRequest sends the requested region of the merged frame
+// With the server's DamageHints incremental requests only reach the compositor for cells that changed.
+func (c *Compositor) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	c.ProcessDirtyUpdate(conn, []image.Rectangle{image.Rect(x, y, x+width, y+height)})
+}
+
+// ProcessDirtyUpdate sends the changed cells of the merged frame in a single update
+func (c *Compositor) ProcessDirtyUpdate(conn *gorfb.RFBConn, rects []image.Rectangle) {
+	var out []gorfb.RFBRectangle
+	for _, r := range rects {
+		r = r.Intersect(c.Bounds)
+		if r.Empty() {
+			continue
+		}
+		img := c.render(r) // Its pixels are in PixelFormat, row after row
+		out = append(out, gorfb.RFBRectangle{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(), Buffer: img.Pix})
+	}
+	conn.SendRectangles(out)
+}
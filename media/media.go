@@ -0,0 +1,162 @@
+// gorfb project media/media.go
+// Package media serves frames from any video producer (GStreamer, ffmpeg pipes, camera capture) through gorfb
+//
+// A FrameSource delivers the frames, Handler pulls them at the source's own rate and marks every new frame dirty,
+// so that it is sent to the clients as they request updates.
+package media
+
+import (
+	"errors"
+	"image"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// PixelFormat is the pixel format the frames are served in, it matches the memory layout of image.RGBA
+var PixelFormat = gorfb.PixelFormat{BitsPerPixel: 32, Depth: 24, BigEndian: 0, TrueColor: 1,
+	RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 0, GreenShift: 8, BlueShift: 16}
+
+// FrameSource produces the frames of a video
+type FrameSource interface {
+	// NextFrame blocks until the next frame is available and returns it with its presentation time
+	// io.EOF is returned at the end of the video
+	NextFrame() (image.Image, time.Time, error)
+}
+
+// Handler is a gorfb.RFBServerHandler that serves the frames of a FrameSource
+// New frames are marked dirty on the servers of the clients, so the handler must be served with DamageHints set
+// (as NewServer does), without it every incremental update request is answered with the current frame.
+type Handler struct {
+	gorfb.BaseHandler
+	Source FrameSource
+	// Frames are held back until their presentation time (relative to the first frame) when Pace is set,
+	// this is needed for sources that deliver faster than real time such as files
+	Pace bool
+	// Bounds of the frames, taken from the first frame
+	Bounds image.Rectangle
+
+	mu    sync.Mutex
+	frame image.Image
+	// Servers of the clients, marked dirty for every frame
+	servers map[*gorfb.RFBServer]bool
+}
+
+// NewHandler reads the first frame of src and returns a handler for it
+// Run must be called to pull the rest of the frames
+func NewHandler(src FrameSource) (*Handler, error) {
+	img, _, err := src.NextFrame()
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{Source: src, Bounds: img.Bounds(), frame: img, servers: make(map[*gorfb.RFBServer]bool)}, nil
+}
+
+// NewServer returns a server serving the frames of src on port and starts pulling frames in the background
+func NewServer(port string, src FrameSource) (*gorfb.RFBServer, error) {
+	h, err := NewHandler(src)
+	if err != nil {
+		return nil, err
+	}
+	rfb := &gorfb.RFBServer{Port: port, Width: h.Bounds.Dx(), Height: h.Bounds.Dy(), PixelFormat: PixelFormat,
+		BufferName: "Video", Handler: h, ConvertPixelFormat: true, DamageHints: true}
+	go func() {
+		if err := h.Run(); err != nil {
+			rfb.Log("Error reading frames: %s\n", err.Error())
+		}
+	}()
+	return rfb, nil
+}
+
+// Run pulls frames from the source until it returns an error, io.EOF is not returned as an error
+// The last frame stays on the screen once the source ended
+func (h *Handler) Run() error {
+	var first, start time.Time
+	for {
+		img, ts, err := h.Source.NextFrame()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if h.Pace && !ts.IsZero() {
+			if first.IsZero() {
+				first, start = ts, time.Now()
+			} else if wait := ts.Sub(first) - time.Since(start); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		h.mu.Lock()
+		h.frame = img
+		servers := make([]*gorfb.RFBServer, 0, len(h.servers))
+		for rfb := range h.servers {
+			servers = append(servers, rfb)
+		}
+		h.mu.Unlock()
+		for _, rfb := range servers {
+			rfb.MarkDirty(image.Rect(0, 0, h.Bounds.Dx(), h.Bounds.Dy()))
+		}
+	}
+}
+
+// Init notes the server of the client, it is marked dirty for every frame
+func (h *Handler) Init(conn *gorfb.RFBConn) {
+	h.mu.Lock()
+	h.servers[conn.Server] = true
+	h.mu.Unlock()
+}
+
+// ProcessUpdateRequest sends the requested region of the current frame
+// With the server's DamageHints incremental requests only reach the handler once a new frame arrived.
+func (h *Handler) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	h.mu.Lock()
+	img := h.frame
+	h.mu.Unlock()
+	b := img.Bounds()
+	r := image.Rect(x, y, x+width, y+height).Add(b.Min).Intersect(b)
+	buf := gorfb.ImageToPixels(img, r, PixelFormat)
+	r = r.Sub(b.Min)
+	conn.SendRectangles([]gorfb.RFBRectangle{{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(), Buffer: buf}})
+}
+
+// RawSource is a FrameSource reading raw RGBA frames of a fixed size from a reader, such as the output of
+// ffmpeg -f rawvideo -pix_fmt rgba
+type RawSource struct {
+	Reader        io.Reader
+	Width, Height int
+	// Frames per second, used for the presentation times
+	FPS   float64
+	start time.Time
+	count int
+}
+
+// NewRawSource returns a source reading width x height RGBA frames at fps from r
+func NewRawSource(r io.Reader, width, height int, fps float64) (*RawSource, error) {
+	if width <= 0 || height <= 0 || fps <= 0 {
+		return nil, errors.New("Invalid frame size or rate")
+	}
+	return &RawSource{Reader: r, Width: width, Height: height, FPS: fps}, nil
+}
+
+// NextFrame reads the next frame
+func (rs *RawSource) NextFrame() (image.Image, time.Time, error) {
+	img := image.NewRGBA(image.Rect(0, 0, rs.Width, rs.Height))
+	if _, err := io.ReadFull(rs.Reader, img.Pix); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, time.Time{}, err
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	if rs.count == 0 {
+		rs.start = time.Now()
+	}
+	ts := rs.start.Add(time.Duration(float64(rs.count) * float64(time.Second) / rs.FPS))
+	rs.count++
+	return img, ts, nil
+}
@@ -0,0 +1,188 @@
+// gorfb project migrate.go
+// Migrating live sessions to another server process, for example to upgrade or rebalance servers
+package gorfb
+
+import (
+	"errors"
+	"image"
+	"net"
+	"os"
+	"time"
+)
+
+// SessionState is the negotiated state of a session, it is serialized (for example as JSON) to continue the
+// session in another process with Attach
+type SessionState struct {
+	// Name of the screen the client is attached to
+	Screen string
+	// Minor protocol version agreed with the client
+	Minor int
+	// Flags of the client
+	Shared, ViewOnly bool
+	// Pixel format and encodings requested by the client
+	PixelFormat PixelFormat
+	Encodings   []Encoding
+	// Names of the protocol extensions enabled by the client
+	Extensions []string
+	// Capabilities the client announced for the extended clipboard
+	ExtendedClipboardFlags uint32
+	// Area continuous updates are enabled for, nil if they are not enabled
+	ContinuousUpdates *image.Rectangle
+	// gii devices created by the client
+	GIIDevices    []SessionGIIDevice
+	GIINextOrigin uint32
+	// When the session started
+	Started time.Time
+}
+
+// SessionGIIDevice is a gii device created by the client
+type SessionGIIDevice struct {
+	Origin    uint32
+	Name      string
+	Valuators int
+}
+
+// filer is implemented by connections whose file descriptor can be passed on, such as *net.TCPConn
+type filer interface {
+	File() (*os.File, error)
+}
+
+// detachResult is what Detach returns, it is handed over by the connection's read loop
+type detachResult struct {
+	state *SessionState
+	file  *os.File
+	err   error
+}
+
+// Detach stops serving the client without closing its connection, it returns the session state and a duplicate
+// of the connection's file to be passed to another process that continues the session with Attach
+// The connection is detached between client messages, the session ends here as if the client disconnected.
+func (fb *RFBConn) Detach() (*SessionState, *os.File, error) {
+	if _, ok := fb.Conn.(filer); !ok {
+		return nil, nil, errors.New("Connection can not be passed on")
+	}
+	res := make(chan detachResult, 1)
+	fb.mu.Lock()
+	if fb.detach != nil {
+		fb.mu.Unlock()
+		return nil, nil, errors.New("Connection is already being detached")
+	}
+	fb.detach = res
+	if !fb.inMessage { // Wake the read loop waiting for the next message
+		fb.setReadDeadline(time.Now())
+	}
+	fb.mu.Unlock()
+	select {
+	case r := <-res:
+		return r.state, r.file, r.err
+	case <-fb.done:
+		select {
+		case r := <-res:
+			return r.state, r.file, r.err
+		default:
+			return nil, nil, errors.New("Connection ended before it was detached")
+		}
+	}
+}
+
+// messageStarted is called by the read loop once the type of the next client message was read
+func (fb *RFBConn) messageStarted() {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.inMessage = true
+	if fb.detach != nil { // Detach woke the read loop too late, the message must be read completely
+		fb.setReadDeadline(time.Time{})
+	}
+}
+
+// messageDone is called by the read loop when a client message was handled, it reports if the connection
+// is to be detached
+func (fb *RFBConn) messageDone() bool {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.inMessage = false
+	return fb.detach != nil
+}
+
+// finishDetach hands the connection's file and the session state to Detach and closes the connection here
+func (fb *RFBConn) finishDetach() {
+	fb.wmu.Lock() // No message may be written halfway
+	defer fb.wmu.Unlock()
+	var r detachResult
+	r.file, r.err = fb.Conn.(filer).File()
+	r.state = fb.sessionState()
+	fb.mu.Lock()
+	if fb.closeReason == "" {
+		fb.closeReason = "Session migrated"
+	}
+	res := fb.detach
+	fb.mu.Unlock()
+	fb.Conn.Close()
+	res <- r
+}
+
+// sessionState returns the negotiated state of the session
+func (fb *RFBConn) sessionState() *SessionState {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	state := &SessionState{Screen: fb.Screen.Name, Minor: fb.version, Shared: fb.shared, ViewOnly: fb.viewOnly,
+		PixelFormat: fb.pixelFormat, Encodings: fb.encodings, Extensions: fb.extensionNames(),
+		ExtendedClipboardFlags: fb.extClientFlags, GIINextOrigin: fb.giiNextOrigin, Started: fb.started}
+	if fb.continuous {
+		area := fb.continuousArea
+		state.ContinuousUpdates = &area
+	}
+	for origin, dev := range fb.giiDevices {
+		state.GIIDevices = append(state.GIIDevices, SessionGIIDevice{Origin: origin, Name: dev.name, Valuators: len(dev.values)})
+	}
+	return state
+}
+
+// Attach continues a session detached from another server, conn is the client's connection (for example
+// net.FileConn on the passed file) and state the state returned by Detach
+// The handshake is skipped, the handler's Init is called and the client is sent a full refresh.
+func (rfb *RFBServer) Attach(conn net.Conn, state *SessionState) (*RFBConn, error) {
+	fb := &RFBConn{Server: rfb, Conn: conn, done: make(chan struct{}), screenName: state.Screen}
+	fb.lookupHostname()
+	if !fb.attachScreen() {
+		return nil, ErrUnknownScreen
+	}
+	fb.version = state.Minor
+	fb.shared = state.Shared
+	fb.started = state.Started
+	for _, ext := range fb.extensions() {
+		for _, name := range state.Extensions {
+			if ext.Name() == name { // Already set up with the client, so not enabled again
+				fb.enabledExtensions = append(fb.enabledExtensions, ext)
+			}
+		}
+	}
+	fb.extClipboard = fb.ExtensionEnabled("ExtendedClipboard")
+	fb.initialized = true // The client completed ServerInit with the server it came from
+	fb.ready = true
+	fb.requested = true
+	fb.streamsLost = true // The client's zlib streams continue those of the server it came from
+	fb.extClientFlags = state.ExtendedClipboardFlags
+	if state.ContinuousUpdates != nil {
+		fb.continuous = true
+		fb.continuousArea = *state.ContinuousUpdates
+	}
+	if fb.ExtensionEnabled("GII") {
+		fb.giiDevices = make(map[uint32]*giiDevice)
+		for _, dev := range state.GIIDevices {
+			fb.giiDevices[dev.Origin] = &giiDevice{name: dev.Name, values: make([]int32, dev.Valuators)}
+		}
+		fb.giiNextOrigin = state.GIINextOrigin
+	}
+	// The rest is restored like a resumed session, after the handler's Init
+	fb.resumed = &resumeState{screenName: state.Screen, viewOnly: state.ViewOnly, pixelFormat: state.PixelFormat,
+		encodings: state.Encodings}
+	rfb.connStarted()
+	go func() {
+		defer close(fb.done)
+		defer rfb.connEnded()
+		fb.session()
+		fb.Conn.Close()
+	}()
+	return fb, nil
+}
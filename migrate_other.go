@@ -0,0 +1,22 @@
+// gorfb project migrate_other.go
+// Stub for systems without Unix sockets
+
+//go:build !unix
+
+package gorfb
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// SendSession returns an error as passing connections is only supported on Unix systems
+func SendSession(uc *net.UnixConn, state *SessionState, f *os.File) error {
+	return errors.New("Passing sessions is only supported on Unix systems")
+}
+
+// ReceiveSession returns an error as passing connections is only supported on Unix systems
+func (rfb *RFBServer) ReceiveSession(uc *net.UnixConn) (*RFBConn, error) {
+	return nil, errors.New("Passing sessions is only supported on Unix systems")
+}
@@ -0,0 +1,84 @@
+// gorfb project migrate_unix.go
+// Passing detached sessions between processes over Unix sockets
+
+//go:build unix
+
+package gorfb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// SendSession passes a session detached with Detach to another process over a Unix socket
+// The state is sent as JSON along with the connection's file descriptor, f can be closed afterwards
+func SendSession(uc *net.UnixConn, state *SessionState, f *os.File) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(buf)))
+	msg = append(msg, buf...)
+	n, _, err := uc.WriteMsgUnix(msg, syscall.UnixRights(int(f.Fd())), nil)
+	if err != nil {
+		return err
+	}
+	_, err = uc.Write(msg[n:])
+	return err
+}
+
+// ReceiveSession receives a session passed with SendSession and continues it on the server with Attach
+func (rfb *RFBServer) ReceiveSession(uc *net.UnixConn) (*RFBConn, error) {
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := uc.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != 1 {
+		return nil, errors.New("No connection was passed")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fds[0]), "rfb-session")
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+	if n < 4 {
+		conn.Close()
+		return nil, errors.New("Session state too short")
+	}
+	sz := int(binary.BigEndian.Uint32(buf))
+	data := append([]byte(nil), buf[4:n]...)
+	if len(data) < sz {
+		rest := make([]byte, sz-len(data))
+		if _, err := io.ReadFull(uc, rest); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		data = append(data, rest...)
+	}
+	var state SessionState
+	if err := json.Unmarshal(data[:sz], &state); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	fb, err := rfb.Attach(conn, &state)
+	if err != nil {
+		conn.Close()
+	}
+	return fb, err
+}
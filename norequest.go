@@ -0,0 +1,62 @@
+// gorfb project norequest.go
+// Handling clients that never ask for framebuffer updates
+package gorfb
+
+import "time"
+
+// NoRequestPolicy determines what happens with a client that sent no FramebufferUpdateRequest within the server's
+// NoRequestTimeout of completing the handshake, as some automation tools do
+type NoRequestPolicy int
+
+const (
+	// NoRequestWait keeps waiting for the client's first update request
+	NoRequestWait NoRequestPolicy = iota
+	// NoRequestUpdate sends the client a full update as if it had asked for one
+	NoRequestUpdate
+	// NoRequestDisconnect disconnects the client
+	NoRequestDisconnect
+)
+
+// DefaultNoRequestTimeout is the time clients have to send their first update request if the server's
+// NoRequestTimeout is 0
+const DefaultNoRequestTimeout = 30 * time.Second
+
+// updateRequestSeen records that the client asked for an update
+func (fb *RFBConn) updateRequestSeen() {
+	fb.mu.Lock()
+	fb.requested = true
+	fb.mu.Unlock()
+}
+
+// watchRequests applies the server's NoRequestPolicy once the client had NoRequestTimeout to send an update request
+func (fb *RFBConn) watchRequests() {
+	policy := fb.Server.NoRequestPolicy
+	if policy == NoRequestWait {
+		return
+	}
+	timeout := fb.Server.NoRequestTimeout
+	if timeout <= 0 {
+		timeout = DefaultNoRequestTimeout
+	}
+	go func() {
+		select {
+		case <-fb.done:
+			return
+		case <-time.After(timeout):
+		}
+		fb.mu.Lock()
+		requested := fb.requested
+		fb.mu.Unlock()
+		if requested {
+			return
+		}
+		if policy == NoRequestDisconnect {
+			fb.Close("No update request")
+			return
+		}
+		fb.logf("Client sent no update request within %s, sending a full update\n", timeout)
+		fb.becomeReady()
+		full := fb.screenBounds()
+		fb.dispatch(updateQueue, func() { fb.requestUpdate(0, 0, full.Dx(), full.Dy(), false) })
+	}()
+}
@@ -0,0 +1,149 @@
+// gorfb project offscreen/offscreen.go
+// Package offscreen serves offscreen rendered GUI canvases through gorfb
+//
+// Any toolkit that can render into an image.Image (Fyne, gioui or ebiten render targets for example) can be
+// accessed remotely by implementing Canvas, and optionally InputSink to receive the clients' input.
+package offscreen
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// PixelFormat is the pixel format the canvas is served in, it matches the memory layout of image.RGBA
+var PixelFormat = gorfb.PixelFormat{BitsPerPixel: 32, Depth: 24, BigEndian: 0, TrueColor: 1,
+	RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 0, GreenShift: 8, BlueShift: 16}
+
+// Canvas is an offscreen rendered surface
+type Canvas interface {
+	// Image returns the current frame, its bounds must not change
+	Image() image.Image
+}
+
+// Notifier can be implemented by a Canvas that knows when it has rendered a new frame
+type Notifier interface {
+	// Changed returns a channel that receives a value every time a new frame was rendered
+	Changed() <-chan struct{}
+}
+
+// InputSink receives the input of the clients
+type InputSink interface {
+	// Key is called for key presses and releases, keysym is an X keysym
+	Key(keysym int, down bool)
+	// Pointer is called for pointer movement, buttons is the RFB button mask
+	Pointer(x, y, buttons int)
+	// Text is called with text pasted by a client
+	Text(text string)
+}
+
+// Handler is a gorfb.RFBServerHandler serving a canvas
+// Every new frame is marked dirty on the servers of the clients, so the handler must be served with DamageHints set
+// (as NewServer does), without it every incremental update request is answered with the current frame.
+type Handler struct {
+	Canvas Canvas
+	// Input if not nil receives the input of the clients
+	Input InputSink
+	// Time between frames when the canvas is not a Notifier (40ms if not set)
+	Interval time.Duration
+
+	mu      sync.Mutex
+	servers map[*gorfb.RFBServer]bool // Servers of the clients, marked dirty for every frame
+	watch   sync.Once
+}
+
+// NewServer returns a server serving the canvas on port with input sent to input (which may be nil)
+func NewServer(port string, canvas Canvas, input InputSink) *gorfb.RFBServer {
+	b := canvas.Image().Bounds()
+	return &gorfb.RFBServer{Port: port, Width: b.Dx(), Height: b.Dy(), PixelFormat: PixelFormat,
+		BufferName: "Canvas", Handler: &Handler{Canvas: canvas, Input: input}, ConvertPixelFormat: true, DamageHints: true}
+}
+
+// Init notes the server of the client and starts watching the canvas for new frames with the first client
+func (h *Handler) Init(conn *gorfb.RFBConn) {
+	h.mu.Lock()
+	if h.servers == nil {
+		h.servers = make(map[*gorfb.RFBServer]bool)
+	}
+	h.servers[conn.Server] = true
+	h.mu.Unlock()
+	h.watch.Do(func() { go h.watchFrames() })
+}
+
+// watchFrames marks the canvas dirty on the servers for every new frame, or every Interval if the canvas is not a
+// Notifier
+func (h *Handler) watchFrames() {
+	var frames <-chan struct{}
+	var ticks <-chan time.Time
+	if n, ok := h.Canvas.(Notifier); ok {
+		frames = n.Changed()
+	} else {
+		interval := h.Interval
+		if interval <= 0 {
+			interval = 40 * time.Millisecond
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	b := h.Canvas.Image().Bounds()
+	for {
+		select {
+		case _, ok := <-frames:
+			if !ok {
+				return
+			}
+		case <-ticks:
+		}
+		h.mu.Lock()
+		servers := make([]*gorfb.RFBServer, 0, len(h.servers))
+		for rfb := range h.servers {
+			servers = append(servers, rfb)
+		}
+		h.mu.Unlock()
+		for _, rfb := range servers {
+			rfb.MarkDirty(image.Rect(0, 0, b.Dx(), b.Dy()))
+		}
+	}
+}
+
+// ProcessSetPixelFormat is handled by the server converting the pixels
+func (h *Handler) ProcessSetPixelFormat(conn *gorfb.RFBConn, pf gorfb.PixelFormat) {}
+
+// ProcessSetEncoding is ignored
+func (h *Handler) ProcessSetEncoding(conn *gorfb.RFBConn, encodings []int) {}
+
+// ProcessUpdateRequest sends the requested region of the canvas
+// With the server's DamageHints incremental requests only reach the handler once a new frame was rendered.
+func (h *Handler) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	img := h.Canvas.Image()
+	b := img.Bounds()
+	r := image.Rect(x, y, x+width, y+height).Add(b.Min).Intersect(b)
+	buf := gorfb.ImageToPixels(img, r, PixelFormat)
+	r = r.Sub(b.Min)
+	conn.SendRectangles([]gorfb.RFBRectangle{{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(), Buffer: buf}})
+}
+
+// ProcessKeyEvent passes the key on to the input sink
+func (h *Handler) ProcessKeyEvent(conn *gorfb.RFBConn, key int, downflag bool) {
+	if h.Input != nil {
+		h.Input.Key(key, downflag)
+	}
+}
+
+// ProcessPointerEvent passes the pointer event on to the input sink
+func (h *Handler) ProcessPointerEvent(conn *gorfb.RFBConn, x, y, button int) {
+	if h.Input != nil {
+		b := h.Canvas.Image().Bounds()
+		h.Input.Pointer(x+b.Min.X, y+b.Min.Y, button)
+	}
+}
+
+// ProcessCutText passes the pasted text on to the input sink
+func (h *Handler) ProcessCutText(conn *gorfb.RFBConn, text string) {
+	if h.Input != nil {
+		h.Input.Text(text)
+	}
+}
@@ -0,0 +1,251 @@
+// gorfb project overlay.go
+// Overlays (banners, notifications, watermarks) composited over the updates sent to clients
+package gorfb
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"time"
+
+	"github.com/hduplooy/gorfb/bitfont"
+)
+
+// Overlay is text drawn over the framebuffer updates sent to clients without the handler having to draw it
+// An overlay must not be changed once it has been added
+type Overlay struct {
+	// Text to show, lines are separated by newlines
+	Text string
+	// Position of the top left corner, negative values position the overlay relative to the right or bottom edge
+	X, Y int
+	// Size of a font pixel (1 if not set)
+	Scale int
+	// Space around the text filled with the background
+	Padding int
+	// Color of the text (white if nil)
+	Foreground color.Color
+	// Color of the box behind the text (transparent if nil), may be translucent
+	Background color.Color
+}
+
+// activeOverlay is an overlay that has been added along with its rendered image
+type activeOverlay struct {
+	overlay *Overlay
+	img     *image.RGBA
+}
+
+// render draws the overlay into an image the size of the overlay
+func (o *Overlay) render() *image.RGBA {
+	scale := o.Scale
+	if scale < 1 {
+		scale = 1
+	}
+	lines := strings.Split(o.Text, "\n")
+	var sz image.Point
+	for _, line := range lines {
+		lsz := bitfont.Measure(line, scale)
+		sz = image.Pt(max(sz.X, lsz.X), sz.Y+lsz.Y)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, sz.X+2*o.Padding, sz.Y+2*o.Padding))
+	if o.Background != nil {
+		draw.Draw(img, img.Bounds(), image.NewUniform(o.Background), image.Point{}, draw.Src)
+	}
+	fg := o.Foreground
+	if fg == nil {
+		fg = color.White
+	}
+	y := o.Padding
+	for _, line := range lines {
+		bitfont.Draw(img, image.Pt(o.Padding, y), line, fg, scale)
+		y += bitfont.Measure(line, scale).Y
+	}
+	return img
+}
+
+// bounds returns the area the overlay covers on a framebuffer of width x height
+func (ao *activeOverlay) bounds(width, height int) image.Rectangle {
+	sz := ao.img.Bounds().Size()
+	x, y := ao.overlay.X, ao.overlay.Y
+	if x < 0 {
+		x += width - sz.X
+	}
+	if y < 0 {
+		y += height - sz.Y
+	}
+	return image.Rectangle{image.Pt(x, y), image.Pt(x, y).Add(sz)}
+}
+
+// AddOverlay shows the overlay to all clients of the server, if d > 0 it is removed again after d
+func (rfb *RFBServer) AddOverlay(o *Overlay, d time.Duration) {
+	rfb.mu.Lock()
+	rfb.overlays = append(rfb.overlays, &activeOverlay{overlay: o, img: o.render()})
+	rfb.mu.Unlock()
+	rfb.refreshOverlay(o)
+	if d > 0 {
+		time.AfterFunc(d, func() { rfb.RemoveOverlay(o) })
+	}
+}
+
+// RemoveOverlay removes an overlay added with AddOverlay
+func (rfb *RFBServer) RemoveOverlay(o *Overlay) {
+	rfb.mu.Lock()
+	var removed bool
+	rfb.overlays, removed = removeOverlay(rfb.overlays, o)
+	rfb.mu.Unlock()
+	if removed {
+		rfb.refreshOverlay(o)
+	}
+}
+
+// refreshOverlay has the area covered by the overlay resent to all clients
+func (rfb *RFBServer) refreshOverlay(o *Overlay) {
+	ao := &activeOverlay{overlay: o, img: o.render()}
+	for _, fb := range rfb.Connections() {
+		size := fb.screenBounds().Size()
+		fb.refreshArea(ao.bounds(size.X, size.Y))
+	}
+}
+
+// AddOverlay shows the overlay to this client only, if d > 0 it is removed again after d
+func (fb *RFBConn) AddOverlay(o *Overlay, d time.Duration) {
+	ao := &activeOverlay{overlay: o, img: o.render()}
+	fb.mu.Lock()
+	fb.overlays = append(fb.overlays, ao)
+	fb.mu.Unlock()
+	size := fb.screenBounds().Size()
+	fb.refreshArea(ao.bounds(size.X, size.Y))
+	if d > 0 {
+		time.AfterFunc(d, func() { fb.RemoveOverlay(o) })
+	}
+}
+
+// RemoveOverlay removes an overlay added with AddOverlay on the connection
+func (fb *RFBConn) RemoveOverlay(o *Overlay) {
+	fb.mu.Lock()
+	var removed bool
+	fb.overlays, removed = removeOverlay(fb.overlays, o)
+	fb.mu.Unlock()
+	if removed {
+		ao := &activeOverlay{overlay: o, img: o.render()}
+		size := fb.screenBounds().Size()
+		fb.refreshArea(ao.bounds(size.X, size.Y))
+	}
+}
+
+// removeOverlay returns the list without o and if it was found
+func removeOverlay(list []*activeOverlay, o *Overlay) ([]*activeOverlay, bool) {
+	for i, ao := range list {
+		if ao.overlay == o {
+			return append(list[:i:i], list[i+1:]...), true
+		}
+	}
+	return list, false
+}
+
+// refreshArea marks an area of the client's framebuffer dirty, for example after an overlay changed
+// The handler resends it in answer to the client's next update request (or the one waiting with DamageHints).
+func (fb *RFBConn) refreshArea(r image.Rectangle) {
+	select {
+	case <-fb.done:
+		return
+	default:
+	}
+	fb.markDirty(r)
+}
+
+// notifyConnection shows the other clients a notification that fb connected or disconnected
+func (rfb *RFBServer) notifyConnection(fb *RFBConn, connected bool) {
+	if !rfb.ConnectNotifications {
+		return
+	}
+	text := "Client disconnected: "
+	if connected {
+		text = "Client connected: "
+	}
+	o := &Overlay{Text: text + fb.peer(), X: 8, Y: -8, Scale: 2, Padding: 4,
+		Background: color.RGBA{0, 0, 0, 0xc0}}
+	for _, other := range rfb.Connections() {
+		if other != fb {
+			other.AddOverlay(o, 5*time.Second)
+		}
+	}
+}
+
+// layer is an image drawn over the updates at bounds
+type layer struct {
+	img    *image.RGBA
+	bounds image.Rectangle
+}
+
+// applyOverlays returns the rectangles (in the client's pixel format) with the active overlays, the watermark and
+// the cursor if the client can not show it itself, drawn over them
+// Rectangles that are not covered by an overlay are passed on as is, the others are copied before drawing
+func (fb *RFBConn) applyOverlays(rects []RFBRectangle) []RFBRectangle {
+	fb.Server.mu.Lock()
+	overlays := append([]*activeOverlay(nil), fb.Server.overlays...)
+	fb.Server.mu.Unlock()
+	fb.mu.Lock()
+	overlays = append(overlays, fb.overlays...)
+	if fb.watermark != nil { // Drawn over the other overlays so that they can not hide it
+		overlays = append(overlays, fb.watermark)
+	}
+	pf := fb.pixelFormat
+	fb.mu.Unlock()
+	var layers []layer
+	size := fb.screenBounds().Size()
+	for _, ao := range overlays {
+		layers = append(layers, layer{ao.img, ao.bounds(size.X, size.Y)})
+	}
+	if img, bounds := fb.cursorLayer(); img != nil {
+		layers = append(layers, layer{img, bounds})
+	}
+	if len(layers) == 0 || pf.TrueColor != 1 {
+		return rects
+	}
+	bpp := pf.BytesPerPixel()
+	var out []RFBRectangle
+	for i, rect := range rects {
+		rr := rect.Bounds()
+		for _, l := range layers {
+			ob := l.bounds
+			isect := rr.Intersect(ob)
+			if isect.Empty() || len(rect.Buffer) < rect.Width*rect.Height*bpp {
+				continue
+			}
+			if out == nil {
+				out = append([]RFBRectangle(nil), rects...)
+			}
+			if &out[i].Buffer[0] == &rect.Buffer[0] { // Don't draw into the caller's buffer
+				out[i].Buffer = append([]byte(nil), rect.Buffer...)
+			}
+			for y := isect.Min.Y; y < isect.Max.Y; y++ {
+				for x := isect.Min.X; x < isect.Max.X; x++ {
+					c := l.img.RGBAAt(x-ob.Min.X, y-ob.Min.Y)
+					if c.A == 0 {
+						continue
+					}
+					pos := ((y-rect.Y)*rect.Width + x - rect.X) * bpp
+					pf.putPixel(out[i].Buffer, pos, blendPixel(pf, pf.getPixel(out[i].Buffer, pos), c))
+				}
+			}
+		}
+	}
+	if out == nil {
+		return rects
+	}
+	return out
+}
+
+// blendPixel draws the (alpha premultiplied) color c over the pixel value val
+func blendPixel(pf PixelFormat, val uint32, c color.RGBA) uint32 {
+	inv := 255 - uint32(c.A)
+	comp := func(shift uint8, max uint16, cc uint8) uint32 {
+		v := scaleColor(uint32(cc), 255, max) + ((val>>shift)&uint32(max))*inv/255
+		if v > uint32(max) {
+			v = uint32(max)
+		}
+		return v << shift
+	}
+	return comp(pf.RedShift, pf.RedMax, c.R) | comp(pf.GreenShift, pf.GreenMax, c.G) | comp(pf.BlueShift, pf.BlueMax, c.B)
+}
@@ -0,0 +1,49 @@
+// gorfb project pause.go
+// Pausing the updates sent to a client, for example while the server is being maintained
+package gorfb
+
+// PauseUpdates stops sending framebuffer updates to the client until ResumeUpdates is called
+// The handler is still asked for updates, the areas of the rectangles it sends are remembered instead of sent.
+// Cut text, bells and other messages are not affected.
+func (fb *RFBConn) PauseUpdates() {
+	fb.mu.Lock()
+	fb.paused = true
+	fb.mu.Unlock()
+}
+
+// ResumeUpdates sends updates again, the client gets a single refresh of everything that changed while paused
+func (fb *RFBConn) ResumeUpdates() {
+	fb.mu.Lock()
+	if !fb.paused {
+		fb.mu.Unlock()
+		return
+	}
+	fb.paused = false
+	damage := fb.pausedDamage
+	fb.pausedDamage = nil
+	fb.mu.Unlock()
+	if !damage.Empty() {
+		fb.dispatch(updateQueue, func() { fb.sendDirty(damage) })
+	}
+}
+
+// UpdatesPaused reports if updates to the client are paused
+func (fb *RFBConn) UpdatesPaused() bool {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.paused
+}
+
+// holdUpdate remembers the areas of the rectangles (in client coordinates) if updates are paused, it reports if
+// they were held back
+func (fb *RFBConn) holdUpdate(rects []RFBRectangle) bool {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if !fb.paused {
+		return false
+	}
+	for _, r := range rects {
+		fb.pausedDamage = fb.pausedDamage.Add(r.Bounds())
+	}
+	return true
+}
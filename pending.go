@@ -0,0 +1,110 @@
+// gorfb project pending.go
+// Holding back cut text, bells and desktop names sent before the client is ready for them
+package gorfb
+
+import (
+	"context"
+	"errors"
+	"unicode/utf8"
+)
+
+// ErrDesktopNameUnsupported is returned by SetDesktopName when the client does not support the DesktopName
+// pseudo-encoding
+var ErrDesktopNameUnsupported = errors.New("Client does not support changing the desktop name")
+
+// pendingSends are the messages sent before the client was ready, guarded by fb.mu
+// Only the last cut text and desktop name matter and any number of bells are rung once
+type pendingSends struct {
+	cutText *string
+	bell    bool
+	name    *string
+}
+
+// deferSend queues a message with queue as long as the client is not ready, it reports if the message was queued
+// Once the client is ready the queued messages are sent before any that follow them
+func (fb *RFBConn) deferSend(queue func(p *pendingSends)) bool {
+	fb.readyMu.Lock()
+	defer fb.readyMu.Unlock()
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if fb.ready {
+		return false
+	}
+	queue(&fb.pending)
+	return true
+}
+
+// becomeReady sends the queued messages, the read loop calls it once the client sent its encodings or its first
+// update request, so that cut text goes out after the extended clipboard was negotiated and before the first update
+func (fb *RFBConn) becomeReady() {
+	fb.readyMu.Lock()
+	defer fb.readyMu.Unlock()
+	fb.mu.Lock()
+	if fb.ready {
+		fb.mu.Unlock()
+		return
+	}
+	fb.ready = true
+	p := fb.pending
+	fb.pending = pendingSends{}
+	fb.mu.Unlock()
+	if p.name != nil {
+		if err := fb.sendDesktopName(*p.name); err != nil && err != ErrDesktopNameUnsupported {
+			fb.logf("Error sending desktop name: %s\n", err.Error())
+		}
+	}
+	if p.cutText != nil {
+		if err := fb.sendCutText(context.Background(), *p.cutText); err != nil && err != ErrCutTextNotAllowed {
+			fb.logf("Error sending cut text: %s\n", err.Error())
+		}
+	}
+	if p.bell {
+		if err := fb.write([]byte{byte(MsgBell)}); err != nil {
+			fb.logf("Error sending bell: %s\n", err.Error())
+		}
+	}
+}
+
+// desktopName returns the name sent to the client in ServerInit, the screen's BufferName unless SetDesktopName
+// was called before
+func (fb *RFBConn) desktopName() string {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if fb.pending.name != nil {
+		name := *fb.pending.name
+		fb.pending.name = nil
+		return name
+	}
+	return fb.Screen.BufferName
+}
+
+// SetDesktopName changes the name of the desktop shown by the client, which needs support for the DesktopName
+// pseudo-encoding. Before the handshake completed the name is sent in ServerInit instead of the BufferName,
+// until the client sent its encodings the name is held back.
+func (fb *RFBConn) SetDesktopName(name string) error {
+	if !utf8.ValidString(name) {
+		return errors.New("Desktop name is not valid UTF-8")
+	}
+	if fb.deferSend(func(p *pendingSends) { p.name = &name }) {
+		return nil
+	}
+	return fb.sendDesktopName(name)
+}
+
+// sendDesktopName sends a FramebufferUpdate with the DesktopName pseudo-rectangle
+func (fb *RFBConn) sendDesktopName(name string) error {
+	if !fb.Supports(EncDesktopName) {
+		return ErrDesktopNameUnsupported
+	}
+	w := NewMessageWriter(20 + len(name)).Uint8(uint8(MsgFramebufferUpdate)).Padding(1).Uint16(1) // A FramebufferUpdate with a single rectangle
+	w.Uint16(0).Uint16(0).Uint16(0).Uint16(0).Int32(int32(EncDesktopName))
+	buf, err := w.Uint32(uint32(len(name))).Data([]byte(name)).Bytes()
+	if err != nil {
+		return err
+	}
+	if err := fb.write(buf); err != nil {
+		return err
+	}
+	fb.countUpdate(EncDesktopName)
+	return nil
+}
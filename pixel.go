@@ -0,0 +1,124 @@
+// gorfb project pixel.go
+// Conversion of pixel data between pixel formats
+package gorfb
+
+import "image"
+
+// BytesPerPixel returns the number of bytes used by a single pixel in the format
+func (pf PixelFormat) BytesPerPixel() int {
+	return int(pf.BitsPerPixel+7) / 8
+}
+
+// wire returns the format that is sent to clients for a framebuffer in this format
+// RFB does not allow 24 bits per pixel so such framebuffers are sent as 32 bits per pixel with a depth of 24
+func (pf PixelFormat) wire() PixelFormat {
+	if pf.BitsPerPixel == 24 {
+		pf.BitsPerPixel = 32
+		pf.Depth = min(pf.Depth, 24)
+	}
+	return pf
+}
+
+// write writes the 16 bytes of the pixel format as used in ServerInit and SetPixelFormat
+func (pf PixelFormat) write(w *MessageWriter) {
+	w.Uint8(pf.BitsPerPixel).Uint8(pf.Depth).Uint8(pf.BigEndian).Uint8(pf.TrueColor)
+	w.Uint16(pf.RedMax).Uint16(pf.GreenMax).Uint16(pf.BlueMax)
+	w.Uint8(pf.RedShift).Uint8(pf.GreenShift).Uint8(pf.BlueShift).Padding(3)
+}
+
+// readPixelFormat reads the 16 bytes of a pixel format
+func readPixelFormat(r *MessageReader) PixelFormat {
+	pf := PixelFormat{BitsPerPixel: r.Uint8(), Depth: r.Uint8(), BigEndian: r.Uint8(), TrueColor: r.Uint8(),
+		RedMax: r.Uint16(), GreenMax: r.Uint16(), BlueMax: r.Uint16(), RedShift: r.Uint8(), GreenShift: r.Uint8(), BlueShift: r.Uint8()}
+	r.Skip(3)
+	return pf
+}
+
+// getPixel returns the pixel value at position pos (in bytes) in buf
+func (pf PixelFormat) getPixel(buf []byte, pos int) uint32 {
+	val := uint32(0)
+	bpp := pf.BytesPerPixel()
+	for i := 0; i < bpp; i++ {
+		if pf.BigEndian == 1 {
+			val = (val << 8) | uint32(buf[pos+i])
+		} else {
+			val |= uint32(buf[pos+i]) << (8 * uint(i))
+		}
+	}
+	return val
+}
+
+// putPixel stores the pixel value at position pos (in bytes) in buf
+func (pf PixelFormat) putPixel(buf []byte, pos int, val uint32) {
+	bpp := pf.BytesPerPixel()
+	for i := 0; i < bpp; i++ {
+		if pf.BigEndian == 1 {
+			buf[pos+bpp-1-i] = byte(val)
+		} else {
+			buf[pos+i] = byte(val)
+		}
+		val >>= 8
+	}
+}
+
+// scaleColor scales a color component from the range 0->from to the range 0->to
+func scaleColor(val uint32, from, to uint16) uint32 {
+	if from == to || from == 0 {
+		return val
+	}
+	return (val*uint32(to) + uint32(from)/2) / uint32(from)
+}
+
+// convertPixel converts a pixel value from format src to format dst
+func convertPixel(src, dst PixelFormat, val uint32) uint32 {
+	r := scaleColor((val>>src.RedShift)&uint32(src.RedMax), src.RedMax, dst.RedMax)
+	g := scaleColor((val>>src.GreenShift)&uint32(src.GreenMax), src.GreenMax, dst.GreenMax)
+	b := scaleColor((val>>src.BlueShift)&uint32(src.BlueMax), src.BlueMax, dst.BlueMax)
+	return r<<dst.RedShift | g<<dst.GreenShift | b<<dst.BlueShift
+}
+
+// ConvertPixels converts pixel data in buf from format src to format dst
+// If the formats are the same, or either of them is not true color, buf is returned as is
+func ConvertPixels(src, dst PixelFormat, buf []byte) []byte {
+	if src == dst || src.TrueColor != 1 || dst.TrueColor != 1 {
+		return buf
+	}
+	if out, ok := swizzlePixels(src, dst, buf); ok { // Only the order of the bytes differs
+		return out
+	}
+	sbpp, dbpp := src.BytesPerPixel(), dst.BytesPerPixel()
+	cnt := len(buf) / sbpp
+	out := make([]byte, cnt*dbpp)
+	for i := 0; i < cnt; i++ {
+		dst.putPixel(out, i*dbpp, convertPixel(src, dst, src.getPixel(buf, i*sbpp)))
+	}
+	return out
+}
+
+// ImageToPixels returns the pixels of region r of img in pixel format pf (which must be true color)
+func ImageToPixels(img image.Image, r image.Rectangle, pf PixelFormat) []byte {
+	r = r.Intersect(img.Bounds())
+	bpp := pf.BytesPerPixel()
+	buf := make([]byte, r.Dx()*r.Dy()*bpp)
+	if _, ok := pf.byteOffsets(); ok {
+		if rgba, ok := img.(*image.RGBA); ok { // Rows can be copied as is, at most the bytes are reordered
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				off := rgba.PixOffset(r.Min.X, y)
+				copy(buf[(y-r.Min.Y)*r.Dx()*4:], rgba.Pix[off:off+r.Dx()*4])
+			}
+			buf, _ = swizzlePixels(LayoutRGBA.PixelFormat(), pf, buf)
+			return buf
+		}
+	}
+	pos := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			val := scaleColor(cr, 0xffff, pf.RedMax)<<pf.RedShift | scaleColor(cg, 0xffff, pf.GreenMax)<<pf.GreenShift |
+				scaleColor(cb, 0xffff, pf.BlueMax)<<pf.BlueShift
+			pf.putPixel(buf, pos, val)
+			pos += bpp
+		}
+	}
+	return buf
+}
@@ -0,0 +1,94 @@
+// gorfb project policy.go
+// Restricting the protocol versions and security types clients can use, refused clients are reported for auditing
+package gorfb
+
+import "fmt"
+
+// HandshakePolicy restricts the protocol versions and security types clients can use
+// Refused clients are told why before the connection is closed.
+type HandshakePolicy struct {
+	// Minor protocol versions (3, 7 or 8 for RFB3.3, RFB3.7 and RFB3.8) clients may use, all if empty
+	Versions []int
+	// Security types clients may use, all if empty. Without SecNone clients are never let in without
+	// authentication, also not when ResumeSkipAuth would skip it
+	SecurityTypes []SecurityType
+}
+
+// HandshakeRejection describes a client refused during the handshake because of its protocol version or security type
+type HandshakeRejection struct {
+	// Address of the client
+	Address string
+	// Minor protocol version agreed with the client
+	Version int
+	// Security type the client was offered, or chose if it was not offered (SecInvalid when refused for its version)
+	Security SecurityType
+	// Reason the client was told
+	Reason string
+}
+
+// allowsVersion reports if clients may use the minor protocol version
+func (p HandshakePolicy) allowsVersion(minor int) bool {
+	if len(p.Versions) == 0 {
+		return true
+	}
+	for _, v := range p.Versions {
+		if v == minor {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsSecurity reports if clients may use the security type
+func (p HandshakePolicy) allowsSecurity(sectype SecurityType) bool {
+	if len(p.SecurityTypes) == 0 {
+		return true
+	}
+	for _, t := range p.SecurityTypes {
+		if t == sectype {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that the policy lets clients in with the security type the server offers
+func (p HandshakePolicy) validate(authenticate bool) error {
+	for _, v := range p.Versions {
+		if v != 3 && v != 7 && v != 8 {
+			return fmt.Errorf("Protocol version 3.%d in the handshake policy is not supported", v)
+		}
+	}
+	sectype := SecNone
+	if authenticate {
+		sectype = SecVNCAuth
+	}
+	if !p.allowsSecurity(sectype) {
+		return fmt.Errorf("The handshake policy does not allow the security type %s the server offers", sectype)
+	}
+	return nil
+}
+
+// refuseHandshake tells the client why it is refused instead of offering it security types, then reports it
+func (fb *RFBConn) refuseHandshake(sectype SecurityType, reason string) {
+	w := NewMessageWriter(5 + len(reason)).Uint8(0) // No security types
+	if fb.version == 3 {
+		w = NewMessageWriter(8 + len(reason)).Uint32(uint32(SecInvalid)) // The invalid security type
+	}
+	msg, err := w.Uint32(uint32(len(reason))).Data([]byte(reason)).Bytes()
+	if err == nil {
+		_, err = fb.Conn.Write(msg)
+	}
+	if err != nil {
+		fb.logf("Error sending the reason the client is refused: %s\n", err.Error())
+	}
+	fb.handshakeRejected(sectype, reason)
+}
+
+// handshakeRejected logs a client refused for its protocol version or security type and calls OnHandshakeRejected
+func (fb *RFBConn) handshakeRejected(sectype SecurityType, reason string) {
+	fb.logf("Client refused: %s\n", reason)
+	if f := fb.Server.OnHandshakeRejected; f != nil {
+		f(fb, HandshakeRejection{Address: fb.Address(), Version: fb.version, Security: sectype, Reason: reason})
+	}
+}
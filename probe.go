@@ -0,0 +1,129 @@
+// gorfb project probe.go
+// Estimating the capacity of the link to a client with a burst of fences right after it enabled them
+package gorfb
+
+import (
+	"bytes"
+	"time"
+)
+
+const (
+	// DefaultBandwidthProbeSize is the size of the probe burst if the server's BandwidthProbeSize is 0
+	DefaultBandwidthProbeSize = 64 << 10
+	// DefaultBandwidthProbeTimeout is how long the responses to the probe are waited for if the server's
+	// BandwidthProbeTimeout is 0
+	DefaultBandwidthProbeTimeout = 5 * time.Second
+)
+
+// probeMarker starts the payload of the fences sent by the probe so their responses can be told apart
+var probeMarker = []byte("gorfb-probe")
+
+// BandwidthEstimate is the result of probing the link to a client
+type BandwidthEstimate struct {
+	// Round trip time of the first fence of the burst
+	RTT time.Duration
+	// Throughput measured over the rest of the burst, it is limited by the slower direction of the link
+	BytesPerSecond int64
+	// The responses to the burst did not all arrive within the timeout, the estimate is based on those that did
+	Partial bool
+}
+
+// bandwidthProbe is a probe in progress
+type bandwidthProbe struct {
+	sent     time.Time
+	count    int       // Fences in the burst
+	received int       // Responses received so far
+	first    time.Time // When the response to the first fence arrived
+	last     time.Time // When the last response arrived
+	timer    *time.Timer
+}
+
+// Bandwidth returns the estimate of the probe, false if the client was not probed (yet)
+func (fb *RFBConn) Bandwidth() (BandwidthEstimate, bool) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.bandwidth, fb.probed
+}
+
+// startProbe sends the client the burst of fences, the responses are counted by probeResponse
+func (fb *RFBConn) startProbe() error {
+	size := fb.Server.BandwidthProbeSize
+	if size == 0 {
+		size = DefaultBandwidthProbeSize
+	}
+	payload := append(append([]byte(nil), probeMarker...), make([]byte, fenceMaxPayload-len(probeMarker))...)
+	msg := len(payload) + 9
+	count := max(size/msg, 2)
+	w := NewMessageWriter(count * msg)
+	for i := 0; i < count; i++ {
+		w.Uint8(uint8(MsgServerFence)).Padding(3).Uint32(FenceRequest).Uint8(uint8(len(payload))).Data(payload)
+	}
+	buf, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	timeout := fb.Server.BandwidthProbeTimeout
+	if timeout == 0 {
+		timeout = DefaultBandwidthProbeTimeout
+	}
+	p := &bandwidthProbe{sent: time.Now(), count: count}
+	fb.mu.Lock()
+	fb.probe = p
+	fb.mu.Unlock()
+	p.timer = time.AfterFunc(timeout, func() { fb.finishProbe(p) })
+	if err := fb.write(buf); err != nil {
+		p.timer.Stop()
+		return err
+	}
+	return nil
+}
+
+// probeResponse counts a fence response that belongs to the probe, false is returned for other responses
+func (fb *RFBConn) probeResponse(payload []byte) bool {
+	if !bytes.HasPrefix(payload, probeMarker) {
+		return false
+	}
+	now := time.Now()
+	fb.mu.Lock()
+	p := fb.probe
+	if p == nil { // Late response to a probe that timed out
+		fb.mu.Unlock()
+		return true
+	}
+	if p.received == 0 {
+		p.first = now
+	}
+	p.received++
+	p.last = now
+	complete := p.received == p.count
+	fb.mu.Unlock()
+	if complete && p.timer.Stop() {
+		fb.finishProbe(p)
+	}
+	return true
+}
+
+// finishProbe works out the estimate once all responses arrived or the probe timed out
+// At least two responses are needed for an estimate
+func (fb *RFBConn) finishProbe(p *bandwidthProbe) {
+	fb.mu.Lock()
+	if fb.probe != p {
+		fb.mu.Unlock()
+		return
+	}
+	fb.probe = nil
+	if p.received < 2 {
+		fb.mu.Unlock()
+		fb.logf("Bandwidth probe timed out\n")
+		return
+	}
+	est := BandwidthEstimate{RTT: p.first.Sub(p.sent), Partial: p.received < p.count}
+	if d := p.last.Sub(p.first); d > 0 {
+		est.BytesPerSecond = int64(float64((p.received-1)*(fenceMaxPayload+9)) / d.Seconds())
+	}
+	fb.bandwidth, fb.probed = est, true
+	fb.mu.Unlock()
+	if fb.Server.OnBandwidthProbe != nil {
+		fb.Server.OnBandwidthProbe(fb, est)
+	}
+}
@@ -0,0 +1,189 @@
+// gorfb project protocol.go
+// Named message types, encodings and security types of the protocol
+package gorfb
+
+import "fmt"
+
+// ClientMessageType is the type of a message sent by a client
+type ClientMessageType uint8
+
+// Client message types
+const (
+	MsgSetPixelFormat           ClientMessageType = 0
+	MsgFixColourMapEntries      ClientMessageType = 1 // Not part of RFB 3.8 but some older clients send it
+	MsgSetEncodings             ClientMessageType = 2
+	MsgFramebufferUpdateRequest ClientMessageType = 3
+	MsgKeyEvent                 ClientMessageType = 4
+	MsgPointerEvent             ClientMessageType = 5
+	MsgClientCutText            ClientMessageType = 6
+	MsgEnableContinuousUpdates  ClientMessageType = 150
+	MsgClientFence              ClientMessageType = 248
+	MsgClientXvp                ClientMessageType = 250
+	MsgClientGII                ClientMessageType = 253
+)
+
+var clientMessageNames = map[ClientMessageType]string{
+	MsgSetPixelFormat:           "SetPixelFormat",
+	MsgFixColourMapEntries:      "FixColourMapEntries",
+	MsgSetEncodings:             "SetEncodings",
+	MsgFramebufferUpdateRequest: "FramebufferUpdateRequest",
+	MsgKeyEvent:                 "KeyEvent",
+	MsgPointerEvent:             "PointerEvent",
+	MsgClientCutText:            "ClientCutText",
+	MsgEnableContinuousUpdates:  "EnableContinuousUpdates",
+	MsgClientFence:              "ClientFence",
+	MsgClientXvp:                "ClientXvp",
+	MsgClientGII:                "ClientGII",
+}
+
+// String returns the name of the message type as the protocol specification has it
+func (t ClientMessageType) String() string {
+	if name, ok := clientMessageNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ClientMessageType(%d)", uint8(t))
+}
+
+// ServerMessageType is the type of a message sent by the server
+type ServerMessageType uint8
+
+// Server message types
+const (
+	MsgFramebufferUpdate      ServerMessageType = 0
+	MsgSetColourMapEntries    ServerMessageType = 1
+	MsgBell                   ServerMessageType = 2
+	MsgServerCutText          ServerMessageType = 3
+	MsgEndOfContinuousUpdates ServerMessageType = 150
+	MsgServerFence            ServerMessageType = 248
+	MsgServerXvp              ServerMessageType = 250
+	MsgServerGII              ServerMessageType = 253
+)
+
+var serverMessageNames = map[ServerMessageType]string{
+	MsgFramebufferUpdate:      "FramebufferUpdate",
+	MsgSetColourMapEntries:    "SetColourMapEntries",
+	MsgBell:                   "Bell",
+	MsgServerCutText:          "ServerCutText",
+	MsgEndOfContinuousUpdates: "EndOfContinuousUpdates",
+	MsgServerFence:            "ServerFence",
+	MsgServerXvp:              "ServerXvp",
+	MsgServerGII:              "ServerGII",
+}
+
+// String returns the name of the message type as the protocol specification has it
+func (t ServerMessageType) String() string {
+	if name, ok := serverMessageNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ServerMessageType(%d)", uint8(t))
+}
+
+// Encoding is an encoding of framebuffer rectangles, or a pseudo-encoding (negative) through which a client
+// announces support for an extension or a setting
+type Encoding int32
+
+// Encodings of rectangles
+const (
+	EncRaw      Encoding = 0
+	EncCopyRect Encoding = 1
+	EncRRE      Encoding = 2
+	EncCoRRE    Encoding = 4
+	EncHextile  Encoding = 5
+	EncZlib     Encoding = 6
+	EncTight    Encoding = 7
+	EncZRLE     Encoding = 16
+)
+
+// Pseudo-encodings
+const (
+	EncQualityLevel0       Encoding = -32 // JPEG quality levels 0 to 9 are -32 to -23
+	EncQualityLevel9       Encoding = -23
+	EncDesktopSize         Encoding = -223
+	EncLastRect            Encoding = -224
+	EncRichCursor          Encoding = -239 // Cursor in the client's pixel format with a bitmask
+	EncXCursor             Encoding = -240 // Two colour cursor
+	EncCompressLevel0      Encoding = -256 // Compression levels 0 to 9 are -256 to -247
+	EncCompressLevel9      Encoding = -247
+	EncGII                 Encoding = -305
+	EncDesktopName         Encoding = -307
+	EncExtendedDesktopSize Encoding = -308
+	EncXvp                 Encoding = -309
+	EncFence               Encoding = -312
+	EncContinuousUpdates   Encoding = -313
+	EncCursorWithAlpha     Encoding = -314        // RGBA cursor with alpha
+	EncExtendedClipboard   Encoding = -1063131698 // 0xC0A1E5CE
+)
+
+var encodingNames = map[Encoding]string{
+	EncRaw:                 "Raw",
+	EncCopyRect:            "CopyRect",
+	EncRRE:                 "RRE",
+	EncCoRRE:               "CoRRE",
+	EncHextile:             "Hextile",
+	EncZlib:                "Zlib",
+	EncTight:               "Tight",
+	EncZRLE:                "ZRLE",
+	EncDesktopSize:         "DesktopSize",
+	EncLastRect:            "LastRect",
+	EncRichCursor:          "RichCursor",
+	EncXCursor:             "XCursor",
+	EncGII:                 "gii",
+	EncDesktopName:         "DesktopName",
+	EncExtendedDesktopSize: "ExtendedDesktopSize",
+	EncXvp:                 "xvp",
+	EncFence:               "Fence",
+	EncContinuousUpdates:   "ContinuousUpdates",
+	EncCursorWithAlpha:     "CursorWithAlpha",
+	EncExtendedClipboard:   "ExtendedClipboard",
+}
+
+// Pseudo reports if the encoding is a pseudo-encoding
+func (e Encoding) Pseudo() bool {
+	return e < 0
+}
+
+// String returns the name of the encoding as the protocol specification has it
+func (e Encoding) String() string {
+	if name, ok := encodingNames[e]; ok {
+		return name
+	}
+	if e >= EncCompressLevel0 && e <= EncCompressLevel9 {
+		return fmt.Sprintf("CompressLevel%d", e-EncCompressLevel0)
+	}
+	if e >= EncQualityLevel0 && e <= EncQualityLevel9 {
+		return fmt.Sprintf("QualityLevel%d", e-EncQualityLevel0)
+	}
+	return fmt.Sprintf("Encoding(%d)", int32(e))
+}
+
+// encodingInts returns encodings as the plain numbers handlers receive them as
+func encodingInts(encodings []Encoding) []int {
+	ints := make([]int, len(encodings))
+	for i, e := range encodings {
+		ints[i] = int(e)
+	}
+	return ints
+}
+
+// SecurityType is a security type offered to clients
+type SecurityType uint8
+
+// Security types
+const (
+	SecInvalid SecurityType = 0
+	SecNone    SecurityType = 1
+	SecVNCAuth SecurityType = 2
+)
+
+// String returns the name of the security type
+func (t SecurityType) String() string {
+	switch t {
+	case SecInvalid:
+		return "Invalid"
+	case SecNone:
+		return "None"
+	case SecVNCAuth:
+		return "VNC Authentication"
+	}
+	return fmt.Sprintf("SecurityType(%d)", uint8(t))
+}
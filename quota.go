@@ -0,0 +1,80 @@
+// gorfb project quota.go
+// Accounting the bytes sent to the clients of each identity over all their sessions, with quotas limiting them
+package gorfb
+
+// DefaultQuotaWarning is the fraction of an identity's byte quota after which OnQuotaWarning is called if the
+// server's QuotaWarning is 0
+const DefaultQuotaWarning = 0.9
+
+// Identity returns the identity the client was given by the server's Identify, empty if it has none
+func (fb *RFBConn) Identity() string {
+	return fb.identity
+}
+
+// identify gives the client its identity once it completed the security handshake and reports if it may continue,
+// clients of an identity that used up its byte quota are refused
+func (fb *RFBConn) identify(resultExpected bool) bool {
+	if fb.Server.Identify == nil {
+		return true
+	}
+	fb.identity = fb.Server.Identify(fb)
+	used, limit := fb.Server.QuotaUsed(fb.identity)
+	if limit <= 0 || used < limit {
+		return true
+	}
+	if resultExpected {
+		fb.sendSecurityResult("Byte quota exceeded")
+	} else {
+		fb.logf("Byte quota of %s exceeded\n", fb.identity)
+	}
+	return false
+}
+
+// QuotaUsed returns the bytes sent to the clients of identity and its byte quota (0 for no limit)
+func (rfb *RFBServer) QuotaUsed(identity string) (used, limit int64) {
+	if identity == "" || rfb.ByteQuota == nil {
+		return 0, 0
+	}
+	rfb.mu.Lock()
+	used = rfb.quotaUsed[identity]
+	rfb.mu.Unlock()
+	return used, rfb.ByteQuota(identity)
+}
+
+// ResetQuota forgets the bytes sent to the clients of identity, for example when a new accounting period starts
+func (rfb *RFBServer) ResetQuota(identity string) {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	delete(rfb.quotaUsed, identity)
+}
+
+// chargeQuota adds size bytes sent to the client to its identity's account, warning once when QuotaWarning of the
+// quota is used and disconnecting the client when all of it is
+func (fb *RFBConn) chargeQuota(size int) {
+	rfb := fb.Server
+	if fb.identity == "" || rfb.ByteQuota == nil {
+		return
+	}
+	rfb.mu.Lock()
+	if rfb.quotaUsed == nil {
+		rfb.quotaUsed = make(map[string]int64)
+	}
+	used := rfb.quotaUsed[fb.identity] + int64(size)
+	rfb.quotaUsed[fb.identity] = used
+	rfb.mu.Unlock()
+	limit := rfb.ByteQuota(fb.identity)
+	if limit <= 0 {
+		return
+	}
+	prev := used - int64(size)
+	warning := rfb.QuotaWarning
+	if warning == 0 {
+		warning = DefaultQuotaWarning
+	}
+	if at := int64(warning * float64(limit)); warning > 0 && prev < at && used >= at && rfb.OnQuotaWarning != nil {
+		go rfb.OnQuotaWarning(fb, fb.identity, used, limit) // The connection's locks may be held
+	}
+	if used >= limit {
+		fb.Close("Byte quota exceeded")
+	}
+}
@@ -0,0 +1,89 @@
+// gorfb project redact.go
+// Filtering the rectangles sent to clients, for example to redact areas of the screen from some of them
+package gorfb
+
+import "image"
+
+// UpdateFilter can change the rectangles of every framebuffer update before they are sent to a client
+type UpdateFilter interface {
+	// FilterRectangles returns the rectangles to send to conn instead of rects
+	// The rectangles are in the client's pixel format and coordinates, their buffers belong to the caller and must
+	// be copied before they are changed
+	FilterRectangles(conn *RFBConn, rects []RFBRectangle) []RFBRectangle
+}
+
+// UpdateFilterFunc is a function used as an UpdateFilter
+type UpdateFilterFunc func(conn *RFBConn, rects []RFBRectangle) []RFBRectangle
+
+// FilterRectangles calls f
+func (f UpdateFilterFunc) FilterRectangles(conn *RFBConn, rects []RFBRectangle) []RFBRectangle {
+	return f(conn, rects)
+}
+
+// Redact blanks the areas (in the handler's coordinates) in every update sent to the client from now on, replacing
+// any areas redacted before. The client is sent the areas that changed. Call it without areas to show everything.
+func (fb *RFBConn) Redact(areas ...image.Rectangle) {
+	fb.mu.Lock()
+	old := fb.redactions
+	fb.redactions = append([]image.Rectangle(nil), areas...)
+	fb.mu.Unlock()
+	var changed Region
+	for _, r := range append(old, areas...) {
+		changed = changed.Add(r)
+	}
+	off := fb.offset()
+	for _, r := range changed {
+		fb.refreshArea(r.Sub(off))
+	}
+}
+
+// Redactions returns the areas (in the handler's coordinates) that are blanked for the client
+func (fb *RFBConn) Redactions() []image.Rectangle {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return append([]image.Rectangle(nil), fb.redactions...)
+}
+
+// filterRectangles blanks the redacted areas of the rectangles (in client coordinates) and applies the server's
+// UpdateFilter
+func (fb *RFBConn) filterRectangles(rects []RFBRectangle) []RFBRectangle {
+	fb.mu.Lock()
+	redactions := fb.redactions
+	fb.mu.Unlock()
+	if len(redactions) > 0 {
+		rects = fb.redactRectangles(rects, redactions)
+	}
+	if f := fb.Server.UpdateFilter; f != nil {
+		rects = f.FilterRectangles(fb, rects)
+	}
+	return rects
+}
+
+// redactRectangles returns the rectangles with the pixels in the areas (in the handler's coordinates) set to 0,
+// black for true color clients
+func (fb *RFBConn) redactRectangles(rects []RFBRectangle, areas []image.Rectangle) []RFBRectangle {
+	off := fb.offset()
+	bpp := fb.PixelFormat().BytesPerPixel()
+	out := make([]RFBRectangle, len(rects))
+	for i, rect := range rects {
+		out[i] = rect
+		rr := rect.Bounds()
+		if len(rect.Buffer) < rect.Width*rect.Height*bpp {
+			continue
+		}
+		for _, a := range areas {
+			isect := rr.Intersect(a.Sub(off))
+			if isect.Empty() {
+				continue
+			}
+			if &out[i].Buffer[0] == &rect.Buffer[0] { // Don't blank the caller's buffer
+				out[i].Buffer = append([]byte(nil), rect.Buffer...)
+			}
+			for y := isect.Min.Y; y < isect.Max.Y; y++ {
+				pos := ((y-rr.Min.Y)*rect.Width + isect.Min.X - rr.Min.X) * bpp
+				clear(out[i].Buffer[pos : pos+isect.Dx()*bpp])
+			}
+		}
+	}
+	return out
+}
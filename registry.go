@@ -0,0 +1,89 @@
+// gorfb project registry.go
+// Registry of the active connections of a server
+package gorfb
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrUnknownConnection is returned when a connection ID does not refer to an active connection
+var ErrUnknownConnection = errors.New("Unknown connection")
+
+// register adds the connection to the server's active connections and assigns its ID
+func (rfb *RFBServer) register(fb *RFBConn) {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	if rfb.conns == nil {
+		rfb.conns = make(map[int]*RFBConn)
+	}
+	rfb.nextID++
+	fb.ID = rfb.nextID
+	rfb.conns[fb.ID] = fb
+	rfb.clientConnected()
+}
+
+// unregister removes the connection from the server's active connections
+func (rfb *RFBServer) unregister(fb *RFBConn) {
+	rfb.mu.Lock()
+	delete(rfb.conns, fb.ID)
+	rfb.mu.Unlock()
+	rfb.clientDisconnected()
+}
+
+// Connections returns the active connections of the server ordered by ID
+func (rfb *RFBServer) Connections() []*RFBConn {
+	rfb.mu.Lock()
+	conns := make([]*RFBConn, 0, len(rfb.conns))
+	for _, fb := range rfb.conns {
+		conns = append(conns, fb)
+	}
+	rfb.mu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	return conns
+}
+
+// Connection returns the active connection with the given ID or nil if there is none
+func (rfb *RFBServer) Connection(id int) *RFBConn {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	return rfb.conns[id]
+}
+
+// Disconnect closes the active connection with the given ID
+// reason is recorded on the connection and logged
+func (rfb *RFBServer) Disconnect(id int, reason string) error {
+	fb := rfb.Connection(id)
+	if fb == nil {
+		return ErrUnknownConnection
+	}
+	fb.Close(reason)
+	return nil
+}
+
+// Close closes the connection with the client, reason is recorded and logged
+// Only the first reason is kept if Close is called more than once
+func (fb *RFBConn) Close(reason string) {
+	fb.mu.Lock()
+	first := fb.closeReason == ""
+	if first {
+		fb.closeReason = reason
+	}
+	fb.mu.Unlock()
+	if first {
+		fb.logf("Closing connection %d from %s: %s\n", fb.ID, fb.peer(), reason)
+	}
+	fb.Conn.Close()
+}
+
+// Done returns a channel that is closed once the connection has ended
+func (fb *RFBConn) Done() <-chan struct{} {
+	return fb.done
+}
+
+// CloseReason returns the reason the connection was closed, empty if it was not closed through Close
+func (fb *RFBConn) CloseReason() string {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.closeReason
+}
@@ -0,0 +1,124 @@
+// gorfb project report.go
+// Per session statistics on what was delivered to the client, reported when the session ends
+package gorfb
+
+import "time"
+
+// SessionReport summarizes what a session delivered to the client, for analyzing the user experience per session
+type SessionReport struct {
+	// How long the session lasted (so far)
+	Duration time.Duration
+	// Bytes of the messages sent to the client after the handshake
+	BytesSent int64
+	// FramebufferUpdates sent and their average rate over the session
+	Updates int
+	FPS     float64
+	// Rectangles sent per encoding, including pseudo-encodings such as the cursor
+	Encodings map[Encoding]int
+	// Encodings and pseudo-encodings the client listed in its last SetEncodings, in its order of preference
+	Advertised []Encoding
+	// How the encodings used for framebuffer contents performed
+	EncodingStats map[Encoding]EncodingStats
+	// Writes that took longer than the FlowControl's StallTimeout and the times the client became congested
+	Stalls      int
+	Congestions int
+	// Result of the bandwidth probe, nil if the client was not probed
+	Bandwidth *BandwidthEstimate
+}
+
+// EncodingStats are the statistics of the framebuffer rectangles sent in an encoding
+type EncodingStats struct {
+	Rectangles int
+	Pixels     int64
+	// Bytes the pixels take in the client's pixel format and the bytes they were sent as, without the rectangle
+	// headers
+	RawBytes     int64
+	EncodedBytes int64
+}
+
+// Ratio returns how many times smaller the encoded data is than the pixels, 0 if nothing was sent
+func (s EncodingStats) Ratio() float64 {
+	if s.EncodedBytes == 0 {
+		return 0
+	}
+	return float64(s.RawBytes) / float64(s.EncodedBytes)
+}
+
+// sessionCounters are the statistics counted for the report, guarded by fb.mu
+type sessionCounters struct {
+	bytesSent     int64
+	updates       int
+	encodings     map[Encoding]int
+	encodingStats map[Encoding]EncodingStats
+}
+
+// Report returns the statistics of the session so far
+func (fb *RFBConn) Report() SessionReport {
+	return fb.report(time.Now())
+}
+
+// report returns the statistics of the session with its duration up to end
+func (fb *RFBConn) report(end time.Time) SessionReport {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	r := SessionReport{BytesSent: fb.counters.bytesSent, Updates: fb.counters.updates, Encodings: map[Encoding]int{},
+		Stalls: fb.flow.stalls, Congestions: fb.flow.congestions}
+	if !fb.started.IsZero() {
+		r.Duration = end.Sub(fb.started)
+	}
+	if r.Duration > 0 {
+		r.FPS = float64(r.Updates) / r.Duration.Seconds()
+	}
+	for enc, n := range fb.counters.encodings {
+		r.Encodings[enc] = n
+	}
+	r.Advertised = append([]Encoding(nil), fb.encodings...)
+	r.EncodingStats = make(map[Encoding]EncodingStats, len(fb.counters.encodingStats))
+	for enc, st := range fb.counters.encodingStats {
+		r.EncodingStats[enc] = st
+	}
+	if fb.probed {
+		est := fb.bandwidth
+		r.Bandwidth = &est
+	}
+	return r
+}
+
+// countSent adds a message of size bytes that was written to the client to the statistics
+func (fb *RFBConn) countSent(size int) {
+	fb.mu.Lock()
+	fb.counters.bytesSent += int64(size)
+	fb.mu.Unlock()
+	fb.chargeQuota(size)
+}
+
+// countUpdate adds a FramebufferUpdate with rectangles in the given encodings to the statistics
+func (fb *RFBConn) countUpdate(encodings ...Encoding) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if fb.counters.encodings == nil {
+		fb.counters.encodings = make(map[Encoding]int)
+	}
+	fb.counters.updates++
+	for _, enc := range encodings {
+		fb.counters.encodings[enc]++
+	}
+}
+
+// countEncoded adds the encoded framebuffer rectangles of an update to the statistics, bpp is the number of bytes
+// per pixel of the client's pixel format
+func (fb *RFBConn) countEncoded(rects []encodedRect, bpp int) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if fb.counters.encodingStats == nil {
+		fb.counters.encodingStats = make(map[Encoding]EncodingStats)
+	}
+	for _, er := range rects {
+		st := fb.counters.encodingStats[er.encoding]
+		st.Rectangles++
+		st.Pixels += int64(er.width * er.height)
+		st.RawBytes += int64(er.width * er.height * bpp)
+		st.EncodedBytes += int64(len(er.data))
+		fb.counters.encodingStats[er.encoding] = st
+	}
+}
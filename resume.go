@@ -0,0 +1,134 @@
+// gorfb project resume.go
+// Resuming the session state of clients that reconnect shortly after losing their connection
+package gorfb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"image"
+	"time"
+)
+
+// resumeState is the session state kept for a client that may reconnect
+type resumeState struct {
+	screenName string
+	viewOnly   bool
+	// The pixel format and encodings the client negotiated, the resumed session continues with them
+	pixelFormat PixelFormat
+	encodings   []Encoding
+	expires     time.Time
+}
+
+// ResumeSecret returns the secret with which the client can resume its session within the server's ResumeWindow,
+// empty if resuming is disabled
+// The secret is issued once the client completed the handshake. The application hands it to the client out of
+// band, and the server's ResumeToken returns it when the client presents it again.
+func (fb *RFBConn) ResumeSecret() string {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.resumeSecret
+}
+
+// issueResumeSecret gives the client a new random secret for resuming its session
+func (fb *RFBConn) issueResumeSecret() {
+	if fb.Server.ResumeWindow <= 0 {
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		fb.logf("Error generating resume secret: %s\n", err.Error())
+		return
+	}
+	fb.mu.Lock()
+	fb.resumeSecret = hex.EncodeToString(buf)
+	fb.mu.Unlock()
+}
+
+// Resumed reports if the connection resumed the state of an earlier session
+func (fb *RFBConn) Resumed() bool {
+	return fb.resumed != nil
+}
+
+// lookupResume finds the state kept for the client if it presented the secret of a session that ended within the
+// server's ResumeWindow, a secret can only be used once
+func (fb *RFBConn) lookupResume() {
+	rfb := fb.Server
+	if rfb.ResumeWindow <= 0 || rfb.ResumeToken == nil {
+		return
+	}
+	token := rfb.ResumeToken(fb)
+	if token == "" {
+		return
+	}
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	state, ok := rfb.resumable[token]
+	if !ok {
+		return
+	}
+	delete(rfb.resumable, token)
+	if time.Now().Before(state.expires) {
+		fb.resumed = state
+	}
+}
+
+// saveResume keeps the session state of the client under its resume secret so that it can be resumed within the
+// server's ResumeWindow
+// Sessions the server closed (disconnected, timed out, migrated) are not kept, only those that ended because the
+// connection was lost.
+func (fb *RFBConn) saveResume() {
+	rfb := fb.Server
+	if rfb.ResumeWindow <= 0 {
+		return
+	}
+	fb.mu.Lock()
+	token := fb.resumeSecret
+	closed := fb.closeReason != "" && !fb.connLost
+	state := &resumeState{screenName: fb.Screen.Name, viewOnly: fb.viewOnly, pixelFormat: fb.pixelFormat,
+		encodings: append([]Encoding(nil), fb.encodings...), expires: time.Now().Add(rfb.ResumeWindow)}
+	fb.mu.Unlock()
+	if token == "" || closed {
+		return
+	}
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	if rfb.resumable == nil {
+		rfb.resumable = make(map[string]*resumeState)
+	}
+	now := time.Now()
+	for tok, st := range rfb.resumable { // Drop the states that can no longer be resumed
+		if now.After(st.expires) {
+			delete(rfb.resumable, tok)
+		}
+	}
+	rfb.resumable[token] = state
+}
+
+// restoreResume restores the resumed state on the connection
+// The client continues with the pixel format and encodings it negotiated before, without sending them again, and
+// is sent a full refresh straight away since whatever it showed is out of date.
+func (fb *RFBConn) restoreResume() {
+	state := fb.resumed
+	if state == nil {
+		return
+	}
+	pf, encodings := state.pixelFormat, state.encodings
+	fb.mu.Lock()
+	fb.viewOnly = state.viewOnly
+	fb.pixelFormat = pf
+	fb.encodings = encodings
+	fb.mu.Unlock()
+	fb.dispatch(updateQueue, func() { fb.CurrentScreen().Handler.ProcessSetPixelFormat(fb, pf) })
+	if len(encodings) > 0 {
+		fb.dispatch(updateQueue, func() { fb.CurrentScreen().Handler.ProcessSetEncoding(fb, encodingInts(encodings)) })
+		if err := fb.enableExtensions(encodings); err != nil {
+			fb.logf("%s\n", err.Error())
+		}
+		fb.becomeReady()
+	}
+	screen := fb.CurrentScreen()
+	width, height := screen.Width, screen.Height
+	if dispatch, _ := fb.updateRequested(image.Rect(0, 0, width, height), false); dispatch {
+		fb.dispatch(updateQueue, func() { fb.requestUpdate(0, 0, width, height, false) })
+	}
+}
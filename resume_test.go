@@ -0,0 +1,83 @@
+package gorfb_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+func TestResumeKeepsNegotiatedState(t *testing.T) {
+	rfb, h := rfbtest.NewServer(32, 16)
+	rfb.Logf = func(format string, args ...interface{}) {}
+	rfb.ResumeWindow = time.Minute
+	var mu sync.Mutex
+	token := ""
+	rfb.ResumeToken = func(conn *gorfb.RFBConn) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return token
+	}
+	ln := rfbtest.Serve(rfb)
+	defer ln.Close()
+	c, err := rfbtest.Connect(ln, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call, err := h.Next(5 * time.Second); err != nil || call.Method != "Init" {
+		t.Fatalf("First call %v (%v)", call, err)
+	}
+	conn := rfb.Connections()[0]
+	pf := rfbtest.PixelFormat
+	pf.RedShift, pf.BlueShift = pf.BlueShift, pf.RedShift // Same size, so the client parses updates alike
+	if err := c.SendSetPixelFormat(pf); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendSetEncodings(gorfb.EncHextile, gorfb.EncRaw); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		call, err := h.Next(5 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if call.Method == "ProcessSetEncoding" {
+			break
+		}
+	}
+	mu.Lock()
+	token = conn.ResumeSecret()
+	mu.Unlock()
+	if token == "" {
+		t.Fatal("No resume secret issued")
+	}
+	c.Close() // The connection is lost
+	select {
+	case <-conn.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection not ended")
+	}
+
+	c, err = rfbtest.Connect(ln, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// The full refresh is sent without the client asking for it or sending its encodings again
+	msg, err := c.Expect(gorfb.MsgFramebufferUpdate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Rectangles) == 0 || msg.Rectangles[0].Encoding != gorfb.EncHextile {
+		t.Errorf("Resumed session sent %v instead of Hextile", msg.Rectangles)
+	}
+	conns := rfb.Connections()
+	if len(conns) != 1 || !conns[0].Resumed() {
+		t.Fatal("Session not resumed")
+	}
+	if got := conns[0].PixelFormat(); got != pf {
+		t.Errorf("Resumed session uses pixel format %+v instead of %+v", got, pf)
+	}
+}
@@ -0,0 +1,64 @@
+// gorfb project rfbtest/client.go
+// The client API that moved to package client, kept here for a release so that existing tests keep compiling
+package rfbtest
+
+import (
+	"context"
+	"net"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/client"
+)
+
+// Server message types
+//
+// Deprecated: use the ServerMessageType constants of gorfb.
+const (
+	FramebufferUpdate   = gorfb.MsgFramebufferUpdate
+	SetColourMapEntries = gorfb.MsgSetColourMapEntries
+	Bell                = gorfb.MsgBell
+	ServerCutText       = gorfb.MsgServerCutText
+	EndOfContinuous     = gorfb.MsgEndOfContinuousUpdates
+	ServerFence         = gorfb.MsgServerFence
+	ServerXvp           = gorfb.MsgServerXvp
+	ServerGII           = gorfb.MsgServerGII
+)
+
+// Client is an RFB client
+//
+// Deprecated: use client.Client.
+type Client = client.Client
+
+// Rectangle is a rectangle of a FramebufferUpdate
+//
+// Deprecated: use client.Rectangle.
+type Rectangle = client.Rectangle
+
+// Message is a message received from the server
+//
+// Deprecated: use client.Message.
+type Message = client.Message
+
+// Change is an area of the framebuffer the server sent in a FramebufferUpdate
+//
+// Deprecated: use client.Change.
+type Change = client.Change
+
+// Viewer keeps an image of the server's framebuffer
+//
+// Deprecated: use client.Viewer.
+type Viewer = client.Viewer
+
+// NewClient returns a client on conn, Handshake must be called first
+//
+// Deprecated: use client.NewClient.
+func NewClient(conn net.Conn) *Client {
+	return client.NewClient(conn)
+}
+
+// NewViewer takes over the messages of c and keeps the viewer's image up to date until ctx is done
+//
+// Deprecated: use client.NewViewer.
+func NewViewer(ctx context.Context, c *Client) (*Viewer, error) {
+	return client.NewViewer(ctx, c)
+}
@@ -0,0 +1,184 @@
+package rfbtest_test
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/client"
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+// serve starts serving rfb without logging and closes the listener when the test ends
+func serve(t *testing.T, rfb *gorfb.RFBServer) *rfbtest.Listener {
+	t.Helper()
+	rfb.Logf = func(format string, args ...interface{}) {}
+	ln := rfbtest.Serve(rfb)
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// connect connects a client to ln and closes it when the test ends
+func connect(t *testing.T, ln *rfbtest.Listener, password string) *client.Client {
+	t.Helper()
+	c, err := rfbtest.Connect(ln, true, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// expectCall waits for a call of method to the handler, skipping other calls
+func expectCall(t *testing.T, h *rfbtest.Handler, method string) rfbtest.Call {
+	t.Helper()
+	for {
+		call, err := h.Next(5 * time.Second)
+		if err != nil {
+			t.Fatalf("Waiting for %s: %s", method, err.Error())
+		}
+		if call.Method == method {
+			return call
+		}
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	rfb, h := rfbtest.NewServer(64, 32)
+	c := connect(t, serve(t, rfb), "")
+	if c.Width != 64 || c.Height != 32 || c.Name != "rfbtest" || c.PixelFormat != rfbtest.PixelFormat {
+		t.Errorf("ServerInit gave %dx%d %q %+v", c.Width, c.Height, c.Name, c.PixelFormat)
+	}
+	expectCall(t, h, "Init")
+}
+
+func TestAuthentication(t *testing.T) {
+	rfb, _ := rfbtest.NewServer(16, 16)
+	rfb.Authenticate, rfb.AuthText = true, "secret"
+	ln := serve(t, rfb)
+	connect(t, ln, "secret")
+	if c, err := rfbtest.Connect(ln, true, "wrong"); err == nil {
+		c.Close()
+		t.Error("Connected with the wrong password")
+	}
+}
+
+func TestUpdateRequest(t *testing.T) {
+	rfb, h := rfbtest.NewServer(20, 10)
+	c := connect(t, serve(t, rfb), "")
+	rects, err := c.RequestUpdate(2, 3, 5, 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rects) != 1 {
+		t.Fatalf("Received %d rectangles", len(rects))
+	}
+	r := rects[0]
+	if r.X != 2 || r.Y != 3 || r.Width != 5 || r.Height != 4 || r.Encoding != gorfb.EncRaw {
+		t.Errorf("Received rectangle %d,%d %dx%d encoded with %s", r.X, r.Y, r.Width, r.Height, r.Encoding)
+	}
+	if !bytes.Equal(r.Data, h.Region(2, 3, 5, 4)) {
+		t.Error("Received pixels differ from the framebuffer")
+	}
+	call := expectCall(t, h, "ProcessUpdateRequest")
+	if call.String() != "ProcessUpdateRequest(2, 3, 5, 4, false)" {
+		t.Errorf("Handler called with %s", call)
+	}
+}
+
+func TestInputReachesHandler(t *testing.T) {
+	rfb, h := rfbtest.NewServer(20, 10)
+	c := connect(t, serve(t, rfb), "")
+	if err := c.SendSetEncodings(gorfb.EncZRLE, gorfb.EncRaw); err != nil {
+		t.Fatal(err)
+	}
+	if call := expectCall(t, h, "ProcessSetEncoding"); call.String() != "ProcessSetEncoding([ZRLE Raw])" {
+		t.Errorf("Handler called with %s", call)
+	}
+	if err := c.SendKey(0x61, true); err != nil {
+		t.Fatal(err)
+	}
+	if call := expectCall(t, h, "ProcessKeyEvent"); call.String() != "ProcessKeyEvent(97, true)" {
+		t.Errorf("Handler called with %s", call)
+	}
+	if err := c.SendPointer(7, 8, 1); err != nil {
+		t.Fatal(err)
+	}
+	if call := expectCall(t, h, "ProcessPointerEvent"); call.String() != "ProcessPointerEvent(7, 8, 1)" {
+		t.Errorf("Handler called with %s", call)
+	}
+	if err := c.SendCutText("café"); err != nil {
+		t.Fatal(err)
+	}
+	if call := expectCall(t, h, "ProcessCutText"); call.String() != "ProcessCutText(café)" {
+		t.Errorf("Handler called with %s", call)
+	}
+}
+
+func TestServerMessages(t *testing.T) {
+	rfb, h := rfbtest.NewServer(16, 16)
+	c := connect(t, serve(t, rfb), "")
+	if err := c.SendSetEncodings(gorfb.EncRaw); err != nil { // Messages are held back until the client sent its encodings
+		t.Fatal(err)
+	}
+	expectCall(t, h, "ProcessSetEncoding")
+	rfb.BroadcastBell()
+	if _, err := c.Expect(gorfb.MsgBell); err != nil {
+		t.Fatal(err)
+	}
+	rfb.BroadcastCutText("hello")
+	msg, err := c.Expect(gorfb.MsgServerCutText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "hello" {
+		t.Errorf("Received cut text %q", msg.Text)
+	}
+}
+
+func TestCancelledCutTextClosesConnection(t *testing.T) {
+	rfb, h := rfbtest.NewServer(16, 16)
+	rfb.CutTextChunkSize = 16
+	rfb.CutTextProgress = func(conn *gorfb.RFBConn, fromClient bool, done, total int) bool { return fromClient }
+	c := connect(t, serve(t, rfb), "")
+	if err := c.SendSetEncodings(gorfb.EncRaw); err != nil {
+		t.Fatal(err)
+	}
+	expectCall(t, h, "ProcessSetEncoding")
+	rfb.BroadcastCutText(strings.Repeat("x", 100))
+	for {
+		msg, err := c.Next()
+		if err != nil {
+			break
+		}
+		if msg.Type == gorfb.MsgServerCutText {
+			t.Fatalf("Received cut text %q from a cancelled transfer", msg.Text)
+		}
+	}
+}
+
+func TestRefreshAnsweredOnRequest(t *testing.T) {
+	rfb, h := rfbtest.NewServer(20, 10)
+	c := connect(t, serve(t, rfb), "")
+	if _, err := c.RequestUpdate(0, 0, 20, 10, false); err != nil {
+		t.Fatal(err)
+	}
+	expectCall(t, h, "ProcessUpdateRequest")
+	rfb.Connections()[0].Redact(image.Rect(2, 2, 6, 6))
+	if err := c.NoMessage(200 * time.Millisecond); err != nil {
+		t.Fatalf("Refresh sent without a request: %s", err.Error())
+	}
+	rects, err := c.RequestUpdate(0, 0, 20, 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rects) != 1 || rects[0].X != 2 || rects[0].Y != 2 || rects[0].Width != 4 || rects[0].Height != 4 {
+		t.Fatalf("Received %+v", rects)
+	}
+	if call := expectCall(t, h, "ProcessUpdateRequest"); call.String() != "ProcessUpdateRequest(2, 2, 4, 4, false)" {
+		t.Errorf("Handler called with %s", call)
+	}
+}
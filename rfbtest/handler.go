@@ -0,0 +1,132 @@
+// gorfb project rfbtest/handler.go
+// Handler that serves a fixed framebuffer and records the calls made to it
+package rfbtest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// PixelFormat is the pixel format of the servers returned by NewServer
+var PixelFormat = gorfb.PixelFormat{BitsPerPixel: 32, Depth: 24, BigEndian: 0, TrueColor: 1,
+	RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+
+// Call is a call made to the handler, Args holds the arguments following the connection
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// String returns the call in the form Method(arg, arg)
+func (c Call) String() string {
+	s := c.Method + "("
+	for i, arg := range c.Args {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprint(arg)
+	}
+	return s + ")"
+}
+
+// Handler is a gorfb.RFBServerHandler serving Buffer and sending every call made to it to Calls
+// Update requests are answered with the requested part of Buffer
+type Handler struct {
+	Width, Height int
+	PixelFormat   gorfb.PixelFormat
+	// Pixels of the framebuffer in PixelFormat
+	Buffer []byte
+	Calls  chan Call
+}
+
+// NewHandler returns a handler for a width x height framebuffer in PixelFormat, filled with a gradient
+func NewHandler(width, height int) *Handler {
+	h := &Handler{Width: width, Height: height, PixelFormat: PixelFormat, Buffer: make([]byte, width*height*4),
+		Calls: make(chan Call, 256)}
+	for i := 0; i < width*height; i++ {
+		h.Buffer[i*4], h.Buffer[i*4+1], h.Buffer[i*4+2], h.Buffer[i*4+3] = byte(i), byte(i>>8), byte(i>>16), 0
+	}
+	return h
+}
+
+// NewServer returns a server (not yet serving) for a new width x height Handler
+func NewServer(width, height int) (*gorfb.RFBServer, *Handler) {
+	h := NewHandler(width, height)
+	return &gorfb.RFBServer{Width: width, Height: height, PixelFormat: h.PixelFormat, BufferName: "rfbtest",
+		Handler: h}, h
+}
+
+// record sends a call to Calls, the call is dropped if nobody reads them
+func (h *Handler) record(method string, args ...interface{}) {
+	select {
+	case h.Calls <- Call{Method: method, Args: args}:
+	default:
+	}
+}
+
+// Next returns the next call made to the handler, waiting up to timeout
+func (h *Handler) Next(timeout time.Duration) (Call, error) {
+	select {
+	case call := <-h.Calls:
+		return call, nil
+	case <-time.After(timeout):
+		return Call{}, errors.New("Timeout waiting for a handler call")
+	}
+}
+
+// Region returns the pixels of an area of Buffer
+func (h *Handler) Region(x, y, width, height int) []byte {
+	bpp := h.PixelFormat.BytesPerPixel()
+	buf := make([]byte, 0, width*height*bpp)
+	for row := y; row < y+height; row++ {
+		pos := (row*h.Width + x) * bpp
+		buf = append(buf, h.Buffer[pos:pos+width*bpp]...)
+	}
+	return buf
+}
+
+// Init records the call
+func (h *Handler) Init(conn *gorfb.RFBConn) {
+	h.record("Init")
+}
+
+// ProcessSetPixelFormat records the call
+func (h *Handler) ProcessSetPixelFormat(conn *gorfb.RFBConn, pf gorfb.PixelFormat) {
+	h.record("ProcessSetPixelFormat", pf)
+}
+
+// ProcessSetEncoding records the call, with the encodings as Encoding values so that they print by name
+func (h *Handler) ProcessSetEncoding(conn *gorfb.RFBConn, encodings []int) {
+	typed := make([]gorfb.Encoding, len(encodings))
+	for i, e := range encodings {
+		typed[i] = gorfb.Encoding(e)
+	}
+	h.record("ProcessSetEncoding", typed)
+}
+
+// ProcessUpdateRequest records the call and sends the requested area of Buffer
+func (h *Handler) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	h.record("ProcessUpdateRequest", x, y, width, height, incremental)
+	x, y = min(max(x, 0), h.Width), min(max(y, 0), h.Height)
+	width, height = min(width, h.Width-x), min(height, h.Height-y)
+	conn.SendRectangles([]gorfb.RFBRectangle{{X: x, Y: y, Width: width, Height: height,
+		Buffer: h.Region(x, y, width, height)}})
+}
+
+// ProcessKeyEvent records the call
+func (h *Handler) ProcessKeyEvent(conn *gorfb.RFBConn, key int, downflag bool) {
+	h.record("ProcessKeyEvent", key, downflag)
+}
+
+// ProcessPointerEvent records the call
+func (h *Handler) ProcessPointerEvent(conn *gorfb.RFBConn, x, y, button int) {
+	h.record("ProcessPointerEvent", x, y, button)
+}
+
+// ProcessCutText records the call
+func (h *Handler) ProcessCutText(conn *gorfb.RFBConn, text string) {
+	h.record("ProcessCutText", text)
+}
@@ -0,0 +1,101 @@
+// gorfb project rfbtest/pipe.go
+// Package rfbtest helps testing gorfb servers and handlers without network sockets
+//
+// A Listener hands out in memory connections (net.Pipe) to a server started with Serve, Connect performs the
+// handshake on such a connection with a client of package client, and Handler is a handler serving a fixed
+// framebuffer that records every call made to it.
+// Transcripts of client messages can be replayed against a server and its output compared with golden files.
+// Tiles and Vectors are a corpus of reference input and encoder output for checking encoders.
+package rfbtest
+
+import (
+	"net"
+	"sync"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/client"
+)
+
+// pipeAddr is the address of the in memory connections
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// Listener is a net.Listener for in memory connections made with Dial
+type Listener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	err   error // Why the server stopped serving
+}
+
+// NewListener returns a listener that is ready to accept connections
+func NewListener() *Listener {
+	return &Listener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// Accept waits for the next connection made with Dial
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener, Accept returns net.ErrClosed
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the address of the listener
+func (l *Listener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// Dial connects to the listener and returns the client side of the connection
+func (l *Listener) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		client.Close()
+		server.Close()
+		if l.err != nil {
+			return nil, l.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// Serve starts serving rfb on a new in memory listener and returns the listener
+// The server stops when the listener is closed, if it fails to start Dial returns the error
+func Serve(rfb *gorfb.RFBServer) *Listener {
+	ln := NewListener()
+	go func() {
+		if err := rfb.Serve(ln); err != nil {
+			ln.err = err
+			ln.Close()
+		}
+	}()
+	return ln
+}
+
+// Connect dials the listener and performs the handshake
+// An empty password selects no authentication
+func Connect(ln *Listener, shared bool, password string) (*client.Client, error) {
+	conn, err := ln.Dial()
+	if err != nil {
+		return nil, err
+	}
+	c := client.NewClient(conn)
+	if err := c.Handshake(shared, password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,262 @@
+// gorfb project rfbtest/references.go
+// Rectangles encoded the way other implementations send them, for checking decoders
+package rfbtest
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/client"
+)
+
+// Reference is a rectangle in one of the variants of an encoding and the pixels it decodes to
+// Data was assembled byte by byte from the specification named in Source, not produced by gorfb's encoders, so a
+// decoder that passes has the layout of the variant right. Zlib data is compressed with compress/zlib, which any
+// inflater accepts the same as the output of the reference implementations.
+type Reference struct {
+	Name     string
+	Source   string
+	Tile     Tile
+	Encoding gorfb.Encoding
+	// The rectangle data following the rectangle header, in the tile's pixel format
+	Data []byte
+	// How much a colour component may be off after decoding (for JPEG)
+	Tolerance int
+}
+
+// Pixels of the colours used in the references: in PixelFormat (blue, green, red and padding), as ZRLE CPIXELs
+// (the 3 least significant bytes) and as Tight TPIXELs (red, green, blue)
+var (
+	refColours = map[byte]uint32{'K': 0x000000, 'R': 0xff0000, 'G': 0x00ff00, 'B': 0x0000ff, 'W': 0xffffff}
+	pixK       = []byte{0, 0, 0, 0}
+	pixR       = []byte{0, 0, 0xff, 0}
+	pixG       = []byte{0, 0xff, 0, 0}
+	pixB       = []byte{0xff, 0, 0, 0}
+	pixW       = []byte{0xff, 0xff, 0xff, 0}
+	cpixK      = []byte{0, 0, 0}
+	cpixR      = []byte{0, 0, 0xff}
+	cpixG      = []byte{0, 0xff, 0}
+	cpixB      = []byte{0xff, 0, 0}
+	cpixW      = []byte{0xff, 0xff, 0xff}
+)
+
+// grid returns a tile of the colours K, R, G, B and W given as one string per row
+func grid(name string, rows ...string) Tile {
+	return tile(name, len(rows[0]), len(rows), func(x, y int) uint32 { return refColours[rows[y][x]] })
+}
+
+// join concatenates byte slices
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// deflate compresses data as a complete zlib stream
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// zrleData returns the ZRLE rectangle data for the tiles as they are before compression
+func zrleData(tiles ...[]byte) []byte {
+	z := deflate(join(tiles...))
+	return join([]byte{byte(len(z) >> 24), byte(len(z) >> 16), byte(len(z) >> 8), byte(len(z))}, z)
+}
+
+// tightCompressed returns the compact length and compressed data of Tight basic compression
+func tightCompressed(data []byte) []byte {
+	z := deflate(data)
+	n := len(z)
+	switch {
+	case n < 0x80:
+		return join([]byte{byte(n)}, z)
+	case n < 0x4000:
+		return join([]byte{byte(n) | 0x80, byte(n >> 7)}, z)
+	}
+	return join([]byte{byte(n) | 0x80, byte(n>>7) | 0x80, byte(n >> 14)}, z)
+}
+
+// References returns the reference rectangles of an encoding: Hextile, ZRLE or Tight
+func References(encoding gorfb.Encoding) []Reference {
+	var refs []Reference
+	add := func(t Tile, source string, data ...[]byte) {
+		refs = append(refs, Reference{Name: t.Name, Source: source, Tile: t, Encoding: encoding, Data: join(data...)})
+	}
+	switch encoding {
+	case gorfb.EncHextile: // Sub-encoding mask, then the tile's fields in the order of the mask bits
+		t := grid("hextile-raw", "KRGB", "WKRG")
+		add(t, specHextile+", Raw sub-encoding", []byte{1}, t.Pixels)
+		add(grid("hextile-background", "RRRR", "RRRR", "RRRR", "RRRR"), specHextile+", BackgroundSpecified",
+			[]byte{2}, pixR)
+		add(tile("hextile-background-kept", 20, 4, func(x, y int) uint32 { return 0xff0000 }),
+			specHextile+", a tile without BackgroundSpecified keeps the previous tile's background",
+			[]byte{2}, pixR, []byte{0})
+		add(grid("hextile-foreground", "KKKKKKKK", "KKGGGKKK", "KKGGGKKK", "KKKKKKKK", "KKKKKKKK", "KKKKKGGK",
+			"KKKKKGGK", "KKKKKKKK"),
+			specHextile+", BackgroundSpecified, ForegroundSpecified and AnySubrects with x<<4|y and (w-1)<<4|(h-1)",
+			[]byte{2 | 4 | 8}, pixK, pixG, []byte{2, 0x21, 0x21, 0x55, 0x11})
+		add(grid("hextile-coloured", "RRWWWWWW", "RRWWWWWW", "WWWWWWWW", "WWWWWWWW", "WWWWBBBB", "WWWWBBBB",
+			"WWWWBBBB", "WWWWBBBB"),
+			specHextile+", BackgroundSpecified, AnySubrects and SubrectsColoured, a pixel before every subrectangle",
+			[]byte{2 | 8 | 16}, pixW, []byte{2}, pixR, []byte{0x00, 0x11}, pixB, []byte{0x44, 0x33})
+		add(tile("hextile-foreground-kept", 32, 1, func(x, y int) uint32 { return []uint32{0, 0x00ff00}[x%16/8] }),
+			specHextile+", the second tile keeps the background and foreground of the first",
+			[]byte{2 | 4 | 8}, pixK, pixG, []byte{1, 0x80, 0x70}, []byte{8}, []byte{1, 0x80, 0x70})
+	case gorfb.EncZRLE: // 64x64 tiles of a sub-encoding byte, the tiles are compressed as one zlib stream
+		add(grid("zrle-raw", "KR", "GB"), specZRLE+", raw CPIXELs (sub-encoding 0)",
+			zrleData([]byte{0}, cpixK, cpixR, cpixG, cpixB))
+		add(grid("zrle-solid", "GGGG", "GGGG", "GGGG", "GGGG"), specZRLE+", solid tile (sub-encoding 1)",
+			zrleData([]byte{1}, cpixG))
+		add(grid("zrle-packed-2", "KWWK", "WWKK"), specZRLE+", packed palette of 2 colours, 1 bit per pixel and rows "+
+			"padded to a byte", zrleData([]byte{2}, cpixK, cpixW, []byte{0x60, 0xc0}))
+		add(grid("zrle-packed-3", "RGB", "BBR"), specZRLE+", packed palette of 3 colours, 2 bits per pixel",
+			zrleData([]byte{3}, cpixR, cpixG, cpixB, []byte{0x18, 0xa0}))
+		add(grid("zrle-plain-rle", "RRRR", "RWWW"), specZRLE+", plain RLE (sub-encoding 128), run lengths minus 1",
+			zrleData([]byte{128}, cpixR, []byte{4}, cpixW, []byte{2}))
+		add(tile("zrle-long-run", 16, 20, func(x, y int) uint32 {
+			if y*16+x < 300 {
+				return 0xff0000
+			}
+			return 0x00ff00
+		}), specZRLE+", plain RLE with a run longer than 256 (bytes of 255 continue the length)",
+			zrleData([]byte{128}, cpixR, []byte{255, 44}, cpixG, []byte{19}))
+		add(tile("zrle-palette-rle", 16, 2, func(x, y int) uint32 {
+			if y*16+x == 20 {
+				return 0xff0000
+			}
+			return 0
+		}),
+			specZRLE+", palette RLE (sub-encoding 130), runs of 1 are the bare palette index",
+			zrleData([]byte{130}, cpixK, cpixR, []byte{0x80, 19, 0x01, 0x80, 10}))
+		add(tile("zrle-two-tiles", 65, 1, func(x, y int) uint32 { return []uint32{0xff0000, 0x00ff00}[x/64] }),
+			specZRLE+", a 64x1 tile followed by a 1x1 tile", zrleData([]byte{1}, cpixR, []byte{1}, cpixG))
+	case gorfb.EncTight: // Compression control byte, then TPIXELs (red, green, blue) and filter data
+		add(grid("tight-fill", "BBBB", "BBBB", "BBBB", "BBBB"), specTight+", fill compression", []byte{0x80, 0, 0, 0xff})
+		add(grid("tight-copy-short", "KR"), specTight+", copy filter, data under 12 bytes is not compressed",
+			[]byte{0x00}, []byte{0, 0, 0, 0xff, 0, 0})
+		t := grid("tight-copy", "KRGB", "WKRG")
+		add(t, specTight+", copy filter on stream 0 reset by the control byte",
+			[]byte{0x01}, tightCompressed([]byte{0, 0, 0, 0xff, 0, 0, 0, 0xff, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0,
+				0xff, 0, 0, 0, 0xff, 0}))
+		add(grid("tight-mono", "WWKKWWKKWW", "KKKKKKKKKK"),
+			specTight+", palette filter with 2 colours, 1 bit per pixel and rows padded to a byte",
+			[]byte{0x40, 1, 1, 0xff, 0xff, 0xff, 0, 0, 0, 0x33, 0x00, 0xff, 0xc0})
+		add(grid("tight-palette", "RGBR", "GBRG", "BRGB", "RGBR"),
+			specTight+", palette filter with 3 colours on stream 1, a byte per pixel",
+			[]byte{0x50, 1, 2, 0xff, 0, 0, 0, 0xff, 0, 0, 0, 0xff},
+			tightCompressed([]byte{0, 1, 2, 0, 1, 2, 0, 1, 2, 0, 1, 2, 0, 1, 2, 0}))
+		gradient := tile("tight-gradient", 4, 4, func(x, y int) uint32 {
+			r := 40 * x
+			if x == 2 && y == 2 {
+				r += 50
+			}
+			return uint32(r)<<16 | uint32(30*y)<<8 | uint32(10*(x+y))
+		})
+		// Every component minus left + above - above left (clamped to 0-255), with 0 outside the rectangle
+		add(gradient, specTight+", gradient filter on stream 2",
+			[]byte{0x60, 2}, tightCompressed([]byte{
+				0x00, 0x00, 0x00, 0x28, 0x00, 0x0a, 0x28, 0x00, 0x0a, 0x28, 0x00, 0x0a,
+				0x00, 0x1e, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x1e, 0x0a, 0x00, 0x00, 0x00, 0x32, 0x00, 0x00, 0xce, 0x00, 0x00,
+				0x00, 0x1e, 0x0a, 0x00, 0x00, 0x00, 0xce, 0x00, 0x00, 0x32, 0x00, 0x00}))
+		photo := tile("tight-jpeg", 16, 16, func(x, y int) uint32 { return uint32(x*16)<<16 | uint32(y*16)<<8 | 0x80 })
+		refs = append(refs, Reference{Name: photo.Name, Source: specTight + ", JPEG compression (image/jpeg, quality 95)",
+			Tile: photo, Encoding: encoding, Data: join([]byte{0x90}, jpegData(photo)), Tolerance: 16})
+	}
+	return refs
+}
+
+// jpegData returns the compact length and JPEG image of the tile
+func jpegData(t Tile) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, t.Width, t.Height))
+	for i := 0; i < t.Width*t.Height; i++ {
+		p := t.Pixels[i*4:]
+		img.SetRGBA(i%t.Width, i/t.Width, color.RGBA{R: p[2], G: p[1], B: p[0], A: 255})
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+	n := buf.Len()
+	return join([]byte{byte(n) | 0x80, byte(n >> 7)}, buf.Bytes())
+}
+
+// Check decodes the reference with a client and compares the pixels with the tile
+// The rectangle is sent by a fake server as the first update of a new connection, so zlib streams start afresh.
+func (ref Reference) Check() error {
+	server, conn := net.Pipe()
+	defer server.Close()
+	go ref.serve(server)
+	c := client.NewClient(conn)
+	defer c.Close()
+	if err := c.Handshake(true, ""); err != nil {
+		return err
+	}
+	msg, err := c.Expect(gorfb.MsgFramebufferUpdate)
+	if err != nil {
+		return fmt.Errorf("%s: %s", ref.Name, err.Error())
+	}
+	if len(msg.Rectangles) != 1 {
+		return fmt.Errorf("%s: %d rectangles", ref.Name, len(msg.Rectangles))
+	}
+	pix, err := msg.Rectangles[0].Pixels(ref.Tile.PixelFormat)
+	if err != nil {
+		return fmt.Errorf("%s (%s): %s", ref.Name, ref.Source, err.Error())
+	}
+	for i := range pix {
+		if d := int(pix[i]) - int(ref.Tile.Pixels[i]); d > ref.Tolerance || -d > ref.Tolerance {
+			p := i / 4
+			return fmt.Errorf("%s (%s): pixel %d,%d decoded as %x instead of %x", ref.Name, ref.Source,
+				p%ref.Tile.Width, p/ref.Tile.Width, pix[p*4:p*4+4], ref.Tile.Pixels[p*4:p*4+4])
+		}
+	}
+	return nil
+}
+
+// serve plays an RFB 3.8 server without authentication that sends the reference as its only update
+// It gives up as soon as the client fails, Check reports why.
+func (ref Reference) serve(conn net.Conn) error {
+	buf := make([]byte, 12)
+	if _, err := conn.Write([]byte(gorfb.PROTOCOL)); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:12]); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte{1, byte(gorfb.SecNone)}); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil { // Shared flag
+		return err
+	}
+	t := ref.Tile
+	pf := t.PixelFormat
+	init, err := gorfb.NewMessageWriter(24 + len(t.Name)).Uint16(uint16(t.Width)).Uint16(uint16(t.Height)).
+		Uint8(pf.BitsPerPixel).Uint8(pf.Depth).Uint8(pf.BigEndian).Uint8(pf.TrueColor).
+		Uint16(pf.RedMax).Uint16(pf.GreenMax).Uint16(pf.BlueMax).Uint8(pf.RedShift).Uint8(pf.GreenShift).
+		Uint8(pf.BlueShift).Padding(3).Uint32(uint32(len(t.Name))).Data([]byte(t.Name)).Bytes()
+	if err != nil {
+		return err
+	}
+	update, err := gorfb.NewMessageWriter(16 + len(ref.Data)).Uint8(uint8(gorfb.MsgFramebufferUpdate)).Padding(1).
+		Uint16(1).Uint16(0).Uint16(0).Uint16(uint16(t.Width)).Uint16(uint16(t.Height)).Int32(int32(ref.Encoding)).
+		Data(ref.Data).Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(join(init, update))
+	return err
+}
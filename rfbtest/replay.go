@@ -0,0 +1,147 @@
+// gorfb project rfbtest/replay.go
+// Replaying transcripts of client messages and comparing the server's output with golden files
+package rfbtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hduplooy/gorfb/client"
+)
+
+// Transcript is the client messages of a session (after the handshake), every entry is sent as one write
+type Transcript [][]byte
+
+// ReadTranscript reads a transcript in its text form: every line holds one client message as hex bytes,
+// spaces within a line are ignored and lines starting with # are comments
+func ReadTranscript(r io.Reader) (Transcript, error) {
+	var t Transcript
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		msg, err := hex.DecodeString(strings.Join(strings.Fields(line), ""))
+		if err != nil {
+			return nil, fmt.Errorf("Transcript line %d: %s", n, err.Error())
+		}
+		t = append(t, msg)
+	}
+	return t, scanner.Err()
+}
+
+// LoadTranscript reads a transcript from a file
+func LoadTranscript(path string) (Transcript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTranscript(f)
+}
+
+// WriteTo writes the transcript in its text form
+func (t Transcript) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	for _, msg := range t {
+		buf.WriteString(hex.EncodeToString(msg))
+		buf.WriteByte('\n')
+	}
+	return buf.WriteTo(w)
+}
+
+// Replay connects to ln without authentication and sends the messages of the transcript one by one
+// After every message the server's output is collected until it has been quiet for settle (50ms if 0),
+// the output following each message is returned
+func Replay(ln *Listener, t Transcript, settle time.Duration) ([][]byte, error) {
+	if settle <= 0 {
+		settle = 50 * time.Millisecond
+	}
+	c, err := Connect(ln, true, "")
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	c.Timeout = settle
+	if _, err := collect(c); err != nil { // Anything the handler sends on Init
+		return nil, err
+	}
+	out := make([][]byte, len(t))
+	for i, msg := range t {
+		if err := c.Send(msg); err != nil {
+			return out, fmt.Errorf("Sending message %d: %s", i+1, err.Error())
+		}
+		if out[i], err = collect(c); err != nil {
+			return out, fmt.Errorf("After message %d: %s", i+1, err.Error())
+		}
+	}
+	return out, nil
+}
+
+// collect returns the bytes of all messages received until none arrives for the client's Timeout
+func collect(c *client.Client) ([]byte, error) {
+	var out []byte
+	for {
+		msg, err := c.Next()
+		if errors.Is(err, client.ErrTimeout) {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(append(out, byte(msg.Type)), msg.Raw...)
+	}
+}
+
+// formatOutput returns the output of a replay in the text form used for golden files
+func formatOutput(out [][]byte) []byte {
+	var buf bytes.Buffer
+	for i, step := range out {
+		fmt.Fprintf(&buf, "# message %d\n", i+1)
+		for len(step) > 0 {
+			n := min(len(step), 32)
+			buf.WriteString(hex.EncodeToString(step[:n]))
+			buf.WriteByte('\n')
+			step = step[n:]
+		}
+	}
+	return buf.Bytes()
+}
+
+// CompareGolden compares the output of a replay with the golden file at path
+// If update is set the golden file is written instead
+func CompareGolden(path string, out [][]byte, update bool) error {
+	text := formatOutput(out)
+	if update {
+		return os.WriteFile(path, text, 0644)
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(golden, text) {
+		return nil
+	}
+	want, got := strings.Split(string(golden), "\n"), strings.Split(string(text), "\n")
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			return fmt.Errorf("Output differs from %s at line %d:\nwant %s\ngot  %s", path, i+1, w, g)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// gorfb project rfbtest/stress.go
+// Concurrency stress test with many synthetic clients, meant to be run under the race detector
+package rfbtest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/client"
+)
+
+// StressOptions configures a stress run
+type StressOptions struct {
+	// Number of concurrent clients (10 if not set)
+	Clients int
+	// How long the clients keep sending (2 seconds if not set)
+	Duration time.Duration
+	// Chance (0 to 1) of a client disconnecting and reconnecting after every message
+	DisconnectRate float64
+	// Seed of the random message selection
+	Seed int64
+}
+
+// StressStats counts what happened during a stress run
+type StressStats struct {
+	Connects int64
+	Sent     int64
+	Updates  int64
+	Received int64
+}
+
+// Stress has synthetic clients connect to rfb (served on ln) and send a random mix of update requests, input,
+// cut text and encoding changes while disconnecting and reconnecting, while the server concurrently broadcasts
+// and disconnects clients itself.
+// Pixel format changes are not sent, the client could not tell which of the updates in flight use the new format.
+// It fails when the server sends a message the client cannot parse or when connections are still registered
+// with the server after all clients have gone.
+func Stress(ln *Listener, rfb *gorfb.RFBServer, opts StressOptions) (StressStats, error) {
+	if opts.Clients <= 0 {
+		opts.Clients = 10
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = 2 * time.Second
+	}
+	var stats StressStats
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	fail := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	deadline := time.Now().Add(opts.Duration)
+	for i := 0; i < opts.Clients; i++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				if err := stressClient(ln, rnd, deadline, opts.DisconnectRate, &stats); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}(rand.New(rand.NewSource(opts.Seed + int64(i))))
+	}
+	wg.Add(1)
+	go func() { // The server side
+		defer wg.Done()
+		rnd := rand.New(rand.NewSource(opts.Seed - 1))
+		for time.Now().Before(deadline) {
+			switch rnd.Intn(4) {
+			case 0:
+				rfb.BroadcastBell()
+			case 1:
+				rfb.BroadcastCutText("stress")
+			case 2:
+				rfb.BroadcastRectangles([]gorfb.RFBRectangle{{X: 0, Y: 0, Width: 1, Height: 1,
+					Buffer: make([]byte, rfb.PixelFormat.BytesPerPixel())}})
+			case 3:
+				if conns := rfb.Connections(); len(conns) > 0 && rnd.Intn(10) == 0 {
+					rfb.Disconnect(conns[rnd.Intn(len(conns))].ID, "Stress test")
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	wg.Wait()
+	for end := time.Now().Add(5 * time.Second); len(rfb.Connections()) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(end) {
+			fail(fmt.Errorf("%d connections still registered after all clients closed", len(rfb.Connections())))
+			break
+		}
+	}
+	return stats, errors.Join(errs...)
+}
+
+// stressClient connects and sends random messages until the deadline or a random disconnect
+func stressClient(ln *Listener, rnd *rand.Rand, deadline time.Time, disconnectRate float64, stats *StressStats) error {
+	c, err := Connect(ln, true, "")
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&stats.Connects, 1)
+	defer c.Close()
+	done := make(chan error, 1)
+	go func() { // Drain the server's messages
+		for {
+			msg, err := c.Next()
+			if errors.Is(err, client.ErrTimeout) {
+				continue
+			}
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+					done <- nil
+				} else {
+					done <- err
+				}
+				return
+			}
+			atomic.AddInt64(&stats.Received, 1)
+			if msg.Type == gorfb.MsgFramebufferUpdate {
+				atomic.AddInt64(&stats.Updates, 1)
+			}
+		}
+	}()
+	for time.Now().Before(deadline) && rnd.Float64() >= disconnectRate {
+		var err error
+		switch rnd.Intn(6) {
+		case 0:
+			err = c.SendUpdateRequest(rnd.Intn(c.Width), rnd.Intn(c.Height), rnd.Intn(c.Width)+1, rnd.Intn(c.Height)+1, rnd.Intn(2) == 0)
+		case 1:
+			err = c.SendKey(0x20+rnd.Intn(0x5f), rnd.Intn(2) == 0)
+		case 2:
+			err = c.SendPointer(rnd.Intn(c.Width), rnd.Intn(c.Height), rnd.Intn(8))
+		case 3:
+			err = c.SendCutText("stress test")
+		case 4:
+			err = c.SendSetEncodings(gorfb.EncRaw, gorfb.EncGII)
+		case 5:
+			time.Sleep(time.Millisecond)
+		}
+		if err != nil { // The server closed the connection
+			break
+		}
+		atomic.AddInt64(&stats.Sent, 1)
+	}
+	c.Close()
+	return <-done
+}
@@ -0,0 +1,25 @@
+package rfbtest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+// TestStress is meant to be run with the race detector: go test -race ./rfbtest
+func TestStress(t *testing.T) {
+	duration := 2 * time.Second
+	if testing.Short() {
+		duration = 300 * time.Millisecond
+	}
+	rfb, _ := rfbtest.NewServer(32, 32)
+	ln := serve(t, rfb)
+	stats, err := rfbtest.Stress(ln, rfb, rfbtest.StressOptions{Clients: 8, Duration: duration, DisconnectRate: 0.01, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Connects < 8 || stats.Sent == 0 || stats.Updates == 0 {
+		t.Errorf("Too little happened: %+v", stats)
+	}
+}
@@ -0,0 +1,141 @@
+package rfbtest_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/client"
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+// tileHandler answers every update request with the current tile at 0,0
+type tileHandler struct {
+	gorfb.BaseHandler
+	mu     sync.Mutex
+	vector rfbtest.Vector
+}
+
+func (h *tileHandler) set(v rfbtest.Vector) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.vector = v
+}
+
+func (h *tileHandler) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	h.mu.Lock()
+	v := h.vector
+	h.mu.Unlock()
+	rect := gorfb.RFBRectangle{Width: v.Tile.Width, Height: v.Tile.Height, Buffer: v.Tile.Pixels}
+	if v.Encoding == gorfb.EncCopyRect {
+		rect = gorfb.RFBRectangle{Width: v.Tile.Width, Height: v.Tile.Height, Encoding: gorfb.EncCopyRect,
+			ForceEncoding: true, SrcX: v.SrcX, SrcY: v.SrcY}
+	}
+	conn.SendRectangles([]gorfb.RFBRectangle{rect})
+}
+
+// serveTiles starts a server with a tileHandler and connects a client asking for encoding
+func serveTiles(t *testing.T, encoding gorfb.Encoding) (*client.Client, *tileHandler) {
+	t.Helper()
+	h := &tileHandler{}
+	ln := rfbtest.Serve(&gorfb.RFBServer{Width: 400, Height: 100, PixelFormat: rfbtest.PixelFormat, BufferName: "tiles",
+		Handler: h})
+	t.Cleanup(func() { ln.Close() })
+	c, err := rfbtest.Connect(ln, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if err := c.SendSetEncodings(encoding); err != nil {
+		t.Fatal(err)
+	}
+	return c, h
+}
+
+// update requests an update and returns its rectangles, skipping other messages
+func update(t *testing.T, c *client.Client) []client.Rectangle {
+	t.Helper()
+	if err := c.SendUpdateRequest(0, 0, c.Width, c.Height, false); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg, err := c.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == gorfb.MsgFramebufferUpdate {
+			return msg.Rectangles
+		}
+	}
+}
+
+func TestEncodersMatchVectors(t *testing.T) {
+	for _, enc := range []gorfb.Encoding{gorfb.EncRaw, gorfb.EncCopyRect, gorfb.EncCoRRE, gorfb.EncHextile, gorfb.EncZlib,
+		gorfb.EncTight, gorfb.EncZRLE} {
+		vs := rfbtest.Vectors(enc)
+		if len(vs) == 0 {
+			t.Errorf("No vectors for encoding %s", enc)
+			continue
+		}
+		c, h := serveTiles(t, enc)
+		for _, v := range vs {
+			h.set(v)
+			rects := update(t, c)
+			if len(rects) != 1 {
+				t.Errorf("Tile %s with encoding %s: received %d rectangles", v.Tile.Name, enc, len(rects))
+				continue
+			}
+			if err := v.Check(rects[0]); err != nil {
+				t.Errorf("Encoding %s: %s", enc, err.Error())
+			}
+		}
+	}
+}
+
+func TestEncodersRoundTrip(t *testing.T) {
+	for _, enc := range []gorfb.Encoding{gorfb.EncRaw, gorfb.EncCoRRE, gorfb.EncHextile, gorfb.EncZlib, gorfb.EncTight,
+		gorfb.EncZRLE} {
+		c, h := serveTiles(t, enc)
+		for _, tile := range rfbtest.Tiles() {
+			h.set(rfbtest.Vector{Tile: tile, Encoding: enc})
+			out := make([]byte, len(tile.Pixels))
+			for _, r := range update(t, c) {
+				pix, err := r.Pixels(tile.PixelFormat)
+				if err != nil {
+					t.Fatalf("Tile %s with encoding %s: %s", tile.Name, enc, err.Error())
+				}
+				for y := 0; y < r.Height; y++ {
+					copy(out[((r.Y+y)*tile.Width+r.X)*4:], pix[y*r.Width*4:(y+1)*r.Width*4])
+				}
+			}
+			if !bytes.Equal(out, tile.Pixels) {
+				t.Errorf("Tile %s with encoding %s: decoded pixels differ", tile.Name, enc)
+			}
+		}
+	}
+}
+
+func TestDecodersMatchReferences(t *testing.T) {
+	for _, enc := range []gorfb.Encoding{gorfb.EncHextile, gorfb.EncZRLE, gorfb.EncTight} {
+		refs := rfbtest.References(enc)
+		if len(refs) == 0 {
+			t.Errorf("No references for encoding %s", enc)
+		}
+		for _, ref := range refs {
+			if err := ref.Check(); err != nil {
+				t.Errorf("Encoding %s: %s", enc, err.Error())
+			}
+		}
+	}
+}
+
+func TestCheckEncoder(t *testing.T) {
+	raw := func(tile rfbtest.Tile) ([]byte, error) { return tile.Pixels, nil }
+	if err := rfbtest.CheckEncoder(gorfb.EncRaw, raw); err != nil {
+		t.Error(err)
+	}
+	if err := rfbtest.CheckEncoder(gorfb.EncZRLE, raw); err == nil {
+		t.Error("Raw pixels passed as ZRLE")
+	}
+}
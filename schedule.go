@@ -0,0 +1,124 @@
+// gorfb project schedule.go
+// Fair scheduling of broadcast updates among clients with different link speeds
+package gorfb
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// DefaultBroadcastWorkers is the number of clients sent to at the same time with FairBroadcast
+	DefaultBroadcastWorkers = 4
+	// DefaultBroadcastBudget is the number of bytes of pixel data a client is sent per turn with FairBroadcast
+	DefaultBroadcastBudget = 256 << 10
+)
+
+// scheduler queues broadcast updates per client and sends them in turns, round-robin, a turn sending at most
+// the budget to the client. Updates queued for a client that has not received the earlier ones yet are combined
+// with them, dropping the rectangles they cover, so slow clients get fewer but fresh frames.
+type scheduler struct {
+	rfb     *RFBServer
+	mu      sync.Mutex
+	pending map[*RFBConn][]RFBRectangle // Rectangles waiting to be sent per client
+	ready   []*RFBConn                  // Clients waiting for their turn, in order
+	sending map[*RFBConn]bool           // Clients being sent to
+	workers int                         // Number of workers sending
+}
+
+// broadcastScheduler returns the server's scheduler, it is created on first use
+func (rfb *RFBServer) broadcastScheduler() *scheduler {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	if rfb.scheduler == nil {
+		rfb.scheduler = &scheduler{rfb: rfb, pending: make(map[*RFBConn][]RFBRectangle), sending: make(map[*RFBConn]bool)}
+	}
+	return rfb.scheduler
+}
+
+// queue adds rectangles (in the client's pixel format) to be sent to fb
+func (s *scheduler) queue(fb *RFBConn, rects []RFBRectangle) {
+	var covered Region
+	for _, rect := range rects {
+		if rect.copyRect() { // Copies what was sent before, so nothing may be dropped
+			covered = nil
+			break
+		}
+		covered = covered.Add(rect.Bounds())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, waiting := s.pending[fb]
+	kept := old[:0:0]
+	for _, rect := range old { // Drop what the new rectangles paint over completely
+		rest := Region{rect.Bounds()}
+		for _, r := range covered {
+			rest = rest.Subtract(r)
+		}
+		if !rest.Empty() {
+			kept = append(kept, rect)
+		}
+	}
+	s.pending[fb] = append(kept, rects...)
+	if !waiting && !s.sending[fb] {
+		s.ready = append(s.ready, fb)
+	}
+	workers := s.rfb.BroadcastWorkers
+	if workers <= 0 {
+		workers = DefaultBroadcastWorkers
+	}
+	if s.workers < workers && len(s.ready) > 0 {
+		s.workers++
+		go s.work()
+	}
+}
+
+// work sends to the clients in turn until none are waiting
+func (s *scheduler) work() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.ready) > 0 {
+		fb := s.ready[0]
+		s.ready = s.ready[1:]
+		rects := s.take(fb)
+		s.sending[fb] = true
+		s.mu.Unlock()
+		err := fb.sendRectangles(context.Background(), rects)
+		s.mu.Lock()
+		delete(s.sending, fb)
+		if err != nil {
+			s.rfb.logf("Error sending broadcast update: %s\n", err.Error())
+		}
+		select {
+		case <-fb.done: // Nothing more is sent to a closed connection
+			delete(s.pending, fb)
+			continue
+		default:
+		}
+		if _, ok := s.pending[fb]; ok { // More was queued or left over, wait for the next turn
+			s.ready = append(s.ready, fb)
+		}
+	}
+	s.workers--
+}
+
+// take removes the rectangles for a single turn from the client's pending rectangles, s.mu must be held
+// At least one rectangle is taken even if it is larger than the budget
+func (s *scheduler) take(fb *RFBConn) []RFBRectangle {
+	budget := s.rfb.BroadcastBudget
+	if budget <= 0 {
+		budget = DefaultBroadcastBudget
+	}
+	rects := s.pending[fb]
+	n, size := 0, 0
+	for n < len(rects) && (n == 0 || size+len(rects[n].Buffer) <= budget) {
+		size += len(rects[n].Buffer)
+		n++
+	}
+	if n == len(rects) {
+		delete(s.pending, fb)
+	} else {
+		s.pending[fb] = rects[n:]
+	}
+	return rects[:n]
+}
@@ -0,0 +1,106 @@
+// gorfb project screen.go
+// Named virtual screens so that one server can serve several framebuffers
+package gorfb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownScreen is returned when a screen name does not refer to one of the server's screens
+var ErrUnknownScreen = errors.New("Unknown screen")
+
+// Screen is a framebuffer with the handler that serves it
+// The server's own Width, Height, PixelFormat, BufferName and Handler make up the default screen (with an empty name)
+type Screen struct {
+	// Name of the screen (the key in the server's Screens)
+	Name string
+	// Pixel Width and Height of the framebuffer
+	Width, Height int
+	PixelFormat   PixelFormat
+	// Layout of the handler's buffers, if set it replaces the PixelFormat
+	Layout     PixelLayout
+	BufferName string
+	// The handler that will handle client requests for this screen
+	Handler RFBServerHandler
+	// If Viewport is set only that part of the handler's framebuffer is served, Width and Height are taken from it
+	Viewport *Viewport
+}
+
+// defaultScreen returns the screen made up by the server's own framebuffer and handler
+func (rfb *RFBServer) defaultScreen() *Screen {
+	screen := &Screen{Width: rfb.Width, Height: rfb.Height, PixelFormat: rfb.PixelFormat, Layout: rfb.Layout,
+		BufferName: rfb.BufferName, Handler: rfb.Handler}
+	if screen.Layout != LayoutCustom {
+		screen.PixelFormat = screen.Layout.PixelFormat()
+	}
+	return screen
+}
+
+// validate checks that the screen can be served
+func (screen *Screen) validate() error {
+	if screen.Layout != LayoutCustom {
+		screen.PixelFormat = screen.Layout.PixelFormat()
+	}
+	if screen.Viewport != nil {
+		sz := screen.Viewport.Rect().Size()
+		screen.Width, screen.Height = sz.X, sz.Y
+	}
+	if screen.Width <= 0 || screen.Height <= 0 {
+		return errors.New("Width and Height must be provided in RFBServer and they must be positive values!")
+	}
+	if screen.Handler == nil {
+		return errors.New("A handler must be provided!")
+	}
+	pf := screen.PixelFormat
+	if pf.BitsPerPixel != 8 && pf.BitsPerPixel != 16 && pf.BitsPerPixel != 24 && pf.BitsPerPixel != 32 {
+		return errors.New("Only 8, 16, 24 and 32 bits per pixel allowed")
+	}
+	if pf.TrueColor == 1 {
+		if pf.RedMax == 0 || pf.GreenMax == 0 || pf.BlueMax == 0 {
+			return errors.New("Provide maximum values for red, green and blue in the PixelFormat structure")
+		}
+		if pf.RedShift == pf.GreenShift || pf.RedShift == pf.BlueShift || pf.GreenShift == pf.BlueShift {
+			return errors.New("None of the shifts can be the same!")
+		}
+	}
+	return nil
+}
+
+// ServeScreen waits for connections on port and attaches all of them to the named screen
+// With ports 5900+n this serves a screen as display number n
+func (rfb *RFBServer) ServeScreen(port, name string) error {
+	if err := rfb.validate(); err != nil {
+		return err
+	}
+	if _, ok := rfb.screen(name); !ok {
+		return ErrUnknownScreen
+	}
+	ln, err := rfb.listen(port)
+	if err != nil {
+		return fmt.Errorf("Error listening on port %s: %s", port, err.Error())
+	}
+	return rfb.serve(ln, name)
+}
+
+// attachScreen attaches the connection to the screen selected by its listener or the server's SelectScreen
+// false is returned if the selected screen does not exist
+func (fb *RFBConn) attachScreen() bool {
+	name := fb.screenName
+	if name == "" && fb.resumed != nil {
+		name = fb.resumed.screenName
+	} else if name == "" && fb.Server.SelectScreen != nil {
+		name = fb.Server.SelectScreen(fb)
+	}
+	if name == "" {
+		fb.Screen = fb.Server.defaultScreen()
+		return true
+	}
+	screen, ok := fb.Server.screen(name)
+	if !ok {
+		fb.logf("Client selected unknown screen %q\n", name)
+		return false
+	}
+	fb.Screen = screen
+	return true
+}
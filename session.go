@@ -0,0 +1,50 @@
+// gorfb project session.go
+// Session lifetime and disconnect notification
+package gorfb
+
+import "time"
+
+// DisconnectInfo describes why and when a session ended, it is passed to the OnDisconnect hook
+type DisconnectInfo struct {
+	// Reason the connection was closed
+	Reason string
+	// Address of the client and its host name if ReverseDNS resolved it
+	Address, Hostname string
+	// What the session delivered to the client
+	Report SessionReport
+	// When the session started (after the handshake) and ended
+	Started, Ended time.Time
+}
+
+// Started returns when the session started, that is when the handshake with the client completed
+func (fb *RFBConn) Started() time.Time {
+	return fb.started
+}
+
+// enforceMaxSession disconnects the client once the session is older than the server's MaxSessionDuration
+func (fb *RFBConn) enforceMaxSession() {
+	if fb.Server.MaxSessionDuration <= 0 {
+		return
+	}
+	timer := time.AfterFunc(fb.Server.MaxSessionDuration, func() {
+		fb.Close("Maximum session duration reached")
+	})
+	go func() {
+		<-fb.done
+		timer.Stop()
+	}()
+}
+
+// ended calls the OnDisconnect hook once the session has ended
+func (fb *RFBConn) ended() {
+	if fb.Server.OnDisconnect == nil {
+		return
+	}
+	reason := fb.CloseReason()
+	if reason == "" {
+		reason = "Connection closed"
+	}
+	ended := time.Now()
+	fb.Server.OnDisconnect(fb, DisconnectInfo{Reason: reason, Address: fb.Address(), Hostname: fb.Hostname(),
+		Started: fb.started, Ended: ended, Report: fb.report(ended)})
+}
@@ -0,0 +1,71 @@
+// gorfb project share.go
+// Policies for sharing the session between multiple clients
+package gorfb
+
+// SharePolicy determines how the shared flag sent by a client in ClientInit is treated
+type SharePolicy int
+
+const (
+	// ShareHonorClient uses the flag as sent by the client
+	ShareHonorClient SharePolicy = iota
+	// ShareAlways treats every client as shared
+	ShareAlways
+	// ShareNever treats every client as exclusive
+	ShareNever
+)
+
+// ExclusivePolicy determines what happens when an exclusive client connects while other clients are connected to the
+// same screen
+type ExclusivePolicy int
+
+const (
+	// ExclusiveDisconnectExisting disconnects the existing clients of the screen
+	ExclusiveDisconnectExisting ExclusivePolicy = iota
+	// ExclusiveRefuse refuses the new client
+	ExclusiveRefuse
+	// ExclusiveCoexist lets the new client in next to the existing clients, as if it asked to share
+	ExclusiveCoexist
+)
+
+// Shared reports if the client shares the session with other clients
+func (fb *RFBConn) Shared() bool {
+	return fb.shared
+}
+
+// applySharePolicy applies the server's share policies to a new client that sent the shared flag
+// Only the clients of the screen the client is attached to are affected, false is returned if the client is refused
+func (fb *RFBConn) applySharePolicy(shared bool) bool {
+	switch fb.Server.SharePolicy {
+	case ShareAlways:
+		shared = true
+	case ShareNever:
+		shared = false
+	}
+	fb.shared = shared
+	if shared {
+		return true
+	}
+	var others []*RFBConn
+	name := fb.CurrentScreen().Name
+	for _, other := range fb.Server.Connections() {
+		if other.CurrentScreen().Name == name {
+			others = append(others, other)
+		}
+	}
+	if len(others) == 0 {
+		return true
+	}
+	switch fb.Server.ExclusivePolicy {
+	case ExclusiveRefuse:
+		fb.logf("Exclusive client refused, %d other clients are connected to its screen\n", len(others))
+		return false
+	case ExclusiveCoexist:
+		fb.logf("Exclusive client shares its screen with %d other clients\n", len(others))
+		fb.shared = true
+		return true
+	}
+	for _, other := range others {
+		other.Close("Exclusive client connected")
+	}
+	return true
+}
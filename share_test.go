@@ -0,0 +1,19 @@
+package gorfb
+
+import "testing"
+
+func TestExclusiveCoexist(t *testing.T) {
+	rfb := &RFBServer{ExclusivePolicy: ExclusiveCoexist, Logf: func(format string, args ...interface{}) {}}
+	existing := attached(rfb, "")
+	rfb.register(existing)
+	fb := attached(rfb, "")
+	if !fb.applySharePolicy(false) {
+		t.Fatal("Exclusive client refused")
+	}
+	if !fb.Shared() {
+		t.Error("Coexisting client not reported as shared")
+	}
+	if existing.closeReason != "" {
+		t.Errorf("Existing client closed: %s", existing.closeReason)
+	}
+}
@@ -0,0 +1,124 @@
+// gorfb project source.go
+// Switching the screen behind running sessions, for example from a "connecting..." splash to the real capture backend
+package gorfb
+
+import (
+	"context"
+	"errors"
+	"image"
+)
+
+// ErrResizeUnsupported is returned when a client would have to be resized but does not support the DesktopSize
+// pseudo-encoding
+var ErrResizeUnsupported = errors.New("Client does not support resizing")
+
+// RFBLeaveHandler can be implemented by a RFBServerHandler that keeps state per connection
+type RFBLeaveHandler interface {
+	// Handle a connection switching to another screen, the handler is not called for it anymore
+	// conn is the RFB connection with the client
+	ProcessLeave(conn *RFBConn)
+}
+
+// CurrentScreen returns the screen the client is attached to, SetScreen may switch it at any time
+func (fb *RFBConn) CurrentScreen() *Screen {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.Screen
+}
+
+// screenBounds returns the framebuffer area of the client's screen
+func (fb *RFBConn) screenBounds() image.Rectangle {
+	screen := fb.CurrentScreen()
+	return image.Rect(0, 0, screen.Width, screen.Height)
+}
+
+// screen returns the named screen of the server
+func (rfb *RFBServer) screen(name string) (*Screen, bool) {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	screen, ok := rfb.Screens[name]
+	return screen, ok
+}
+
+// ReplaceScreen replaces the named screen by screen, new clients are attached to it and the connected clients of
+// the old screen are switched to it with SetScreen. The error of every client that could not be switched is returned.
+func (rfb *RFBServer) ReplaceScreen(name string, screen *Screen) error {
+	if err := screen.validate(); err != nil {
+		return err
+	}
+	screen.Name = name
+	rfb.mu.Lock()
+	_, ok := rfb.Screens[name]
+	if ok {
+		rfb.Screens[name] = screen
+	}
+	rfb.mu.Unlock()
+	if !ok {
+		return ErrUnknownScreen
+	}
+	var errs []error
+	for _, fb := range rfb.Connections() {
+		if current := fb.CurrentScreen(); current.Name == name && current != screen { // Clients that connected meanwhile have it already
+			if err := fb.SetScreen(screen); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SetScreen switches the connection to another screen without the client reconnecting
+// The old screen's handler is told with RFBLeaveHandler, the new one is initialized for the connection and told
+// the client's pixel format and encodings. If the size differs the client is resized, which needs support for the
+// DesktopSize pseudo-encoding, and then it is sent the whole framebuffer of the new screen.
+func (fb *RFBConn) SetScreen(screen *Screen) error {
+	if err := screen.validate(); err != nil {
+		return err
+	}
+	if !fb.Initialized() {
+		return ErrNotInitialized
+	}
+	old := fb.CurrentScreen()
+	resize := screen.Width != old.Width || screen.Height != old.Height
+	if resize && !fb.Supports(EncDesktopSize) {
+		return ErrResizeUnsupported
+	}
+	var msg []byte
+	if resize {
+		w := NewMessageWriter(16).Uint8(uint8(MsgFramebufferUpdate)).Padding(1).Uint16(1) // A FramebufferUpdate with a single rectangle
+		w.Uint16(0).Uint16(0).Uint16(uint16(screen.Width)).Uint16(uint16(screen.Height))
+		var err error
+		if msg, err = w.Int32(int32(EncDesktopSize)).Bytes(); err != nil {
+			return err
+		}
+	}
+	unlock, err := fb.lockWrite(context.Background(), len(msg)) // No update of the old screen is sent halfway
+	if err != nil {
+		return err
+	}
+	fb.mu.Lock()
+	fb.Screen = screen
+	pf := fb.pixelFormat
+	encodings := append([]Encoding(nil), fb.encodings...)
+	fb.mu.Unlock()
+	if resize {
+		_, err = fb.Conn.Write(msg)
+	}
+	unlock()
+	if err != nil {
+		return fb.writeFailed(err)
+	}
+	if resize {
+		fb.countUpdate(EncDesktopSize)
+	}
+	if lh, ok := old.Handler.(RFBLeaveHandler); ok {
+		lh.ProcessLeave(fb)
+	}
+	screen.Handler.Init(fb)
+	fb.dispatch(updateQueue, func() {
+		screen.Handler.ProcessSetPixelFormat(fb, pf)
+		screen.Handler.ProcessSetEncoding(fb, encodingInts(encodings))
+	})
+	fb.refreshArea(image.Rect(0, 0, screen.Width, screen.Height))
+	return nil
+}
@@ -0,0 +1,62 @@
+package gorfb_test
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+func TestSetScreenWhileUpdating(t *testing.T) {
+	rfb, h := rfbtest.NewServer(16, 16)
+	rfb.Logf = func(format string, args ...interface{}) {}
+	rfb.Dispatch = gorfb.DispatchSplit // Input and updates are handled on goroutines of their own
+	rfb.DamageHints = true
+	ln := rfbtest.Serve(rfb)
+	defer ln.Close()
+	c, err := rfbtest.Connect(ln, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if call, err := h.Next(5 * time.Second); err != nil || call.Method != "Init" {
+		t.Fatalf("First call %v (%v)", call, err)
+	}
+	conn := rfb.Connections()[0]
+	screens := []*gorfb.Screen{
+		{Width: 16, Height: 16, PixelFormat: rfbtest.PixelFormat, BufferName: "one", Handler: rfbtest.NewHandler(16, 16)},
+		{Width: 16, Height: 16, PixelFormat: rfbtest.PixelFormat, BufferName: "two", Handler: rfbtest.NewHandler(16, 16)},
+	}
+	done := make(chan error, 2)
+	go func() { // The handler marks changes while the screen is switched
+		for i := 0; i < 200; i++ {
+			conn.MarkDirty(image.Rect(0, 0, 16, 16))
+		}
+		done <- nil
+	}()
+	go func() { // Updates flow while the screen is switched
+		for i := 0; i < 200; i++ {
+			if _, err := c.RequestUpdate(0, 0, 16, 16, false); err != nil {
+				done <- err
+				return
+			}
+			if err := c.SendPointer(i%16, i%16, 0); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 200; i++ {
+		if err := conn.SetScreen(screens[i%2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
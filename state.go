@@ -0,0 +1,81 @@
+// gorfb project state.go
+// Protocol state kept per connection
+package gorfb
+
+// PixelFormat returns the pixel format currently requested by the client
+// Until the client sends SetPixelFormat this is the server's PixelFormat (32 bits per pixel if that has 24)
+func (fb *RFBConn) PixelFormat() PixelFormat {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return fb.pixelFormat
+}
+
+// Encodings returns the encodings (including pseudo-encodings) last sent by the client in order of preference
+func (fb *RFBConn) Encodings() []Encoding {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	return append([]Encoding(nil), fb.encodings...)
+}
+
+// Supports reports if the client listed the encoding (or pseudo-encoding) in its last SetEncodings
+func (fb *RFBConn) Supports(encoding Encoding) bool {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	for _, enc := range fb.encodings {
+		if enc == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// Capabilities summarizes what was negotiated with a client
+type Capabilities struct {
+	// Protocol version agreed with the client
+	Major, Minor int
+	// Encodings supported by the client in order of preference
+	Encodings []Encoding
+	// Pseudo-encodings (negative) announcing the client's support for protocol extensions
+	PseudoEncodings []Encoding
+	// The extended clipboard is used with the client
+	ExtendedClipboard bool
+	// The client uses gii for extended input such as multitouch
+	GII bool
+	// Names of the protocol extensions enabled by the client
+	Extensions []string
+	// Pseudo-encoding used to send the client its cursor, 0 if the cursor is drawn into the updates
+	Cursor Encoding
+}
+
+// Capabilities returns what was negotiated with the client so far
+func (fb *RFBConn) Capabilities() Capabilities {
+	caps := Capabilities{Cursor: fb.cursorEncoding()}
+	caps.Major, caps.Minor = fb.ProtocolVersion()
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	for _, enc := range fb.encodings {
+		if enc.Pseudo() {
+			caps.PseudoEncodings = append(caps.PseudoEncodings, enc)
+		} else {
+			caps.Encodings = append(caps.Encodings, enc)
+		}
+	}
+	caps.ExtendedClipboard = fb.extClipboard
+	caps.GII = fb.giiDevices != nil
+	caps.Extensions = fb.extensionNames()
+	return caps
+}
+
+// convertRectangles converts rectangles in the server's PixelFormat to the client's pixel format
+func (fb *RFBConn) convertRectangles(rects []RFBRectangle) []RFBRectangle {
+	pf := fb.PixelFormat()
+	if pf == fb.CurrentScreen().PixelFormat {
+		return rects
+	}
+	crects := make([]RFBRectangle, len(rects))
+	for i, rect := range rects {
+		crects[i] = rect
+		crects[i].Buffer = ConvertPixels(fb.CurrentScreen().PixelFormat, pf, rect.Buffer)
+	}
+	return crects
+}
@@ -0,0 +1,107 @@
+// gorfb project static.go
+// Static images such as splash and error screens, encoded once per pixel format and encoding for all clients
+package gorfb
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync"
+)
+
+// ErrUnknownStaticImage is returned by SendStaticImage for a name that was not registered
+var ErrUnknownStaticImage = errors.New("Unknown static image")
+
+// staticImage is a registered static image with its pixels per pixel format and its encodings
+type staticImage struct {
+	img     image.Image
+	mu      sync.Mutex
+	pixels  map[PixelFormat][]byte
+	encoded map[staticKey][]encodedRect
+}
+
+// staticKey identifies an encoding of a static image
+type staticKey struct {
+	pf       PixelFormat
+	encoding Encoding
+}
+
+// RegisterStaticImage registers img under name, replacing an image registered under the name before
+// The image is drawn at its bounds (in the handler's coordinates) by SendStaticImage, it must not be changed after
+// it was registered. A nil img removes the registration.
+func (rfb *RFBServer) RegisterStaticImage(name string, img image.Image) {
+	rfb.mu.Lock()
+	defer rfb.mu.Unlock()
+	if img == nil {
+		delete(rfb.statics, name)
+		return
+	}
+	if rfb.statics == nil {
+		rfb.statics = make(map[string]*staticImage)
+	}
+	rfb.statics[name] = &staticImage{img: img, pixels: make(map[PixelFormat][]byte),
+		encoded: make(map[staticKey][]encodedRect)}
+}
+
+// SendStaticImage sends the static image registered under name to the client
+// The pixels and their encoding are computed for the first client using a pixel format and encoding and reused
+// for the others, updates that are changed for the client (by overlays, redactions or a viewport) are encoded anew.
+// The client must use a true color pixel format.
+func (fb *RFBConn) SendStaticImage(name string) error {
+	fb.Server.mu.Lock()
+	s, ok := fb.Server.statics[name]
+	fb.Server.mu.Unlock()
+	if !ok {
+		return ErrUnknownStaticImage
+	}
+	pf := fb.PixelFormat()
+	if pf.TrueColor != 1 {
+		return errors.New("Static images can only be sent to clients using a true color pixel format")
+	}
+	r := s.img.Bounds().Intersect(fb.screenBounds().Add(fb.offset()))
+	if r.Empty() {
+		return nil
+	}
+	return fb.sendUpdate(context.Background(), []RFBRectangle{s.rectangle(r, pf)}, s)
+}
+
+// rectangle returns the part r of the image in pf, the pixels of the whole image are kept for the next client
+func (s *staticImage) rectangle(r image.Rectangle, pf PixelFormat) RFBRectangle {
+	s.mu.Lock()
+	pixels, ok := s.pixels[pf]
+	if !ok {
+		pixels = ImageToPixels(s.img, s.img.Bounds(), pf)
+		s.pixels[pf] = pixels
+	}
+	s.mu.Unlock()
+	b := s.img.Bounds()
+	rect := RFBRectangle{X: b.Min.X, Y: b.Min.Y, Width: b.Dx(), Height: b.Dy(), Buffer: pixels}
+	if r == b {
+		return rect
+	}
+	return RFBRectangle{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(), Buffer: subPixels(rect, r, pf.BytesPerPixel())}
+}
+
+// encode returns rect encoded for fb, from the cache if rect is still the whole image as the image's rectangle
+// returned it
+func (s *staticImage) encode(fb *RFBConn, rect RFBRectangle, encoding Encoding, pf PixelFormat) ([]encodedRect, error) {
+	if fb.Server.statefulEncoding(encoding) {
+		return fb.encodeRectangle(rect, encoding, pf)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pixels := s.pixels[pf]
+	if rect.Bounds() != s.img.Bounds() || len(rect.Buffer) == 0 || len(pixels) != len(rect.Buffer) ||
+		&rect.Buffer[0] != &pixels[0] {
+		return fb.encodeRectangle(rect, encoding, pf)
+	}
+	key := staticKey{pf, encoding}
+	if er, ok := s.encoded[key]; ok {
+		return er, nil
+	}
+	er, err := fb.encodeRectangle(rect, encoding, pf)
+	if err == nil {
+		s.encoded[key] = er
+	}
+	return er, err
+}
@@ -4,8 +4,149 @@
 // Functions to support the network actions
 package gorfb
 
+import "fmt"
+
+// MessageWriter builds a protocol message of a known size in big endian format
+// Writing more or less than the size is not silently ignored, Bytes reports it as an error
+type MessageWriter struct {
+	buf  []byte
+	size int
+}
+
+// NewMessageWriter returns a writer for a message of size bytes
+func NewMessageWriter(size int) *MessageWriter {
+	return &MessageWriter{buf: make([]byte, 0, size), size: size}
+}
+
+// Uint8 writes a byte
+func (w *MessageWriter) Uint8(val uint8) *MessageWriter {
+	w.buf = append(w.buf, val)
+	return w
+}
+
+// Uint16 writes val as 2 bytes
+func (w *MessageWriter) Uint16(val uint16) *MessageWriter {
+	w.buf = append(w.buf, byte(val>>8), byte(val))
+	return w
+}
+
+// Uint32 writes val as 4 bytes
+func (w *MessageWriter) Uint32(val uint32) *MessageWriter {
+	w.buf = append(w.buf, byte(val>>24), byte(val>>16), byte(val>>8), byte(val))
+	return w
+}
+
+// Int32 writes a signed val as 4 bytes (two's complement), as used for encodings and cut text lengths
+func (w *MessageWriter) Int32(val int32) *MessageWriter {
+	return w.Uint32(uint32(val))
+}
+
+// Uint64 writes val as 8 bytes
+func (w *MessageWriter) Uint64(val uint64) *MessageWriter {
+	return w.Uint32(uint32(val >> 32)).Uint32(uint32(val))
+}
+
+// Padding writes n zero bytes
+func (w *MessageWriter) Padding(n int) *MessageWriter {
+	for i := 0; i < n; i++ {
+		w.buf = append(w.buf, 0)
+	}
+	return w
+}
+
+// Data writes the bytes of data as is
+func (w *MessageWriter) Data(data []byte) *MessageWriter {
+	w.buf = append(w.buf, data...)
+	return w
+}
+
+// Bytes returns the message, an error is returned if a different number of bytes than the size was written
+func (w *MessageWriter) Bytes() ([]byte, error) {
+	if len(w.buf) != w.size {
+		return nil, fmt.Errorf("Message of %d bytes built with %d bytes", w.size, len(w.buf))
+	}
+	return w.buf, nil
+}
+
+// MessageReader reads the fields of a protocol message in big endian format
+// Reading past the end of the message returns zero values and makes Err return an error
+type MessageReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+// NewMessageReader returns a reader for the message in buf
+func NewMessageReader(buf []byte) *MessageReader {
+	return &MessageReader{buf: buf}
+}
+
+// next returns the next n bytes or nil if there are not enough left
+func (r *MessageReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.buf) {
+		r.err = fmt.Errorf("Reading %d bytes at %d of a %d byte message", n, r.pos, len(r.buf))
+		return nil
+	}
+	r.pos += n
+	return r.buf[r.pos-n : r.pos]
+}
+
+// Uint8 reads a byte
+func (r *MessageReader) Uint8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// Uint16 reads 2 bytes
+func (r *MessageReader) Uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return uint16(b[0])<<8 | uint16(b[1])
+	}
+	return 0
+}
+
+// Uint32 reads 4 bytes
+func (r *MessageReader) Uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	}
+	return 0
+}
+
+// Int32 reads 4 bytes as a signed value (two's complement)
+func (r *MessageReader) Int32() int32 {
+	return int32(r.Uint32())
+}
+
+// Uint64 reads 8 bytes
+func (r *MessageReader) Uint64() uint64 {
+	return uint64(r.Uint32())<<32 | uint64(r.Uint32())
+}
+
+// Skip skips n bytes of padding
+func (r *MessageReader) Skip(n int) {
+	r.next(n)
+}
+
+// Data reads the next n bytes
+func (r *MessageReader) Data(n int) []byte {
+	return r.next(n)
+}
+
+// Err returns the error of the first read past the end of the message
+func (r *MessageReader) Err() error {
+	return r.err
+}
+
 // SetUint64 set 8 bytes at pos in buf to the val (in big endian format)
-// A test is done to ensure there are 8 bytes available at pos in the buffer
+// Nothing is written if there are not 8 bytes available at pos in the buffer
+//
+// Deprecated: use MessageWriter, which reports a message that does not fit
 func SetUint64(buf []byte, pos int, val uint64) {
 	if pos+8 > len(buf) {
 		return
@@ -17,7 +158,9 @@ func SetUint64(buf []byte, pos int, val uint64) {
 }
 
 // SetUint32 set 4 bytes at pos in buf to the val (in big endian format)
-// A test is done to ensure there are 4 bytes available at pos in the buffer
+// Nothing is written if there are not 4 bytes available at pos in the buffer
+//
+// Deprecated: use MessageWriter, which reports a message that does not fit
 func SetUint32(buf []byte, pos int, val uint32) {
 	if pos+4 > len(buf) {
 		return
@@ -29,7 +172,9 @@ func SetUint32(buf []byte, pos int, val uint32) {
 }
 
 // SetUint16 set 2 bytes at pos in buf to the val (in big endian format)
-// A test is done to ensure there are 2 bytes available at pos in the buffer
+// Nothing is written if there are not 2 bytes available at pos in the buffer
+//
+// Deprecated: use MessageWriter, which reports a message that does not fit
 func SetUint16(buf []byte, pos int, val uint16) {
 	if pos+2 > len(buf) {
 		return
@@ -41,7 +186,9 @@ func SetUint16(buf []byte, pos int, val uint16) {
 }
 
 // GetUint64 gets 8 bytes at pos in buf and return it as uint64 (from big endian format)
-// A test is done to ensure there are 8 bytes available at pos in the buffer
+// 0 is returned if there are not 8 bytes available at pos in the buffer
+//
+// Deprecated: use MessageReader, which reports reads past the end of the message
 func GetUint64(buf []byte, pos int) uint64 {
 	if pos+8 > len(buf) {
 		return 0
@@ -54,7 +201,9 @@ func GetUint64(buf []byte, pos int) uint64 {
 }
 
 // GetUint32 gets 4 bytes at pos in buf and return it as uint32 (from big endian format)
-// A test is done to ensure there are 4 bytes available at pos in the buffer
+// 0 is returned if there are not 4 bytes available at pos in the buffer
+//
+// Deprecated: use MessageReader, which reports reads past the end of the message
 func GetUint32(buf []byte, pos int) uint32 {
 	if pos+4 > len(buf) {
 		return 0
@@ -67,7 +216,9 @@ func GetUint32(buf []byte, pos int) uint32 {
 }
 
 // GetUint16 gets 2 bytes at pos in buf and return it as uint16 (from big endian format)
-// A test is done to ensure there are 2 bytes available at pos in the buffer
+// 0 is returned if there are not 2 bytes available at pos in the buffer
+//
+// Deprecated: use MessageReader, which reports reads past the end of the message
 func GetUint16(buf []byte, pos int) uint16 {
 	if pos+2 > len(buf) {
 		return 0
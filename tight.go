@@ -0,0 +1,143 @@
+// gorfb project tight.go
+// The Tight encoding: rectangles filled with a single colour or sent with a palette or as full colour pixels,
+// compressed with one of four zlib streams that last for the whole session
+package gorfb
+
+import (
+	"compress/zlib"
+
+	"github.com/hduplooy/gorfb/internal/tile"
+)
+
+// Tight compression control, the high 4 bits select fill, JPEG or basic compression with the stream in bits 4-5
+// and an explicit filter (otherwise the pixels are copied)
+const (
+	tightExplicitFilter = 0x40
+	tightFill           = 0x80
+	tightJPEG           = 0x90
+)
+
+// tightFilterPalette is the Tight filter sending palette indices
+const tightFilterPalette = 1
+
+// Tight zlib streams used for the filters
+const (
+	tightStreamCopy    = 0
+	tightStreamMono    = 1
+	tightStreamIndexed = 2
+)
+
+// Limits of Tight rectangles, larger rectangles are split
+const (
+	tightMaxWidth    = 2048
+	tightMaxRectSize = 65536 // Pixels
+)
+
+// tightMinToCompress is the size from which the data of basic compression is compressed
+const tightMinToCompress = 12
+
+// tightMaxPalette is the largest palette of the palette filter
+const tightMaxPalette = 256
+
+// tpixelSize returns the bytes of the pixels of Tight in pf: with 32 bits per pixel and 8 bits per colour the red,
+// green and blue bytes only
+func tpixelSize(pf PixelFormat) int {
+	return tile.TPixelSize(pf.BytesPerPixel(), int(pf.Depth), pf.TrueColor == 1, pf.RedMax, pf.GreenMax, pf.BlueMax)
+}
+
+// appendTPixel appends the pixel val (in pf) to buf as Tight sends it
+func appendTPixel(buf []byte, pf PixelFormat, val uint32) []byte {
+	if tpixelSize(pf) == 3 {
+		return append(buf, byte(val>>pf.RedShift), byte(val>>pf.GreenShift), byte(val>>pf.BlueShift))
+	}
+	var b [4]byte
+	bpp := pf.BytesPerPixel()
+	pf.putPixel(b[:bpp], 0, val)
+	return append(buf, b[:bpp]...)
+}
+
+// encodeTight encodes rect (in the client's pixel format pf) with Tight, fb.emu must be held
+func (fb *RFBConn) encodeTight(rect RFBRectangle, pf PixelFormat) ([]encodedRect, error) {
+	tw := min(rect.Width, tightMaxWidth)
+	var out []encodedRect
+	for _, t := range SplitTiles(rect.Bounds(), tw, max(1, tightMaxRectSize/tw)) {
+		data, err := fb.tightRect(tilePixels(subPixels(rect, t, pf.BytesPerPixel()), pf), t.Dx(), pf)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, encodedRect{t.Min.X, t.Min.Y, t.Dx(), t.Dy(), EncTight, data})
+	}
+	return out, nil
+}
+
+// tightRect returns the Tight data of the pixels pix of a rectangle that is width pixels wide
+func (fb *RFBConn) tightRect(pix []uint32, width int, pf PixelFormat) ([]byte, error) {
+	pal := tile.NewPalette(tightMaxPalette)
+	for _, p := range pix {
+		if pal.Add(p); pal.Overflowed {
+			break
+		}
+	}
+	palette := pal.Colours
+	if len(palette) == 1 {
+		return appendTPixel([]byte{tightFill}, pf, palette[0]), nil
+	}
+	if quality, ok := fb.jpegQuality(pf); ok && palette == nil && len(pix) >= tightMinJPEG {
+		return tightJPEGRect(pix, width, pf, quality)
+	}
+	tp := tpixelSize(pf)
+	var hdr, data []byte
+	stream := tightStreamCopy
+	switch {
+	case len(palette) == 2: // A bit per pixel, each row starts at a byte
+		stream = tightStreamMono
+		height := len(pix) / width
+		data = make([]byte, 0, height*tile.PackedRowSize(width, 1))
+		for y := 0; y < height; y++ {
+			data = pal.AppendPacked(data, pix[y*width:(y+1)*width], 1)
+		}
+	case len(palette) > 2 && 2+len(palette)*tp+len(pix) < len(pix)*tp: // A byte per pixel
+		stream = tightStreamIndexed
+		data = make([]byte, len(pix))
+		for i, p := range pix {
+			data[i] = byte(pal.Index(p))
+		}
+	default:
+		data = make([]byte, 0, len(pix)*tp)
+		for _, p := range pix {
+			data = appendTPixel(data, pf, p)
+		}
+	}
+	if stream == tightStreamCopy {
+		hdr = []byte{byte(stream << 4)}
+	} else {
+		hdr = []byte{byte(stream<<4) | tightExplicitFilter, tightFilterPalette, byte(len(palette) - 1)}
+		for _, p := range palette {
+			hdr = appendTPixel(hdr, pf, p)
+		}
+	}
+	if len(data) < tightMinToCompress {
+		return append(hdr, data...), nil
+	}
+	if fb.tight[stream] == nil {
+		s, err := fb.newZlibStream(fb.compressLevel(zlib.DefaultCompression))
+		if err != nil {
+			return nil, err
+		}
+		fb.tight[stream] = s
+	}
+	z, err := fb.tight[stream].compress(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(appendCompactLength(hdr, len(z)), z...), nil
+}
+
+// appendCompactLength appends the length n as Tight does: 7 bits per byte, the high bit set if another byte follows
+func appendCompactLength(buf []byte, n int) []byte {
+	for n > 0x7f {
+		buf = append(buf, byte(n&0x7f|0x80))
+		n >>= 7
+	}
+	return append(buf, byte(n))
+}
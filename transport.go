@@ -0,0 +1,73 @@
+// gorfb project transport.go
+// Serving clients over byte streams other than network connections, such as serial links or SSH channels
+package gorfb
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// Deadliner is implemented by transports that support deadlines, as net.Conn does
+// Over transports without deadlines the HandshakeTimeout is not enforced, a blocked write can not be aborted
+// through a context and Detach waits for the client's next message
+type Deadliner interface {
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// addresser is implemented by transports that know the address of the other side, as net.Conn does
+type addresser interface {
+	RemoteAddr() net.Addr
+}
+
+// transportAddr is the address of clients on transports that do not have one
+type transportAddr struct{}
+
+func (transportAddr) Network() string { return "transport" }
+func (transportAddr) String() string  { return "transport" }
+
+// ServeTransport serves a single client over rw, for example a serial link, an SSH channel or a pipe
+// It returns once the session ended, rw is closed by then
+func (rfb *RFBServer) ServeTransport(rw io.ReadWriteCloser) error {
+	if err := rfb.validate(); err != nil {
+		return err
+	}
+	if err := rfb.defaultScreen().validate(); err != nil {
+		return err
+	}
+	fb := &RFBConn{Server: rfb, Conn: rw, done: make(chan struct{})}
+	rfb.connStarted()
+	fb.process()
+	return nil
+}
+
+// remoteAddr returns the address of the client
+func (fb *RFBConn) remoteAddr() net.Addr {
+	if a, ok := fb.Conn.(addresser); ok {
+		return a.RemoteAddr()
+	}
+	return transportAddr{}
+}
+
+// setDeadline sets the read and write deadline if the transport supports deadlines
+func (fb *RFBConn) setDeadline(t time.Time) {
+	if d, ok := fb.Conn.(Deadliner); ok {
+		d.SetDeadline(t)
+	}
+}
+
+// setReadDeadline sets the read deadline if the transport supports deadlines
+func (fb *RFBConn) setReadDeadline(t time.Time) {
+	if d, ok := fb.Conn.(Deadliner); ok {
+		d.SetReadDeadline(t)
+	}
+}
+
+// setWriteDeadline sets the write deadline if the transport supports deadlines
+func (fb *RFBConn) setWriteDeadline(t time.Time) {
+	if d, ok := fb.Conn.(Deadliner); ok {
+		d.SetWriteDeadline(t)
+	}
+}
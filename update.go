@@ -0,0 +1,134 @@
+// gorfb project update.go
+// Building framebuffer updates from damaged areas instead of slicing rectangles by hand
+package gorfb
+
+import (
+	"context"
+	"errors"
+	"image"
+)
+
+const (
+	// DefaultMaxUpdateRects is the number of rectangles in an update built by an UpdateBuilder if MaxRects is 0
+	DefaultMaxUpdateRects = 64
+	// DefaultMergeWaste is the number of undamaged pixels merging two rectangles may add if MaxWaste is 0
+	DefaultMergeWaste = 64 * 64
+	// maxUpdateRects is what fits in the rectangle count of a FramebufferUpdate, leaving room for a cursor
+	maxUpdateRects = 0xffff - 1
+)
+
+// UpdateBuilder collects damaged areas of a client's framebuffer and sends them as an efficient set of rectangles:
+// overlaps are removed, nearby rectangles merged and the result kept within MaxRects and the 16 bit coordinates
+// of the protocol. Its fields may be changed before the first call to Add.
+type UpdateBuilder struct {
+	// MaxRects limits the rectangles in the update, rectangles are merged further until they fit
+	// 0 means DefaultMaxUpdateRects, negative as many as a FramebufferUpdate can hold
+	MaxRects int
+	// TileSize aligns the rectangles to a grid of tiles of this size, as encoders working on tiles prefer
+	// 0 means no alignment
+	TileSize int
+	// MaxWaste is the number of undamaged pixels merging two rectangles may add
+	// 0 means DefaultMergeWaste, negative only merges rectangles that touch without adding any
+	MaxWaste int
+	conn     *RFBConn
+	region   Region
+}
+
+// NewUpdateBuilder returns an empty update builder for the client
+func (fb *RFBConn) NewUpdateBuilder() *UpdateBuilder {
+	return &UpdateBuilder{conn: fb}
+}
+
+// Add adds damaged rectangles (in the handler's coordinates)
+func (b *UpdateBuilder) Add(rects ...image.Rectangle) *UpdateBuilder {
+	for _, r := range rects {
+		b.region = b.region.Add(r.Intersect(image.Rect(0, 0, 0xffff, 0xffff)))
+	}
+	return b
+}
+
+// AddRegion adds a damaged region (in the handler's coordinates)
+func (b *UpdateBuilder) AddRegion(rg Region) *UpdateBuilder {
+	return b.Add(rg...)
+}
+
+// Empty reports if nothing was added since the builder was created or last sent
+func (b *UpdateBuilder) Empty() bool {
+	return b.region.Empty()
+}
+
+// Reset drops everything that was added
+func (b *UpdateBuilder) Reset() {
+	b.region = nil
+}
+
+// Rectangles returns the rectangles the update will consist of, they do not overlap
+func (b *UpdateBuilder) Rectangles() []image.Rectangle {
+	rects := []image.Rectangle(b.region)
+	if b.TileSize > 0 {
+		var aligned Region
+		for _, r := range rects {
+			aligned = aligned.Add(b.align(r))
+		}
+		rects = aligned
+	}
+	maxRects := limit(b.MaxRects, DefaultMaxUpdateRects)
+	if maxRects > maxUpdateRects {
+		maxRects = maxUpdateRects
+	}
+	waste := b.MaxWaste
+	if waste == 0 {
+		waste = DefaultMergeWaste
+	}
+	for {
+		rects = b.merge(rects, max(waste, 0))
+		if len(rects) <= maxRects {
+			return rects
+		}
+		waste = max(waste*2, 1) // Merge more aggressively until the rectangles fit, in the end into a single one
+	}
+}
+
+// merge merges rectangles with at most waste undamaged pixels added and removes the overlaps merging left
+func (b *UpdateBuilder) merge(rects []image.Rectangle, waste int) []image.Rectangle {
+	var out Region
+	for _, r := range MergeRects(rects, waste) {
+		out = out.Add(r)
+	}
+	return out
+}
+
+// align grows r to the tile grid, within the coordinates the protocol can express
+func (b *UpdateBuilder) align(r image.Rectangle) image.Rectangle {
+	t := b.TileSize
+	grown := image.Rect(r.Min.X/t*t, r.Min.Y/t*t, (r.Max.X+t-1)/t*t, (r.Max.Y+t-1)/t*t)
+	return grown.Intersect(image.Rect(0, 0, 0xffff, 0xffff))
+}
+
+// Send sends the damaged areas of img (in the handler's coordinates) to the client as a single update and resets
+// the builder. The client must use a true color pixel format.
+func (b *UpdateBuilder) Send(img image.Image) error {
+	return b.SendContext(context.Background(), img)
+}
+
+// SendContext is Send giving up when ctx is done
+func (b *UpdateBuilder) SendContext(ctx context.Context, img image.Image) error {
+	pf := b.conn.PixelFormat()
+	if pf.TrueColor != 1 {
+		return errors.New("Updates can only be built for clients using a true color pixel format")
+	}
+	var rects []RFBRectangle
+	for _, r := range b.Rectangles() {
+		r = r.Intersect(img.Bounds())
+		if r.Empty() {
+			continue
+		}
+		rects = append(rects, RFBRectangle{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(),
+			Buffer: ImageToPixels(img, r, pf)})
+	}
+	b.Reset()
+	if len(rects) == 0 {
+		return nil
+	}
+	return b.conn.sendRectangles(ctx, rects)
+}
@@ -0,0 +1,196 @@
+// gorfb project validate.go
+// Validation of the values clients send
+package gorfb
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// PixelFormatPolicy determines what happens with an invalid pixel format sent by a client in SetPixelFormat
+type PixelFormatPolicy int
+
+const (
+	// PixelFormatNormalize fixes what can be fixed (flags that are not 0 or 1 and the depth),
+	// formats that still are not valid are ignored
+	PixelFormatNormalize PixelFormatPolicy = iota
+	// PixelFormatIgnore ignores invalid formats, the client keeps its previous format
+	PixelFormatIgnore
+	// PixelFormatDisconnect disconnects clients that send an invalid format
+	PixelFormatDisconnect
+)
+
+// clampUpdateRequest clips a requested area to the framebuffer
+// false is returned if nothing of the area is left, or in strict mode if the area was not completely inside
+func (fb *RFBConn) clampUpdateRequest(x, y, width, height int) (int, int, int, int, bool) {
+	req := image.Rect(x, y, x+width, y+height)
+	if req.Empty() {
+		return 0, 0, 0, 0, false
+	}
+	bounds := fb.screenBounds()
+	r := req.Intersect(bounds)
+	if r != req {
+		if fb.Server.StrictUpdateRequests {
+			fb.logf("Update request %v outside the %dx%d framebuffer rejected\n", req, bounds.Dx(), bounds.Dy())
+			return 0, 0, 0, 0, false
+		}
+		if r.Empty() {
+			fb.logf("Update request %v outside the %dx%d framebuffer ignored\n", req, bounds.Dx(), bounds.Dy())
+			return 0, 0, 0, 0, false
+		}
+	}
+	return r.Min.X, r.Min.Y, r.Dx(), r.Dy(), true
+}
+
+// check reports why the pixel format can not be used for a client, nil if it can
+func (pf PixelFormat) check() error {
+	if pf.BitsPerPixel != 8 && pf.BitsPerPixel != 16 && pf.BitsPerPixel != 32 {
+		return errors.New("Bits per pixel must be 8, 16 or 32")
+	}
+	if pf.Depth == 0 || pf.Depth > pf.BitsPerPixel {
+		return errors.New("Depth must be between 1 and the bits per pixel")
+	}
+	if pf.BigEndian > 1 || pf.TrueColor > 1 {
+		return errors.New("Big endian and true color flags must be 0 or 1")
+	}
+	if pf.TrueColor == 0 {
+		return nil
+	}
+	var used uint32
+	for _, c := range []struct {
+		max   uint16
+		shift uint8
+	}{{pf.RedMax, pf.RedShift}, {pf.GreenMax, pf.GreenShift}, {pf.BlueMax, pf.BlueShift}} {
+		if c.max == 0 || c.max&(c.max+1) != 0 {
+			return errors.New("Color maximums must be one less than a power of 2")
+		}
+		if uint32(c.shift)+uint32(bitLen(c.max)) > uint32(pf.BitsPerPixel) {
+			return errors.New("Colors must fit in the bits per pixel")
+		}
+		mask := uint32(c.max) << c.shift
+		if used&mask != 0 {
+			return errors.New("Colors may not overlap")
+		}
+		used |= mask
+	}
+	return nil
+}
+
+// bitLen returns the number of bits needed for val
+func bitLen(val uint16) int {
+	n := 0
+	for ; val != 0; val >>= 1 {
+		n++
+	}
+	return n
+}
+
+// checkClientPixelFormat applies the server's PixelFormatPolicy and PixelFormatHints to a format sent by the client
+// It returns the format to use, or false if the client's format must not be changed
+func (fb *RFBConn) checkClientPixelFormat(pf PixelFormat) (PixelFormat, bool) {
+	err := pf.check()
+	if err == nil {
+		if accept := fb.Server.PixelFormatHints.Accept; accept != nil && !accept(fb, pf) {
+			if fb.Server.PixelFormatPolicy == PixelFormatDisconnect {
+				fb.Close(fmt.Sprintf("Pixel format %+v not accepted", pf))
+			} else {
+				fb.logf("Pixel format %+v from client not accepted\n", pf)
+			}
+			return pf, false
+		}
+		return pf, true
+	}
+	switch fb.Server.PixelFormatPolicy {
+	case PixelFormatDisconnect:
+		fb.Close("Invalid pixel format: " + err.Error())
+		return pf, false
+	case PixelFormatNormalize:
+		fixed := pf
+		fixed.BigEndian = min(fixed.BigEndian, 1)
+		fixed.TrueColor = min(fixed.TrueColor, 1)
+		if fixed.TrueColor == 1 {
+			fixed.Depth = uint8(bitLen(fixed.RedMax) + bitLen(fixed.GreenMax) + bitLen(fixed.BlueMax))
+		}
+		if fixed.Depth == 0 || fixed.Depth > fixed.BitsPerPixel {
+			fixed.Depth = fixed.BitsPerPixel
+		}
+		if fixed.check() == nil {
+			fb.logf("Invalid pixel format %+v from client normalized to %+v\n", pf, fixed)
+			return fixed, true
+		}
+	}
+	fb.logf("Invalid pixel format %+v from client ignored: %s\n", pf, err.Error())
+	return pf, false
+}
+
+// Limits bounds what a client may send, so that a malicious client can not make the server allocate huge buffers
+// or queue unbounded work
+// A zero field uses the default limit, a negative field removes the limit
+type Limits struct {
+	// Largest cut text in bytes, also for extended clipboard messages (default 32 MB)
+	MaxCutText int
+	// Most encodings in a SetEncodings message (default 1024)
+	MaxEncodings int
+	// Most entries in a FixColourMapEntries message (default 256)
+	MaxColourMapEntries int
+	// Most update requests not yet answered by a FramebufferUpdate, further requests are dropped (default 32)
+	// Incremental requests for an area already requested are not counted.
+	MaxOutstandingUpdates int
+}
+
+// Default limits used for zero fields of Limits
+const (
+	DefaultMaxCutText            = 32 * 1024 * 1024
+	DefaultMaxEncodings          = 1024
+	DefaultMaxColourMapEntries   = 256
+	DefaultMaxOutstandingUpdates = 32
+)
+
+// limit returns the limit in effect for a configured value
+func limit(configured, def int) int {
+	switch {
+	case configured == 0:
+		return def
+	case configured < 0:
+		return int(^uint(0) >> 1)
+	}
+	return configured
+}
+
+// checkLimit returns an error if a client sent more than the limit of what
+// Clients exceeding a limit are disconnected as the data can not be trusted to be anything but an attack
+func checkLimit(what string, n, configured, def int) error {
+	if lim := limit(configured, def); n > lim {
+		return fmt.Errorf("Client sent %d %s, the limit is %d", n, what, lim)
+	}
+	return nil
+}
+
+// updateRequested counts an update request for area (in client coordinates) waiting to be answered
+// An incremental request for an area the waiting requests cover is coalesced with them, the next update answers it
+// too, so that clients pipelining incremental requests while nothing changes are not held against the limit.
+// dispatch reports if the request must be passed on, ok is false if too many requests are waiting already and the
+// request is dropped.
+func (fb *RFBConn) updateRequested(area image.Rectangle, incremental bool) (dispatch, ok bool) {
+	lim := limit(fb.Server.Limits.MaxOutstandingUpdates, DefaultMaxOutstandingUpdates)
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	if incremental && fb.outstandingUpdates > 0 && area.In(fb.outstandingArea) {
+		return false, true
+	}
+	if fb.outstandingUpdates >= lim {
+		return false, false
+	}
+	fb.outstandingUpdates++
+	fb.outstandingArea = fb.outstandingArea.Union(area)
+	return true, true
+}
+
+// updateSent marks all waiting update requests as answered
+func (fb *RFBConn) updateSent() {
+	fb.mu.Lock()
+	fb.outstandingUpdates = 0
+	fb.outstandingArea = image.Rectangle{}
+	fb.mu.Unlock()
+}
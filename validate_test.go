@@ -0,0 +1,44 @@
+package gorfb_test
+
+import (
+	"testing"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/rfbtest"
+)
+
+// idleHandler answers only non-incremental update requests, as if nothing ever changed
+type idleHandler struct {
+	*rfbtest.Handler
+}
+
+func (h idleHandler) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	if !incremental {
+		h.Handler.ProcessUpdateRequest(conn, x, y, width, height, incremental)
+	}
+}
+
+func TestIdleIncrementalRequests(t *testing.T) {
+	rfb, h := rfbtest.NewServer(16, 16)
+	rfb.Handler = idleHandler{h}
+	rfb.Limits.MaxOutstandingUpdates = 4
+	rfb.Logf = func(format string, args ...interface{}) {}
+	ln := rfbtest.Serve(rfb)
+	defer ln.Close()
+	c, err := rfbtest.Connect(ln, true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.RequestUpdate(0, 0, 16, 16, false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ { // A client waiting for changes keeps asking
+		if err := c.SendUpdateRequest(0, 0, 16, 16, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.RequestUpdate(0, 0, 16, 16, false); err != nil {
+		t.Fatalf("Full update request after idle incremental requests not answered: %v", err)
+	}
+}
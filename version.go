@@ -0,0 +1,46 @@
+// gorfb project version.go
+// Parsing the protocol version sent by clients
+package gorfb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Protocol version strings of the versions the server can speak
+var protocolVersions = map[string]int{"RFB 003.003\n": 3, "RFB 003.007\n": 7, "RFB 003.008\n": 8}
+
+// parseVersion returns the minor protocol version (3, 7 or 8) to use with a client that sent buf
+// In strict mode only the exact version strings of 3.3, 3.7 and 3.8 are accepted. Otherwise whitespace and
+// trailing garbage are ignored, versions above 3.8 are treated as 3.8 and the unofficial versions between
+// 3.3 and 3.7 as 3.3, as the specification suggests.
+func parseVersion(buf []byte, strict bool) (int, error) {
+	if strict {
+		if minor, ok := protocolVersions[string(buf)]; ok {
+			return minor, nil
+		}
+		return 0, fmt.Errorf("Invalid protocol version %q", buf)
+	}
+	text := strings.TrimSpace(string(buf))
+	if !strings.HasPrefix(text, "RFB") {
+		return 0, fmt.Errorf("Invalid protocol version %q", buf)
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(strings.TrimSpace(text[3:]), "%d.%d", &major, &minor); err != nil {
+		return 0, fmt.Errorf("Invalid protocol version %q", buf)
+	}
+	switch {
+	case major != 3 || minor < 3:
+		return 0, fmt.Errorf("Protocol version %d.%d is not supported", major, minor)
+	case minor >= 8:
+		return 8, nil
+	case minor == 7:
+		return 7, nil
+	}
+	return 3, nil
+}
+
+// ProtocolVersion returns the protocol version agreed with the client as major and minor version
+func (fb *RFBConn) ProtocolVersion() (int, int) {
+	return 3, fb.version
+}
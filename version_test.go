@@ -0,0 +1,32 @@
+package gorfb
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// agree runs agreeProtocol with a client writing the chunks of its version one after the other
+func agree(t *testing.T, strict bool, chunks ...string) (int, bool) {
+	t.Helper()
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go func() {
+		io.ReadFull(client, make([]byte, len(PROTOCOL)))
+		for _, chunk := range chunks {
+			client.Write([]byte(chunk))
+		}
+	}()
+	fb := &RFBConn{Conn: server, Server: &RFBServer{StrictVersion: strict, Logf: func(format string, args ...interface{}) {}}}
+	ok := fb.agreeProtocol()
+	return fb.version, ok
+}
+
+func TestAgreeProtocolSplitVersion(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		if version, ok := agree(t, strict, "RFB 003.", "007\n"); !ok || version != 7 {
+			t.Errorf("Version split over two writes (strict %v) agreed as %d, %v", strict, version, ok)
+		}
+	}
+}
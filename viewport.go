@@ -0,0 +1,131 @@
+// gorfb project viewport.go
+// Serving a sub-rectangle of a larger source framebuffer
+package gorfb
+
+import (
+	"errors"
+	"image"
+	"sync"
+)
+
+// Viewport selects the part of a larger source framebuffer that a screen serves
+// The screen's handler keeps working in source coordinates: update requests and pointer events are translated
+// to the source and the rectangles it sends are clipped to the viewport and translated back for the client.
+// This way one captured desktop can be served as several cropped screens, each on its own port.
+type Viewport struct {
+	mu     sync.Mutex
+	rect   image.Rectangle
+	source image.Rectangle
+}
+
+// NewViewport returns a viewport showing rect of a source framebuffer of sourceWidth x sourceHeight
+// rect is clipped to the source
+func NewViewport(rect image.Rectangle, sourceWidth, sourceHeight int) *Viewport {
+	source := image.Rect(0, 0, sourceWidth, sourceHeight)
+	return &Viewport{rect: rect.Intersect(source), source: source}
+}
+
+// Rect returns the part of the source currently shown
+func (vp *Viewport) Rect() image.Rectangle {
+	vp.mu.Lock()
+	defer vp.mu.Unlock()
+	return vp.rect
+}
+
+// pan moves the top left corner of the viewport to x,y, keeping it within the source
+func (vp *Viewport) pan(x, y int) {
+	vp.mu.Lock()
+	defer vp.mu.Unlock()
+	sz := vp.rect.Size()
+	x = max(vp.source.Min.X, min(x, vp.source.Max.X-sz.X))
+	y = max(vp.source.Min.Y, min(y, vp.source.Max.Y-sz.Y))
+	vp.rect = image.Rectangle{image.Pt(x, y), image.Pt(x, y).Add(sz)}
+}
+
+// PanScreen moves the viewport of the named screen so that its top left corner is at x,y in the source
+// and sends the newly visible area to the screen's clients
+func (rfb *RFBServer) PanScreen(name string, x, y int) error {
+	screen, ok := rfb.screen(name)
+	if !ok {
+		return ErrUnknownScreen
+	}
+	if screen.Viewport == nil {
+		return errors.New("Screen has no viewport")
+	}
+	screen.Viewport.pan(x, y)
+	for _, fb := range rfb.Connections() {
+		if fb.CurrentScreen().Name == name {
+			fb.refreshArea(fb.screenBounds())
+		}
+	}
+	return nil
+}
+
+// offset returns the position of the client's framebuffer within the handler's source framebuffer
+func (fb *RFBConn) offset() image.Point {
+	vp := fb.CurrentScreen().Viewport
+	if vp == nil {
+		return image.Point{}
+	}
+	return vp.Rect().Min
+}
+
+// requestUpdate passes an update request in client coordinates on to the handler
+// Incremental requests are answered with the areas the server refreshed, with DamageHints with what was marked dirty
+func (fb *RFBConn) requestUpdate(x, y, width, height int, incremental bool) {
+	area := image.Rect(x, y, x+width, y+height)
+	if !incremental {
+		fb.cleanArea(area)
+	} else if fb.dirtyRequest(area, fb.Server.DamageHints) {
+		return
+	}
+	screen := fb.CurrentScreen()
+	if vp := screen.Viewport; vp != nil {
+		rect := vp.Rect()
+		r := image.Rect(x, y, x+width, y+height).Add(rect.Min).Intersect(rect)
+		x, y, width, height = r.Min.X, r.Min.Y, r.Dx(), r.Dy()
+	}
+	screen.Handler.ProcessUpdateRequest(fb, x, y, width, height, incremental)
+}
+
+// cropRectangles clips rectangles (in the client's pixel format and source coordinates) to the viewport
+// and translates them to client coordinates
+// CopyRect rectangles are clipped to the part of which the source is in the viewport too.
+func (fb *RFBConn) cropRectangles(rects []RFBRectangle) []RFBRectangle {
+	vp := fb.CurrentScreen().Viewport
+	if vp == nil {
+		return rects
+	}
+	rect := vp.Rect()
+	bpp := fb.PixelFormat().BytesPerPixel()
+	out := make([]RFBRectangle, 0, len(rects))
+	for _, r := range rects {
+		rr := r.Bounds()
+		isect := rr.Intersect(rect)
+		if r.copyRect() {
+			src := image.Pt(r.SrcX, r.SrcY).Sub(rr.Min) // Offset of the source
+			if isect = isect.Intersect(rect.Sub(src)); !isect.Empty() {
+				r.SrcX, r.SrcY = isect.Min.X+src.X-rect.Min.X, isect.Min.Y+src.Y-rect.Min.Y
+				isect = isect.Sub(rect.Min)
+				r.X, r.Y, r.Width, r.Height = isect.Min.X, isect.Min.Y, isect.Dx(), isect.Dy()
+				out = append(out, r)
+			}
+			continue
+		}
+		if isect.Empty() || len(r.Buffer) < r.Width*r.Height*bpp {
+			continue
+		}
+		buf := r.Buffer
+		if isect != rr {
+			buf = make([]byte, 0, isect.Dx()*isect.Dy()*bpp)
+			for y := isect.Min.Y; y < isect.Max.Y; y++ {
+				pos := ((y-r.Y)*r.Width + isect.Min.X - r.X) * bpp
+				buf = append(buf, r.Buffer[pos:pos+isect.Dx()*bpp]...)
+			}
+		}
+		isect = isect.Sub(rect.Min)
+		r.X, r.Y, r.Width, r.Height, r.Buffer = isect.Min.X, isect.Min.Y, isect.Dx(), isect.Dy(), buf
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+// gorfb project vncterm/pty_linux.go
+// Starting a command on a PTY on Linux
+
+//go:build linux
+
+package vncterm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	tiocgptn   = 0x80045430
+	tiocsptlck = 0x40045431
+	tiocswinsz = 0x5414
+)
+
+// winsize is struct winsize used by TIOCSWINSZ
+type winsize struct {
+	Rows, Cols, XPixel, YPixel uint16
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openPTY opens a new PTY pair and sets its window size
+func openPTY(cols, rows int) (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var n uint32
+	unlock := int32(0)
+	if err = ioctl(master.Fd(), tiocgptn, unsafe.Pointer(&n)); err == nil {
+		err = ioctl(master.Fd(), tiocsptlck, unsafe.Pointer(&unlock))
+	}
+	if err == nil {
+		ws := winsize{Rows: uint16(rows), Cols: uint16(cols)}
+		err = ioctl(master.Fd(), tiocswinsz, unsafe.Pointer(&ws))
+	}
+	if err == nil {
+		slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	}
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// Start runs the command on a new PTY of cols x rows characters and returns the terminal showing it
+// Every font pixel is drawn as a scale x scale block
+func Start(cols, rows, scale int, name string, args ...string) (*Terminal, *exec.Cmd, error) {
+	master, slave, err := openPTY(cols, rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer slave.Close()
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "TERM=vt100", fmt.Sprintf("COLUMNS=%d", cols), fmt.Sprintf("LINES=%d", rows))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return newTerminal(master, cols, rows, scale), cmd, nil
+}
@@ -0,0 +1,16 @@
+// gorfb project vncterm/pty_other.go
+// Stub for systems without PTY support
+
+//go:build !linux
+
+package vncterm
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Start returns an error as PTYs are only supported on Linux
+func Start(cols, rows, scale int, name string, args ...string) (*Terminal, *exec.Cmd, error) {
+	return nil, nil, errors.New("PTYs are only supported on Linux")
+}
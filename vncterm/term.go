@@ -0,0 +1,504 @@
+// gorfb project vncterm/term.go
+// Package vncterm serves a terminal running in a PTY through gorfb
+//
+// The terminal contents are rendered with the embedded bitmap font and key events from the
+// clients are translated to the byte sequences a VT100 style terminal would send.
+package vncterm
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/hduplooy/gorfb"
+	"github.com/hduplooy/gorfb/bitfont"
+)
+
+// PixelFormat is the pixel format of the rendered terminal, it matches the memory layout of image.RGBA
+var PixelFormat = gorfb.PixelFormat{BitsPerPixel: 32, Depth: 24, BigEndian: 0, TrueColor: 1,
+	RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 0, GreenShift: 8, BlueShift: 16}
+
+// palette holds the 8 ANSI colours followed by their bright variants
+var palette = []color.RGBA{
+	{0, 0, 0, 255}, {205, 0, 0, 255}, {0, 205, 0, 255}, {205, 205, 0, 255},
+	{0, 0, 238, 255}, {205, 0, 205, 255}, {0, 205, 205, 255}, {229, 229, 229, 255},
+	{127, 127, 127, 255}, {255, 0, 0, 255}, {0, 255, 0, 255}, {255, 255, 0, 255},
+	{92, 92, 255, 255}, {255, 0, 255, 255}, {0, 255, 255, 255}, {255, 255, 255, 255},
+}
+
+const (
+	defaultFG = 7
+	defaultBG = 0
+)
+
+// cell is a single character position on the screen
+type cell struct {
+	r      rune
+	fg, bg int
+}
+
+// Terminal is a terminal emulator connected to a PTY, it is a gorfb.RFBServerHandler
+// The rows that change are marked dirty on the servers of the clients, so the terminal must be served with
+// DamageHints set (as NewServer does), without it every incremental update request is answered in full.
+type Terminal struct {
+	Cols, Rows int
+	// Every font pixel is drawn as a Scale x Scale block
+	Scale int
+
+	pty     io.ReadWriteCloser
+	mu      sync.Mutex
+	cells   [][]cell
+	rowver  []uint64 // Version at which each row last changed
+	version uint64
+	// Version up to which the rows have been rendered into img
+	renderedVersion uint64
+	img             *image.RGBA
+	cx, cy          int  // Cursor position
+	fg, bg          int  // Current colours
+	bold            bool // Current attributes
+	reverse         bool
+	esc             []byte // Escape sequence being collected
+	utf8buf         []byte // Incomplete UTF-8 sequence
+	ctrl            bool   // Control key is held down
+	// Servers of the clients, marked dirty when rows change
+	servers map[*gorfb.RFBServer]bool
+}
+
+// newTerminal creates a terminal of cols x rows characters connected to pty and starts reading its output
+func newTerminal(pty io.ReadWriteCloser, cols, rows, scale int) *Terminal {
+	if scale < 1 {
+		scale = 1
+	}
+	t := &Terminal{Cols: cols, Rows: rows, Scale: scale, pty: pty, fg: defaultFG, bg: defaultBG,
+		servers: make(map[*gorfb.RFBServer]bool)}
+	t.cells = make([][]cell, rows)
+	t.rowver = make([]uint64, rows)
+	for y := range t.cells {
+		t.cells[y] = t.blankRow()
+	}
+	t.img = image.NewRGBA(image.Rect(0, 0, cols*bitfont.Width*scale, rows*bitfont.Height*scale))
+	for y := range t.cells {
+		t.renderRow(y)
+	}
+	go t.readPTY()
+	return t
+}
+
+// NewServer returns a server serving the terminal on port
+func (t *Terminal) NewServer(port string) *gorfb.RFBServer {
+	b := t.img.Bounds()
+	return &gorfb.RFBServer{Port: port, Width: b.Dx(), Height: b.Dy(), PixelFormat: PixelFormat,
+		BufferName: "Terminal", Handler: t, ConvertPixelFormat: true, DamageHints: true}
+}
+
+// Close closes the PTY
+func (t *Terminal) Close() error {
+	return t.pty.Close()
+}
+
+// blankRow returns a row of spaces in the current colours
+func (t *Terminal) blankRow() []cell {
+	row := make([]cell, t.Cols)
+	for x := range row {
+		row[x] = cell{' ', t.fg, t.bg}
+	}
+	return row
+}
+
+// readPTY feeds the output of the PTY to the emulator
+func (t *Terminal) readPTY() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := t.pty.Read(buf)
+		if n > 0 {
+			t.mu.Lock()
+			from := t.version
+			t.feed(buf[:n])
+			dirty := t.changedRows(from)
+			servers := make([]*gorfb.RFBServer, 0, len(t.servers))
+			for rfb := range t.servers {
+				servers = append(servers, rfb)
+			}
+			t.mu.Unlock()
+			if !dirty.Empty() { // Not marked with t.mu held, the update may be sent from MarkDirty
+				for _, rfb := range servers {
+					rfb.MarkDirty(dirty)
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// changedRows returns the area of the rows that changed after version, t.mu must be held
+func (t *Terminal) changedRows(version uint64) image.Rectangle {
+	ch := bitfont.Height * t.Scale
+	var r image.Rectangle
+	for row, ver := range t.rowver {
+		if ver > version {
+			r = r.Union(image.Rect(0, row*ch, t.img.Bounds().Dx(), (row+1)*ch))
+		}
+	}
+	return r
+}
+
+// touch marks a row as changed
+func (t *Terminal) touch(y int) {
+	if y >= 0 && y < t.Rows {
+		t.version++
+		t.rowver[y] = t.version
+	}
+}
+
+// feed interprets output of the PTY
+func (t *Terminal) feed(data []byte) {
+	oldy := t.cy
+	for _, b := range data {
+		if t.esc != nil {
+			t.escape(b)
+			continue
+		}
+		if len(t.utf8buf) > 0 || b >= 0x80 {
+			t.utf8buf = append(t.utf8buf, b)
+			if !utf8.FullRune(t.utf8buf) {
+				continue
+			}
+			r, _ := utf8.DecodeRune(t.utf8buf)
+			t.utf8buf = t.utf8buf[:0]
+			t.put(r)
+			continue
+		}
+		switch b {
+		case 0x1b:
+			t.esc = []byte{}
+		case '\r':
+			t.cx = 0
+		case '\n', 0x0b, 0x0c:
+			t.lineFeed()
+		case '\b':
+			if t.cx > 0 {
+				t.cx--
+			}
+		case '\t':
+			t.cx = min((t.cx/8+1)*8, t.Cols-1)
+		case 0x07: // Bell is ignored
+		default:
+			if b >= 0x20 {
+				t.put(rune(b))
+			}
+		}
+	}
+	t.touch(oldy) // Cursor moved away from this row
+	t.touch(t.cy)
+	for y := range t.cells {
+		if t.rowver[y] > t.renderedVersion {
+			t.renderRow(y)
+		}
+	}
+	t.renderedVersion = t.version
+}
+
+// put writes a character at the cursor and advances it
+func (t *Terminal) put(r rune) {
+	if t.cx >= t.Cols {
+		t.cx = 0
+		t.lineFeed()
+	}
+	fg, bg := t.fg, t.bg
+	if t.bold && fg < 8 {
+		fg += 8
+	}
+	if t.reverse {
+		fg, bg = bg, fg
+	}
+	t.cells[t.cy][t.cx] = cell{r, fg, bg}
+	t.touch(t.cy)
+	t.cx++
+}
+
+// lineFeed moves the cursor down a line, scrolling the screen at the bottom
+func (t *Terminal) lineFeed() {
+	if t.cy < t.Rows-1 {
+		t.cy++
+		return
+	}
+	copy(t.cells, t.cells[1:])
+	t.cells[t.Rows-1] = t.blankRow()
+	for y := range t.cells {
+		t.touch(y)
+	}
+}
+
+// escape collects and executes escape sequences
+func (t *Terminal) escape(b byte) {
+	t.esc = append(t.esc, b)
+	if len(t.esc) == 1 {
+		switch b {
+		case '[', ']', '(', ')':
+			return
+		case 'c': // Reset
+			t.fg, t.bg, t.bold, t.reverse = defaultFG, defaultBG, false, false
+			t.erase(0, 0, t.Cols, t.Rows)
+			t.cx, t.cy = 0, 0
+		case 'M': // Reverse line feed
+			if t.cy > 0 {
+				t.cy--
+			}
+		}
+		t.esc = nil
+		return
+	}
+	switch t.esc[0] {
+	case '(', ')': // Character set selection is ignored
+		t.esc = nil
+	case ']': // Operating system commands (window title) end with BEL or ST
+		if b == 0x07 || (b == '\\' && len(t.esc) > 1 && t.esc[len(t.esc)-2] == 0x1b) {
+			t.esc = nil
+		}
+	case '[':
+		if b >= 0x40 && b <= 0x7e {
+			t.csi(string(t.esc[1:len(t.esc)-1]), b)
+			t.esc = nil
+		}
+	}
+	if len(t.esc) > 256 {
+		t.esc = nil
+	}
+}
+
+// csiParams parses the numeric parameters of a control sequence, missing parameters are def
+func csiParams(params string, def int) []int {
+	var vals []int
+	cur, have := 0, false
+	for _, c := range params {
+		switch {
+		case c >= '0' && c <= '9':
+			cur = cur*10 + int(c-'0')
+			have = true
+		case c == ';':
+			if !have {
+				cur = def
+			}
+			vals = append(vals, cur)
+			cur, have = 0, false
+		}
+	}
+	if !have {
+		cur = def
+	}
+	return append(vals, cur)
+}
+
+// csi executes a control sequence
+func (t *Terminal) csi(params string, cmd byte) {
+	if len(params) > 0 && params[0] == '?' { // Private modes are ignored
+		return
+	}
+	p := csiParams(params, 1)
+	n := max(p[0], 1)
+	switch cmd {
+	case 'A':
+		t.cy = max(t.cy-n, 0)
+	case 'B':
+		t.cy = min(t.cy+n, t.Rows-1)
+	case 'C':
+		t.cx = min(t.cx+n, t.Cols-1)
+	case 'D':
+		t.cx = max(t.cx-n, 0)
+	case 'G':
+		t.cx = min(n-1, t.Cols-1)
+	case 'd':
+		t.cy = min(n-1, t.Rows-1)
+	case 'H', 'f':
+		col := 1
+		if len(p) > 1 {
+			col = max(p[1], 1)
+		}
+		t.cy, t.cx = min(n-1, t.Rows-1), min(col-1, t.Cols-1)
+	case 'J':
+		switch csiParams(params, 0)[0] {
+		case 0:
+			t.erase(t.cx, t.cy, t.Cols, t.cy+1)
+			t.erase(0, t.cy+1, t.Cols, t.Rows)
+		case 1:
+			t.erase(0, 0, t.Cols, t.cy)
+			t.erase(0, t.cy, t.cx+1, t.cy+1)
+		default:
+			t.erase(0, 0, t.Cols, t.Rows)
+		}
+	case 'K':
+		switch csiParams(params, 0)[0] {
+		case 0:
+			t.erase(t.cx, t.cy, t.Cols, t.cy+1)
+		case 1:
+			t.erase(0, t.cy, t.cx+1, t.cy+1)
+		default:
+			t.erase(0, t.cy, t.Cols, t.cy+1)
+		}
+	case 'm':
+		for _, v := range csiParams(params, 0) {
+			switch {
+			case v == 0:
+				t.fg, t.bg, t.bold, t.reverse = defaultFG, defaultBG, false, false
+			case v == 1:
+				t.bold = true
+			case v == 7:
+				t.reverse = true
+			case v == 22:
+				t.bold = false
+			case v == 27:
+				t.reverse = false
+			case v >= 30 && v <= 37:
+				t.fg = v - 30
+			case v == 39:
+				t.fg = defaultFG
+			case v >= 40 && v <= 47:
+				t.bg = v - 40
+			case v == 49:
+				t.bg = defaultBG
+			case v >= 90 && v <= 97:
+				t.fg = v - 90 + 8
+			case v >= 100 && v <= 107:
+				t.bg = v - 100 + 8
+			}
+		}
+	}
+}
+
+// erase clears the cells from x0,y0 up to (not including) x1,y1 on each row
+func (t *Terminal) erase(x0, y0, x1, y1 int) {
+	for y := max(y0, 0); y < min(y1, t.Rows); y++ {
+		for x := max(x0, 0); x < min(x1, t.Cols); x++ {
+			t.cells[y][x] = cell{' ', t.fg, t.bg}
+		}
+		t.touch(y)
+	}
+}
+
+// renderRow draws a row of cells (and the cursor if it is on the row) into the image
+func (t *Terminal) renderRow(y int) {
+	cw, ch := bitfont.Width*t.Scale, bitfont.Height*t.Scale
+	for x, c := range t.cells[y] {
+		fg, bg := palette[c.fg], palette[c.bg]
+		if x == t.cx && y == t.cy {
+			fg, bg = bg, fg
+		}
+		for py := 0; py < ch; py++ {
+			for px := 0; px < cw; px++ {
+				col := bg
+				if bitfont.Set(c.r, px/t.Scale, py/t.Scale) {
+					col = fg
+				}
+				t.img.SetRGBA(x*cw+px, y*ch+py, col)
+			}
+		}
+	}
+}
+
+// Init notes the server of the client, it is marked dirty when rows change
+func (t *Terminal) Init(conn *gorfb.RFBConn) {
+	t.mu.Lock()
+	t.servers[conn.Server] = true
+	t.mu.Unlock()
+}
+
+// ProcessSetPixelFormat is handled by the server converting the pixels
+func (t *Terminal) ProcessSetPixelFormat(conn *gorfb.RFBConn, pf gorfb.PixelFormat) {}
+
+// ProcessSetEncoding is ignored, raw is used
+func (t *Terminal) ProcessSetEncoding(conn *gorfb.RFBConn, encodings []int) {}
+
+// ProcessUpdateRequest sends the requested region
+// With the server's DamageHints incremental requests only reach the terminal for rows that changed.
+func (t *Terminal) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	t.ProcessDirtyUpdate(conn, []image.Rectangle{image.Rect(x, y, x+width, y+height)})
+}
+
+// ProcessDirtyUpdate sends the changed rows in a single update
+func (t *Terminal) ProcessDirtyUpdate(conn *gorfb.RFBConn, rects []image.Rectangle) {
+	var out []gorfb.RFBRectangle
+	t.mu.Lock()
+	for _, r := range rects {
+		r = r.Intersect(t.img.Bounds())
+		if r.Empty() {
+			continue
+		}
+		buf := make([]byte, 0, r.Dx()*r.Dy()*4)
+		for py := r.Min.Y; py < r.Max.Y; py++ {
+			off := t.img.PixOffset(r.Min.X, py)
+			buf = append(buf, t.img.Pix[off:off+r.Dx()*4]...)
+		}
+		out = append(out, gorfb.RFBRectangle{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy(), Buffer: buf})
+	}
+	t.mu.Unlock()
+	conn.SendRectangles(out)
+}
+
+// keySequences maps X keysyms of special keys to the bytes a VT100 style terminal sends
+var keySequences = map[int]string{
+	0xff08: "\x7f",    // BackSpace
+	0xff09: "\t",      // Tab
+	0xff0d: "\r",      // Return
+	0xff8d: "\r",      // KP Enter
+	0xff1b: "\x1b",    // Escape
+	0xff50: "\x1b[H",  // Home
+	0xff51: "\x1b[D",  // Left
+	0xff52: "\x1b[A",  // Up
+	0xff53: "\x1b[C",  // Right
+	0xff54: "\x1b[B",  // Down
+	0xff55: "\x1b[5~", // Page Up
+	0xff56: "\x1b[6~", // Page Down
+	0xff57: "\x1b[F",  // End
+	0xff63: "\x1b[2~", // Insert
+	0xffff: "\x1b[3~", // Delete
+	0xffbe: "\x1bOP",  // F1
+	0xffbf: "\x1bOQ",  // F2
+	0xffc0: "\x1bOR",  // F3
+	0xffc1: "\x1bOS",  // F4
+}
+
+// ProcessKeyEvent writes the bytes for the key to the PTY
+func (t *Terminal) ProcessKeyEvent(conn *gorfb.RFBConn, key int, downflag bool) {
+	if key == 0xffe3 || key == 0xffe4 { // Control L/R
+		t.mu.Lock()
+		t.ctrl = downflag
+		t.mu.Unlock()
+		return
+	}
+	if !downflag {
+		return
+	}
+	if seq, ok := keySequences[key]; ok {
+		io.WriteString(t.pty, seq)
+		return
+	}
+	var r rune
+	switch {
+	case key >= 0x20 && key <= 0xff: // Latin-1 keysyms are the same as the characters
+		r = rune(key)
+	case key&0xff000000 == 0x01000000: // Unicode keysyms
+		r = rune(key & 0xffffff)
+	default:
+		return
+	}
+	t.mu.Lock()
+	ctrl := t.ctrl
+	t.mu.Unlock()
+	if ctrl && r >= '@' && r <= '~' { // Control characters
+		t.pty.Write([]byte{byte(r) & 0x1f})
+		return
+	}
+	io.WriteString(t.pty, string(r))
+}
+
+// ProcessPointerEvent is ignored
+func (t *Terminal) ProcessPointerEvent(conn *gorfb.RFBConn, x, y, button int) {}
+
+// ProcessCutText types the pasted text into the terminal
+func (t *Terminal) ProcessCutText(conn *gorfb.RFBConn, text string) {
+	io.WriteString(t.pty, text)
+}
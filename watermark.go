@@ -0,0 +1,75 @@
+// gorfb project watermark.go
+// Watermarks: text identifying the session (user, time) stamped into every update a client receives
+package gorfb
+
+import (
+	"bytes"
+	"time"
+)
+
+// DefaultWatermarkInterval is how often the watermark is asked for again if the server's WatermarkInterval is 0
+const DefaultWatermarkInterval = time.Second
+
+// startWatermark shows the client its watermark and keeps it current until the connection is closed
+func (fb *RFBConn) startWatermark() {
+	if fb.Server.Watermark == nil {
+		return
+	}
+	if o := fb.Server.Watermark(fb); o != nil { // The client has not been sent anything yet, no need to refresh
+		fb.mu.Lock()
+		fb.watermark = &activeOverlay{overlay: o, img: o.render()}
+		fb.mu.Unlock()
+	}
+	interval := fb.Server.WatermarkInterval
+	if interval == 0 {
+		interval = DefaultWatermarkInterval
+	}
+	if interval < 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-fb.done:
+				return
+			case <-ticker.C:
+				fb.updateWatermark()
+			}
+		}
+	}()
+}
+
+// updateWatermark asks for the client's watermark and, if it looks different from the one shown, has the areas
+// of the old and the new one resent so that the client's framebuffer only ever holds the current one
+func (fb *RFBConn) updateWatermark() {
+	var ao *activeOverlay
+	if o := fb.Server.Watermark(fb); o != nil {
+		ao = &activeOverlay{overlay: o, img: o.render()}
+	}
+	screen := fb.CurrentScreen()
+	width, height := screen.Width, screen.Height
+	fb.mu.Lock()
+	old := fb.watermark
+	if sameOverlay(old, ao, width, height) {
+		fb.mu.Unlock()
+		return
+	}
+	fb.watermark = ao
+	fb.mu.Unlock()
+	if old != nil {
+		fb.refreshArea(old.bounds(width, height))
+	}
+	if ao != nil {
+		fb.refreshArea(ao.bounds(width, height))
+	}
+}
+
+// sameOverlay reports if a and b (either may be nil) draw the same pixels at the same place
+func sameOverlay(a, b *activeOverlay, width, height int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.bounds(width, height) == b.bounds(width, height) && bytes.Equal(a.img.Pix, b.img.Pix)
+}
@@ -0,0 +1,7 @@
+// gorfb project windesktop/doc.go
+// Package windesktop serves the Windows desktop through gorfb
+//
+// The screen is captured with DXGI desktop duplication, which reports the parts of the desktop that changed, or
+// with GDI (BitBlt) where duplication is not available. Input from clients is injected with SendInput.
+// The package only does something on Windows, on other systems NewHandler returns an error.
+package windesktop
@@ -0,0 +1,360 @@
+// gorfb project windesktop/dxgi_windows.go
+// Capture with DXGI desktop duplication, which reports what changed on the desktop
+
+//go:build windows
+
+package windesktop
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	d3d11 = syscall.NewLazyDLL("d3d11.dll")
+
+	procD3D11CreateDevice = d3d11.NewProc("D3D11CreateDevice")
+)
+
+const (
+	d3dDriverTypeHardware = 1
+	d3d11SDKVersion       = 7
+	d3d11UsageStaging     = 3
+	d3d11CPUAccessRead    = 0x20000
+	d3d11MapRead          = 1
+
+	dxgiErrorAccessLost  = 0x887A0026
+	dxgiErrorWaitTimeout = 0x887A0027
+)
+
+// Methods of the COM interfaces used, as indices in their vtables
+const (
+	methodQueryInterface = 0
+	methodRelease        = 2
+
+	methodDeviceCreateTexture2D = 5  // ID3D11Device
+	methodContextMap            = 14 // ID3D11DeviceContext
+	methodContextUnmap          = 15
+	methodContextCopyResource   = 47
+	methodDXGIDeviceGetAdapter  = 7  // IDXGIDevice
+	methodAdapterEnumOutputs    = 7  // IDXGIAdapter
+	methodOutputDuplicateOutput = 22 // IDXGIOutput1
+	methodDuplGetDesc           = 7  // IDXGIOutputDuplication
+	methodDuplAcquireNextFrame  = 8
+	methodDuplGetFrameDirtyRect = 9
+	methodDuplGetFrameMoveRects = 10
+	methodDuplReleaseFrame      = 14
+	methodTextureGetDesc        = 10 // ID3D11Texture2D
+)
+
+// guid is a COM interface identifier
+type guid struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+var (
+	iidIDXGIDevice     = guid{0x54ec77fa, 0x1377, 0x44e6, [8]byte{0x8c, 0x32, 0x88, 0xfd, 0x5f, 0x44, 0xc8, 0x4c}}
+	iidIDXGIOutput1    = guid{0x00cddea8, 0x939b, 0x4b83, [8]byte{0xa3, 0x40, 0xa6, 0x85, 0x22, 0x66, 0x66, 0xcc}}
+	iidID3D11Texture2D = guid{0x6f15aaf2, 0xd208, 0x4e89, [8]byte{0x9a, 0xb4, 0x48, 0x95, 0x35, 0xd3, 0x4f, 0x9c}}
+)
+
+// comObject is a COM object, its methods are called through its vtable
+type comObject struct {
+	vtbl *[64]uintptr
+}
+
+// call calls a method of the object and returns the result (a HRESULT for most methods)
+func (o *comObject) call(method int, args ...uintptr) uintptr {
+	r, _, _ := syscall.SyscallN(o.vtbl[method], append([]uintptr{uintptr(unsafe.Pointer(o))}, args...)...)
+	return r
+}
+
+// check calls a method returning a HRESULT and returns an error if it failed
+func (o *comObject) check(name string, method int, args ...uintptr) error {
+	if hr := o.call(method, args...); int32(hr) < 0 {
+		return fmt.Errorf("%s failed: 0x%08x", name, uint32(hr))
+	}
+	return nil
+}
+
+// queryInterface returns the interface iid of the object
+func (o *comObject) queryInterface(iid *guid) (*comObject, error) {
+	var out *comObject
+	err := o.check("QueryInterface", methodQueryInterface, uintptr(unsafe.Pointer(iid)), uintptr(unsafe.Pointer(&out)))
+	return out, err
+}
+
+// release releases the object, it may be nil
+func (o *comObject) release() {
+	if o != nil {
+		o.call(methodRelease)
+	}
+}
+
+// rect is a RECT
+type rect struct {
+	Left, Top, Right, Bottom int32
+}
+
+// moveRect is a DXGI_OUTDUPL_MOVE_RECT
+type moveRect struct {
+	SourceX, SourceY int32
+	Destination      rect
+}
+
+// duplDesc is a DXGI_OUTDUPL_DESC
+type duplDesc struct {
+	Width, Height                  uint32
+	RefreshNumerator, RefreshDenom uint32
+	Format, ScanlineOrdering       uint32
+	Scaling, Rotation              uint32
+	DesktopImageInSystemMemory     int32
+}
+
+// frameInfo is a DXGI_OUTDUPL_FRAME_INFO
+type frameInfo struct {
+	LastPresentTime           int64
+	LastMouseUpdateTime       int64
+	AccumulatedFrames         uint32
+	RectsCoalesced            int32
+	ProtectedContentMaskedOut int32
+	PointerX, PointerY        int32
+	PointerVisible            int32
+	TotalMetadataBufferSize   uint32
+	PointerShapeBufferSize    uint32
+}
+
+// texture2DDesc is a D3D11_TEXTURE2D_DESC
+type texture2DDesc struct {
+	Width, Height, MipLevels, ArraySize uint32
+	Format                              uint32
+	SampleCount, SampleQuality          uint32
+	Usage, BindFlags                    uint32
+	CPUAccessFlags, MiscFlags           uint32
+}
+
+// mappedSubresource is a D3D11_MAPPED_SUBRESOURCE
+type mappedSubresource struct {
+	Data       unsafe.Pointer
+	RowPitch   uint32
+	DepthPitch uint32
+}
+
+// DXGICapturer captures the first display of the default adapter with DXGI desktop duplication
+// It keeps a copy of the desktop that Damage updates with what changed, Capture reads from that copy. Rotated
+// displays are not supported and the pointer is not drawn into the desktop image.
+type DXGICapturer struct {
+	mu      sync.Mutex
+	device  *comObject // ID3D11Device
+	context *comObject // ID3D11DeviceContext
+	dupl    *comObject // IDXGIOutputDuplication
+	staging *comObject // ID3D11Texture2D the frames are copied to for reading them
+	width   int
+	height  int
+	frame   []byte // The desktop in PixelFormat
+}
+
+// NewDXGICapturer starts duplicating the first display of the default adapter
+func NewDXGICapturer() (*DXGICapturer, error) {
+	if err := d3d11.Load(); err != nil {
+		return nil, err
+	}
+	d := &DXGICapturer{}
+	if hr, _, _ := procD3D11CreateDevice.Call(0, d3dDriverTypeHardware, 0, 0, 0, 0, d3d11SDKVersion,
+		uintptr(unsafe.Pointer(&d.device)), 0, uintptr(unsafe.Pointer(&d.context))); int32(hr) < 0 {
+		return nil, fmt.Errorf("D3D11CreateDevice failed: 0x%08x", uint32(hr))
+	}
+	if err := d.duplicate(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if _, err := d.Damage(500 * time.Millisecond); err != nil { // The first frame holds the whole desktop
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// duplicate starts duplicating the first output of the device's adapter
+func (d *DXGICapturer) duplicate() error {
+	dxgiDevice, err := d.device.queryInterface(&iidIDXGIDevice)
+	if err != nil {
+		return err
+	}
+	defer dxgiDevice.release()
+	var adapter, output *comObject
+	if err := dxgiDevice.check("GetAdapter", methodDXGIDeviceGetAdapter, uintptr(unsafe.Pointer(&adapter))); err != nil {
+		return err
+	}
+	defer adapter.release()
+	if err := adapter.check("EnumOutputs", methodAdapterEnumOutputs, 0, uintptr(unsafe.Pointer(&output))); err != nil {
+		return err
+	}
+	defer output.release()
+	output1, err := output.queryInterface(&iidIDXGIOutput1)
+	if err != nil {
+		return err
+	}
+	defer output1.release()
+	if err := output1.check("DuplicateOutput", methodOutputDuplicateOutput, uintptr(unsafe.Pointer(d.device)),
+		uintptr(unsafe.Pointer(&d.dupl))); err != nil {
+		return err
+	}
+	var desc duplDesc
+	d.dupl.call(methodDuplGetDesc, uintptr(unsafe.Pointer(&desc)))
+	if int(desc.Width) != d.width || int(desc.Height) != d.height {
+		d.width, d.height = int(desc.Width), int(desc.Height)
+		d.frame = make([]byte, d.width*d.height*4)
+		d.staging.release()
+		d.staging = nil
+	}
+	return nil
+}
+
+// Close stops duplicating the display
+func (d *DXGICapturer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.staging.release()
+	d.dupl.release()
+	d.context.release()
+	d.device.release()
+	d.staging, d.dupl, d.context, d.device = nil, nil, nil, nil
+	return nil
+}
+
+// Size returns the size of the display
+func (d *DXGICapturer) Size() (int, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.width, d.height
+}
+
+// Capture returns a region of the copy of the desktop
+func (d *DXGICapturer) Capture(r image.Rectangle) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r = r.Intersect(image.Rect(0, 0, d.width, d.height))
+	buf := make([]byte, 0, r.Dx()*r.Dy()*4)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		pos := (y*d.width + r.Min.X) * 4
+		buf = append(buf, d.frame[pos:pos+r.Dx()*4]...)
+	}
+	return buf, nil
+}
+
+// Damage waits up to timeout for a new frame, copies the parts that changed into the copy of the desktop and
+// returns them
+// When the duplication was lost (the display mode changed or a secure desktop was shown) it is started again and
+// the whole desktop is returned.
+func (d *DXGICapturer) Damage(timeout time.Duration) ([]image.Rectangle, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dupl == nil {
+		return nil, errors.New("Desktop duplication was closed")
+	}
+	var info frameInfo
+	var resource *comObject
+	hr := d.dupl.call(methodDuplAcquireNextFrame, uintptr(timeout/time.Millisecond), uintptr(unsafe.Pointer(&info)),
+		uintptr(unsafe.Pointer(&resource)))
+	switch {
+	case uint32(hr) == dxgiErrorWaitTimeout:
+		return nil, nil
+	case uint32(hr) == dxgiErrorAccessLost:
+		d.dupl.release()
+		d.dupl = nil
+		if err := d.duplicate(); err != nil {
+			return nil, err
+		}
+		return []image.Rectangle{image.Rect(0, 0, d.width, d.height)}, nil
+	case int32(hr) < 0:
+		return nil, fmt.Errorf("AcquireNextFrame failed: 0x%08x", uint32(hr))
+	}
+	defer d.dupl.call(methodDuplReleaseFrame)
+	defer resource.release()
+	if info.LastPresentTime == 0 { // Only the pointer moved
+		return nil, nil
+	}
+	rects, err := d.frameRects(info.TotalMetadataBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	texture, err := resource.queryInterface(&iidID3D11Texture2D)
+	if err != nil {
+		return nil, err
+	}
+	defer texture.release()
+	if err := d.copyFrame(texture, rects); err != nil {
+		return nil, err
+	}
+	return rects, nil
+}
+
+// frameRects returns the areas of the acquired frame that changed: its dirty rectangles and the destinations of
+// its moved rectangles
+func (d *DXGICapturer) frameRects(size uint32) ([]image.Rectangle, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	var out []image.Rectangle
+	bounds := image.Rect(0, 0, d.width, d.height)
+	moves := make([]moveRect, size/uint32(unsafe.Sizeof(moveRect{}))+1)
+	var used uint32
+	if hr := d.dupl.call(methodDuplGetFrameMoveRects, uintptr(len(moves))*unsafe.Sizeof(moveRect{}),
+		uintptr(unsafe.Pointer(&moves[0])), uintptr(unsafe.Pointer(&used))); int32(hr) < 0 {
+		return nil, fmt.Errorf("GetFrameMoveRects failed: 0x%08x", uint32(hr))
+	}
+	for _, m := range moves[:used/uint32(unsafe.Sizeof(moveRect{}))] {
+		r := m.Destination
+		out = append(out, image.Rect(int(r.Left), int(r.Top), int(r.Right), int(r.Bottom)).Intersect(bounds))
+	}
+	dirty := make([]rect, size/uint32(unsafe.Sizeof(rect{}))+1)
+	hr := d.dupl.call(methodDuplGetFrameDirtyRect, uintptr(len(dirty))*unsafe.Sizeof(rect{}),
+		uintptr(unsafe.Pointer(&dirty[0])), uintptr(unsafe.Pointer(&used)))
+	if int32(hr) < 0 {
+		return nil, fmt.Errorf("GetFrameDirtyRects failed: 0x%08x", uint32(hr))
+	}
+	for _, r := range dirty[:used/uint32(unsafe.Sizeof(rect{}))] {
+		out = append(out, image.Rect(int(r.Left), int(r.Top), int(r.Right), int(r.Bottom)).Intersect(bounds))
+	}
+	return out, nil
+}
+
+// copyFrame copies the rectangles of the frame in texture into the copy of the desktop, through a staging
+// texture the CPU can read
+func (d *DXGICapturer) copyFrame(texture *comObject, rects []image.Rectangle) error {
+	if d.staging == nil {
+		var desc texture2DDesc
+		texture.call(methodTextureGetDesc, uintptr(unsafe.Pointer(&desc)))
+		desc.MipLevels, desc.ArraySize, desc.SampleCount, desc.SampleQuality = 1, 1, 1, 0
+		desc.Usage, desc.BindFlags, desc.CPUAccessFlags, desc.MiscFlags = d3d11UsageStaging, 0, d3d11CPUAccessRead, 0
+		if err := d.device.check("CreateTexture2D", methodDeviceCreateTexture2D, uintptr(unsafe.Pointer(&desc)), 0,
+			uintptr(unsafe.Pointer(&d.staging))); err != nil {
+			return err
+		}
+	}
+	d.context.call(methodContextCopyResource, uintptr(unsafe.Pointer(d.staging)), uintptr(unsafe.Pointer(texture)))
+	var mapped mappedSubresource
+	if err := d.context.check("Map", methodContextMap, uintptr(unsafe.Pointer(d.staging)), 0, d3d11MapRead, 0,
+		uintptr(unsafe.Pointer(&mapped))); err != nil {
+		return err
+	}
+	defer d.context.call(methodContextUnmap, uintptr(unsafe.Pointer(d.staging)), 0)
+	src := unsafe.Slice((*byte)(mapped.Data), int(mapped.RowPitch)*d.height)
+	for _, r := range rects {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			from := y*int(mapped.RowPitch) + r.Min.X*4
+			to := (y*d.width + r.Min.X) * 4
+			copy(d.frame[to:to+r.Dx()*4], src[from:from+r.Dx()*4])
+		}
+	}
+	return nil
+}
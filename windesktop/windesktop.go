@@ -0,0 +1,139 @@
+// gorfb project windesktop/windesktop.go
+// Handler serving the Windows desktop
+package windesktop
+
+import (
+	"image"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hduplooy/gorfb"
+)
+
+// PixelFormat is the pixel format delivered by the capturers (32 bit BGRX)
+var PixelFormat = gorfb.PixelFormat{BitsPerPixel: 32, Depth: 24, BigEndian: 0, TrueColor: 1,
+	RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+
+// Capturer captures regions of the desktop
+type Capturer interface {
+	// Size returns the size of the desktop
+	Size() (width, height int)
+	// Capture returns the pixels (in PixelFormat) of a region of the desktop
+	Capture(r image.Rectangle) ([]byte, error)
+}
+
+// DamageReporter can be implemented by a Capturer that knows which parts of the desktop changed
+type DamageReporter interface {
+	// Damage waits up to timeout for the desktop to change and returns the parts that changed, none if nothing did
+	Damage(timeout time.Duration) ([]image.Rectangle, error)
+}
+
+// Injector injects input into the desktop
+type Injector interface {
+	// Key presses or releases the key identified by an X keysym
+	Key(keysym int, down bool) error
+	// Pointer moves the pointer to x,y on a desktop of the given size and sets the button mask
+	Pointer(x, y, width, height, buttons int) error
+}
+
+// Handler is a gorfb.RFBServerHandler serving the desktop
+// The parts of the desktop that change are marked dirty on the servers of the clients, the whole desktop every
+// Interval if the capturer is not a DamageReporter. The handler must be served with DamageHints set (as NewServer
+// does), without it every incremental update request is answered in full.
+type Handler struct {
+	Capturer Capturer
+	Injector Injector
+	// How often the desktop is marked dirty, or how long to wait for changes at most with a DamageReporter (100ms
+	// if not set)
+	Interval time.Duration
+
+	mu      sync.Mutex
+	servers map[*gorfb.RFBServer]bool // Servers of the clients, marked dirty when the desktop changes
+	watch   sync.Once
+}
+
+// NewServer returns a server serving the desktop through handler on port
+// The server converts the captured pixels to the pixel format requested by each client
+func NewServer(port string, handler *Handler) *gorfb.RFBServer {
+	width, height := handler.Capturer.Size()
+	return &gorfb.RFBServer{Port: port, Width: width, Height: height, PixelFormat: PixelFormat,
+		BufferName: "Windows desktop", Handler: handler, ConvertPixelFormat: true, DamageHints: true}
+}
+
+// Init notes the server of the client and starts watching the desktop for changes with the first client
+func (h *Handler) Init(conn *gorfb.RFBConn) {
+	h.mu.Lock()
+	if h.servers == nil {
+		h.servers = make(map[*gorfb.RFBServer]bool)
+	}
+	h.servers[conn.Server] = true
+	h.mu.Unlock()
+	h.watch.Do(func() { go h.watchDesktop() })
+}
+
+// watchDesktop marks the parts of the desktop that change dirty on the servers
+func (h *Handler) watchDesktop() {
+	interval := h.Interval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	dr, reports := h.Capturer.(DamageReporter)
+	for {
+		var rects []image.Rectangle
+		if reports {
+			var err error
+			if rects, err = dr.Damage(interval); err != nil {
+				log.Printf("Error waiting for desktop changes: %s\n", err.Error())
+				time.Sleep(interval)
+				continue
+			}
+		} else {
+			time.Sleep(interval)
+			width, height := h.Capturer.Size()
+			rects = []image.Rectangle{image.Rect(0, 0, width, height)}
+		}
+		if len(rects) == 0 {
+			continue
+		}
+		h.mu.Lock()
+		servers := make([]*gorfb.RFBServer, 0, len(h.servers))
+		for rfb := range h.servers {
+			servers = append(servers, rfb)
+		}
+		h.mu.Unlock()
+		for _, rfb := range servers {
+			rfb.MarkDirty(rects...)
+		}
+	}
+}
+
+// ProcessSetPixelFormat is handled by the server converting the pixels
+func (h *Handler) ProcessSetPixelFormat(conn *gorfb.RFBConn, pf gorfb.PixelFormat) {}
+
+// ProcessSetEncoding is ignored, raw is used
+func (h *Handler) ProcessSetEncoding(conn *gorfb.RFBConn, encodings []int) {}
+
+// ProcessUpdateRequest captures the requested region and sends it to the client
+// With the server's DamageHints incremental requests only reach the handler for parts of the desktop that changed.
+func (h *Handler) ProcessUpdateRequest(conn *gorfb.RFBConn, x, y, width, height int, incremental bool) {
+	buf, err := h.Capturer.Capture(image.Rect(x, y, x+width, y+height))
+	if err != nil {
+		return
+	}
+	conn.SendRectangles([]gorfb.RFBRectangle{{X: x, Y: y, Width: width, Height: height, Buffer: buf}})
+}
+
+// ProcessKeyEvent injects the key
+func (h *Handler) ProcessKeyEvent(conn *gorfb.RFBConn, key int, downflag bool) {
+	h.Injector.Key(key, downflag)
+}
+
+// ProcessPointerEvent injects the pointer movement and buttons
+func (h *Handler) ProcessPointerEvent(conn *gorfb.RFBConn, x, y, button int) {
+	width, height := h.Capturer.Size()
+	h.Injector.Pointer(x, y, width, height, button)
+}
+
+// ProcessCutText is ignored, use a gorfb.ClipboardBridge to synchronize the clipboard
+func (h *Handler) ProcessCutText(conn *gorfb.RFBConn, text string) {}
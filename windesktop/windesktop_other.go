@@ -0,0 +1,13 @@
+// gorfb project windesktop/windesktop_other.go
+// Stub for systems other than Windows
+
+//go:build !windows
+
+package windesktop
+
+import "errors"
+
+// NewHandler returns an error as the Windows desktop can only be served on Windows
+func NewHandler() (*Handler, error) {
+	return nil, errors.New("The Windows desktop can only be served on Windows")
+}
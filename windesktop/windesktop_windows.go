@@ -0,0 +1,275 @@
+// gorfb project windesktop/windesktop_windows.go
+// GDI capture and SendInput injection
+
+//go:build windows
+
+package windesktop
+
+import (
+	"errors"
+	"image"
+	"log"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	user32 = syscall.NewLazyDLL("user32.dll")
+	gdi32  = syscall.NewLazyDLL("gdi32.dll")
+
+	procGetSystemMetrics       = user32.NewProc("GetSystemMetrics")
+	procGetDC                  = user32.NewProc("GetDC")
+	procReleaseDC              = user32.NewProc("ReleaseDC")
+	procSendInput              = user32.NewProc("SendInput")
+	procCreateCompatibleDC     = gdi32.NewProc("CreateCompatibleDC")
+	procCreateCompatibleBitmap = gdi32.NewProc("CreateCompatibleBitmap")
+	procSelectObject           = gdi32.NewProc("SelectObject")
+	procBitBlt                 = gdi32.NewProc("BitBlt")
+	procGetDIBits              = gdi32.NewProc("GetDIBits")
+	procDeleteObject           = gdi32.NewProc("DeleteObject")
+	procDeleteDC               = gdi32.NewProc("DeleteDC")
+)
+
+const (
+	smCXScreen = 0
+	smCYScreen = 1
+	srcCopy    = 0x00CC0020
+	captureBlt = 0x40000000
+	biRGB      = 0
+	dibRGB     = 0
+
+	inputMouse    = 0
+	inputKeyboard = 1
+
+	mouseMove       = 0x0001
+	mouseLeftDown   = 0x0002
+	mouseLeftUp     = 0x0004
+	mouseRightDown  = 0x0008
+	mouseRightUp    = 0x0010
+	mouseMiddleDown = 0x0020
+	mouseMiddleUp   = 0x0040
+	mouseWheel      = 0x0800
+	mouseAbsolute   = 0x8000
+	wheelDelta      = 120
+
+	keyExtended = 0x0001
+	keyUp       = 0x0002
+	keyUnicode  = 0x0004
+)
+
+type bitmapInfoHeader struct {
+	Size          uint32
+	Width         int32
+	Height        int32
+	Planes        uint16
+	BitCount      uint16
+	Compression   uint32
+	SizeImage     uint32
+	XPelsPerMeter int32
+	YPelsPerMeter int32
+	ClrUsed       uint32
+	ClrImportant  uint32
+}
+
+type bitmapInfo struct {
+	Header bitmapInfoHeader
+	Colors [1]uint32
+}
+
+type mouseInput struct {
+	Dx, Dy    int32
+	MouseData uint32
+	Flags     uint32
+	Time      uint32
+	ExtraInfo uintptr
+}
+
+type keybdInput struct {
+	Vk, Scan  uint16
+	Flags     uint32
+	Time      uint32
+	ExtraInfo uintptr
+	_         [8]byte // Pad to the size of the INPUT union (the size of MOUSEINPUT)
+}
+
+type mouseINPUT struct {
+	Type uint32
+	Mi   mouseInput
+}
+
+type keybdINPUT struct {
+	Type uint32
+	Ki   keybdInput
+}
+
+// GDICapturer captures the primary display with GDI
+type GDICapturer struct{}
+
+// Size returns the size of the primary display
+func (GDICapturer) Size() (int, int) {
+	w, _, _ := procGetSystemMetrics.Call(smCXScreen)
+	h, _, _ := procGetSystemMetrics.Call(smCYScreen)
+	return int(w), int(h)
+}
+
+// Capture copies the region of the screen into a top-down 32 bit DIB
+func (GDICapturer) Capture(r image.Rectangle) ([]byte, error) {
+	w, h := r.Dx(), r.Dy()
+	if w <= 0 || h <= 0 {
+		return nil, nil
+	}
+	screen, _, _ := procGetDC.Call(0)
+	if screen == 0 {
+		return nil, errors.New("GetDC failed")
+	}
+	defer procReleaseDC.Call(0, screen)
+	mem, _, _ := procCreateCompatibleDC.Call(screen)
+	if mem == 0 {
+		return nil, errors.New("CreateCompatibleDC failed")
+	}
+	defer procDeleteDC.Call(mem)
+	bmp, _, _ := procCreateCompatibleBitmap.Call(screen, uintptr(w), uintptr(h))
+	if bmp == 0 {
+		return nil, errors.New("CreateCompatibleBitmap failed")
+	}
+	defer procDeleteObject.Call(bmp)
+	old, _, _ := procSelectObject.Call(mem, bmp)
+	defer procSelectObject.Call(mem, old)
+	if ok, _, err := procBitBlt.Call(mem, 0, 0, uintptr(w), uintptr(h), screen, uintptr(r.Min.X), uintptr(r.Min.Y), srcCopy|captureBlt); ok == 0 {
+		return nil, err
+	}
+	bi := bitmapInfo{Header: bitmapInfoHeader{Width: int32(w), Height: -int32(h), Planes: 1, BitCount: 32, Compression: biRGB}}
+	bi.Header.Size = uint32(unsafe.Sizeof(bi.Header))
+	buf := make([]byte, w*h*4)
+	if lines, _, err := procGetDIBits.Call(mem, bmp, 0, uintptr(h), uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&bi)), dibRGB); lines == 0 {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// SendInputInjector injects input with SendInput
+type SendInputInjector struct {
+	buttons int // Last button mask
+}
+
+// vkeys maps X keysyms of keys that do not produce characters to virtual key codes (and if the key is extended)
+var vkeys = map[int]struct {
+	vk       uint16
+	extended bool
+}{
+	0xff08: {0x08, false}, // BackSpace
+	0xff09: {0x09, false}, // Tab
+	0xff0d: {0x0d, false}, // Return
+	0xff1b: {0x1b, false}, // Escape
+	0xff50: {0x24, true},  // Home
+	0xff51: {0x25, true},  // Left
+	0xff52: {0x26, true},  // Up
+	0xff53: {0x27, true},  // Right
+	0xff54: {0x28, true},  // Down
+	0xff55: {0x21, true},  // Page Up
+	0xff56: {0x22, true},  // Page Down
+	0xff57: {0x23, true},  // End
+	0xff63: {0x2d, true},  // Insert
+	0xffff: {0x2e, true},  // Delete
+	0xffe1: {0xa0, false}, // Shift L
+	0xffe2: {0xa1, false}, // Shift R
+	0xffe3: {0xa2, false}, // Control L
+	0xffe4: {0xa3, true},  // Control R
+	0xffe9: {0xa4, false}, // Alt L
+	0xffea: {0xa5, true},  // Alt R
+	0xffeb: {0x5b, true},  // Super L
+	0xffec: {0x5c, true},  // Super R
+	0xff67: {0x5d, true},  // Menu
+	0xffe5: {0x14, false}, // Caps Lock
+	0xff13: {0x13, false}, // Pause
+	0xff61: {0x2c, true},  // Print
+}
+
+// Key injects a key press or release
+// Keys that produce characters are sent as unicode, the others as virtual keys
+func (inj *SendInputInjector) Key(keysym int, down bool) error {
+	in := keybdINPUT{Type: inputKeyboard}
+	if !down {
+		in.Ki.Flags = keyUp
+	}
+	switch {
+	case keysym >= 0xffbe && keysym <= 0xffc9: // F1-F12
+		in.Ki.Vk = uint16(0x70 + keysym - 0xffbe)
+	case vkeys[keysym].vk != 0:
+		in.Ki.Vk = vkeys[keysym].vk
+		if vkeys[keysym].extended {
+			in.Ki.Flags |= keyExtended
+		}
+	case keysym >= 0x20 && keysym <= 0xff: // Latin-1 keysyms are the same as the characters
+		in.Ki.Scan = uint16(keysym)
+		in.Ki.Flags |= keyUnicode
+	case keysym&0xff000000 == 0x01000000 && keysym&0xffffff <= 0xffff: // Unicode keysyms
+		in.Ki.Scan = uint16(keysym & 0xffff)
+		in.Ki.Flags |= keyUnicode
+	default:
+		return errors.New("Keysym can not be injected")
+	}
+	return sendInput(unsafe.Pointer(&in), unsafe.Sizeof(in))
+}
+
+// Pointer injects an absolute pointer movement and the changes in the button mask
+func (inj *SendInputInjector) Pointer(x, y, width, height, buttons int) error {
+	in := mouseINPUT{Type: inputMouse}
+	in.Mi.Flags = mouseMove | mouseAbsolute
+	if width > 1 && height > 1 {
+		in.Mi.Dx = int32(x * 65535 / (width - 1))
+		in.Mi.Dy = int32(y * 65535 / (height - 1))
+	}
+	changed := buttons ^ inj.buttons
+	flags := []struct{ mask, down, up uint32 }{{1, mouseLeftDown, mouseLeftUp}, {2, mouseMiddleDown, mouseMiddleUp}, {4, mouseRightDown, mouseRightUp}}
+	for _, f := range flags {
+		if changed&int(f.mask) != 0 {
+			if buttons&int(f.mask) != 0 {
+				in.Mi.Flags |= f.down
+			} else {
+				in.Mi.Flags |= f.up
+			}
+		}
+	}
+	inj.buttons = buttons
+	if err := sendInput(unsafe.Pointer(&in), unsafe.Sizeof(in)); err != nil {
+		return err
+	}
+	if buttons&(8|16) != 0 && changed&(8|16) != 0 { // Wheel buttons scroll once when pressed
+		wheel := mouseINPUT{Type: inputMouse}
+		wheel.Mi.Flags = mouseWheel
+		delta := int32(wheelDelta)
+		if buttons&16 != 0 {
+			delta = -delta
+		}
+		wheel.Mi.MouseData = uint32(delta)
+		return sendInput(unsafe.Pointer(&wheel), unsafe.Sizeof(wheel))
+	}
+	return nil
+}
+
+// sendInput sends a single INPUT structure
+func sendInput(in unsafe.Pointer, size uintptr) error {
+	if n, _, err := procSendInput.Call(1, uintptr(in), size); n != 1 {
+		return err
+	}
+	return nil
+}
+
+// NewHandler returns a handler capturing the primary display with DXGI desktop duplication, or with GDI where that
+// is not available (remote sessions, older systems), and injecting input with SendInput
+func NewHandler() (*Handler, error) {
+	if err := user32.Load(); err != nil {
+		return nil, err
+	}
+	if err := gdi32.Load(); err != nil {
+		return nil, err
+	}
+	var capturer Capturer = GDICapturer{}
+	if dxgi, err := NewDXGICapturer(); err == nil {
+		capturer = dxgi
+	} else {
+		log.Printf("Desktop duplication not available, capturing with GDI: %s\n", err.Error())
+	}
+	return &Handler{Capturer: capturer, Injector: &SendInputInjector{}}, nil
+}
@@ -0,0 +1,83 @@
+// gorfb project xvp.go
+// xvp extension used by clients to shut down, reboot or reset the machine behind the framebuffer
+package gorfb
+
+import (
+	"io"
+)
+
+const (
+	xvpVersion = 1
+
+	xvpFail = 0
+	xvpInit = 1
+)
+
+// PowerAction is an action requested through xvp
+type PowerAction int
+
+const (
+	// Shut the machine down cleanly
+	PowerShutdown PowerAction = 2
+	// Reboot the machine cleanly
+	PowerReboot PowerAction = 3
+	// Reset the machine (hard reboot)
+	PowerReset PowerAction = 4
+)
+
+// RFBPowerHandler can be implemented by a RFBServerHandler that lets clients shut down, reboot or reset the
+// machine it shows (for example a virtual machine), clients are only offered xvp if the handler implements it
+type RFBPowerHandler interface {
+	// Perform the action requested by the client
+	// conn is the RFB connection with the client
+	// An error is reported to the client as a failure
+	ProcessPowerAction(conn *RFBConn, action PowerAction) error
+}
+
+// xvpExtension is the xvp extension
+type xvpExtension struct{}
+
+func (xvpExtension) Name() string                      { return "XVP" }
+func (xvpExtension) PseudoEncodings() []Encoding       { return []Encoding{EncXvp} }
+func (xvpExtension) MessageTypes() []ClientMessageType { return []ClientMessageType{MsgClientXvp} }
+
+// Enable tells the client that xvp is supported
+func (xvpExtension) Enable(fb *RFBConn) error {
+	return fb.writeXVP(xvpInit)
+}
+
+// ProcessMessage passes a requested action on to the handler, failures and unknown actions are reported to the client
+func (xvpExtension) ProcessMessage(fb *RFBConn, msgType ClientMessageType) error {
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(fb.Conn, buf); err != nil {
+		return err
+	}
+	r := NewMessageReader(buf)
+	r.Skip(1)
+	version, action := r.Uint8(), PowerAction(r.Uint8())
+	if err := r.Err(); err != nil {
+		return err
+	}
+	ph, ok := fb.CurrentScreen().Handler.(RFBPowerHandler)
+	if !ok || version != xvpVersion || action < PowerShutdown || action > PowerReset || !fb.acceptInput() {
+		return fb.writeXVP(xvpFail)
+	}
+	fb.dispatch(inputQueue, func() {
+		if err := ph.ProcessPowerAction(fb, action); err != nil {
+			fb.logf("xvp action %d failed: %s\n", action, err.Error())
+			if err := fb.writeXVP(xvpFail); err != nil {
+				fb.logf("Error sending xvp failure: %s\n", err.Error())
+			}
+		}
+	})
+	return nil
+}
+
+// writeXVP sends an xvp message with the given code
+func (fb *RFBConn) writeXVP(code uint8) error {
+	buf, err := NewMessageWriter(4).Uint8(uint8(MsgServerXvp)).Padding(1).Uint8(xvpVersion).Uint8(code).Bytes()
+	if err != nil {
+		return err
+	}
+	return fb.write(buf)
+}
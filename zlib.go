@@ -0,0 +1,36 @@
+// gorfb project zlib.go
+// The Zlib encoding: raw pixels compressed with a zlib stream that lasts for the whole session
+package gorfb
+
+import "compress/zlib"
+
+// compressLevel returns the compression level the client asked for with a pseudo-encoding, def if it did not
+func (fb *RFBConn) compressLevel(def int) int {
+	for _, enc := range fb.Encodings() {
+		if enc >= EncCompressLevel0 && enc <= EncCompressLevel9 {
+			return int(enc - EncCompressLevel0)
+		}
+	}
+	return def
+}
+
+// encodeZlib encodes rect (in the client's pixel format) with Zlib, fb.emu must be held
+// The stream is started with the compression level the client asks for when the first rectangle is sent
+func (fb *RFBConn) encodeZlib(rect RFBRectangle) ([]encodedRect, error) {
+	if fb.zlib == nil {
+		s, err := fb.newZlibStream(fb.compressLevel(zlib.DefaultCompression))
+		if err != nil {
+			return nil, err
+		}
+		fb.zlib = s
+	}
+	data, err := fb.zlib.compress(rect.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := NewMessageWriter(4 + len(data)).Uint32(uint32(len(data))).Data(data).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return []encodedRect{{rect.X, rect.Y, rect.Width, rect.Height, EncZlib, buf}}, nil
+}
@@ -0,0 +1,131 @@
+// gorfb project zrle.go
+// The ZRLE encoding: 64x64 tiles sent raw, as a single colour, with a packed palette or run length encoded, all
+// compressed with a zlib stream that lasts for the whole session
+package gorfb
+
+import (
+	"compress/zlib"
+
+	"github.com/hduplooy/gorfb/internal/tile"
+)
+
+// cpixel describes the compressed pixels of ZRLE and Tight: with 32 bits per pixel and the colours in 3 of the
+// bytes only those 3 bytes are sent
+type cpixel struct {
+	pf    PixelFormat
+	size  int  // Bytes per compressed pixel
+	shift uint // The colours are in the most significant 3 bytes
+}
+
+// newCPixel returns the compressed pixels of pf
+func newCPixel(pf PixelFormat) cpixel {
+	mask := uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+	size, shift := tile.CPixel(pf.BytesPerPixel(), int(pf.Depth), pf.TrueColor == 1, mask)
+	return cpixel{pf: pf, size: size, shift: shift}
+}
+
+// append appends the pixel val to buf
+func (cp cpixel) append(buf []byte, val uint32) []byte {
+	if cp.size != 3 {
+		var b [4]byte
+		cp.pf.putPixel(b[:cp.size], 0, val)
+		return append(buf, b[:cp.size]...)
+	}
+	val >>= cp.shift
+	if cp.pf.BigEndian == 1 {
+		return append(buf, byte(val>>16), byte(val>>8), byte(val))
+	}
+	return append(buf, byte(val), byte(val>>8), byte(val>>16))
+}
+
+// encodeZRLE encodes rect (in the client's pixel format pf) with ZRLE, fb.emu must be held
+// The stream is started with the compression level the client asks for when the first rectangle is sent
+func (fb *RFBConn) encodeZRLE(rect RFBRectangle, pf PixelFormat) ([]encodedRect, error) {
+	if fb.zrle == nil {
+		s, err := fb.newZlibStream(fb.compressLevel(zlib.DefaultCompression))
+		if err != nil {
+			return nil, err
+		}
+		fb.zrle = s
+	}
+	cp := newCPixel(pf)
+	out := make([]byte, 0, len(rect.Buffer))
+	for _, t := range tile.Split(rect.Bounds(), tile.ZRLESize, tile.ZRLESize) {
+		out = appendZRLETile(out, tilePixels(subPixels(rect, t, pf.BytesPerPixel()), pf), t.Dx(), cp)
+	}
+	data, err := fb.zrle.compress(out)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := NewMessageWriter(4 + len(data)).Uint32(uint32(len(data))).Data(data).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return []encodedRect{{rect.X, rect.Y, rect.Width, rect.Height, EncZRLE, buf}}, nil
+}
+
+// appendZRLETile appends the tile with the pixels pix, which is width pixels wide, in the smallest sub-encoding
+func appendZRLETile(out []byte, pix []uint32, width int, cp cpixel) []byte {
+	height := len(pix) / width
+	// Palette in the order the colours appear, given up when it gets too large for the palette sub-encodings
+	palette := tile.NewPalette(tile.ZRLEMaxPalette)
+	plainRLE, paletteRLE := 0, 0
+	tile.Runs(pix, func(c uint32, n int) {
+		plainRLE += cp.size + tile.RunLengthSize(n)
+		paletteRLE++
+		if n > 1 {
+			paletteRLE += tile.RunLengthSize(n)
+		}
+		palette.Add(c)
+	})
+	colours := palette.Colours
+	if len(colours) == 1 {
+		return cp.append(append(out, tile.ZRLESolid), colours[0])
+	}
+	sub, size := tile.ZRLERaw, len(pix)*cp.size
+	if plainRLE < size {
+		sub, size = tile.ZRLEPlainRLE, plainRLE
+	}
+	bits := 0
+	if !palette.Overflowed {
+		if s := len(colours)*cp.size + paletteRLE; s < size {
+			sub, size = tile.ZRLEPaletteRLE+len(colours), s
+		}
+		if len(colours) <= tile.ZRLEMaxPacked {
+			bits = tile.PaletteBits(len(colours))
+			if s := len(colours)*cp.size + height*tile.PackedRowSize(width, bits); s < size {
+				sub, size = len(colours), s
+			}
+		}
+	}
+	out = append(out, byte(sub))
+	switch {
+	case sub == tile.ZRLERaw:
+		for _, p := range pix {
+			out = cp.append(out, p)
+		}
+	case sub == tile.ZRLEPlainRLE:
+		tile.Runs(pix, func(c uint32, n int) {
+			out = tile.AppendRunLength(cp.append(out, c), n)
+		})
+	case sub > tile.ZRLEPaletteRLE:
+		for _, p := range colours {
+			out = cp.append(out, p)
+		}
+		tile.Runs(pix, func(c uint32, n int) {
+			if n == 1 {
+				out = append(out, byte(palette.Index(c)))
+			} else {
+				out = tile.AppendRunLength(append(out, byte(palette.Index(c))|128), n)
+			}
+		})
+	default: // Packed palette, each row starts at a byte
+		for _, p := range colours {
+			out = cp.append(out, p)
+		}
+		for y := 0; y < height; y++ {
+			out = palette.AppendPacked(out, pix[y*width:(y+1)*width], bits)
+		}
+	}
+	return out
+}